@@ -0,0 +1,359 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// DefaultInterval is how often a tracker saves the committed position while
+// a migration runs. Records acknowledged after the last save are sent again
+// when the migration resumes, so migrations are at-least-once.
+const DefaultInterval = time.Second
+
+// markEvery bounds the pending positions a tracker keeps: positions reported
+// within markEvery records of each other are merged into one, which only
+// delays their commit.
+const markEvery = 100
+
+// Position is how far a source has got, as integration-specific keys and
+// values such as the primary-key watermark of each table or the next offset
+// of each partition.
+type Position map[string]string
+
+func (p Position) clone() Position {
+	c := make(Position, len(p))
+	for key, value := range p {
+		c[key] = value
+	}
+	return c
+}
+
+// mark is a change of position that becomes committed once every
+// destination has acknowledged seq records.
+type mark struct {
+	first int64 // seq of the first position merged into the mark
+	seq   int64
+	delta Position
+}
+
+// Tracker records how far a migration has got, so that a restarted
+// migration resumes from the last position its destinations have durably
+// written instead of starting over. A tracker is reused by every run of a
+// scheduled migration.
+//
+// Sources report their position with Send as they stream records, and read
+// the position to resume from with Resume. Destinations acknowledge durable
+// writes with Ack. A position is committed once every destination has
+// acknowledged the records sent before it. A destination that never calls
+// Ack acknowledges everything when it completes, so its migrations are
+// checkpointed at the end of each run.
+type Tracker struct {
+	// Interval is the minimum time between two saves while a run is in
+	// progress. The position is always saved when a run ends.
+	Interval time.Duration
+
+	store Store
+	name  string
+
+	mu        sync.Mutex
+	committed Position
+	start     Position // Committed position when the current run started
+	sent      int64
+	marks     []mark
+	acked     []int64
+	skips     skips   // Records dropped before the destinations
+	destSkips []skips // Records dropped by each destination
+	saved     time.Time
+	dirty     bool
+	onCommit  []func(Position)
+}
+
+// NewTracker loads the committed position of the migration called name.
+func NewTracker(ctx context.Context, store Store, name string) (*Tracker, error) {
+	position, err := store.Load(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint %s: %w", name, err)
+	}
+	if position == nil {
+		position = Position{}
+	} else {
+		logger.Infof("Resuming %s from checkpoint %v", name, position)
+	}
+	return &Tracker{Interval: DefaultInterval, store: store, name: name, committed: position}, nil
+}
+
+// Name returns the name of the migration in the store.
+func (t *Tracker) Name() string {
+	return t.name
+}
+
+// Position returns a copy of the committed position.
+func (t *Tracker) Position() Position {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.committed.clone()
+}
+
+// Close closes the store of the tracker.
+func (t *Tracker) Close() error {
+	return t.store.Close()
+}
+
+type trackerKey struct{}
+type destinationKey struct{}
+
+// WithTracker returns a context that checkpoints the migration run with it.
+// A nil tracker turns checkpoints off, which stages that reorder or combine
+// records use to keep their sources from reporting positions.
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+func fromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(trackerKey{}).(*Tracker)
+	return t
+}
+
+// Enabled reports whether the migration run with ctx is checkpointed.
+func Enabled(ctx context.Context) bool {
+	return fromContext(ctx) != nil
+}
+
+// Start begins a run with the given number of destinations. It is called by
+// the pipeline before the source and destinations start.
+func Start(ctx context.Context, destinations int) {
+	t := fromContext(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start = t.committed.clone()
+	t.sent = 0
+	t.marks = nil
+	t.acked = make([]int64, destinations)
+	t.skips = skips{}
+	t.destSkips = make([]skips, destinations)
+	t.onCommit = nil
+}
+
+// ForDestination returns the context passed to the i-th destination of a run.
+func ForDestination(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, destinationKey{}, i)
+}
+
+// Resume returns the position the source should resume from, or an empty
+// position when the migration is not checkpointed or has no checkpoint yet.
+func Resume(ctx context.Context) Position {
+	t := fromContext(ctx)
+	if t == nil {
+		return Position{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.start.clone()
+}
+
+// Send sends record like interfaces.Send, then reports that the source has
+// reached key=value once the record is written. A source that reports
+// positions must send every record with Send, since positions are matched to
+// acknowledgements by counting records. An empty key sends a record that does
+// not move the position.
+func Send(ctx context.Context, out chan<- *interfaces.Record, record *interfaces.Record, key, value string) error {
+	if err := interfaces.Send(ctx, out, record); err != nil {
+		return err
+	}
+	if t := fromContext(ctx); t != nil {
+		t.mark(key, value)
+	}
+	return nil
+}
+
+// Skip reports that a stage between the source and the destinations dropped
+// a record after passing on passed records, so that acknowledgements keep
+// matching the positions the source reported. A stage of a single
+// destination reports its drops with the context of that destination.
+func Skip(ctx context.Context, passed int64) {
+	if t := fromContext(ctx); t != nil {
+		t.skip(ctx, passed)
+	}
+}
+
+// OnCommit registers fn to be called with the committed position after it
+// is saved, e.g. to commit consumer offsets to a broker only once the
+// records are durable. Callbacks are dropped when the next run starts.
+func OnCommit(ctx context.Context, fn func(Position)) {
+	if t := fromContext(ctx); t != nil {
+		t.mu.Lock()
+		t.onCommit = append(t.onCommit, fn)
+		t.mu.Unlock()
+	}
+}
+
+// Ack reports that the destination run with ctx has durably written n more
+// records.
+func Ack(ctx context.Context, n int) {
+	if t := fromContext(ctx); t != nil {
+		t.ack(ctx, int64(n))
+	}
+}
+
+// Complete reports that the destination run with ctx has written every
+// record it received. The pipeline calls it when SendData succeeds.
+func Complete(ctx context.Context) {
+	if t := fromContext(ctx); t != nil {
+		t.ack(ctx, math.MaxInt64)
+	}
+}
+
+// Finish saves the committed position at the end of a run, whether the run
+// succeeded or not. It is called by the pipeline once every destination is
+// done.
+func Finish(ctx context.Context) error {
+	t := fromContext(ctx)
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Save even when the run was interrupted
+	return t.save(context.WithoutCancel(ctx))
+}
+
+func (t *Tracker) mark(key, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent++
+	if key == "" {
+		return
+	}
+	if n := len(t.marks); n > 0 && t.sent-t.marks[n-1].first < markEvery {
+		last := &t.marks[n-1]
+		last.seq = t.sent
+		last.delta[key] = value
+		return
+	}
+	t.marks = append(t.marks, mark{first: t.sent, seq: t.sent, delta: Position{key: value}})
+}
+
+func (t *Tracker) ack(ctx context.Context, n int64) {
+	i, ok := ctx.Value(destinationKey{}).(int)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !ok || i >= len(t.acked) {
+		return
+	}
+	t.acked[i] = add(t.acked[i], n)
+	t.commit(ctx)
+}
+
+func (t *Tracker) skip(ctx context.Context, passed int64) {
+	i, ok := ctx.Value(destinationKey{}).(int)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case !ok:
+		t.skips.add(passed)
+	case i < len(t.destSkips):
+		t.destSkips[i].add(passed)
+	default:
+		return
+	}
+	t.commit(ctx)
+}
+
+// commit commits the positions every destination has acknowledged. The
+// caller holds t.mu.
+func (t *Tracker) commit(ctx context.Context) {
+	if len(t.acked) == 0 {
+		return
+	}
+	// Count the records each destination has received, then the records of
+	// the source they stand for, including the dropped ones
+	low, received := int64(math.MaxInt64), int64(math.MaxInt64)
+	for i, acked := range t.acked {
+		r := add(acked, t.destSkips[i].count(acked))
+		t.destSkips[i].prune(acked)
+		received = min(received, r)
+		low = min(low, add(r, t.skips.count(r)))
+	}
+	t.skips.prune(received)
+
+	committed := 0
+	for committed < len(t.marks) && t.marks[committed].seq <= low {
+		for key, value := range t.marks[committed].delta {
+			t.committed[key] = value
+		}
+		committed++
+	}
+	if committed == 0 {
+		return
+	}
+	t.marks = t.marks[committed:]
+	t.dirty = true
+	if time.Since(t.saved) >= t.Interval {
+		if err := t.save(ctx); err != nil {
+			logger.Errorf("Failed to save checkpoint %s: %v", t.name, err)
+		}
+	}
+}
+
+// add returns a+b, or math.MaxInt64 when the sum overflows.
+func add(a, b int64) int64 {
+	if b > math.MaxInt64-a {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// skips are the records a stage dropped, each recorded as the number of
+// records the stage had passed on before it.
+type skips struct {
+	pruned  int64   // Drops before the records every destination acknowledged
+	pending []int64 // passed of the later drops, in order
+}
+
+func (s *skips) add(passed int64) {
+	s.pending = append(s.pending, passed)
+}
+
+// count returns the records dropped before the stage passed on more than
+// passed records. passed must not be less than the last pruned value.
+func (s *skips) count(passed int64) int64 {
+	return s.pruned + int64(s.before(passed))
+}
+
+// prune forgets the drops count no longer needs to look at once every
+// destination is past passed.
+func (s *skips) prune(passed int64) {
+	n := s.before(passed)
+	s.pruned += int64(n)
+	s.pending = s.pending[n:]
+}
+
+func (s *skips) before(passed int64) int {
+	return sort.Search(len(s.pending), func(i int) bool { return s.pending[i] > passed })
+}
+
+// save writes the committed position if it changed. The caller holds t.mu.
+func (t *Tracker) save(ctx context.Context) error {
+	if !t.dirty {
+		return nil
+	}
+	if err := t.store.Save(ctx, t.name, t.committed); err != nil {
+		return err
+	}
+	t.dirty = false
+	t.saved = time.Now()
+	for _, fn := range t.onCommit {
+		fn(t.committed.clone())
+	}
+	return nil
+}
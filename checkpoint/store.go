@@ -0,0 +1,251 @@
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver
+)
+
+// Store persists the committed position of every checkpointed migration.
+type Store interface {
+	// Load returns the position saved for the migration called name, or
+	// nil when there is none.
+	Load(ctx context.Context, name string) (Position, error)
+	// Save replaces the position of the migration called name.
+	Save(ctx context.Context, name string, position Position) error
+	Close() error
+}
+
+// Opener creates a store from its configuration.
+type Opener func(config interfaces.Checkpoint) (Store, error)
+
+var (
+	mu      sync.RWMutex
+	openers = map[string]Opener{
+		"file":   openFile,
+		"sqlite": openSQLite,
+	}
+)
+
+// RegisterStore makes a store available under name, in addition to the
+// built-in "file" and "sqlite" stores. It panics if the name is taken.
+func RegisterStore(name string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	key := strings.ToLower(name)
+	if _, exists := openers[key]; exists {
+		panic(fmt.Sprintf("checkpoint store %s is already registered", name))
+	}
+	openers[key] = open
+}
+
+// Open creates the store named by config.Store.
+func Open(config interfaces.Checkpoint) (Store, error) {
+	mu.RLock()
+	open, ok := openers[strings.ToLower(config.Store)]
+	names := make([]string, 0, len(openers))
+	for name := range openers {
+		names = append(names, name)
+	}
+	mu.RUnlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown checkpoint store %q, expected one of %s", config.Store, strings.Join(names, ", "))
+	}
+	return open(config)
+}
+
+// DefaultPath is where the file store keeps checkpoints when no path is set.
+const DefaultPath = ".fractal/checkpoints.json"
+
+// FileStore keeps the checkpoints of every migration in one JSON file. The
+// file is replaced atomically on every save, so a crash never leaves it
+// half-written.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// fileEntry is the checkpoint of one migration in the file.
+type fileEntry struct {
+	Position  Position  `json:"position"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewFileStore returns a store that keeps checkpoints in the file at path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func openFile(config interfaces.Checkpoint) (Store, error) {
+	path := config.Path
+	if path == "" {
+		path = DefaultPath
+	}
+	return NewFileStore(path), nil
+}
+
+func (f *FileStore) Load(ctx context.Context, name string) (Position, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return entries[name].Position, nil
+}
+
+func (f *FileStore) Save(ctx context.Context, name string, position Position) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := f.read()
+	if err != nil {
+		return err
+	}
+	entries[name] = fileEntry{Position: position, UpdatedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	// Make the new checkpoint durable before it replaces the old one
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), f.path)
+}
+
+func (f *FileStore) Close() error {
+	return nil
+}
+
+// read returns every checkpoint in the file, which may not exist yet.
+func (f *FileStore) read() (map[string]fileEntry, error) {
+	entries := make(map[string]fileEntry)
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", f.path, err)
+	}
+	return entries, nil
+}
+
+// DefaultDatabase is the SQLite database the sqlite store uses when no path
+// is set.
+const DefaultDatabase = ".fractal/checkpoints.db"
+
+// SQLStore keeps checkpoints in the fractal_checkpoints table of a SQL
+// database, one row per migration holding its position as JSON.
+type SQLStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+}
+
+// NewSQLStore creates the checkpoint table in db if needed. numbered selects
+// $1-style placeholders, as used by PostgreSQL, instead of ?.
+func NewSQLStore(ctx context.Context, db *sql.DB, numbered bool) (*SQLStore, error) {
+	s := &SQLStore{db: db, placeholder: func(int) string { return "?" }}
+	if numbered {
+		s.placeholder = func(n int) string { return fmt.Sprintf("$%d", n) }
+	}
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS fractal_checkpoints (
+	name TEXT PRIMARY KEY,
+	position TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint table: %w", err)
+	}
+	return s, nil
+}
+
+// openSQLite opens the sqlite store through database/sql, with the pure Go
+// modernc.org/sqlite driver ("sqlite") unless config names another driver the
+// binary links, such as "sqlite3" for github.com/mattn/go-sqlite3.
+func openSQLite(config interfaces.Checkpoint) (Store, error) {
+	driver := config.Driver
+	if driver == "" {
+		driver = "sqlite"
+	}
+	path := config.Path
+	if path == "" {
+		path = DefaultDatabase
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint database (is the %s driver linked in?): %w", driver, err)
+	}
+	store, err := NewSQLStore(context.Background(), db, false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *SQLStore) Load(ctx context.Context, name string) (Position, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT position FROM fractal_checkpoints WHERE name = "+s.placeholder(1), name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var position Position
+	if err := json.Unmarshal([]byte(data), &position); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", name, err)
+	}
+	return position, nil
+}
+
+func (s *SQLStore) Save(ctx context.Context, name string, position Position) error {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO fractal_checkpoints (name, position, updated_at) VALUES (%s, %s, %s)
+ON CONFLICT (name) DO UPDATE SET position = excluded.position, updated_at = excluded.updated_at`,
+		s.placeholder(1), s.placeholder(2), s.placeholder(3))
+	_, err = s.db.ExecContext(ctx, query, name, string(data), time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -3,7 +3,7 @@ package config
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"strings"
 
 	"github.com/SkySingh04/fractal/logger"
 	"github.com/SkySingh04/fractal/registry"
@@ -20,6 +20,112 @@ type Config struct {
 	Validations     []string               `yaml:"validations"`
 	Transformations []string               `yaml:"transformations"`
 	ErrorHandling   ErrorHandling          `yaml:"errorhandling"`
+	Outputs         []OutputConfig         `yaml:"outputs"` // Replaces outputMethod to write to several destinations
+	FanOut          string                 `yaml:"fanout"`  // all_or_nothing or best_effort
+	Inputs          []InputConfig          `yaml:"inputs"`  // Replaces inputMethod to read from several sources
+	Merge           MergeConfig            `yaml:"merge"`
+	Pipeline        PipelineConfig         `yaml:"pipeline"` // Replaces the input and output settings with a DAG of stages
+	Checkpoint      CheckpointConfig       `yaml:"checkpoint"`
+	Dedup           DedupConfig            `yaml:"dedup"`    // Drops the duplicate records of the source
+	Contract        ContractConfig         `yaml:"contract"` // Schema the records of the source must follow
+}
+
+// CheckpointConfig represents where a migration records how far it has got
+type CheckpointConfig struct {
+	Store  string `yaml:"store"` // file or sqlite, checkpoints are off when empty
+	Name   string `yaml:"name"`
+	Path   string `yaml:"path"`
+	Driver string `yaml:"driver"` // database/sql driver of the sqlite store
+}
+
+// DedupConfig represents how duplicate records are dropped
+type DedupConfig struct {
+	Keys    []string `yaml:"keys"`
+	Window  string   `yaml:"window"` // How long a key is remembered, such as 24h
+	Keep    string   `yaml:"keep"`   // first, last or merge
+	Store   string   `yaml:"store"`  // memory or file
+	MaxKeys int      `yaml:"max_keys"`
+	Path    string   `yaml:"path"`
+}
+
+// ContractConfig represents the schema records must follow, given by one of
+// fields, json_schema and infer
+type ContractConfig struct {
+	Fields         []ContractFieldConfig `yaml:"fields"`
+	JSONSchema     string                `yaml:"json_schema"` // JSON Schema document, or the path of a file holding one
+	Infer          int                   `yaml:"infer"`       // Records sampled to infer the schema
+	Path           string                `yaml:"path"`        // File the inferred schema is saved to
+	AllowNewFields bool                  `yaml:"allow_new_fields"`
+	OnDrift        string                `yaml:"on_drift"` // fail or log
+}
+
+// ContractFieldConfig represents a declared field of a contract
+type ContractFieldConfig struct {
+	Name     string                `yaml:"name"`
+	Type     string                `yaml:"type"` // string, int, float, decimal, bool, timestamp, binary, object, array or any
+	Nullable bool                  `yaml:"nullable"`
+	Optional bool                  `yaml:"optional"`
+	Enum     []interface{}         `yaml:"enum"`
+	Fields   []ContractFieldConfig `yaml:"fields"` // Fields of an object
+	Items    *ContractFieldConfig  `yaml:"items"`  // Elements of an array
+}
+
+// PipelineConfig represents a pipeline made of named stages
+type PipelineConfig struct {
+	Stages []StageConfig `yaml:"stages"`
+}
+
+// StageConfig represents one stage of a pipeline
+type StageConfig struct {
+	Name        string                 `yaml:"name"`
+	Type        string                 `yaml:"type"`   // source, filter, transform, router, aggregate, dedup, contract or sink
+	Inputs      []string               `yaml:"inputs"` // Stages read by this stage, "<router>.<route>" for a route
+	Integration string                 `yaml:"integration"`
+	Config      map[string]interface{} `yaml:"config"`
+	Rules       string                 `yaml:"rules"`
+	Routes      []RouteConfig          `yaml:"routes"`
+	GroupBy     []string               `yaml:"group_by"`
+	Aggregates  []AggregateConfig      `yaml:"aggregates"`
+	Dedup       DedupConfig            `yaml:"dedup"`
+	Contract    ContractConfig         `yaml:"contract"`
+}
+
+// RouteConfig represents a route of a router stage
+type RouteConfig struct {
+	Name string `yaml:"name"`
+	When string `yaml:"when"`
+}
+
+// AggregateConfig represents a summary field of an aggregate stage
+type AggregateConfig struct {
+	Name     string `yaml:"name"`
+	Function string `yaml:"function"` // count, sum, avg, min or max
+	Field    string `yaml:"field"`
+}
+
+// InputConfig represents one source of a fan-in migration
+type InputConfig struct {
+	Name        string                 `yaml:"name"`
+	InputMethod string                 `yaml:"inputMethod"`
+	InputConfig map[string]interface{} `yaml:"inputconfig"`
+	Key         string                 `yaml:"key"` // Join key, defaults to merge.key
+}
+
+// MergeConfig represents how the inputs of a fan-in migration are combined
+type MergeConfig struct {
+	Mode        string `yaml:"mode"` // union, inner_join or left_join
+	Key         string `yaml:"key"`
+	MaxInMemory int    `yaml:"max_in_memory"`
+	Spill       bool   `yaml:"spill"`
+}
+
+// OutputConfig represents one destination of a fan-out migration
+type OutputConfig struct {
+	Name            string                 `yaml:"name"`
+	OutputMethod    string                 `yaml:"outputMethod"`
+	OutputConfig    map[string]interface{} `yaml:"outputconfig"`
+	Transformations string                 `yaml:"transformations"`
+	ErrorHandling   ErrorHandling          `yaml:"errorhandling"`
 }
 
 // ErrorHandling represents the error handling configuration
@@ -62,30 +168,57 @@ func LoadConfig(configFile string) (map[string]interface{}, error) {
 		"inputconfig":     viper.GetStringMap("inputconfig"),
 		"outputconfig":    viper.GetStringMap("outputconfig"),
 		"errorhandling":   viper.GetStringMap("errorhandling"), // Keep this as a map if it contains structured data
-		"validations":     viper.GetString("validations"),      // Changed to GetString
-		"transformations": viper.GetString("transformations"),  // Changed to GetString
+		"validations":     Rules(viper.Get("validations")),
+		"transformations": Rules(viper.Get("transformations")),
+		"outputs":         viper.Get("outputs"), // Optional list of fan-out outputs
+		"fanout":          viper.GetString("fanout"),
+		"inputs":          viper.Get("inputs"), // Optional list of fan-in inputs
+		"merge":           viper.GetStringMap("merge"),
+		"pipeline":        viper.Get("pipeline"), // Optional DAG of stages
+		"checkpoint":      viper.GetStringMap("checkpoint"),
+		"references":      viper.Get("references"), // Optional reference datasets read by LOOKUP
+		"dedup":           viper.Get("dedup"),
+		"contract":        viper.Get("contract"),
 	}
 
 	logger.Infof("Configuration loaded from %s", configFile)
 	return config, nil
 }
 
+// Rules returns the rule text of a validations or transformations setting,
+// which is either a block of rules or a list with one rule per item.
+func Rules(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		lines := make([]string, 0, len(v))
+		for _, item := range v {
+			lines = append(lines, fmt.Sprint(item))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
 // SetupConfigInteractively prompts the user to set up input and output methods interactively,
 // including all required fields for the selected integrations.
 func SetupConfigInteractively() (map[string]interface{}, error) {
 	// Dynamically retrieve registered input and output options
-	inputMethods := getRegisteredDataSources()
-	outputMethods := getRegisteredDataDestinations()
+	inputMethods := registry.Sources()
+	outputMethods := registry.Destinations()
 
 	// Prompt for Input Method
 	inputPrompt := promptui.Select{
-		Label: "Select Input Method",
-		Items: inputMethods,
+		Label:     "Select Input Method",
+		Items:     inputMethods,
+		Templates: integrationTemplates,
 	}
-	_, inputMethod, err := inputPrompt.Run()
+	i, _, err := inputPrompt.Run()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get input method: %w", err)
 	}
+	inputMethod := inputMethods[i].Name
 
 	// Read additional fields for the input method
 	inputconfig, err := readIntegrationFields(inputMethod, true)
@@ -95,13 +228,15 @@ func SetupConfigInteractively() (map[string]interface{}, error) {
 
 	// Prompt for Output Method
 	outputPrompt := promptui.Select{
-		Label: "Select Output Method",
-		Items: outputMethods,
+		Label:     "Select Output Method",
+		Items:     outputMethods,
+		Templates: integrationTemplates,
 	}
-	_, outputMethod, err := outputPrompt.Run()
+	i, _, err = outputPrompt.Run()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get output method: %w", err)
 	}
+	outputMethod := outputMethods[i].Name
 
 	// Read additional fields for the output method
 	outputconfig, err := readIntegrationFields(outputMethod, false)
@@ -140,48 +275,72 @@ func SetupConfigInteractively() (map[string]interface{}, error) {
 	return config, nil
 }
 
-// readIntegrationFields dynamically prompts for and reads all fields in the selected integration struct
+// integrationTemplates shows the display name of each integration in the
+// selection list and its description and capabilities below it.
+var integrationTemplates = &promptui.SelectTemplates{
+	Active:   "▸ {{ .DisplayName | cyan }}",
+	Inactive: "  {{ .DisplayName }}",
+	Selected: "✔ {{ .DisplayName | green }}",
+	Details: `{{ .Description }}
+Capabilities: {{ range $i, $c := .Capabilities }}{{ if $i }}, {{ end }}{{ $c }}{{ end }}`,
+}
+
+// readIntegrationFields prompts for every configuration option declared by the
+// selected integration. Defaults are offered as the initial value and secret
+// options are masked while typing.
 func readIntegrationFields(method string, isSource bool) (map[string]interface{}, error) {
-	var integration interface{}
+	var metadata registry.Metadata
 	var found bool
 
 	// Get the appropriate integration
 	if isSource {
-		integration, found = registry.GetSource(method)
+		metadata, found = registry.SourceMetadata(method)
 	} else {
-		integration, found = registry.GetDestination(method)
+		metadata, found = registry.DestinationMetadata(method)
 	}
 
 	if !found {
 		return nil, errors.New("integration not found in registry")
 	}
 
-	// Use reflection to inspect the integration struct
-	val := reflect.ValueOf(integration)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem() // Dereference if it's a pointer
-	}
-	if val.Kind() != reflect.Struct {
-		return nil, errors.New("integration is not a struct")
-	}
-
 	config := make(map[string]interface{})
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Type().Field(i)
-		fieldName := field.Name
-		fieldType := field.Type
+	for _, field := range metadata.Config {
+		label := fmt.Sprintf("Enter %s (%s)", field.Name, field.Type)
+		if field.Description != "" {
+			label = fmt.Sprintf("%s - %s", label, field.Description)
+		}
+		if field.Example != "" && field.Default == "" {
+			label += fmt.Sprintf(", e.g. %s", field.Example)
+		}
+		if !field.Required {
+			label += " [optional]"
+		}
 
 		// Prompt the user for the field value
 		prompt := promptui.Prompt{
-			Label: fmt.Sprintf("Enter %s (%s)", fieldName, fieldType),
+			Label:   label,
+			Default: field.Default,
+		}
+		if field.Secret {
+			prompt.Mask = '*'
+		}
+		if field.Required && field.Default == "" {
+			prompt.Validate = func(input string) error {
+				if input == "" {
+					return fmt.Errorf("%s is required", field.Name)
+				}
+				return nil
+			}
 		}
 		value, err := prompt.Run()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get value for field %s: %w", fieldName, err)
+			return nil, fmt.Errorf("failed to get value for field %s: %w", field.Name, err)
 		}
 
 		// Assign the value to the config
-		config[fieldName] = value
+		if value != "" {
+			config[field.Name] = value
+		}
 	}
 
 	return config, nil
@@ -233,21 +392,3 @@ func saveConfig(config map[string]interface{}) {
 		fmt.Println("Configuration saved to config.yaml")
 	}
 }
-
-// Helper function to retrieve registered input methods
-func getRegisteredDataSources() []string {
-	var sources []string
-	for source := range registry.GetSources() {
-		sources = append(sources, source)
-	}
-	return sources
-}
-
-// Helper function to retrieve registered output methods
-func getRegisteredDataDestinations() []string {
-	var destinations []string
-	for dest := range registry.GetDestinations() {
-		destinations = append(destinations, dest)
-	}
-	return destinations
-}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"gopkg.in/yaml.v3"
+)
+
+// FormatRules rewrites the rules of a configuration file with their
+// canonical text, as language.Format writes it: the validations and
+// transformations, the transformations of each output, and the rules of the
+// filter, transform and aggregate stages and the routes of the routers of a
+// pipeline. Only the lines of those settings change; the rest of the file is
+// returned as it was. A setting holding a list keeps one rule per item.
+// Settings written in a flow mapping, such as {name: a, when: ...}, are left
+// as they are.
+func FormatRules(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: a configuration is a mapping of settings", root.Line)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	var blocks []block
+	for _, s := range ruleSettings(root, len(lines)) {
+		b, err := formatBlock(lines, s.key, s.value, s.end, s.kind)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.source, err)
+		}
+		if b != nil {
+			blocks = append(blocks, *b)
+		}
+	}
+
+	// Replace the blocks from the last, so the lines of the others stay put
+	for i := len(blocks) - 1; i >= 0; i-- {
+		b := blocks[i]
+		lines = append(lines[:b.start], append([]string{b.text}, lines[b.end:]...)...)
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// setting is a setting holding rules, found in the file under source, such
+// as pipeline.stages[valid].rules. Its lines end at end, counted from 1.
+type setting struct {
+	source     string
+	kind       string
+	key, value *yaml.Node
+	end        int
+}
+
+// entry is a key and its value in a mapping, or an item of a list with a nil
+// key, whose lines end at end, counted from 1.
+type entry struct {
+	key, value *yaml.Node
+	end        int
+}
+
+// ruleSettings finds the settings holding rules in a configuration, at the
+// places the rules check command reads them.
+func ruleSettings(root *yaml.Node, end int) []setting {
+	var settings []setting
+	for _, e := range entries(root, end) {
+		switch strings.ToLower(e.key.Value) {
+		case "validations":
+			settings = append(settings, setting{e.key.Value, language.Validation, e.key, e.value, e.end})
+		case "transformations":
+			settings = append(settings, setting{e.key.Value, language.Transformation, e.key, e.value, e.end})
+		case "outputs":
+			for i, output := range entries(e.value, e.end) {
+				fields := entries(output.value, output.end)
+				if rules, ok := lookup(fields, "transformations"); ok {
+					source := fmt.Sprintf("outputs[%s].transformations", nameOf(fields, fmt.Sprint(i)))
+					settings = append(settings, setting{source, language.Transformation, rules.key, rules.value, rules.end})
+				}
+			}
+		case "pipeline":
+			stages, _ := lookup(entries(e.value, e.end), "stages")
+			for _, stage := range entries(stages.value, stages.end) {
+				settings = append(settings, stageSettings(entries(stage.value, stage.end))...)
+			}
+		}
+	}
+	return settings
+}
+
+// stageSettings finds the settings holding rules in the fields of a stage:
+// the rules of a filter, transform or aggregate, or the when of each route
+// of a router.
+func stageSettings(fields []entry) []setting {
+	source := fmt.Sprintf("pipeline.stages[%s]", nameOf(fields, ""))
+	kind := ""
+	if stageType, ok := lookup(fields, "type"); ok {
+		switch stageType.value.Value {
+		case interfaces.StageFilter:
+			kind = language.Validation
+		case interfaces.StageTransform:
+			kind = language.Transformation
+		case interfaces.StageAggregate:
+			kind = language.Aggregation
+		case interfaces.StageRouter:
+			var settings []setting
+			routes, _ := lookup(fields, "routes")
+			for _, route := range entries(routes.value, routes.end) {
+				routeFields := entries(route.value, route.end)
+				if when, ok := lookup(routeFields, "when"); ok {
+					routeSource := fmt.Sprintf("%s.routes[%s].when", source, nameOf(routeFields, ""))
+					settings = append(settings, setting{routeSource, language.Validation, when.key, when.value, when.end})
+				}
+			}
+			return settings
+		}
+	}
+	if rules, ok := lookup(fields, "rules"); ok && kind != "" {
+		return []setting{{source + ".rules", kind, rules.key, rules.value, rules.end}}
+	}
+	return nil
+}
+
+// entries returns the keys and values of a mapping, or the items of a list,
+// whose lines end at end. It returns nil for other nodes, and for a mapping
+// or list written in flow style, whose settings share lines.
+func entries(node *yaml.Node, end int) []entry {
+	if node == nil || node.Style&yaml.FlowStyle != 0 {
+		return nil
+	}
+	var result []entry
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			result = append(result, entry{key: node.Content[i], value: node.Content[i+1], end: end})
+			if i+2 < len(node.Content) {
+				result[len(result)-1].end = node.Content[i+2].Line - 1
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			result = append(result, entry{value: item, end: end})
+			if i+1 < len(node.Content) {
+				result[i].end = node.Content[i+1].Line - 1
+			}
+		}
+	}
+	return result
+}
+
+// lookup returns the entry whose key is name, in any case, as viper reads
+// keys.
+func lookup(fields []entry, name string) (entry, bool) {
+	for _, field := range fields {
+		if field.key != nil && strings.EqualFold(field.key.Value, name) {
+			return field, true
+		}
+	}
+	return entry{}, false
+}
+
+// nameOf returns the name field of a mapping, or fallback when it has none.
+func nameOf(fields []entry, fallback string) string {
+	if field, ok := lookup(fields, "name"); ok && field.value.Kind == yaml.ScalarNode {
+		return field.value.Value
+	}
+	return fallback
+}
+
+// block is the text replacing the lines start to end of a file, counted
+// from 0 and excluding end.
+type block struct {
+	start, end int
+	text       string
+}
+
+// formatBlock formats the rules of a setting whose lines run from the line
+// of key to end, counted from 1. It returns nil when they are already
+// formatted.
+func formatBlock(lines []string, key, value *yaml.Node, end int, kind string) (*block, error) {
+	literal := value.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0
+	// Blank lines and comments after the setting belong to what follows it,
+	// except comments indented into a block of rules, which are rules
+	for end > key.Line {
+		line := lines[end-1]
+		text := strings.TrimSpace(line)
+		if text != "" && (!strings.HasPrefix(text, "#") || literal && indentOf(line) > key.Column-1) {
+			break
+		}
+		end--
+	}
+
+	formatted := *value
+	formatted.HeadComment, formatted.FootComment = "", ""
+	changed := false
+	switch value.Kind {
+	case yaml.ScalarNode:
+		text, err := formatScalar(&formatted, kind)
+		if err != nil {
+			return nil, err
+		}
+		changed = text != value.Value
+	case yaml.SequenceNode:
+		formatted.Content = make([]*yaml.Node, len(value.Content))
+		for i, item := range value.Content {
+			rule := *item
+			if i == len(value.Content)-1 {
+				rule.FootComment = ""
+			}
+			if _, err := formatScalar(&rule, kind); err != nil {
+				return nil, fmt.Errorf("item %d: %w", i+1, err)
+			}
+			changed = changed || rule.Value != item.Value
+			formatted.Content[i] = &rule
+		}
+	default:
+		return nil, fmt.Errorf("line %d: expected a block of rules or a list of rules", value.Line)
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	name := *key
+	name.HeadComment, name.FootComment = "", ""
+	var text bytes.Buffer
+	encoder := yaml.NewEncoder(&text)
+	encoder.SetIndent(indentation(lines, key, value))
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{&name, &formatted}}); err != nil {
+		return nil, err
+	}
+
+	// A nested setting keeps what precedes its key, such as the dash of a
+	// list item, and the lines after it are indented to its key
+	encoded := strings.SplitAfter(text.String(), "\n")
+	encoded[0] = lines[key.Line-1][:key.Column-1] + encoded[0]
+	for i := 1; i < len(encoded); i++ {
+		if strings.TrimSpace(encoded[i]) != "" {
+			encoded[i] = strings.Repeat(" ", key.Column-1) + encoded[i]
+		}
+	}
+	return &block{start: key.Line - 1, end: end, text: strings.Join(encoded, "")}, nil
+}
+
+// formatScalar sets the value of node to the canonical text of its rules. A
+// single rule stays on the line of its setting unless it was written as a
+// block, and several rules become a block with one rule per line.
+func formatScalar(node *yaml.Node, kind string) (string, error) {
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("line %d: expected rules, found a %s", node.Line, describeKind(node.Kind))
+	}
+	text, err := language.Format(node.Value, kind)
+	if err != nil {
+		return "", err
+	}
+	if strings.Count(text, "\n") > 1 || node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		node.Style = yaml.LiteralStyle
+	} else {
+		text = strings.TrimSuffix(text, "\n")
+	}
+	node.Value = text
+	return text, nil
+}
+
+func describeKind(kind yaml.Kind) string {
+	switch kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "list"
+	}
+	return "value"
+}
+
+// indentation returns the indentation the block of a setting is written
+// with, so the formatted block keeps it. It is 4 spaces, as the setup writes
+// the file, when the block shows none.
+func indentation(lines []string, key, value *yaml.Node) int {
+	indent := 0
+	switch {
+	case value.Kind == yaml.SequenceNode && len(value.Content) > 0 && value.Style&yaml.FlowStyle == 0:
+		indent = value.Content[0].Column - 3 - (key.Column - 1) // Up to the dash of the first item
+	case value.Kind == yaml.ScalarNode && value.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		for _, line := range lines[key.Line:] {
+			if strings.TrimSpace(line) != "" {
+				indent = indentOf(line) - (key.Column - 1)
+				break
+			}
+		}
+	}
+	if indent < 2 || indent > 9 {
+		return 4
+	}
+	return indent
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
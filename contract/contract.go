@@ -0,0 +1,295 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// Schema is a contract the records of a migration follow: the fields they
+// hold and the values those fields may take. A record whose fields no longer
+// match it has drifted, see DriftError, and a record holding a value the
+// schema does not allow violates it, see ViolationError.
+type Schema struct {
+	Fields []*Field
+	// Closed makes a field the schema does not declare drift. Records of an
+	// open schema may hold other fields.
+	Closed bool
+}
+
+// Field is a field of a Schema, or the elements of an array field.
+type Field struct {
+	Name     string
+	Type     interfaces.FieldType // TypeAny accepts every value
+	Nullable bool                 // The field may hold null
+	Optional bool                 // The field may be missing
+	Enum     []interface{}        // Values the field may hold, compared by their text; any value when empty
+	Fields   []*Field             // Fields of an object, which may hold any field when there are none
+	Closed   bool                 // The object holds no field but Fields
+	Items    *Field               // Elements of an array, of any type when nil
+}
+
+// Kinds of drift.
+const (
+	// NewField is a field the schema does not declare.
+	NewField = "new"
+	// MissingField is a field the schema requires that a record lacks.
+	MissingField = "missing"
+	// RetypedField is a field holding a value of another type than the
+	// schema declares.
+	RetypedField = "retyped"
+)
+
+// Change is a difference between the fields of a record and its schema.
+type Change struct {
+	Kind     string
+	Field    string               // e.g. customer.address.city, or items[].sku for the elements of an array
+	Expected interfaces.FieldType // Type of the schema, unless the field is new
+	Found    interfaces.FieldType // Type of the record, unless the field is missing
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case NewField:
+		return fmt.Sprintf("new field %s (%s)", c.Field, c.Found)
+	case MissingField:
+		return fmt.Sprintf("missing field %s", c.Field)
+	}
+	return fmt.Sprintf("field %s retyped from %s to %s", c.Field, c.Expected, c.Found)
+}
+
+// DriftError reports a record whose fields no longer match the schema: it
+// holds new fields, lacks required ones or holds values of another type.
+// Drift is a change of the source, unlike a ViolationError, which is a bad
+// value.
+type DriftError struct {
+	Changes []Change
+}
+
+func (e *DriftError) Error() string {
+	changes := make([]string, len(e.Changes))
+	for i, change := range e.Changes {
+		changes[i] = change.String()
+	}
+	return "schema drift: " + strings.Join(changes, ", ")
+}
+
+// ViolationError reports a value the schema does not allow, such as a null
+// in a field that is not nullable or a value outside an enum.
+type ViolationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("schema violation: field %s %s", e.Field, e.Reason)
+}
+
+// Check returns a *DriftError listing every change between the fields of
+// record and the schema, or, when there is none, a *ViolationError for the
+// first value the schema does not allow. It returns nil when record follows
+// the schema.
+func (s *Schema) Check(record *interfaces.Record) error {
+	c := &checker{}
+	c.object("", s.Fields, s.Closed, record)
+	if len(c.changes) > 0 {
+		return &DriftError{Changes: c.changes}
+	}
+	if c.violation != nil {
+		return c.violation
+	}
+	return nil
+}
+
+// checker collects what a record does not follow in a schema.
+type checker struct {
+	changes   []Change
+	violation *ViolationError
+}
+
+func (c *checker) object(path string, fields []*Field, closed bool, record *interfaces.Record) {
+	for _, field := range fields {
+		value, ok := record.Get(field.Name)
+		if !ok {
+			if !field.Optional {
+				c.changes = append(c.changes, Change{Kind: MissingField, Field: path + field.Name, Expected: field.Type})
+			}
+			continue
+		}
+		c.value(path+field.Name, field, value)
+	}
+	if !closed {
+		return
+	}
+	for _, f := range record.Fields {
+		if !declares(fields, f.Name) {
+			c.changes = append(c.changes, Change{Kind: NewField, Field: path + f.Name, Found: interfaces.TypeOf(f.Value)})
+		}
+	}
+}
+
+func (c *checker) value(path string, field *Field, value interface{}) {
+	if value == nil {
+		if !field.Nullable {
+			c.violate(path, "is null, but not nullable")
+		}
+		return
+	}
+	if !accepts(field.Type, value) {
+		c.changes = append(c.changes, Change{Kind: RetypedField, Field: path, Expected: field.Type, Found: interfaces.TypeOf(value)})
+		return
+	}
+	if len(field.Enum) > 0 && !inEnum(field.Enum, value) {
+		c.violate(path, fmt.Sprintf("holds %q, which is not one of %s", interfaces.ValueString(value), enumText(field.Enum)))
+	}
+	switch v := value.(type) {
+	case *interfaces.Record:
+		if field.Type == interfaces.TypeObject {
+			c.object(path+".", field.Fields, field.Closed, v)
+		}
+	case []interface{}:
+		if field.Type == interfaces.TypeArray && field.Items != nil {
+			for _, item := range v {
+				c.value(path+"[]", field.Items, item)
+			}
+		}
+	}
+}
+
+func (c *checker) violate(path, reason string) {
+	if c.violation == nil {
+		c.violation = &ViolationError{Field: path, Reason: reason}
+	}
+}
+
+func declares(fields []*Field, name string) bool {
+	return find(fields, name) != nil
+}
+
+// accepts reports whether a field of type t may hold value, which is not
+// null. Float and decimal fields hold every number, and timestamp fields
+// hold RFC 3339 timestamps and dates written as text, as JSON and CSV files
+// store them.
+func accepts(t interfaces.FieldType, value interface{}) bool {
+	found := interfaces.TypeOf(value)
+	switch t {
+	case interfaces.TypeAny:
+		return true
+	case interfaces.TypeFloat, interfaces.TypeDecimal:
+		return found == interfaces.TypeInt || found == interfaces.TypeFloat || found == interfaces.TypeDecimal
+	case interfaces.TypeTimestamp:
+		if text, ok := value.(string); ok {
+			return isTimestamp(text)
+		}
+	}
+	return found == t
+}
+
+func isTimestamp(text string) bool {
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		if _, err := time.Parse(layout, text); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	text := interfaces.ValueString(value)
+	for _, allowed := range enum {
+		if interfaces.ValueString(allowed) == text {
+			return true
+		}
+	}
+	return false
+}
+
+func enumText(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = interfaces.ValueString(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// types are the types a declared field may have.
+var types = []interfaces.FieldType{
+	interfaces.TypeString, interfaces.TypeInt, interfaces.TypeFloat, interfaces.TypeDecimal, interfaces.TypeBool,
+	interfaces.TypeTimestamp, interfaces.TypeBinary, interfaces.TypeObject, interfaces.TypeArray, interfaces.TypeAny,
+}
+
+// Declare returns the schema of declared fields. Records of a closed schema
+// hold no other fields, nor do their declared objects.
+func Declare(fields []interfaces.ContractField, closed bool) (*Schema, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("a contract needs fields")
+	}
+	declared, err := declareFields("", fields, closed)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{Fields: declared, Closed: closed}, nil
+}
+
+func declareFields(path string, fields []interfaces.ContractField, closed bool) ([]*Field, error) {
+	declared := make([]*Field, len(fields))
+	for i, field := range fields {
+		if field.Name == "" {
+			return nil, fmt.Errorf("field %d%s has no name", i+1, within(path))
+		}
+		if declares(declared[:i], field.Name) {
+			return nil, fmt.Errorf("duplicate field %s%s", field.Name, within(path))
+		}
+		var err error
+		if declared[i], err = declareField(path+field.Name, field, closed); err != nil {
+			return nil, err
+		}
+	}
+	return declared, nil
+}
+
+func declareField(path string, field interfaces.ContractField, closed bool) (*Field, error) {
+	f := &Field{Name: field.Name, Type: interfaces.FieldType(field.Type), Nullable: field.Nullable, Optional: field.Optional, Enum: field.Enum}
+	if f.Type == "" {
+		f.Type = interfaces.TypeAny
+	}
+	if !slices.Contains(types, f.Type) {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = string(t)
+		}
+		return nil, fmt.Errorf("field %s: unknown type %q, expected %s or %s", path, field.Type, strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+	}
+	if len(field.Fields) > 0 {
+		if f.Type != interfaces.TypeObject {
+			return nil, fmt.Errorf("field %s: only an object has fields", path)
+		}
+		var err error
+		if f.Fields, err = declareFields(path+".", field.Fields, closed); err != nil {
+			return nil, err
+		}
+		f.Closed = closed
+	}
+	if field.Items != nil {
+		if f.Type != interfaces.TypeArray {
+			return nil, fmt.Errorf("field %s: only an array has items", path)
+		}
+		var err error
+		if f.Items, err = declareField(path+"[]", *field.Items, closed); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// within describes the object at path in errors.
+func within(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " in " + strings.TrimSuffix(path, ".")
+}
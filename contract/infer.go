@@ -0,0 +1,82 @@
+package contract
+
+import "github.com/SkySingh04/fractal/interfaces"
+
+// Infer returns the schema of a sample of records: every field they hold,
+// in the order first seen, with the type of its values. A field missing
+// from some records is optional and one holding null is nullable. Values of
+// several types give the type any, except numbers, which give float, or
+// decimal when one of them is a decimal. Objects and array elements are
+// inferred the same way. Records of a closed schema hold no other fields,
+// nor do their objects.
+func Infer(records []*interfaces.Record, closed bool) *Schema {
+	return &Schema{Fields: inferFields(records, closed), Closed: closed}
+}
+
+// inferFields returns the fields of objects.
+func inferFields(objects []*interfaces.Record, closed bool) []*Field {
+	var fields []*Field
+	values := make(map[string][]interface{})
+	for _, object := range objects {
+		for _, f := range object.Fields {
+			if _, ok := values[f.Name]; !ok {
+				fields = append(fields, &Field{Name: f.Name})
+			}
+			values[f.Name] = append(values[f.Name], f.Value)
+		}
+	}
+	for _, field := range fields {
+		inferField(field, values[field.Name], closed)
+		field.Optional = len(values[field.Name]) < len(objects)
+	}
+	return fields
+}
+
+// inferField sets the type of field from the values it holds.
+func inferField(field *Field, values []interface{}, closed bool) {
+	var objects []*interfaces.Record
+	var items []interface{}
+	for _, value := range values {
+		if value == nil {
+			field.Nullable = true
+			continue
+		}
+		field.Type = widen(field.Type, interfaces.TypeOf(value))
+		switch v := value.(type) {
+		case *interfaces.Record:
+			objects = append(objects, v)
+		case []interface{}:
+			items = append(items, v...)
+		}
+	}
+	switch field.Type {
+	case "":
+		field.Type = interfaces.TypeAny // Only nulls
+	case interfaces.TypeObject:
+		field.Fields, field.Closed = inferFields(objects, closed), closed
+	case interfaces.TypeArray:
+		if len(items) > 0 {
+			field.Items = &Field{}
+			inferField(field.Items, items, closed)
+		}
+	}
+}
+
+// widen returns the type of a field holding values of type t and of type
+// found.
+func widen(t, found interfaces.FieldType) interfaces.FieldType {
+	switch {
+	case t == "" || t == found:
+		return found
+	case number(t) && number(found):
+		if t == interfaces.TypeDecimal || found == interfaces.TypeDecimal {
+			return interfaces.TypeDecimal
+		}
+		return interfaces.TypeFloat
+	}
+	return interfaces.TypeAny
+}
+
+func number(t interfaces.FieldType) bool {
+	return t == interfaces.TypeInt || t == interfaces.TypeFloat || t == interfaces.TypeDecimal
+}
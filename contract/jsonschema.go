@@ -0,0 +1,389 @@
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// ParseJSONSchema reads a schema from a JSON Schema document describing an
+// object. It understands type, properties, required, additionalProperties,
+// items, enum, const, the date-time and date formats, base64 content,
+// OpenAPI's nullable, references to $defs and definitions of the same
+// document, and anyOf or oneOf of one type and null. Other composition
+// keywords are rejected, and value constraints such as minimum or pattern
+// are ignored: rules check those.
+func ParseJSONSchema(data []byte) (*Schema, error) {
+	document, err := interfaces.ParseJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	root, ok := document.(*interfaces.Record)
+	if !ok {
+		return nil, errors.New("invalid JSON Schema: the document is not an object")
+	}
+	p := &parser{root: root, resolving: make(map[string]bool)}
+	field, err := p.field("", root)
+	if err != nil {
+		return nil, err
+	}
+	if field.Type != interfaces.TypeObject {
+		return nil, fmt.Errorf("a JSON Schema contract describes an object, found type %s", field.Type)
+	}
+	return &Schema{Fields: field.Fields, Closed: field.Closed}, nil
+}
+
+// parser reads the fields of a JSON Schema document.
+type parser struct {
+	root      *interfaces.Record
+	resolving map[string]bool // References being read, to reject recursive ones
+}
+
+// unsupported are the keywords a contract cannot express.
+var unsupported = []string{"allOf", "not", "if", "then", "else", "patternProperties", "prefixItems", "dependentSchemas"}
+
+func (p *parser) field(path string, node *interfaces.Record) (*Field, error) {
+	if ref, ok := node.Get("$ref"); ok {
+		return p.reference(path, ref)
+	}
+	for _, keyword := range unsupported {
+		if _, ok := node.Get(keyword); ok {
+			return nil, fmt.Errorf("%s: %s is not supported by contracts", where(path), keyword)
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		if branches, ok := node.Get(keyword); ok {
+			return p.nullable(path, keyword, branches)
+		}
+	}
+
+	f := &Field{Type: interfaces.TypeAny}
+	switch t := get(node, "type").(type) {
+	case nil:
+		if get(node, "properties") != nil {
+			f.Type = interfaces.TypeObject
+		} else if get(node, "items") != nil {
+			f.Type = interfaces.TypeArray
+		} else {
+			f.Nullable = true // A schema without a type holds null too
+		}
+	case string:
+		if t == "null" {
+			f.Type, f.Nullable = interfaces.TypeNull, true
+		} else if f.Type = jsonTypes[t]; f.Type == "" {
+			return nil, fmt.Errorf("%s: unknown type %q", where(path), t)
+		}
+	case []interface{}:
+		var named []string
+		for _, item := range t {
+			name, _ := item.(string)
+			if _, ok := jsonTypes[name]; !ok && name != "null" {
+				return nil, fmt.Errorf("%s: unknown type %q", where(path), interfaces.ValueString(item))
+			}
+			if name == "null" {
+				f.Nullable = true
+			} else {
+				named = append(named, name)
+			}
+		}
+		if len(named) == 1 {
+			f.Type = jsonTypes[named[0]]
+		} else if len(named) == 0 {
+			f.Type = interfaces.TypeNull
+		}
+	default:
+		return nil, fmt.Errorf("%s: type must be a string or a list of strings", where(path))
+	}
+	if get(node, "nullable") == true {
+		f.Nullable = true
+	}
+	if f.Type == interfaces.TypeString {
+		switch {
+		case get(node, "format") == "date-time" || get(node, "format") == "date":
+			f.Type = interfaces.TypeTimestamp
+		case get(node, "contentEncoding") == "base64":
+			f.Type = interfaces.TypeBinary
+		}
+	}
+
+	if value, ok := node.Get("const"); ok {
+		f.Enum = []interface{}{value}
+	}
+	if enum, ok := node.Get("enum"); ok {
+		values, ok := enum.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: enum must be a list", where(path))
+		}
+		f.Enum = values
+	}
+	for _, value := range f.Enum {
+		if value == nil {
+			f.Nullable = true
+		}
+	}
+
+	switch f.Type {
+	case interfaces.TypeObject:
+		if err := p.object(path, node, f); err != nil {
+			return nil, err
+		}
+	case interfaces.TypeArray:
+		if items := get(node, "items"); items != nil {
+			item, ok := items.(*interfaces.Record)
+			if !ok {
+				return nil, fmt.Errorf("%s: items must be a schema", where(path))
+			}
+			var err error
+			if f.Items, err = p.field(path+"[]", item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// object reads the properties of an object node into f.
+func (p *parser) object(path string, node *interfaces.Record, f *Field) error {
+	prefix := path
+	if path != "" {
+		prefix += "."
+	}
+	if properties := get(node, "properties"); properties != nil {
+		record, ok := properties.(*interfaces.Record)
+		if !ok {
+			return fmt.Errorf("%s: properties must be an object", where(path))
+		}
+		for _, property := range record.Fields {
+			schema, ok := property.Value.(*interfaces.Record)
+			if !ok {
+				return fmt.Errorf("%s: property %s must be a schema", where(path), property.Name)
+			}
+			field, err := p.field(prefix+property.Name, schema)
+			if err != nil {
+				return err
+			}
+			field.Name, field.Optional = property.Name, true
+			f.Fields = append(f.Fields, field)
+		}
+	}
+	if required, ok := node.Get("required"); ok {
+		names, ok := required.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: required must be a list", where(path))
+		}
+		for _, name := range names {
+			field := find(f.Fields, interfaces.ValueString(name))
+			if field == nil {
+				// A required field without a schema holds anything
+				field = &Field{Name: interfaces.ValueString(name), Type: interfaces.TypeAny, Nullable: true}
+				f.Fields = append(f.Fields, field)
+			}
+			field.Optional = false
+		}
+	}
+	f.Closed = get(node, "additionalProperties") == false
+	return nil
+}
+
+// reference reads the schema a $ref points to, which must be in the same
+// document.
+func (p *parser) reference(path string, ref interface{}) (*Field, error) {
+	pointer, _ := ref.(string)
+	if !strings.HasPrefix(pointer, "#/") {
+		return nil, fmt.Errorf("%s: $ref %q is not supported by contracts, only references within the document are", where(path), interfaces.ValueString(ref))
+	}
+	if p.resolving[pointer] {
+		return nil, fmt.Errorf("%s: $ref %q is recursive, which contracts do not support", where(path), pointer)
+	}
+	var node interface{} = p.root
+	for _, name := range strings.Split(pointer[2:], "/") {
+		name = strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+		record, ok := node.(*interfaces.Record)
+		if !ok {
+			return nil, fmt.Errorf("%s: $ref %q points to nothing", where(path), pointer)
+		}
+		node = get(record, name)
+	}
+	target, ok := node.(*interfaces.Record)
+	if !ok {
+		return nil, fmt.Errorf("%s: $ref %q points to nothing", where(path), pointer)
+	}
+	p.resolving[pointer] = true
+	defer delete(p.resolving, pointer)
+	return p.field(path, target)
+}
+
+// nullable reads an anyOf or oneOf of one schema and null, the way many
+// generators write a nullable field.
+func (p *parser) nullable(path, keyword string, branches interface{}) (*Field, error) {
+	list, _ := branches.([]interface{})
+	var schemas []*interfaces.Record
+	nullable := false
+	for _, branch := range list {
+		schema, ok := branch.(*interfaces.Record)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a list of schemas", where(path), keyword)
+		}
+		if get(schema, "type") == "null" && schema.Len() == 1 {
+			nullable = true
+		} else {
+			schemas = append(schemas, schema)
+		}
+	}
+	if len(schemas) != 1 {
+		return nil, fmt.Errorf("%s: %s is only supported by contracts for one schema and null", where(path), keyword)
+	}
+	f, err := p.field(path, schemas[0])
+	if err != nil {
+		return nil, err
+	}
+	f.Nullable = f.Nullable || nullable
+	return f, nil
+}
+
+// jsonTypes maps JSON Schema types to field types.
+var jsonTypes = map[string]interfaces.FieldType{
+	"string":  interfaces.TypeString,
+	"integer": interfaces.TypeInt,
+	"number":  interfaces.TypeFloat,
+	"boolean": interfaces.TypeBool,
+	"object":  interfaces.TypeObject,
+	"array":   interfaces.TypeArray,
+}
+
+func get(node *interfaces.Record, keyword string) interface{} {
+	value, _ := node.Get(keyword)
+	return value
+}
+
+// find returns the field of fields with the given name, or nil.
+func find(fields []*Field, name string) *Field {
+	for _, field := range fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// where describes a field of a JSON Schema in errors.
+func where(path string) string {
+	if path == "" {
+		return "JSON Schema root"
+	}
+	return "JSON Schema field " + path
+}
+
+// JSONSchema returns the schema as a JSON Schema document, which
+// ParseJSONSchema reads back.
+func (s *Schema) JSONSchema() ([]byte, error) {
+	document := interfaces.NewRecord()
+	document.Set("$schema", "https://json-schema.org/draft/2020-12/schema")
+	for _, field := range object(s.Fields, s.Closed).Fields {
+		document.Set(field.Name, field.Value)
+	}
+	data, err := document.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return nil, err
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+// object returns the JSON Schema of an object holding fields.
+func object(fields []*Field, closed bool) *interfaces.Record {
+	node := interfaces.NewRecord()
+	node.Set("type", "object")
+	if len(fields) > 0 {
+		properties := interfaces.NewRecord()
+		var required []interface{}
+		for _, field := range fields {
+			properties.Set(field.Name, jsonSchema(field))
+			if !field.Optional {
+				required = append(required, field.Name)
+			}
+		}
+		node.Set("properties", properties)
+		if len(required) > 0 {
+			node.Set("required", required)
+		}
+	}
+	if closed {
+		node.Set("additionalProperties", false)
+	}
+	return node
+}
+
+// jsonSchema returns the JSON Schema of a field.
+func jsonSchema(field *Field) *interfaces.Record {
+	node := interfaces.NewRecord()
+	var name, format, encoding string
+	switch field.Type {
+	case interfaces.TypeObject:
+		node = object(field.Fields, field.Closed)
+		name = "object"
+	case interfaces.TypeArray:
+		name = "array"
+	case interfaces.TypeInt:
+		name = "integer"
+	case interfaces.TypeFloat, interfaces.TypeDecimal:
+		name = "number"
+	case interfaces.TypeBool:
+		name = "boolean"
+	case interfaces.TypeString:
+		name = "string"
+	case interfaces.TypeTimestamp:
+		name, format = "string", "date-time"
+	case interfaces.TypeBinary:
+		name, encoding = "string", "base64"
+	case interfaces.TypeNull:
+		name = "null"
+	}
+	switch {
+	case name == "":
+		// Any type, null included
+	case field.Nullable && name != "null":
+		node.Set("type", []interface{}{name, "null"})
+	default:
+		node.Set("type", name)
+	}
+	if format != "" {
+		node.Set("format", format)
+	}
+	if encoding != "" {
+		node.Set("contentEncoding", encoding)
+	}
+	if len(field.Enum) > 0 {
+		node.Set("enum", field.Enum)
+	}
+	if field.Items != nil {
+		node.Set("items", jsonSchema(field.Items))
+	}
+	return node
+}
+
+// LoadJSONSchema reads a schema from a JSON Schema document, or from the
+// file at source when it is not a document.
+func LoadJSONSchema(source string) (*Schema, error) {
+	if strings.HasPrefix(strings.TrimSpace(source), "{") {
+		return ParseJSONSchema([]byte(source))
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := ParseJSONSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return schema, nil
+}
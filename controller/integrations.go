@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"github.com/SkySingh04/fractal/registry"
+	"gofr.dev/pkg/gofr"
+)
+
+// IntegrationsHandler describes every registered source and destination,
+// including the configuration options each one accepts.
+func IntegrationsHandler(ctx *gofr.Context) (interface{}, error) {
+	return map[string][]registry.Metadata{
+		"sources":      registry.Sources(),
+		"destinations": registry.Destinations(),
+	}, nil
+}
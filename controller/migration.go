@@ -1,16 +1,22 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 
+	"github.com/SkySingh04/fractal/checkpoint"
 	"github.com/SkySingh04/fractal/factory"
 	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
 	"gofr.dev/pkg/gofr"
 )
 
 func RegisterRoutes(app *gofr.App) {
 	app.POST("/migrate", MigrationHandler)
+	app.GET("/integrations", IntegrationsHandler)
+	app.POST("/rules/check", RulesCheckHandler)
 }
 
 func MigrationHandler(ctx *gofr.Context) (interface{}, error) {
@@ -19,37 +25,99 @@ func MigrationHandler(ctx *gofr.Context) (interface{}, error) {
 		// Log detailed error to understand the bind issue
 		return nil, fmt.Errorf("failed to bind request: %v", err)
 	}
-	return runMigration(req)
+	// The request context is cancelled when the client goes away
+	return runMigration(ctx, req)
 }
 
-func runMigration(req interfaces.Request) (interface{}, error) {
-	// Create source
-	input, err := factory.CreateSource(req.Input)
+func runMigration(ctx context.Context, req interfaces.Request) (interface{}, error) {
+	// Reference datasets are loaded by the migration when its rules read them
+	references, err := factory.CreateReferences(req)
+	if err != nil {
+		log.Printf("Error creating references: %v", err)
+		return nil, fmt.Errorf("failed to create references: %v", err)
+	}
+	ctx = pipeline.WithReferences(ctx, references)
+
+	if len(req.Pipeline.Stages) > 0 {
+		return runPipeline(ctx, req)
+	}
+
+	// Create the source, combining every input of a fan-in migration
+	input, err := factory.CreateInputs(req)
 	if err != nil {
 		log.Printf("Error creating source for input method %s: %v", req.Input, err)
 		return nil, fmt.Errorf("failed to create source for input method %s: %v", req.Input, err)
 	}
 
-	// Create destination
-	output, err := factory.CreateDestination(req.Output)
+	// Create a destination for every output
+	targets, err := factory.CreateTargets(req)
 	if err != nil {
-		log.Printf("Error creating destination for output method %s: %v", req.Output, err)
-		return nil, fmt.Errorf("failed to create destination for output method %s: %v", req.Output, err)
+		log.Printf("Error creating destinations: %v", err)
+		return nil, fmt.Errorf("failed to create destinations: %v", err)
 	}
 
-	// Fetch data from the source
-	data, err := input.FetchData(req)
+	// Resume from the last checkpoint when the request enables them
+	tracker, err := factory.CreateTracker(ctx, req)
 	if err != nil {
-		log.Printf("Error fetching data from source: %v", err)
-		return nil, fmt.Errorf("failed to fetch data from source: %v", err)
+		log.Printf("Error loading checkpoint: %v", err)
+		return nil, fmt.Errorf("failed to load checkpoint: %v", err)
+	}
+	if tracker != nil {
+		defer tracker.Close()
+		ctx = checkpoint.WithTracker(ctx, tracker)
 	}
 
-	// Send data to the destination
-	if err := output.SendData(data, req); err != nil {
-		log.Printf("Error sending data to destination: %v", err)
-		return nil, fmt.Errorf("failed to send data to destination: %v", err)
+	// Read the source once and stream records to every output
+	result, err := pipeline.FanOut(ctx, input, targets, req, req.FanOut)
+	if err != nil {
+		log.Printf("Error running migration: %v", err)
+		return nil, err
+	}
+	return migrationResponse(result), nil
+}
+
+// badRequest is an error in the request itself, answered with status 400.
+type badRequest struct{ error }
+
+// StatusCode is the status gofr answers the error with.
+func (badRequest) StatusCode() int { return http.StatusBadRequest }
+
+// runPipeline runs a migration described as a DAG of stages.
+func runPipeline(ctx context.Context, req interfaces.Request) (interface{}, error) {
+	graph, err := factory.CreateGraph(req)
+	if err != nil {
+		log.Printf("Error creating pipeline: %v", err)
+		return nil, badRequest{fmt.Errorf("failed to create pipeline: %v", err)}
+	}
+
+	result, err := graph.Run(ctx)
+	if err != nil {
+		log.Printf("Error running pipeline: %v", err)
+		return nil, err
+	}
+	return migrationResponse(result), nil
+}
+
+// migrationResponse reports the records written to each output, the error
+// of every failed one and the records dropped by filter rules and dedups.
+func migrationResponse(result pipeline.Result) map[string]interface{} {
+	outputs := make([]map[string]interface{}, len(result.Outputs))
+	for i, output := range result.Outputs {
+		outputs[i] = map[string]interface{}{"name": output.Name, "records": output.Records}
+		if output.Err != nil {
+			outputs[i]["error"] = output.Err.Error()
+		}
+	}
+	response := map[string]interface{}{"status": "success", "outputs": outputs}
+	if len(result.Dropped) > 0 {
+		response["dropped"] = result.Dropped
+	}
+	if len(result.Failed()) > 0 {
+		log.Println("Migration completed with failed outputs")
+		response["status"] = "partial"
+		return response
 	}
 
 	log.Println("Migration successful!")
-	return map[string]string{"status": "success"}, nil
+	return response
 }
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/SkySingh04/fractal/language"
+	"gofr.dev/pkg/gofr"
+)
+
+// rulesCheckRequest is the body of a request to check rules.
+type rulesCheckRequest struct {
+	Rules string `json:"rules"`
+	Kind  string `json:"kind"` // validation, transformation or aggregation, any kind when empty
+}
+
+// RulesCheckHandler reports every error in a rule text with its position,
+// the offending token, what was expected instead and a suggestion, so editors
+// can check rules as they are written.
+func RulesCheckHandler(ctx *gofr.Context) (interface{}, error) {
+	var req rulesCheckRequest
+	if err := ctx.Bind(&req); err != nil {
+		return nil, fmt.Errorf("failed to bind request: %v", err)
+	}
+	switch req.Kind {
+	case "", language.Validation, language.Transformation, language.Aggregation:
+	default:
+		return nil, fmt.Errorf("unknown kind %q, expected %s, %s or %s", req.Kind, language.Validation, language.Transformation, language.Aggregation)
+	}
+	diagnostics := language.Diagnose(req.Rules, req.Kind)
+	if diagnostics == nil {
+		diagnostics = []*language.SyntaxError{}
+	}
+	return map[string]interface{}{
+		"valid":       len(diagnostics) == 0,
+		"diagnostics": diagnostics,
+	}, nil
+}
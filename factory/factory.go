@@ -1,24 +1,168 @@
 package factory
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/SkySingh04/fractal/checkpoint"
 	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
 	"github.com/SkySingh04/fractal/registry"
 )
 
-func CreateSource(name string) (interfaces.DataSource, error) {
-	source, exists := registry.GetSource(name)
-	if !exists {
-		return nil, fmt.Errorf("source %s not found", name)
+// CreateSource creates the named source and configures it from config.
+func CreateSource(name string, config map[string]interface{}) (interfaces.DataSource, error) {
+	return registry.NewSource(name, config)
+}
+
+// CreateDestination creates the named destination and configures it from config.
+func CreateDestination(name string, config map[string]interface{}) (interfaces.DataDestination, error) {
+	return registry.NewDestination(name, config)
+}
+
+// CreateTargets creates a destination for every output of req. Outputs are
+// checked up front, so a misconfigured output fails the migration before
+// the source is read.
+func CreateTargets(req interfaces.Request) ([]pipeline.Target, error) {
+	outputs := req.Destinations()
+	targets := make([]pipeline.Target, len(outputs))
+	names := make(map[string]bool, len(outputs))
+	for i, output := range outputs {
+		if names[output.Name] {
+			return nil, fmt.Errorf("duplicate output name %s, give each output a unique name", output.Name)
+		}
+		names[output.Name] = true
+		destination, err := CreateDestination(output.Output, output.OutputConfig)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", output.Name, err)
+		}
+		targets[i] = pipeline.Target{
+			Name:        output.Name,
+			Destination: destination,
+			Request:     req.ForOutput(output),
+		}
+		// Only the outputs of a fan-out choose their own policy; the
+		// top-level error handling of a single output does not
+		if len(req.Outputs) > 0 {
+			targets[i].ErrorHandling = output.ErrorHandling
+		}
+	}
+	return targets, nil
+}
+
+// CreateInputs creates a source for every input of req and combines them as
+// described by req.Merge. Without Inputs it creates the single source named
+// by req.Input.
+func CreateInputs(req interfaces.Request) (interfaces.DataSource, error) {
+	if len(req.Inputs) == 0 {
+		return CreateSource(req.Input, req.InputConfig)
+	}
+	sources := req.Sources()
+	inputs := make([]pipeline.Input, len(sources))
+	names := make(map[string]bool, len(sources))
+	for i, input := range sources {
+		if names[input.Name] {
+			return nil, fmt.Errorf("duplicate input name %s, give each input a unique name", input.Name)
+		}
+		names[input.Name] = true
+		source, err := CreateSource(input.Input, input.InputConfig)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", input.Name, err)
+		}
+		inputs[i] = pipeline.Input{
+			Name:    input.Name,
+			Source:  source,
+			Request: req.ForInput(input),
+			Key:     input.Key,
+		}
+	}
+	return pipeline.Combine(inputs, req.Merge)
+}
+
+// CreateGraph creates the integrations of every source and sink of
+// req.Pipeline and connects the stages into a graph. The structure of the
+// pipeline is checked before any integration is created. Pipelines are not
+// checkpointed, so a request that also sets a checkpoint is rejected.
+func CreateGraph(req interfaces.Request) (*pipeline.Graph, error) {
+	if err := pipeline.ValidateStages(req.Pipeline.Stages); err != nil {
+		return nil, err
+	}
+	if req.Checkpoint.Store != "" {
+		return nil, fmt.Errorf("checkpoints are not supported for pipelines of stages, remove the checkpoint setting")
+	}
+	nodes := make([]pipeline.Node, len(req.Pipeline.Stages))
+	for i, stage := range req.Pipeline.Stages {
+		nodes[i].Stage = stage
+		var err error
+		switch stage.Type {
+		case interfaces.StageSource:
+			nodes[i].Source, err = CreateSource(stage.Integration, stage.Config)
+			nodes[i].Request = interfaces.Request{Input: stage.Integration, InputConfig: stage.Config, ErrorHandling: req.ErrorHandling}
+		case interfaces.StageSink:
+			nodes[i].Destination, err = CreateDestination(stage.Integration, stage.Config)
+			nodes[i].Request = interfaces.Request{Output: stage.Integration, OutputConfig: stage.Config, ErrorHandling: req.ErrorHandling}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+	}
+	return pipeline.NewGraph(nodes)
+}
+
+// CreateReferences creates the source of every reference dataset of req,
+// read by the LOOKUP function of its rules. The datasets are loaded when a
+// migration using them starts.
+func CreateReferences(req interfaces.Request) (*pipeline.References, error) {
+	references := make([]pipeline.Reference, len(req.References))
+	for i, reference := range req.References {
+		source, err := CreateSource(reference.Input, reference.InputConfig)
+		if err != nil {
+			return nil, fmt.Errorf("reference %s: %w", reference.Name, err)
+		}
+		var refresh time.Duration
+		if reference.Refresh != "" {
+			refresh, err = time.ParseDuration(reference.Refresh)
+			if err != nil || refresh <= 0 {
+				return nil, fmt.Errorf("reference %s: invalid refresh %q, expected a duration such as 10m", reference.Name, reference.Refresh)
+			}
+		}
+		references[i] = pipeline.Reference{
+			Name:    reference.Name,
+			Source:  source,
+			Request: interfaces.Request{Input: reference.Input, InputConfig: reference.InputConfig},
+			Refresh: refresh,
+		}
 	}
-	return source, nil
+	return pipeline.NewReferences(references)
 }
 
-func CreateDestination(name string) (interfaces.DataDestination, error) {
-	destination, exists := registry.GetDestination(name)
-	if !exists {
-		return nil, fmt.Errorf("destination %s not found", name)
+// CreateTracker opens the checkpoint store of req and loads the position of
+// its migration. It returns nil when req does not enable checkpoints. The
+// caller closes the tracker once the migration no longer runs.
+func CreateTracker(ctx context.Context, req interfaces.Request) (*checkpoint.Tracker, error) {
+	if req.Checkpoint.Store == "" {
+		return nil, nil
+	}
+	name := req.Checkpoint.Name
+	if name == "" {
+		outputs := req.Destinations()
+		names := make([]string, len(outputs))
+		for i, output := range outputs {
+			names[i] = output.Name
+		}
+		name = req.Input + "->" + strings.Join(names, ",")
+	}
+
+	store, err := checkpoint.Open(req.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	tracker, err := checkpoint.NewTracker(ctx, store, name)
+	if err != nil {
+		store.Close()
+		return nil, err
 	}
-	return destination, nil
+	return tracker, nil
 }
@@ -1,19 +1,17 @@
 package integrations
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
+	"github.com/SkySingh04/fractal/checkpoint"
 	"github.com/SkySingh04/fractal/interfaces"
-	"github.com/SkySingh04/fractal/language"
 	"github.com/SkySingh04/fractal/logger"
 	"github.com/SkySingh04/fractal/registry"
 )
@@ -64,148 +62,97 @@ func WriteCSV(fileName string, data []byte) error {
 
 // CSVSource struct represents the configuration for consuming messages from CSV.
 type CSVSource struct {
-	CSVSourceFileName string `json:"csv_source_file_name"`
+	FileName string `json:"csv_source_file_name" validate:"required" description:"Path of the CSV file to read" example:"input.csv"`
 }
 
 // CSVDestination struct represents the configuration for publishing messages to CSV.
 type CSVDestination struct {
-	CSVDestinationFileName string `json:"csv_destination_file_name"`
+	FileName string `json:"csv_destination_file_name" validate:"required" description:"Path of the CSV file to write" example:"output.csv"`
 }
 
-// FetchData connects to CSV, retrieves data, and processes it concurrently.
-func (r CSVSource) FetchData(req interfaces.Request) (interface{}, error) {
-	logger.Infof("Reading data from CSV Source: %s", req.CSVSourceFileName)
+// FetchData streams rows from a CSV file as records. The header row supplies
+// the field names and every value is read as a string.
+//
+// A checkpointed migration records the byte offset of the last row written
+// and resumes after it, so rows appended to the file are read by the next run.
+func (r CSVSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	logger.Infof("Reading data from CSV Source: %s", r.FileName)
 
-	if req.CSVSourceFileName == "" {
-		return nil, errors.New("missing CSV source file name")
+	if r.FileName == "" {
+		return errors.New("missing CSV source file name")
 	}
 
-	// Create channels for processing pipeline
-	dataChan := make(chan string, bufferSize)
-	validChan := make(chan string, bufferSize)
-	transformedChan := make(chan string, bufferSize)
+	// Rows are read on their own goroutine so parsing overlaps with processing
+	var offset int64
+	if value, ok := checkpoint.Resume(ctx)[r.FileName]; ok {
+		offset, _ = strconv.ParseInt(value, 10, 64)
+	}
+	dataChan := make(chan csvRow, bufferSize)
 	errChan := make(chan error, 1)
-
-	var wg sync.WaitGroup
-
-	// Start concurrent CSV reading
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		if err := readCSVConcurrently(req.CSVSourceFileName, dataChan, errChan); err != nil {
-			errChan <- err
-		}
-		close(dataChan)
+		defer close(dataChan)
+		errChan <- readCSVConcurrently(ctx, r.FileName, offset, dataChan)
 	}()
 
-	// Start concurrent validation
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for data := range dataChan {
-			if validData, err := validateCSVData([]byte(data), req.ValidationRules); err != nil {
-				errChan <- err
-			} else {
-				validChan <- string(validData)
-			}
+	var schema *interfaces.Schema
+	var procErr error
+	for row := range dataChan {
+		if procErr != nil {
+			continue // Drain the reader so it can exit
 		}
-		close(validChan)
-	}()
-
-	// Start concurrent transformation
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for validData := range validChan {
-			dataRecieved, _ := transformCSVData([]byte(validData), req.TransformationRules)
-			transformedChan <- string(dataRecieved)
+		if schema == nil {
+			schema = csvSchema(r.FileName, row.values)
+			continue
 		}
-		close(transformedChan)
-	}()
 
-	// Wait for all goroutines to finish
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
-
-	// Collect results or errors
-	var results []string
-	for transformedData := range transformedChan {
-		results = append(results, transformedData)
+		record, err := csvRowToRecord(schema, row.values)
+		if err != nil {
+			procErr = err
+			continue
+		}
+		if err := checkpoint.Send(ctx, out, record, r.FileName, strconv.FormatInt(row.offset, 10)); err != nil {
+			procErr = err
+		}
 	}
 
-	// Check for errors
-	if err, ok := <-errChan; ok {
-		return nil, err
+	if err := <-errChan; err != nil {
+		return err
 	}
-
-	return strings.Join(results, "\n"), nil
+	return procErr
 }
 
-// SendData writes data to a CSV file concurrently.
-func (r CSVDestination) SendData(data interface{}, req interfaces.Request) error {
-	logger.Infof("Writing data to CSV Destination: %s", req.CSVDestinationFileName)
+// SendData writes each streamed record to a CSV file as it arrives. The field
+// names of the first record become the header row.
+func (r CSVDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	logger.Infof("Writing data to CSV Destination: %s", r.FileName)
 
-	if req.CSVDestinationFileName == "" {
+	if r.FileName == "" {
 		return errors.New("missing CSV destination file name")
 	}
 
-	// Convert data to a slice of strings for writing
-	lines, ok := data.(string)
-	if !ok {
-		return errors.New("invalid data format for CSV destination")
-	}
-	records := strings.Split(lines, "\n")
-
-	// Write concurrently
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- writeCSVConcurrently(req.CSVDestinationFileName, records)
-	}()
-
-	// Check for errors
-	if err := <-errChan; err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// readCSVConcurrently reads the content of a CSV file and sends records to a channel.
-func readCSVConcurrently(fileName string, out chan<- string, errChan chan<- error) error {
-	file, err := os.Open(fileName)
+	file, err := os.Create(r.FileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	writer := csv.NewWriter(file)
+	var header []string
 	for {
-		record, err := reader.Read()
+		record, err := interfaces.Receive(ctx, in)
 		if err != nil {
-			if errors.Is(err, os.ErrClosed) || errors.Is(err, io.EOF) {
-				break
-			}
-			errChan <- err
 			return err
 		}
-		out <- strings.Join(record, ",")
-	}
-	return nil
-}
-
-// writeCSVConcurrently writes data records to a CSV file concurrently.
-func writeCSVConcurrently(fileName string, records []string) error {
-	file, err := os.Create(fileName)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	for _, record := range records {
-		if err := writer.Write(strings.Split(record, ",")); err != nil {
+		if record == nil {
+			break
+		}
+		if header == nil {
+			header = record.Names()
+			if err := writer.Write(header); err != nil {
+				return err
+			}
+		}
+		if err := writer.Write(recordToCSVRow(record, header)); err != nil {
 			return err
 		}
 	}
@@ -213,352 +160,106 @@ func writeCSVConcurrently(fileName string, records []string) error {
 	return writer.Error()
 }
 
-// applyValidationRule processes a single record against a validation rule AST node.
-func applyValidationRule(record string, headers []string, ruleNode language.Node) error {
-	// Split the record into fields (assuming CSV format)
-	logger.Infof("Inside of applyValidationRule , record: %s , headers: %v , ruleNode: %v", record, headers, ruleNode)
-	fields := strings.Split(record, ",")
-
-	// Ensure the number of fields matches the number of headers
-	if len(fields) != len(headers) {
-		return fmt.Errorf("mismatch between headers and fields: headers=%v, fields=%v", headers, fields)
-	}
-	logger.Infof("Headers: %v", headers)
-	logger.Infof("Fields: %v", fields)
-
-	// Map field names to their values based on headers
-	fieldMap := map[string]string{}
-	for i, header := range headers {
-		fieldMap[header] = strings.TrimSpace(fields[i])
-	}
-
-	// Log the constructed field map
-	fmt.Printf("Constructed FieldMap: %v", fieldMap)
-
-	// Evaluate the ruleNode recursively
-	logger.Infof("Evaluating rule: %s", ruleNode.Value)
-	if err := evaluateNode(&ruleNode, fieldMap); err != nil {
-		return err
-	}
-
-	return nil
+// csvSchema describes the columns of a CSV file from its header row. The
+// schema is named after the file, without its extension.
+func csvSchema(fileName string, header []string) *interfaces.Schema {
+	schema := &interfaces.Schema{Name: collectionName(fileName)}
+	for _, name := range header {
+		schema.Fields = append(schema.Fields, interfaces.FieldSchema{
+			Name: strings.TrimSpace(name),
+			Type: interfaces.TypeString,
+		})
+	}
+	return schema
 }
 
-// validateCSVData ensures the input data meets the required criteria using validation rules.
-func validateCSVData(data []byte, validationRules string) ([]byte, error) {
-
-	// logger.Infof("Validating data: %s", data)
-
-	if len(data) == 0 {
-		return nil, errors.New("data is empty")
-	}
-	logger.Infof("validationRules: %s", validationRules)
-
-	// Initialize lexer and tokenize the validation rules
-	lexer := language.NewLexer(validationRules)
-	tokens, err := lexer.Tokenize(validationRules)
-	if err != nil {
-		return nil, fmt.Errorf("failed to tokenize validation rules: %v", err)
-	}
-	logger.Infof("Tokens: %v", tokens)
-
-	// Parse the tokens into an AST
-	parser := language.NewParser()
-	// var tokenValues []string
-	// for _, token := range tokens {
-	// 	tokenValues = append(tokenValues, token.Value)
-	// }
-	rulesAST, err := parser.ParseRules(tokens)
-	if err != nil {
-		fmt.Println("hi")
-		return nil, fmt.Errorf("failed to parse validation rules: %v", err)
-	}
-
-	// Apply validation rules to data
-	records := strings.Split(strings.TrimSpace(string(data)), "\n")
-
-	// Extract headers from the first line
-	headers := strings.Split(records[0], ",")
-	for i := range headers {
-		headers[i] = strings.TrimSpace(headers[i]) // Trim whitespace from headers
+// csvRowToRecord pairs the values of a row with the column names of schema.
+func csvRowToRecord(schema *interfaces.Schema, row []string) (*interfaces.Record, error) {
+	if len(row) != len(schema.Fields) {
+		return nil, fmt.Errorf("mismatch between headers and fields: headers=%d, fields=%v", len(schema.Fields), row)
 	}
-	//remove the header
-	records = records[1:]
-	logger.Infof("Validating %d records", len(records))
-	for _, record := range records {
-		logger.Infof("Validating record: %s", record)
-		logger.Infof("Number of validation rules: %d", len(rulesAST.Children))
-		for _, ruleNode := range rulesAST.Children {
-			err := applyValidationRule(record, headers, *ruleNode)
-			if err != nil {
-				return nil, err // Return the first validation error encountered
-			}
-		}
+	record := &interfaces.Record{Schema: schema, Fields: make([]interfaces.Field, len(row))}
+	for i, value := range row {
+		record.Fields[i] = interfaces.Field{Name: schema.Fields[i].Name, Value: value}
 	}
-
-	return data, nil
-
+	return record, nil
 }
 
-// transformCSVData modifies the input data as per business logic using transformation rules.
-func transformCSVData(data []byte, transformationRules string) ([]byte, error) {
-	// logger.Infof("Transforming data: %s", data)
-
-	// Initialize lexer and tokenize the transformation rules
-	lexer := language.NewLexer(transformationRules)
-	tokens, err := lexer.Tokenize(transformationRules)
-	if err != nil {
-		return nil, fmt.Errorf("failed to tokenize transformation rules: %v", err)
-	}
-
-	// Parse the tokens into an AST
-	parser := language.NewParser()
-	// var tokenValues []string
-	// for _, token := range tokens {
-	// 	tokenValues = append(tokenValues, token.Value)
-	// }
-	rulesAST, err := parser.ParseRules(tokens)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse transformation rules: %v", err)
-	}
-
-	// Apply transformation rules to data
-	var transformedRecords []string
-	records := strings.Split(strings.TrimSpace(string(data)), "\n")
-	for _, record := range records {
-		for _, ruleNode := range rulesAST.Children {
-			transformedRecord, err := applyTransformationRule(record, ruleNode)
-			if err != nil {
-				return nil, err
-			}
-			record = transformedRecord // Apply each rule sequentially
+// recordToCSVRow renders the fields of record in header order. Fields that
+// the record does not have are written as empty cells.
+func recordToCSVRow(record *interfaces.Record, header []string) []string {
+	row := make([]string, len(header))
+	for i, name := range header {
+		if value, ok := record.Get(name); ok {
+			row[i] = interfaces.ValueString(value)
 		}
-		transformedRecords = append(transformedRecords, record)
 	}
-
-	return []byte(strings.Join(transformedRecords, "\n")), nil
+	return row
 }
 
-// Helper function to resolve field names
-func resolveField(field string) string {
-	// Check if the field is in the format FIELD("field_name")
-	if strings.HasPrefix(field, `FIELD("`) && strings.HasSuffix(field, `")`) {
-		return strings.TrimSuffix(strings.TrimPrefix(field, `FIELD("`), `")`)
-	}
-	return field // Return as-is if not wrapped
+// csvRow is a row of a CSV file with the byte offset just after it.
+type csvRow struct {
+	values []string
+	offset int64
 }
 
-// Recursive function to evaluate nodes
-func evaluateNode(node *language.Node, fieldMap map[string]string) error {
-	fmt.Printf("Fieldmap in evaluateNode: %v", fieldMap)
-	//print the fields in the fieldMap
-	for key, value := range fieldMap {
-		fmt.Printf("Key: %s, Value: %s", key, value)
-	}
-	switch node.Type {
-	case language.TokenField:
-		return nil // This case is handled within expressions
-
-	case "EXPRESSION":
-		fieldNode := node.Children[0]
-		conditionNode := node.Children[1]
-		valueNode := node.Children[2]
-
-		resolvedField := resolveField(fieldNode.Value) // Resolve FIELD("...") to actual field name
-		logger.Infof("Evaluating expression: %s %s %s", resolvedField, conditionNode.Value, valueNode.Value)
-
-		// Display the FieldMap with fmt.Printf for debugging
-
-		fieldMap := map[string]string{
-			"name": "Alice",
-			"age":  "30",
-			"city": "New York",
-		}
-
-		fmt.Printf("FieldMap: %v\n", fieldMap)
-		// Check if the field exists in FieldMap
-		fieldValue, exists := fieldMap[resolvedField]
-		if !exists {
-			return fmt.Errorf("field %s not found", resolvedField)
-		}
-
-		// Remove quotes from fieldValue
-		fieldValue = strings.Trim(fieldValue, "'\"")
-
-		switch conditionNode.Value {
-		case "TYPE":
-			return evaluateTypeCondition(fieldValue, valueNode.Value)
-		case "RANGE":
-			// fieldValue = strings.ReplaceAll(fieldValue, "'", "")
-			logger.Infof("Field Value: %s", fieldValue)
-			// logger.Infof("Value Node Children: %v", valueNode.Children)
-			// logger.Infof("Value Node Value: %s", valueNode.Value)
-			return evaluateRangeCondition(fieldValue, valueNode.Value)
-		case "MATCHES":
-			return evaluateRegexCondition(fieldValue, valueNode.Value)
-		case "IN":
-			return evaluateInCondition(fieldValue, valueNode.Children)
-		case "REQUIRED":
-			return evaluateRequiredCondition(fieldValue)
-		default:
-			return fmt.Errorf("unsupported condition: %s", conditionNode.Value)
-		}
-
-	case language.TokenLogical:
-		return evaluateLogicalCondition(node, fieldMap)
-	}
-
-	return fmt.Errorf("unknown node type: %s", node.Type)
-}
-
-func evaluateTypeCondition(value, expectedType string) error {
-	switch expectedType {
-	case "STRING":
-		return nil // All values are strings by default
-	case "INT":
-		if _, err := strconv.Atoi(value); err != nil {
-			return fmt.Errorf("value '%s' is not an integer", value)
-		}
-	case "FLOAT":
-		if _, err := strconv.ParseFloat(value, 64); err != nil {
-			return fmt.Errorf("value '%s' is not a float", value)
-		}
-	case "BOOL":
-		if _, err := strconv.ParseBool(value); err != nil {
-			return fmt.Errorf("value '%s' is not a boolean", value)
-		}
-	case "DATE":
-		if _, err := time.Parse("2006-01-02", value); err != nil {
-			return fmt.Errorf("value '%s' is not a valid date", value)
-		}
-	default:
-		return fmt.Errorf("unknown type: %s", expectedType)
-	}
-	return nil
-}
-func evaluateRangeCondition(value string, rangeNodes string) error {
-	//here rangeNodes is a string of min and max values separated by comma like (30 , 50)
-	//split the rangeNodes string into min and max values
-	rangeValues := strings.Split(rangeNodes, ",")
-	//remove the ( from the min value
-	rangeValues[0] = strings.ReplaceAll(rangeValues[0], "(", "")
-	//remove the ) from the max value
-	rangeValues[1] = strings.ReplaceAll(rangeValues[1], ")", "")
-	logger.Infof("Range Values: %v", rangeValues)
-	if len(rangeValues) != 2 {
-		return errors.New("range condition should have two values")
-	}
-	minValue, err1 := strconv.ParseInt(rangeValues[0], 10, 64)
-	maxValue, err2 := strconv.ParseInt(rangeValues[1], 10, 64)
-	if err1 != nil || err2 != nil {
-		return errors.New("range values should be numbers")
-	}
-	fieldValue, err := strconv.ParseInt(value, 10, 64)
+// readCSVConcurrently reads the rows of a CSV file and sends them to a channel.
+// The header row is always sent first. A positive offset skips the rows
+// before it, unless the file is now shorter, in which case it is read again
+// from the start.
+func readCSVConcurrently(ctx context.Context, fileName string, offset int64, out chan<- csvRow) error {
+	file, err := os.Open(fileName)
 	if err != nil {
-		return errors.New("field value should be a number")
-	}
-	if fieldValue < minValue || fieldValue > maxValue {
-		return fmt.Errorf("value '%s' not in range", value)
-	}
-	return nil
-}
-
-func evaluateRegexCondition(value, pattern string) error {
-	matched, err := regexp.MatchString(pattern, value)
-	if err != nil || !matched {
-		return fmt.Errorf("value '%s' does not match pattern", value)
-	}
-	return nil
-}
-func evaluateInCondition(value string, allowedValues []*language.Node) error {
-	for _, valNode := range allowedValues {
-		if value == valNode.Value {
-			return nil
-		}
-	}
-	return fmt.Errorf("value '%s' not in allowed list", value)
-}
-func evaluateRequiredCondition(value string) error {
-	if strings.TrimSpace(value) == "" {
-		return errors.New("field is required and cannot be empty")
+		return err
 	}
-	return nil
-}
-
-func evaluateLogicalCondition(node *language.Node, fieldMap map[string]string) error {
-	leftErr := evaluateNode(node.Children[0], fieldMap)
-	rightErr := evaluateNode(node.Children[1], fieldMap)
+	defer file.Close()
 
-	switch node.Value {
-	case "AND":
-		if leftErr != nil || rightErr != nil {
-			return errors.New("AND condition failed")
-		}
-	case "OR":
-		if leftErr != nil && rightErr != nil {
-			return errors.New("OR condition failed")
+	reader := csv.NewReader(file)
+	var base int64 // Offset the reader started at
+	header := true
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) || errors.Is(err, io.EOF) {
+				break
+			}
+			return err
 		}
-	case "NOT":
-		if leftErr == nil {
-			return errors.New("NOT condition failed")
+		select {
+		case out <- csvRow{values: record, offset: base + reader.InputOffset()}:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-	default:
-		return fmt.Errorf("unknown logical operator: %s", node.Value)
-	}
-	return nil
-}
 
-// Evaluate individual condition (fieldValue compared to ruleValue)
-func evaluateCondition(fieldValue, operator, ruleValue string) error {
-	switch operator {
-	case "==":
-		if fieldValue != ruleValue {
-			return fmt.Errorf("expected %s, got %s", ruleValue, fieldValue)
-		}
-	case "!=":
-		if fieldValue == ruleValue {
-			return fmt.Errorf("field value should not be %s", ruleValue)
-		}
-	case ">":
-		fieldNum, err1 := strconv.ParseFloat(fieldValue, 64)
-		ruleNum, err2 := strconv.ParseFloat(ruleValue, 64)
-		if err1 != nil || err2 != nil || fieldNum <= ruleNum {
-			return fmt.Errorf("expected greater than %s, got %s", ruleValue, fieldValue)
-		}
-	case "<":
-		fieldNum, err1 := strconv.ParseFloat(fieldValue, 64)
-		ruleNum, err2 := strconv.ParseFloat(ruleValue, 64)
-		if err1 != nil || err2 != nil || fieldNum >= ruleNum {
-			return fmt.Errorf("expected less than %s, got %s", ruleValue, fieldValue)
-		}
-	case ">=":
-		fieldNum, err1 := strconv.ParseFloat(fieldValue, 64)
-		ruleNum, err2 := strconv.ParseFloat(ruleValue, 64)
-		if err1 != nil || err2 != nil || fieldNum < ruleNum {
-			return fmt.Errorf("expected greater than or equal to %s, got %s", ruleValue, fieldValue)
-		}
-	case "<=":
-		fieldNum, err1 := strconv.ParseFloat(fieldValue, 64)
-		ruleNum, err2 := strconv.ParseFloat(ruleValue, 64)
-		if err1 != nil || err2 != nil || fieldNum > ruleNum {
-			return fmt.Errorf("expected less than or equal to %s, got %s", ruleValue, fieldValue)
+		// Skip to the checkpoint once the header has been read
+		if header && offset > reader.InputOffset() {
+			info, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			if offset > info.Size() {
+				logger.Warnf("CSV file %s is shorter than its checkpoint, reading it from the start", fileName)
+			} else {
+				if _, err := file.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+				reader = csv.NewReader(file)
+				base = offset
+			}
 		}
-
-	default:
-		return fmt.Errorf("unsupported operator %s", operator)
+		header = false
 	}
 	return nil
 }
 
-// applyTransformationRule processes a single record against a transformation rule AST node.
-func applyTransformationRule(record string, ruleNode *language.Node) (string, error) {
-	// Implementation details based on your business logic
-	// Transform the record using the information from ruleNode
-	return record, nil // Replace with actual transformation logic
-}
-
 // Initialize the CSV integrations by registering them with the registry.
 func init() {
 	registry.RegisterSource("CSV", CSVSource{})
 	registry.RegisterDestination("CSV", CSVDestination{})
+	registry.Describe("CSV", registry.Info{
+		DisplayName:  "CSV",
+		Description:  "Reads and writes comma separated files. The header row names the fields.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch, registry.CapabilitySchemaAware},
+	})
 }
@@ -1,16 +1,19 @@
 package integrations
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/SkySingh04/fractal/logger"
 	"github.com/SkySingh04/fractal/registry"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
@@ -39,30 +42,47 @@ func (m *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, er
 	return nil, errors.New("table not found")
 }
 
+// ScanWithContext is Scan bounded by ctx, as in the DynamoDB client.
+func (m *MockDynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Scan(input)
+}
+
 func (m *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
 	// Simulate a successful PutItem operation
 	return &dynamodb.PutItemOutput{}, nil
 }
 
+// PutItemWithContext is PutItem bounded by ctx, as in the DynamoDB client.
+func (m *MockDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.PutItem(input)
+}
+
 // DynamoDBSource represents the configuration for reading data from DynamoDB.
 type DynamoDBSource struct {
-	TableName string `json:"table_name"`
-	Region    string `json:"region"`
+	TableName string `json:"table_name" validate:"required" description:"Name of the DynamoDB table" example:"orders"`
+	Region    string `json:"region" validate:"required" description:"AWS region of the table" example:"us-east-1"`
 }
 
 // DynamoDBDestination represents the configuration for writing data to DynamoDB.
 type DynamoDBDestination struct {
-	TableName string `json:"table_name"`
-	Region    string `json:"region"`
+	TableName string `json:"table_name" validate:"required" description:"Name of the DynamoDB table" example:"orders"`
+	Region    string `json:"region" validate:"required" description:"AWS region of the table" example:"us-east-1"`
 }
 
-// FetchData retrieves data from the source DynamoDB table in the specified region.
-func (d DynamoDBSource) FetchData(req interfaces.Request) (interface{}, error) {
-	logger.Infof("Connecting to DynamoDB Source: Table=%s, Region=%s", req.DynamoDBSourceTable, req.DynamoDBSourceRegion)
+// FetchData scans the source DynamoDB table page by page and streams each
+// validated and transformed item as a record.
+func (d DynamoDBSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	logger.Infof("Connecting to DynamoDB Source: Table=%s, Region=%s", d.TableName, d.Region)
 
-	// Validate the request
-	if err := validateDynamoDBRequest(req, true); err != nil {
-		return nil, err
+	// Validate the config
+	if err := validateDynamoDBConfig(d.TableName, d.Region); err != nil {
+		return err
 	}
 
 	// Mock DynamoDB client
@@ -70,151 +90,224 @@ func (d DynamoDBSource) FetchData(req interfaces.Request) (interface{}, error) {
 
 	// Scan the table
 	input := &dynamodb.ScanInput{
-		TableName: aws.String(req.DynamoDBSourceTable),
+		TableName: aws.String(d.TableName),
 	}
 
-	result, err := mockDynamoDB.Scan(input)
-	if err != nil {
-		return nil, err
-	}
-
-	// Handle empty result
-	if len(result.Items) == 0 {
-		logger.Logf("No data retrieved from DynamoDB table: %s", req.DynamoDBSourceTable)
-		return nil, errors.New("no data retrieved from DynamoDB")
-	}
-
-	// Create channels for concurrency
-	dataChannel := make(chan map[string]interface{}, len(result.Items))
-	errorChannel := make(chan error, len(result.Items))
-	var wg sync.WaitGroup
-
-	// Process and transform items concurrently using goroutines
-	for _, item := range result.Items {
-		wg.Add(1)
-		go func(item map[string]*dynamodb.AttributeValue) {
-			defer wg.Done()
+	schema := &interfaces.Schema{Name: d.TableName}
+	count := 0
+	for {
+		result, err := mockDynamoDB.ScanWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
 
+		for _, item := range result.Items {
 			// Validate data
 			validatedData, err := validateDynamoDBData(item)
 			if err != nil {
-				errorChannel <- fmt.Errorf("validation failed for item: %v, Error: %s", item, err)
-				return
+				return fmt.Errorf("validation failed for item: %v, Error: %s", item, err)
 			}
 
-			// Transform data
-			transformedData := transformDynamoDBData(validatedData)
-
-			// Convert transformed data (map[string]*dynamodb.AttributeValue) to map[string]interface{}
-			interfaceData := make(map[string]interface{})
-			for key, value := range transformedData {
-				if value.S != nil {
-					interfaceData[key] = *value.S
-				} else if value.N != nil {
-					interfaceData[key] = *value.N
-				} else if value.BOOL != nil {
-					interfaceData[key] = *value.BOOL
-				}
+			// Transform data and convert it to a record
+			record, err := DynamoDBItemToRecord(transformDynamoDBData(validatedData))
+			if err != nil {
+				return err
 			}
+			record.Schema = schema
+			if err := interfaces.Send(ctx, out, record); err != nil {
+				return err
+			}
+			count++
+		}
 
-			// Send processed data to the channel
-			dataChannel <- interfaceData
-		}(item)
-	}
-
-	// Wait for all goroutines to finish
-	wg.Wait()
-
-	// Close channels after processing
-	close(dataChannel)
-	close(errorChannel)
-
-	// Check for errors
-	if len(errorChannel) > 0 {
-		return nil, <-errorChannel
-	}
-
-	// Collect and return the processed data
-	var processedData []map[string]interface{}
-	for data := range dataChannel {
-		processedData = append(processedData, data)
+		// Continue with the next page, if any
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
 	}
 
-	if len(processedData) == 0 {
-		return nil, errors.New("no valid data processed from DynamoDB")
+	// Handle empty result
+	if count == 0 {
+		logger.Logf("No data retrieved from DynamoDB table: %s", d.TableName)
+		return errors.New("no data retrieved from DynamoDB")
 	}
-
-	return processedData, nil
+	return nil
 }
 
-// SendData writes data to the target DynamoDB table in the specified region.
-func (d DynamoDBDestination) SendData(data interface{}, req interfaces.Request) error {
-	logger.Infof("Connecting to DynamoDB Destination: Table=%s, Region=%s", req.DynamoDBTargetTable, req.DynamoDBTargetRegion)
+// SendData writes each streamed record to the target DynamoDB table in the specified region.
+func (d DynamoDBDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	logger.Infof("Connecting to DynamoDB Destination: Table=%s, Region=%s", d.TableName, d.Region)
 
-	// Validate the request
-	if err := validateDynamoDBRequest(req, false); err != nil {
+	// Validate the config
+	if err := validateDynamoDBConfig(d.TableName, d.Region); err != nil {
 		return err
 	}
 
-	// Ensure the data is of the correct type (map[string]interface{})
-	dataMap, ok := data.(map[string]interface{})
-	if !ok {
-		// Attempt to convert the data to map[string]interface{}
-		dataBytes, err := json.Marshal(data)
+	// Mock DynamoDB client
+	mockDynamoDB := &MockDynamoDB{}
+
+	for {
+		record, err := interfaces.Receive(ctx, in)
 		if err != nil {
-			return fmt.Errorf("failed to marshal data for conversion: %v", err)
+			return err
 		}
-
-		err = json.Unmarshal(dataBytes, &dataMap)
+		if record == nil {
+			break
+		}
+		// Prepare the item
+		item, err := prepareDynamoDBItem(record)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal data for conversion: %v", err)
+			return err
 		}
-	}
 
-	// Mock DynamoDB client
-	mockDynamoDB := &MockDynamoDB{}
+		// Put the item into the target table
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(d.TableName),
+			Item:      item,
+		}
 
-	// Prepare the item
-	item, err := prepareDynamoDBItem(dataMap)
-	if err != nil {
-		return err
+		if _, err := mockDynamoDB.PutItemWithContext(ctx, input); err != nil {
+			return err
+		}
+
+		logger.Infof("Data successfully written to DynamoDB table %s: %v", d.TableName, item)
 	}
+	return nil
+}
 
-	// Put the item into the target table
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(req.DynamoDBTargetTable),
-		Item:      item,
+// DynamoDBItemToRecord converts a DynamoDB item to a record. Attributes are
+// sorted by name since items carry no order. Numbers become int64 when they
+// are integral and fit, and decimals otherwise.
+func DynamoDBItemToRecord(item map[string]*dynamodb.AttributeValue) (*interfaces.Record, error) {
+	names := make([]string, 0, len(item))
+	for name := range item {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	_, err = mockDynamoDB.PutItem(input)
-	if err != nil {
-		return err
+	record := &interfaces.Record{Fields: make([]interfaces.Field, 0, len(item))}
+	for _, name := range names {
+		value, err := fromAttributeValue(item[name])
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		record.Set(name, value)
 	}
+	return record, nil
+}
 
-	logger.Infof("Data successfully written to DynamoDB table %s: %v", req.DynamoDBTargetTable, data)
-	return nil
+func fromAttributeValue(value *dynamodb.AttributeValue) (interface{}, error) {
+	switch {
+	case value == nil, value.NULL != nil:
+		return nil, nil
+	case value.S != nil:
+		return *value.S, nil
+	case value.N != nil:
+		return parseDynamoDBNumber(*value.N)
+	case value.BOOL != nil:
+		return *value.BOOL, nil
+	case value.B != nil:
+		return value.B, nil
+	case value.M != nil:
+		return DynamoDBItemToRecord(value.M)
+	case value.L != nil:
+		items := make([]interface{}, len(value.L))
+		for i, item := range value.L {
+			converted, err := fromAttributeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
+		}
+		return items, nil
+	case value.SS != nil:
+		items := make([]interface{}, len(value.SS))
+		for i, item := range value.SS {
+			items[i] = *item
+		}
+		return items, nil
+	case value.NS != nil:
+		items := make([]interface{}, len(value.NS))
+		for i, item := range value.NS {
+			number, err := parseDynamoDBNumber(*item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = number
+		}
+		return items, nil
+	case value.BS != nil:
+		items := make([]interface{}, len(value.BS))
+		for i, item := range value.BS {
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, errors.New("unsupported attribute value")
 }
 
-// prepareDynamoDBItem converts a map[string]interface{} to a map[string]*dynamodb.AttributeValue
-func prepareDynamoDBItem(data map[string]interface{}) (map[string]*dynamodb.AttributeValue, error) {
-	// Convert the map to a DynamoDB-compatible item
-	item := make(map[string]*dynamodb.AttributeValue)
-	for k, v := range data {
-		switch v := v.(type) {
-		case string:
-			item[k] = &dynamodb.AttributeValue{S: aws.String(v)}
-		case int, int64:
-			item[k] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%v", v))}
-		case bool:
-			item[k] = &dynamodb.AttributeValue{BOOL: aws.Bool(v)}
-		default:
-			return nil, fmt.Errorf("unsupported attribute type for key '%s'", k)
+func parseDynamoDBNumber(number string) (interface{}, error) {
+	if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return i, nil
+	}
+	if strings.ContainsAny(number, "eE") {
+		return strconv.ParseFloat(number, 64)
+	}
+	return interfaces.ParseDecimal(number)
+}
+
+// prepareDynamoDBItem converts a record to a map[string]*dynamodb.AttributeValue
+func prepareDynamoDBItem(record *interfaces.Record) (map[string]*dynamodb.AttributeValue, error) {
+	// Convert the record to a DynamoDB-compatible item
+	item := make(map[string]*dynamodb.AttributeValue, record.Len())
+	for _, field := range record.Fields {
+		value, err := toAttributeValue(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported attribute type for key '%s': %w", field.Name, err)
 		}
+		item[field.Name] = value
 	}
 
 	return item, nil
 }
 
+func toAttributeValue(value interface{}) (*dynamodb.AttributeValue, error) {
+	switch v := value.(type) {
+	case nil:
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	case string:
+		return &dynamodb.AttributeValue{S: aws.String(v)}, nil
+	case bool:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(v)}, nil
+	case int64, float64, interfaces.Decimal:
+		return &dynamodb.AttributeValue{N: aws.String(interfaces.ValueString(v))}, nil
+	case []byte:
+		return &dynamodb.AttributeValue{B: v}, nil
+	case time.Time:
+		// DynamoDB has no timestamp type, so store ISO 8601 text
+		return &dynamodb.AttributeValue{S: aws.String(interfaces.ValueString(v))}, nil
+	case *interfaces.Record:
+		item, err := prepareDynamoDBItem(v)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{M: item}, nil
+	case []interface{}:
+		items := make([]*dynamodb.AttributeValue, len(v))
+		for i, item := range v {
+			converted, err := toAttributeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
+		}
+		return &dynamodb.AttributeValue{L: items}, nil
+	default:
+		return nil, fmt.Errorf("%T", v)
+	}
+}
+
 // validateDynamoDBData ensures the input DynamoDB data meets required criteria.
 func validateDynamoDBData(data map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
 	logger.Infof("Validating DynamoDB data: %v", data)
@@ -239,16 +332,10 @@ func transformDynamoDBData(data map[string]*dynamodb.AttributeValue) map[string]
 	return data
 }
 
-// validateDynamoDBRequest validates the request fields for DynamoDB operations.
-func validateDynamoDBRequest(req interfaces.Request, isSource bool) error {
-	if isSource {
-		if req.DynamoDBSourceTable == "" || req.DynamoDBSourceRegion == "" {
-			return errors.New("missing source DynamoDB table or region")
-		}
-	} else {
-		if req.DynamoDBTargetTable == "" || req.DynamoDBTargetRegion == "" {
-			return errors.New("missing target DynamoDB table or region")
-		}
+// validateDynamoDBConfig validates the table settings for DynamoDB operations.
+func validateDynamoDBConfig(tableName, region string) error {
+	if tableName == "" || region == "" {
+		return errors.New("missing DynamoDB table or region")
 	}
 	return nil
 }
@@ -257,4 +344,10 @@ func validateDynamoDBRequest(req interfaces.Request, isSource bool) error {
 func init() {
 	registry.RegisterSource("DynamoDB", DynamoDBSource{})
 	registry.RegisterDestination("DynamoDB", DynamoDBDestination{})
+	registry.Describe("DynamoDB", registry.Info{
+		DisplayName:  "Amazon DynamoDB",
+		Description:  "Scans a DynamoDB table and writes items with PutItem.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch, registry.CapabilityUpsert},
+	})
 }
@@ -2,15 +2,13 @@ package integrations
 
 import (
 	"context"
-	"encoding/json"
-	_ "errors"
+	"errors"
 	"fmt"
 	_ "strings"
-	"sync"
 	"time"
 
 	firebase "firebase.google.com/go"
-	_ "google.golang.org/api/iterator"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
 	"github.com/SkySingh04/fractal/interfaces"
@@ -18,115 +16,124 @@ import (
 	"github.com/SkySingh04/fractal/registry"
 )
 
+// FirebaseSource reads the documents of a Firestore collection.
 type FirebaseSource struct {
-	CredentialFileAddr string `json:"firebase_credential_file"`
-	Collection         string `json:"firebase_collection"`
-	Document           string `json:"firebase_document"`
+	CredentialFileAddr string `json:"credential_file_addr" default:"firebaseConfig.json" description:"Path of the service account credentials file"`
+	Collection         string `json:"collection" validate:"required" description:"Name of the Firestore collection" example:"users"`
 }
 
+// FirebaseDestination writes records to a Firestore collection.
 type FirebaseDestination struct {
-	CredentialFileAddr string `json:"firebase_credential_file"`
-	Collection         string `json:"firebase_collection"`
-	Document           string `json:"firebase_document"`
+	CredentialFileAddr string `json:"credential_file_addr" default:"firebaseConfig.json" description:"Path of the service account credentials file"`
+	Collection         string `json:"collection" validate:"required" description:"Name of the Firestore collection" example:"users"`
 }
 
-func (f FirebaseSource) FetchData(req interfaces.Request) (interface{}, error) {
-	logger.Infof("Connecting to Firebase Source: Collection=%s, using Service Account=%s", req.Collection, req.CredentialFileAddr)
+// FetchData streams the documents of a Firestore collection as records using
+// the document iterator, so the collection is never loaded into memory at once.
+// The document ID is stored in the _id field.
+func (f FirebaseSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	logger.Infof("Connecting to Firebase Source: Collection=%s, using Service Account=%s", f.Collection, f.CredentialFileAddr)
 
-	opt := option.WithCredentialsFile(req.CredentialFileAddr)
-	app, err := firebase.NewApp(context.Background(), nil, opt)
+	opt := option.WithCredentialsFile(f.CredentialFileAddr)
+	app, err := firebase.NewApp(ctx, nil, opt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Firebase app: %w", err)
+		return fmt.Errorf("failed to initialize Firebase app: %w", err)
 	}
 
-	client, err := app.Firestore(context.Background())
+	client, err := app.Firestore(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Firestore client: %w", err)
+		return fmt.Errorf("failed to initialize Firestore client: %w", err)
 	}
 	defer client.Close()
 
-	docs, err := client.Collection(req.Collection).Documents(context.Background()).GetAll()
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch documents: %w", err)
-	}
-
-	logger.Infof("Fetched documents from Firebase: %d documents", len(docs))
-	for i, doc := range docs {
-		logger.Infof("Document %d ID: %s, Data: %v", i, doc.Ref.ID, doc.Data())
-	}
-
-	var allData []map[string]interface{}
-	for _, doc := range docs {
-		data := doc.Data() 
-		logger.Infof("Fetched data from Firebase: %v", data)
+	docs := client.Collection(f.Collection).Documents(ctx)
+	defer docs.Stop()
 
-		data["_id"] = doc.Ref.ID
+	schema := &interfaces.Schema{Name: f.Collection}
+	count := 0
+	for {
+		doc, err := docs.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch documents: %w", err)
+		}
 
-		validatedData := data            
-		transformedData := validatedData 
+		// The document ID goes first and replaces any stored _id field
+		record := interfaces.RecordFromMap(doc.Data())
+		record.Delete("_id")
+		record.Fields = append([]interfaces.Field{{Name: "_id", Value: doc.Ref.ID}}, record.Fields...)
+		record.Schema = schema
 
-		allData = append(allData, transformedData)
+		if err := interfaces.Send(ctx, out, record); err != nil {
+			return err
+		}
+		count++
 	}
 
-	return allData, nil
+	logger.Infof("Fetched documents from Firebase: %d documents", count)
+	return nil
 }
 
-func (f FirebaseDestination) SendData(data interface{}, req interfaces.Request) error {
-	logger.Infof("Writing data to Firebase database: Collection=%s, Document=%s", req.Collection, req.Document)
+// SendData writes each streamed record to the collection as a new document.
+func (f FirebaseDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	logger.Infof("Writing data to Firebase database: Collection=%s", f.Collection)
 
-	opt := option.WithCredentialsFile(req.CredentialFileAddr)
-	app, err := firebase.NewApp(context.Background(), nil, opt)
+	opt := option.WithCredentialsFile(f.CredentialFileAddr)
+	app, err := firebase.NewApp(ctx, nil, opt)
 	if err != nil {
 		return fmt.Errorf("failed to initialize Firebase app: %w", err)
 	}
 
-	client, err := app.Firestore(context.Background())
+	client, err := app.Firestore(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to initialize Firestore client: %w", err)
 	}
 	defer client.Close()
 
-	var post map[string]interface{}
-	if err := convertToMap(data, &post); err != nil {
-		return fmt.Errorf("failed to convert data: %w", err)
-	}
-
-	errChan := make(chan error, 1)
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		_, err := client.Collection(req.Collection).NewDoc().Create(context.Background(), post)
+	count := 0
+	for {
+		record, err := interfaces.Receive(ctx, in)
 		if err != nil {
-			errChan <- fmt.Errorf("error writing to Firestore: %w", err)
+			return err
 		}
-	}()
-
-	wg.Wait()
-	close(errChan)
+		if record == nil {
+			break
+		}
+		post := toFirestoreValue(record).(map[string]interface{})
 
-	select {
-	case err := <-errChan:
-		return err
-	default:
-		logger.Infof("Successfully written data to Firestore: Collection=%s, Document=%s", req.Collection, req.Document)
-		return nil
+		if _, err := client.Collection(f.Collection).NewDoc().Create(ctx, post); err != nil {
+			return fmt.Errorf("error writing to Firestore: %w", err)
+		}
+		count++
 	}
-}
-
-func convertToMap(data interface{}, result *map[string]interface{}) error {
-	logger.Infof("Firebase data to map: %v", data)
 
-	temp, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal data to JSON: %w", err)
-	}
+	logger.Infof("Successfully written %d documents to Firestore: Collection=%s", count, f.Collection)
+	return nil
+}
 
-	if err := json.Unmarshal(temp, result); err != nil {
-		return fmt.Errorf("failed to unmarshal JSON to map: %w", err)
+// toFirestoreValue converts a canonical value into a type Firestore can
+// store. Firestore numbers are doubles, so decimals lose exactness.
+func toFirestoreValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *interfaces.Record:
+		m := make(map[string]interface{}, v.Len())
+		for _, field := range v.Fields {
+			m[field.Name] = toFirestoreValue(field.Value)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = toFirestoreValue(item)
+		}
+		return items
+	case interfaces.Decimal:
+		return v.Float64()
+	default:
+		return v
 	}
-	return nil
 }
 
 func validateFirebaseData(data map[string]interface{}) (map[string]interface{}, error) {
@@ -150,4 +157,10 @@ func transformFirebaseData(data map[string]interface{}) map[string]interface{} {
 func init() {
 	registry.RegisterSource("Firebase", FirebaseSource{})
 	registry.RegisterDestination("Firebase", FirebaseDestination{})
+	registry.Describe("Firebase", registry.Info{
+		DisplayName:  "Cloud Firestore",
+		Description:  "Reads and writes the documents of a Firestore collection.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch},
+	})
 }
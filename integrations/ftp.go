@@ -1,7 +1,9 @@
 package integrations
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -16,69 +18,71 @@ import (
 
 // FTPSource implements the DataSource interface
 type FTPSource struct {
-	URL         string `json:"url"`
-	User        string `json:"user"`
-	Password    string `json:"password"`
-	FTPFILEPATH string `json:"file_path"`
+	URL      string `json:"url" validate:"required" description:"Address of the FTP server" example:"ftp.example.com:21"`
+	User     string `json:"user" validate:"required" description:"User to log in as" example:"anonymous"`
+	Password string `json:"password" validate:"required" secret:"true" description:"Password of the user"`
+	FilePath string `json:"file_path" validate:"required" description:"Path of the file on the server" example:"/data/records.jsonl"`
 }
 
 // FTPDestination implements the DataDestination interface
 type FTPDestination struct {
-	URL         string `json:"url"`
-	User        string `json:"user"`
-	Password    string `json:"password"`
-	FTPFILEPATH string `json:"file_path"`
+	URL      string `json:"url" validate:"required" description:"Address of the FTP server" example:"ftp.example.com:21"`
+	User     string `json:"user" validate:"required" description:"User to log in as" example:"anonymous"`
+	Password string `json:"password" validate:"required" secret:"true" description:"Password of the user"`
+	FilePath string `json:"file_path" validate:"required" description:"Path of the file on the server" example:"/data/records.jsonl"`
 }
 
-// FetchData fetches data from an FTP server
-func (f FTPSource) FetchData(req interfaces.Request) (interface{}, error) {
-	if err := validateFTPRequest(req, true); err != nil {
-		return nil, err
+// FetchData streams a file from an FTP server line by line
+func (f FTPSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	if err := validateFTPConfig(f.URL, f.User, f.Password, f.FilePath); err != nil {
+		return err
 	}
-	logger.Infof("Connecting to FTP server at %s...", req.FTPURL)
+	logger.Infof("Connecting to FTP server at %s...", f.URL)
 
-	conn, err := dialFTP(req.FTPURL, req.FTPUser, req.FTPPassword)
+	conn, err := dialFTP(ctx, f.URL, f.User, f.Password)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Quit()
 
-	logger.Infof("Downloading file from FTP: %s", req.FTPFILEPATH)
-	resp, err := conn.Retr(req.FTPFILEPATH)
+	logger.Infof("Downloading file from FTP: %s", f.FilePath)
+	resp, err := conn.Retr(f.FilePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve file from FTP: %w", err)
+		return fmt.Errorf("failed to retrieve file from FTP: %w", err)
 	}
 	defer resp.Close()
 
-	data, err := io.ReadAll(resp)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read data from FTP response: %w", err)
+	if err := streamLines(ctx, collectionName(f.FilePath), resp, out); err != nil {
+		return fmt.Errorf("failed to read data from FTP response: %w", err)
 	}
 
 	logger.Infof("Successfully fetched data from FTP.")
-	return data, nil
+	return nil
 }
 
-// SendData sends data to an FTP server
-func (f FTPDestination) SendData(data interface{}, req interfaces.Request) error {
-	if err := validateFTPRequest(req, false); err != nil {
+// SendData streams records to a file on an FTP server, one record per line
+func (f FTPDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	if err := validateFTPConfig(f.URL, f.User, f.Password, f.FilePath); err != nil {
 		return err
 	}
-	logger.Infof("Connecting to FTP server at %s...", req.FTPURL)
+	logger.Infof("Connecting to FTP server at %s...", f.URL)
 
-	conn, err := dialFTP(req.FTPURL, req.FTPUser, req.FTPPassword)
+	conn, err := dialFTP(ctx, f.URL, f.User, f.Password)
 	if err != nil {
 		return err
 	}
 	defer conn.Quit()
 
-	logger.Infof("Uploading file to FTP: %s", req.FTPFILEPATH)
-	dataBytes, ok := data.([]byte)
-	if !ok {
-		return fmt.Errorf("invalid data format; expected []byte, got %T", data)
-	}
+	logger.Infof("Uploading file to FTP: %s", f.FilePath)
+
+	// The upload reads from a pipe that is filled as records arrive
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(writeLines(ctx, pipeWriter, in))
+	}()
 
-	err = conn.Stor(req.FTPFILEPATH, bytes.NewReader(dataBytes))
+	err = conn.Stor(f.FilePath, pipeReader)
+	pipeReader.CloseWithError(err) // Unblock the writer if the upload failed early
 	if err != nil {
 		return fmt.Errorf("failed to store file to FTP: %w", err)
 	}
@@ -87,12 +91,61 @@ func (f FTPDestination) SendData(data interface{}, req interfaces.Request) error
 	return nil
 }
 
-// dialFTP creates and authenticates an FTP connection
-func dialFTP(url, user, password string) (*ftp.ServerConn, error) {
+// streamLines sends every line read from r on out as a record, decoding
+// JSON lines into fields. It stops with ctx.Err() once ctx is done.
+func streamLines(ctx context.Context, collection string, r io.Reader, out chan<- *interfaces.Record) error {
+	reader := bufio.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := interfaces.Send(ctx, out, recordFromPayload(collection, bytes.TrimRight(line, "\r\n"))); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// writeLines writes each record from in to w as a line, encoding records
+// with fields as JSON lines.
+func writeLines(ctx context.Context, w io.Writer, in <-chan *interfaces.Record) error {
+	writer := bufio.NewWriter(w)
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			break
+		}
+		line, err := payloadFromRecord(record)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+		if err := writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// dialFTP creates and authenticates an FTP connection. ctx bounds the dial.
+func dialFTP(ctx context.Context, url, user, password string) (*ftp.ServerConn, error) {
 	// Remove "ftp://" prefix if present
 	url = strings.TrimPrefix(url, "ftp://")
 
-	conn, err := ftp.Dial(url, ftp.DialWithTimeout(10*time.Second))
+	conn, err := ftp.Dial(url, ftp.DialWithTimeout(10*time.Second), ftp.DialWithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to FTP server: %w", err)
 	}
@@ -107,6 +160,12 @@ func dialFTP(url, user, password string) (*ftp.ServerConn, error) {
 func init() {
 	registry.RegisterSource("FTP", FTPSource{})
 	registry.RegisterDestination("FTP", FTPDestination{})
+	registry.Describe("FTP", registry.Info{
+		DisplayName:  "FTP",
+		Description:  "Transfers a file over FTP. Every line is one record; JSON lines become fields.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch},
+	})
 }
 
 // Predefined FTP errors
@@ -117,22 +176,22 @@ var (
 	ErrFTPFileUploadFailed = errors.New("failed to upload file to FTP server")
 )
 
-// validateFTPRequest validates the request fields for FTP
-func validateFTPRequest(req interfaces.Request, isSource bool) error {
-	if req.FTPURL == "" {
+// validateFTPConfig validates the connection settings for FTP
+func validateFTPConfig(url, user, password, filePath string) error {
+	if url == "" {
 		return errors.New("missing FTP URL")
 	}
-	if req.FTPUser == "" {
+	if user == "" {
 		return errors.New("missing FTP user")
 	}
-	if req.FTPPassword == "" {
+	if password == "" {
 		return errors.New("missing FTP password")
 	}
-	if req.FTPFILEPATH == "" {
+	if filePath == "" {
 		return errors.New("missing file path")
 	}
-	if !strings.HasPrefix(req.FTPURL, "ftp://") {
-		return fmt.Errorf("invalid FTP URL: %s", req.FTPURL)
+	if !strings.HasPrefix(url, "ftp://") {
+		return fmt.Errorf("invalid FTP URL: %s", url)
 	}
 	return nil
 }
@@ -1,70 +1,166 @@
 package integrations
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/SkySingh04/fractal/logger"
 	"github.com/SkySingh04/fractal/registry"
 )
 
+// jsonCollection names the schema of records read from inline JSON data.
+const jsonCollection = "json"
+
+// JSONSource reads records from inline JSON data.
 type JSONSource struct {
-	Data string `json:"json_source_data"`
+	Data string `json:"data" validate:"required" description:"JSON document, array or sequence of documents to read" example:"[{\"id\": 1}]"`
 }
 
+// JSONDestination writes records to a JSON file.
 type JSONDestination struct {
-	Filename string `json:"json_output_filename"`
+	Filename string `json:"filename" validate:"required" description:"Path of the JSON file to write" example:"output.json"`
 }
 
-// FetchData retrieves and processes JSON source data
-func (j JSONSource) FetchData(req interfaces.Request) (interface{}, error) {
-	if req.JSONSourceData == "" {
-		return nil, errors.New("missing JSON source data")
+// FetchData streams JSON source data as records. A top-level array is emitted
+// one element at a time; any other value, or a sequence of concatenated values,
+// is emitted value by value. Objects keep their key order and other values are
+// wrapped in a single "value" field.
+func (j JSONSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	if j.Data == "" {
+		return errors.New("missing JSON source data")
 	}
 
-	// Validate and sanitize JSON data
-	validatedData, err := ValidateJSONData(req.JSONSourceData)
-	if err != nil {
-		logger.Fatalf("Validation error: %v", err)
-		return nil, err
+	decoder := json.NewDecoder(strings.NewReader(j.Data))
+	emit := func(raw json.RawMessage) error {
+		value, err := interfaces.ParseJSON(raw)
+		if err != nil {
+			return errors.New("invalid JSON format")
+		}
+
+		// Transform each value independently
+		transformedData, err := transformJSONData(value)
+		if err != nil {
+			logger.Fatalf("Transformation error: %v", err)
+			return err
+		}
+		if err := interfaces.Send(ctx, out, recordFromValue(jsonCollection, transformedData)); err != nil {
+			return err
+		}
+		return nil
 	}
 
-	// Transform JSON data
-	transformedData, err := transformJSONData(validatedData)
-	if err != nil {
-		logger.Fatalf("Transformation error: %v", err)
-		return nil, err
+	if strings.HasPrefix(strings.TrimSpace(j.Data), "[") {
+		// Consume the opening bracket and decode elements one by one
+		if _, err := decoder.Token(); err != nil {
+			return errors.New("invalid JSON format")
+		}
+		for decoder.More() {
+			var element json.RawMessage
+			if err := decoder.Decode(&element); err != nil {
+				return errors.New("invalid JSON format")
+			}
+			if err := emit(element); err != nil {
+				return err
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return errors.New("invalid JSON format")
+		}
+		return nil
 	}
 
-	return transformedData, nil
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return errors.New("invalid JSON format")
+		}
+		if err := emit(value); err != nil {
+			return err
+		}
+	}
 }
 
-// SendData writes JSON data to a destination file
-func (j JSONDestination) SendData(data interface{}, req interfaces.Request) error {
-	if req.JSONOutputFilename == "" {
+// SendData writes streamed records to a JSON file. A single record is written
+// as-is; two or more records are written incrementally as a JSON array.
+func (j JSONDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	if j.Filename == "" {
 		return errors.New("missing JSON destination filename")
 	}
 
 	logger.Infof("Sending data to JSON destination...")
-	logger.Infof("Data: %v", data)
 
-	// Write data to a JSON file
-	err := writeJSONFile(req.JSONOutputFilename, data)
+	file, err := os.Create(j.Filename)
 	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+
+	// Hold back the first record until we know whether an array is needed
+	var first *interfaces.Record
+	count := 0
+	for {
+		data, err := interfaces.Receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			break
+		}
+		count++
+		if count == 1 {
+			first = data
+			continue
+		}
+		if count == 2 {
+			if err := writeJSONElement(writer, "[\n  ", first); err != nil {
+				return err
+			}
+		}
+		if err := writeJSONElement(writer, ",\n  ", data); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case count == 1:
+		if err := writeJSONValue(writer, first); err != nil {
+			return err
+		}
+	case count > 1:
+		if _, err := writer.WriteString("\n]\n"); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
 		logger.Fatalf("Error writing data to JSON file: %v", err)
 		return err
 	}
 
-	logger.Infof("Data successfully written to %s", req.JSONOutputFilename)
+	logger.Infof("%d records successfully written to %s", count, j.Filename)
 	return nil
 }
 
 func init() {
 	registry.RegisterSource("JSON", JSONSource{})
 	registry.RegisterDestination("JSON", JSONDestination{})
+	registry.Describe("JSON", registry.Info{
+		DisplayName:  "JSON",
+		Description:  "Reads JSON documents from inline data and writes records to a JSON file.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch},
+	})
 }
 
 // ValidateJSONData validates, sanitizes, and unmarshals JSON data
@@ -108,29 +204,32 @@ func sanitizeJSONData(data interface{}) interface{} {
 	}
 }
 
-// writeJSONFile writes the provided data to a JSON file with proper formatting
-func writeJSONFile(filename string, data interface{}) error {
-	file, err := os.Create(filename)
+// writeJSONElement writes sep followed by data formatted as an array element
+func writeJSONElement(w io.Writer, sep string, data interface{}) error {
+	element, err := json.MarshalIndent(data, "  ", "  ")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
+	if _, err := io.WriteString(w, sep); err != nil {
 		return err
 	}
+	_, err = w.Write(element)
+	return err
+}
 
-	return nil
+// writeJSONValue writes a single value with proper formatting
+func writeJSONValue(w io.Writer, data interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
 }
 
 // transformJSONData applies transformations to the JSON data
 func transformJSONData(data interface{}) (interface{}, error) {
-	// Example transformation: Add a key-value pair if the data is a map
-	if jsonMap, ok := data.(map[string]interface{}); ok {
-		jsonMap["transformed"] = true
-		return jsonMap, nil
+	// Example transformation: Add a key-value pair if the data is an object
+	if record, ok := data.(*interfaces.Record); ok {
+		record.Set("transformed", true)
+		return record, nil
 	}
 
 	// If no transformation is required, return data as is
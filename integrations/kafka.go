@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 
+	"github.com/SkySingh04/fractal/checkpoint"
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/SkySingh04/fractal/logger"
 	"github.com/SkySingh04/fractal/registry"
@@ -15,144 +18,169 @@ import (
 
 // KafkaSource struct represents the configuration for consuming messages from Kafka.
 type KafkaSource struct {
-	URL   string `json:"consumer_url"`
-	Topic string `json:"consumer_topic"`
+	URL     string `json:"url" validate:"required" description:"Comma separated list of brokers" example:"localhost:9092"`
+	Topic   string `json:"topic" validate:"required" description:"Topic to consume from or publish to" example:"events"`
+	GroupID string `json:"group_id" default:"fractal-group" description:"Consumer group that tracks the read offsets"`
 }
 
 // KafkaDestination struct represents the configuration for publishing messages to Kafka.
 type KafkaDestination struct {
-	URL   string `json:"producer_url"`
-	Topic string `json:"producer_topic"`
+	URL   string `json:"url" validate:"required" description:"Comma separated list of brokers" example:"localhost:9092"`
+	Topic string `json:"topic" validate:"required" description:"Topic to consume from or publish to" example:"events"`
 }
 
-// FetchData connects to Kafka, retrieves data, and processes it concurrently.
-func (k KafkaSource) FetchData(req interfaces.Request) (interface{}, error) {
-	logger.Infof("Connecting to Kafka Source: URL=%s, Topic=%s", req.ConsumerURL, req.ConsumerTopic)
-
-	if req.ConsumerURL == "" || req.ConsumerTopic == "" {
-		return nil, errors.New("missing Kafka source details")
+// FetchData connects to Kafka and streams each consumed message downstream
+// until the reader fails or is closed, or ctx is cancelled.
+//
+// A checkpointed migration records the next offset of each partition and
+// commits offsets to the consumer group only once the records are written.
+// Messages the checkpoint already covers are skipped when they are delivered
+// again.
+func (k KafkaSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	logger.Infof("Connecting to Kafka Source: URL=%s, Topic=%s", k.URL, k.Topic)
+
+	if k.URL == "" || k.Topic == "" {
+		return errors.New("missing Kafka source details")
 	}
 
 	// Create Kafka reader
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  strings.Split(req.ConsumerURL, ","),
-		Topic:    req.ConsumerTopic,
-		GroupID:  "fractal-group", // Example: change as needed
-		MinBytes: 10e3,            // 10KB
-		MaxBytes: 10e6,            // 10MB
+		Brokers:  strings.Split(k.URL, ","),
+		Topic:    k.Topic,
+		GroupID:  k.GroupID,
+		MinBytes: 10e3, // 10KB
+		MaxBytes: 10e6, // 10MB
 	})
-	defer reader.Close()
-
-	var wg sync.WaitGroup
-	msgChannel := make(chan interface{}, 100) // Buffered channel to collect results
-
-	// Process messages concurrently
-	go func() {
-		for {
-			message, err := reader.ReadMessage(context.Background())
-			if err != nil {
-				logger.Errorf("Error reading message from Kafka: %v", err)
-				continue
-			}
+	var closed atomic.Bool
+	defer func() {
+		closed.Store(true)
+		reader.Close()
+	}()
 
-			logger.Infof("Message received from Kafka: %s", message.Value)
+	tracked := checkpoint.Enabled(ctx)
+	resume := checkpoint.Resume(ctx)
+	if tracked && k.GroupID != "" {
+		checkpoint.OnCommit(ctx, func(position checkpoint.Position) {
+			if !closed.Load() {
+				commitKafkaOffsets(context.WithoutCancel(ctx), reader, k.Topic, position)
+			}
+		})
+	}
 
-			// Validation
-			validatedData, err := validateKafkaData(message.Value)
-			if err != nil {
-				logger.Errorf("Validation failed for message: %s, Error: %s", message.Value, err)
-				continue // Skip invalid message
+	for {
+		// Offsets are committed by the checkpoint when it is enabled
+		read := reader.ReadMessage
+		if tracked {
+			read = reader.FetchMessage
+		}
+		message, err := read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return nil // Reader closed, end of stream
 			}
+			return fmt.Errorf("error reading message from Kafka: %w", err)
+		}
 
-			// Transformation
-			transformedData := transformKafkaData(validatedData)
+		logger.Infof("Message received from Kafka: %s", message.Value)
 
-			// Send processed data to channel for further handling
-			wg.Add(1)
-			go func(data interface{}) {
-				defer wg.Done()
-				msgChannel <- data
-			}(transformedData)
+		// Validation
+		validatedData, err := validateKafkaData(message.Value)
+		if err != nil {
+			logger.Errorf("Validation failed for message: %s, Error: %s", message.Value, err)
+			continue // Skip invalid message
 		}
-	}()
 
-	// Wait for all goroutines to finish processing
-	go func() {
-		wg.Wait()
-		close(msgChannel)
-	}()
+		key := "partition-" + strconv.Itoa(message.Partition)
+		if next, ok := resume[key]; ok {
+			if offset, err := strconv.ParseInt(next, 10, 64); err == nil && message.Offset < offset {
+				continue // Written by a previous run
+			}
+		}
 
-	// Collect final data from the channel
-	var result interface{}
-	for data := range msgChannel {
-		result = data
+		// Transformation
+		record := recordFromPayload(k.Topic, transformKafkaData(validatedData))
+		if err := checkpoint.Send(ctx, out, record, key, strconv.FormatInt(message.Offset+1, 10)); err != nil {
+			return err
+		}
 	}
-
-	return result, nil
 }
 
-// SendData connects to Kafka and publishes data to the specified topic concurrently.
-func (k KafkaDestination) SendData(data interface{}, req interfaces.Request) error {
-	logger.Infof("Connecting to Kafka Destination: URL=%s, Topic=%s", req.ProducerURL, req.ProducerTopic)
+// SendData connects to Kafka and publishes each streamed record to the
+// specified topic.
+func (k KafkaDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	logger.Infof("Connecting to Kafka Destination: URL=%s, Topic=%s", k.URL, k.Topic)
 
-	if req.ProducerURL == "" || req.ProducerTopic == "" {
+	if k.URL == "" || k.Topic == "" {
 		return errors.New("missing Kafka target details")
 	}
 
 	// Create Kafka writer
 	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: strings.Split(req.ProducerURL, ","),
-		Topic:   req.ProducerTopic,
+		Brokers: strings.Split(k.URL, ","),
+		Topic:   k.Topic,
 	})
 	defer writer.Close()
 
-	// Convert data to string
-	var message string
-	switch v := data.(type) {
-	case string:
-		message = v
-	case []byte:
-		message = string(v) // Convert bytes to string
-	default:
-		return fmt.Errorf("unsupported data type: %T", v)
-	}
-
-	// Batch send messages concurrently
-	var wg sync.WaitGroup
-	errCh := make(chan error, 1)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		// Publish message
-		err := writer.WriteMessages(context.Background(),
-			kafka.Message{
-				Value: []byte(message),
-			},
-		)
+	count := 0
+	for {
+		record, err := interfaces.Receive(ctx, in)
 		if err != nil {
-			errCh <- err
+			return err
+		}
+		if record == nil {
+			break
+		}
+		message, err := payloadFromRecord(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode record for Kafka: %w", err)
 		}
-	}()
-
-	// Wait for all goroutines to finish and handle errors
-	wg.Wait()
-	close(errCh)
 
-	// If any error occurred during message sending, return it
-	if err := <-errCh; err != nil {
-		return err
+		// Publish message
+		if err := writer.WriteMessages(ctx, kafka.Message{Value: message}); err != nil {
+			return err
+		}
+		checkpoint.Ack(ctx, 1)
+		count++
 	}
 
-	logger.Infof("Message sent to Kafka topic %s: %s", req.ProducerTopic, message)
+	logger.Infof("%d messages sent to Kafka topic %s", count, k.Topic)
 	return nil
 }
 
+// commitKafkaOffsets commits the next offset of every partition of position
+// to the consumer group of reader.
+func commitKafkaOffsets(ctx context.Context, reader *kafka.Reader, topic string, position checkpoint.Position) {
+	var messages []kafka.Message
+	for key, value := range position {
+		partition, err := strconv.Atoi(strings.TrimPrefix(key, "partition-"))
+		if err != nil {
+			continue
+		}
+		next, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		// The reader commits the offset after the one of the message
+		messages = append(messages, kafka.Message{Topic: topic, Partition: partition, Offset: next - 1})
+	}
+	if err := reader.CommitMessages(ctx, messages...); err != nil {
+		logger.Errorf("Failed to commit Kafka offsets: %v", err)
+	}
+}
+
 // Initialize the Kafka integrations by registering them with the registry.
 func init() {
 	registry.RegisterSource("Kafka", KafkaSource{})
 	registry.RegisterDestination("Kafka", KafkaDestination{})
+	registry.Describe("Kafka", registry.Info{
+		DisplayName:  "Apache Kafka",
+		Description:  "Consumes and publishes messages on a Kafka topic.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityStreaming},
+	})
 }
 
 // validateKafkaData ensures the input data meets the required criteria.
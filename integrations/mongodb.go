@@ -4,176 +4,300 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/SkySingh04/fractal/checkpoint"
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/SkySingh04/fractal/logger"
 	"github.com/SkySingh04/fractal/registry"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const bufferSize = 10 // Buffer size for channels
+const (
+	bufferSize     = 10   // Buffer size for channels
+	mongoBatchSize = 1000 // Documents per cursor batch and per InsertMany call
+)
 
 // MongoDBSource struct represents the configuration for consuming messages from MongoDB.
 type MongoDBSource struct {
-	ConnString string `json:"source_mongodb_conn_string"`
-	Database   string `json:"source_mongodb_database"`
-	Collection string `json:"source_mongodb_collection"`
+	ConnString string `json:"conn_string" validate:"required" secret:"true" description:"MongoDB connection URI" example:"mongodb://localhost:27017"`
+	Database   string `json:"database" validate:"required" description:"Name of the database" example:"shop"`
+	Collection string `json:"collection" validate:"required" description:"Name of the collection" example:"orders"`
 }
 
 // MongoDBDestination struct represents the configuration for publishing messages to MongoDB.
 type MongoDBDestination struct {
-	ConnString string `json:"target_mongodb_conn_string"`
-	Database   string `json:"target_mongodb_database"`
-	Collection string `json:"target_mongodb_collection"`
+	ConnString string `json:"conn_string" validate:"required" secret:"true" description:"MongoDB connection URI" example:"mongodb://localhost:27017"`
+	Database   string `json:"database" validate:"required" description:"Name of the database" example:"shop"`
+	Collection string `json:"collection" validate:"required" description:"Name of the collection" example:"orders"`
 }
 
-// FetchData connects to MongoDB, retrieves data, and returns it.
-func (m MongoDBSource) FetchData(req interfaces.Request) (interface{}, error) {
-	if req.SourceMongoDBConnString == "" || req.SourceMongoDBDatabase == "" || req.SourceMongoDBCollection == "" {
-		return nil, errors.New("missing MongoDB source connection details")
+// FetchData connects to MongoDB and streams every document of the collection
+// as a record as it is decoded from the cursor. Field order is preserved.
+//
+// A checkpointed migration reads documents in _id order and resumes after the
+// last _id written. Ids that are not ObjectIDs, strings or integers do not
+// move the position.
+func (m MongoDBSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	if m.ConnString == "" || m.Database == "" || m.Collection == "" {
+		return errors.New("missing MongoDB source connection details")
 	}
 	logger.Infof("Connecting to MongoDB source...")
 
-	clientOptions := options.Client().ApplyURI(req.SourceMongoDBConnString)
-	client, err := mongo.Connect(context.TODO(), clientOptions)
+	clientOptions := options.Client().ApplyURI(m.ConnString)
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
-		if err = client.Disconnect(context.TODO()); err != nil {
-			log.Fatal(err)
+		// Disconnect even when ctx is cancelled so the session is released
+		if err := client.Disconnect(context.Background()); err != nil {
+			logger.Errorf("Error disconnecting MongoDB client: %v", err)
 		}
 	}()
 
-	collection := client.Database(req.SourceMongoDBDatabase).Collection(req.SourceMongoDBCollection)
+	collection := client.Database(m.Database).Collection(m.Collection)
 
-	cursor, err := collection.Find(context.TODO(), bson.D{})
+	// The cursor fetches documents from the server in batches
+	filter := bson.D{}
+	findOptions := options.Find().SetBatchSize(mongoBatchSize)
+	if checkpoint.Enabled(ctx) {
+		findOptions.SetSort(bson.D{{Key: "_id", Value: 1}})
+		if value, ok := checkpoint.Resume(ctx)[m.Collection]; ok {
+			id, err := decodeMongoID(value)
+			if err != nil {
+				return fmt.Errorf("invalid checkpoint for collection %s: %w", m.Collection, err)
+			}
+			filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}}
+		}
+	}
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(context.Background())
 
-	// Use a buffered channel to handle data
-	dataChannel := make(chan bson.M, bufferSize)
-	var wg sync.WaitGroup
-	var allResults []bson.M
-	var fetchError error
-	mutex := &sync.Mutex{}
-
-	// Goroutine to collect data from the channel
-	go func() {
-		for doc := range dataChannel {
-			mutex.Lock()
-			allResults = append(allResults, doc)
-			mutex.Unlock()
-		}
-	}()
-
-	// Process each document
-	for cursor.Next(context.TODO()) {
-		var doc bson.M
+	schema := &interfaces.Schema{Name: m.Collection}
+	count := 0
+	for cursor.Next(ctx) {
+		var doc bson.D
 		if err := cursor.Decode(&doc); err != nil {
-			fetchError = err
-			break
+			return err
 		}
-
-		wg.Add(1)
-		go func(d bson.M) {
-			defer wg.Done()
-			dataChannel <- d
-		}(doc)
+		record := BSONToRecord(doc)
+		record.Schema = schema
+		var key string
+		value, ok := encodeMongoID(doc.Map()["_id"])
+		if ok {
+			key = m.Collection
+		}
+		if err := checkpoint.Send(ctx, out, record, key, value); err != nil {
+			return err
+		}
+		count++
 	}
-
-	wg.Wait()
-	close(dataChannel)
-
-	if fetchError != nil {
-		return nil, fetchError
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	if err := cursor.Err(); err != nil {
-		return nil, err
+		return err
 	}
 
-	logger.Infof("Data fetched from MongoDB: %d documents", len(allResults))
-	return allResults, nil
+	logger.Infof("Data fetched from MongoDB: %d documents", count)
+	return nil
 }
 
-// SendData connects to MongoDB and publishes data to the specified collection.
-func (m MongoDBDestination) SendData(data interface{}, req interfaces.Request) error {
-	if req.TargetMongoDBConnString == "" || req.TargetMongoDBDatabase == "" || req.TargetMongoDBCollection == "" {
+// SendData connects to MongoDB and inserts streamed documents into the
+// collection in batches of mongoBatchSize.
+func (m MongoDBDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	if m.ConnString == "" || m.Database == "" || m.Collection == "" {
 		return errors.New("missing MongoDB target connection details")
 	}
 	logger.Infof("Connecting to MongoDB destination...")
 
 	// Initialize MongoDB client
-	clientOptions := options.Client().ApplyURI(req.TargetMongoDBConnString)
-	client, err := mongo.Connect(context.TODO(), clientOptions)
+	clientOptions := options.Client().ApplyURI(m.ConnString)
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 	defer func() {
-		if err = client.Disconnect(context.TODO()); err != nil {
+		if err := client.Disconnect(context.Background()); err != nil {
 			logger.Errorf("Error disconnecting MongoDB client: %v", err)
 		}
 	}()
 
-	// Transform data to BSON
-	bsonData, err := TransformDataToBSON(data)
-	if err != nil {
-		return fmt.Errorf("data transformation failed: %w", err)
-	}
-
 	// Access database and collection
-	collection := client.Database(req.TargetMongoDBDatabase).Collection(req.TargetMongoDBCollection)
+	collection := client.Database(m.Database).Collection(m.Collection)
 
-	// Insert data into MongoDB
-	if len(bsonData) == 1 {
-		// Insert a single document
-		_, err = collection.InsertOne(context.TODO(), bsonData[0])
-		if err != nil {
-			return fmt.Errorf("failed to insert document: %w", err)
+	batch := make([]interface{}, 0, mongoBatchSize)
+	total := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
+		if _, err := collection.InsertMany(ctx, batch); err != nil {
+			return fmt.Errorf("failed to insert documents: %w", err)
+		}
+		checkpoint.Ack(ctx, len(batch))
+		total += len(batch)
+		batch = batch[:0]
+		return nil
+	}
 
-	} else {
-		// Insert multiple documents
-		docs := make([]interface{}, len(bsonData))
-		for i, doc := range bsonData {
-			docs[i] = doc
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			break
 		}
-		_, err = collection.InsertMany(context.TODO(), docs)
+		// Transform data to BSON
+		doc, err := TransformDataToBSON(record)
 		if err != nil {
-			return fmt.Errorf("failed to insert documents: %w", err)
+			return fmt.Errorf("data transformation failed: %w", err)
+		}
+		batch = append(batch, doc)
+		if len(batch) == mongoBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
 		}
-		logger.Infof("Successfully inserted %d documents into MongoDB collection %s", len(bsonData), req.TargetMongoDBCollection)
+	}
+	if err := flush(); err != nil {
+		return err
 	}
 
+	logger.Infof("Successfully inserted %d documents into MongoDB collection %s", total, m.Collection)
 	return nil
 }
 
+// encodeMongoID renders a document id as a checkpoint value prefixed with its
+// type, so that it is compared with ids of the same type when resuming.
+func encodeMongoID(id interface{}) (string, bool) {
+	switch v := id.(type) {
+	case primitive.ObjectID:
+		return "oid:" + v.Hex(), true
+	case string:
+		return "str:" + v, true
+	case int32:
+		return "int:" + strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return "int:" + strconv.FormatInt(v, 10), true
+	}
+	return "", false
+}
+
+// decodeMongoID parses a checkpoint value written by encodeMongoID.
+func decodeMongoID(value string) (interface{}, error) {
+	kind, id, _ := strings.Cut(value, ":")
+	switch kind {
+	case "oid":
+		return primitive.ObjectIDFromHex(id)
+	case "str":
+		return id, nil
+	case "int":
+		return strconv.ParseInt(id, 10, 64)
+	}
+	return nil, fmt.Errorf("unknown id %q", value)
+}
+
 // Initialize the MongoDB integrationfs by registering them with the registry.
 func init() {
 	registry.RegisterSource("MongoDB", MongoDBSource{})
 	registry.RegisterDestination("MongoDB", MongoDBDestination{})
+	registry.RegisterAlias("mongo", "MongoDB")
+	registry.Describe("MongoDB", registry.Info{
+		DisplayName:  "MongoDB",
+		Description:  "Reads and writes the documents of a MongoDB collection.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch},
+	})
 }
 
-func TransformDataToBSON(data interface{}) ([]bson.M, error) {
-	logger.Infof("Data received for BSON conversion insertion: %+v", data)
-	switch v := data.(type) {
-	case map[string]interface{}: // Single document
-		return []bson.M{v}, nil
-	case []map[string]interface{}: // Multiple documents
-		result := make([]bson.M, len(v))
+// TransformDataToBSON converts a record into an ordered BSON document.
+// Decimals are stored as Decimal128 values.
+func TransformDataToBSON(record *interfaces.Record) (bson.D, error) {
+	doc := make(bson.D, 0, record.Len())
+	for _, field := range record.Fields {
+		value, err := toBSONValue(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		doc = append(doc, bson.E{Key: field.Name, Value: value})
+	}
+	return doc, nil
+}
+
+func toBSONValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *interfaces.Record:
+		return TransformDataToBSON(v)
+	case []interface{}:
+		items := make(bson.A, len(v))
 		for i, item := range v {
-			result[i] = item
+			converted, err := toBSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
 		}
-		return result, nil
-	case []bson.M: // Already in bson.M
+		return items, nil
+	case interfaces.Decimal:
+		return primitive.ParseDecimal128(v.String())
+	default:
 		return v, nil
+	}
+}
+
+// BSONToRecord converts a BSON document into a record, mapping BSON specific
+// types such as ObjectID and Decimal128 to canonical values.
+func BSONToRecord(doc bson.D) *interfaces.Record {
+	record := &interfaces.Record{Fields: make([]interfaces.Field, 0, len(doc))}
+	for _, element := range doc {
+		record.Set(element.Key, fromBSONValue(element.Value))
+	}
+	return record
+}
+
+func fromBSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return BSONToRecord(v)
+	case bson.M:
+		record := interfaces.RecordFromMap(v)
+		for i := range record.Fields {
+			record.Fields[i].Value = fromBSONValue(v[record.Fields[i].Name])
+		}
+		return record
+	case bson.A:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = fromBSONValue(item)
+		}
+		return items
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC()
+	case primitive.Decimal128:
+		if d, err := interfaces.ParseDecimal(v.String()); err == nil {
+			return d
+		}
+		return v.String() // NaN and Infinity have no exact decimal form
+	case primitive.Binary:
+		return v.Data
+	case primitive.Null, primitive.Undefined:
+		return nil
 	default:
-		return nil, fmt.Errorf("unsupported data format: %T", v)
+		return interfaces.Normalize(v)
 	}
 }
@@ -0,0 +1,58 @@
+package integrations
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// payloadField is the field that holds message payloads that are not JSON objects.
+const payloadField = "value"
+
+// recordFromPayload converts a message payload into a record. JSON objects
+// become records with the same fields; any other payload is stored verbatim
+// as a string in a single "value" field. The record is described by a schema
+// named after the topic, queue or file it was read from.
+func recordFromPayload(collection string, payload []byte) *interfaces.Record {
+	if value, err := interfaces.ParseJSON(payload); err == nil {
+		if _, isObject := value.(*interfaces.Record); isObject {
+			return recordFromValue(collection, value)
+		}
+	}
+	return recordFromValue(collection, string(payload))
+}
+
+// recordFromValue wraps a decoded document in a record. Objects are used as
+// the record itself and any other value is stored in a single "value" field.
+func recordFromValue(collection string, value interface{}) *interfaces.Record {
+	record, ok := value.(*interfaces.Record)
+	if !ok {
+		record = interfaces.NewRecord()
+		record.Set(payloadField, value)
+	}
+	record.Schema = &interfaces.Schema{Name: collection}
+	return record
+}
+
+// payloadFromRecord converts a record into a message payload. It reverses
+// recordFromPayload: a record holding only a textual or binary "value" field
+// is sent as that raw value, and anything else is encoded as a JSON object.
+func payloadFromRecord(record *interfaces.Record) ([]byte, error) {
+	if record.Len() == 1 && record.Fields[0].Name == payloadField {
+		switch v := record.Fields[0].Value.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		}
+	}
+	return record.MarshalJSON()
+}
+
+// collectionName derives a schema name from a file path by dropping the
+// directory and the extension.
+func collectionName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
@@ -1,11 +1,11 @@
 package integrations
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/SkySingh04/fractal/interfaces"
@@ -17,127 +17,90 @@ import (
 
 // SFTPSource implements the DataSource interface
 type SFTPSource struct {
-	URL          string `json:"url"`
-	User         string `json:"user"`
-	Password     string `json:"password"`
-	SFTPFILEPATH string `json:"file_path"`
+	URL      string `json:"url" validate:"required" description:"Address of the SFTP server" example:"sftp.example.com:22"`
+	User     string `json:"user" validate:"required" description:"User to log in as" example:"anonymous"`
+	Password string `json:"password" validate:"required" secret:"true" description:"Password of the user"`
+	FilePath string `json:"file_path" validate:"required" description:"Path of the file on the server" example:"/data/records.jsonl"`
 }
 
 // SFTPDestination implements the DataDestination interface
 type SFTPDestination struct {
-	URL          string `json:"url"`
-	User         string `json:"user"`
-	Password     string `json:"password"`
-	SFTPFILEPATH string `json:"file_path"`
+	URL      string `json:"url" validate:"required" description:"Address of the SFTP server" example:"sftp.example.com:22"`
+	User     string `json:"user" validate:"required" description:"User to log in as" example:"anonymous"`
+	Password string `json:"password" validate:"required" secret:"true" description:"Password of the user"`
+	FilePath string `json:"file_path" validate:"required" description:"Path of the file on the server" example:"/data/records.jsonl"`
 }
 
-// FetchData fetches data from an SFTP server concurrently
-func (s SFTPSource) FetchData(req interfaces.Request) (interface{}, error) {
-	if err := validateSFTPRequest(req, true); err != nil {
-		return nil, err
+// FetchData streams a file from an SFTP server line by line
+func (s SFTPSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	if err := validateSFTPConfig(s.URL, s.User, s.Password, s.FilePath); err != nil {
+		return err
 	}
-	logger.Infof("Connecting to SFTP server at %s...", req.SFTPURL)
+	logger.Infof("Connecting to SFTP server at %s...", s.URL)
 
-	client, err := dialSFTP(req.SFTPURL, req.SFTPUser, req.SFTPPassword)
+	client, err := dialSFTP(ctx, s.URL, s.User, s.Password)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer client.Close()
 
-	// Use WaitGroup to ensure all operations finish
-	var wg sync.WaitGroup
-	dataChan := make(chan []byte)
-	errorChan := make(chan error)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	// Closing the client interrupts a transfer blocked on the network
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
 
-		logger.Infof("Downloading file from SFTP: %s", req.SFTPFILEPATH)
-		file, err := client.Open(req.SFTPFILEPATH)
-		if err != nil {
-			errorChan <- fmt.Errorf("failed to retrieve file from SFTP: %w", err)
-			return
-		}
-		defer file.Close()
+	logger.Infof("Downloading file from SFTP: %s", s.FilePath)
+	file, err := client.Open(s.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve file from SFTP: %w", err)
+	}
+	defer file.Close()
 
-		data, err := io.ReadAll(file)
-		if err != nil {
-			errorChan <- fmt.Errorf("failed to read data from SFTP response: %w", err)
-			return
+	if err := streamLines(ctx, collectionName(s.FilePath), file, out); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-
-		dataChan <- data
-	}()
-
-	// Wait for the goroutine to finish and check for errors
-	wg.Wait()
-	close(dataChan)
-	close(errorChan)
-
-	if len(errorChan) > 0 {
-		return nil, <-errorChan
+		return fmt.Errorf("failed to read data from SFTP response: %w", err)
 	}
-
-	// Return the data received from the channel
-	return <-dataChan, nil
+	return nil
 }
 
-// SendData sends data to an SFTP server concurrently
-func (s SFTPDestination) SendData(data interface{}, req interfaces.Request) error {
-	if err := validateSFTPRequest(req, false); err != nil {
+// SendData streams records to a file on an SFTP server, one record per line
+func (s SFTPDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	if err := validateSFTPConfig(s.URL, s.User, s.Password, s.FilePath); err != nil {
 		return err
 	}
-	logger.Infof("Connecting to SFTP server at %s...", req.SFTPURL)
+	logger.Infof("Connecting to SFTP server at %s...", s.URL)
 
-	client, err := dialSFTP(req.SFTPURL, req.SFTPUser, req.SFTPPassword)
+	client, err := dialSFTP(ctx, s.URL, s.User, s.Password)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	// Use WaitGroup to ensure all operations finish
-	var wg sync.WaitGroup
-	errorChan := make(chan error)
+	// Closing the client interrupts a transfer blocked on the network
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
 
-	dataBytes, ok := data.([]byte)
-	if !ok {
-		return fmt.Errorf("invalid data format; expected []byte, got %T", data)
+	logger.Infof("Uploading file to SFTP: %s", s.FilePath)
+	file, err := client.Create(s.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file on SFTP server: %w", err)
 	}
+	defer file.Close()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		logger.Infof("Uploading file to SFTP: %s", req.SFTPFILEPATH)
-		file, err := client.Create(req.SFTPFILEPATH)
-		if err != nil {
-			errorChan <- fmt.Errorf("failed to create file on SFTP server: %w", err)
-			return
-		}
-		defer file.Close()
-
-		_, err = file.Write(dataBytes)
-		if err != nil {
-			errorChan <- fmt.Errorf("failed to write file to SFTP server: %w", err)
-			return
+	if err := writeLines(ctx, file, in); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-	}()
-
-	// Wait for the goroutine to finish and check for errors
-	wg.Wait()
-	close(errorChan)
-
-	if len(errorChan) > 0 {
-		return <-errorChan
+		return fmt.Errorf("failed to write file to SFTP server: %w", err)
 	}
 
 	logger.Infof("Successfully sent data to SFTP.")
 	return nil
 }
 
-// dialSFTP creates and authenticates an SFTP connection
-func dialSFTP(url, user, password string) (*sftp.Client, error) {
+// dialSFTP creates and authenticates an SFTP connection. ctx bounds the dial.
+func dialSFTP(ctx context.Context, url, user, password string) (*sftp.Client, error) {
 	// Remove "sftp://" prefix if present
 	url = strings.TrimPrefix(url, "sftp://")
 
@@ -150,10 +113,17 @@ func dialSFTP(url, user, password string) (*sftp.Client, error) {
 		Timeout:         10 * time.Second,
 	}
 
-	conn, err := ssh.Dial("tcp", url, config)
+	dialer := net.Dialer{Timeout: config.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP server: %w", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, url, config)
 	if err != nil {
+		netConn.Close()
 		return nil, fmt.Errorf("failed to connect to SFTP server: %w", err)
 	}
+	conn := ssh.NewClient(sshConn, chans, reqs)
 
 	client, err := sftp.NewClient(conn)
 	if err != nil {
@@ -163,22 +133,22 @@ func dialSFTP(url, user, password string) (*sftp.Client, error) {
 	return client, nil
 }
 
-// validateSFTPRequest validates the request fields for SFTP
-func validateSFTPRequest(req interfaces.Request, isSource bool) error {
-	if req.SFTPURL == "" {
+// validateSFTPConfig validates the connection settings for SFTP
+func validateSFTPConfig(url, user, password, filePath string) error {
+	if url == "" {
 		return errors.New("missing SFTP URL")
 	}
-	if req.SFTPUser == "" {
+	if user == "" {
 		return errors.New("missing SFTP user")
 	}
-	if req.SFTPPassword == "" {
+	if password == "" {
 		return errors.New("missing SFTP password")
 	}
-	if req.SFTPFILEPATH == "" {
+	if filePath == "" {
 		return errors.New("missing file path")
 	}
-	if !strings.HasPrefix(req.SFTPURL, "sftp://") {
-		return fmt.Errorf("invalid SFTP URL: %s", req.SFTPURL)
+	if !strings.HasPrefix(url, "sftp://") {
+		return fmt.Errorf("invalid SFTP URL: %s", url)
 	}
 	return nil
 }
@@ -186,4 +156,10 @@ func validateSFTPRequest(req interfaces.Request, isSource bool) error {
 func init() {
 	registry.RegisterSource("SFTP", SFTPSource{})
 	registry.RegisterDestination("SFTP", SFTPDestination{})
+	registry.Describe("SFTP", registry.Info{
+		DisplayName:  "SFTP",
+		Description:  "Transfers a file over SFTP. Every line is one record; JSON lines become fields.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch},
+	})
 }
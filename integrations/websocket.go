@@ -1,7 +1,9 @@
 package integrations
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/SkySingh04/fractal/interfaces"
@@ -12,84 +14,99 @@ import (
 
 // WebSocketSource struct represents the configuration for consuming messages from WebSocket.
 type WebSocketSource struct {
-	URL string `json:"websocket_source_url"`
+	URL string `json:"url" validate:"required" description:"WebSocket URL to connect to" example:"ws://localhost:8080/stream"`
 }
 
 // WebSocketDestination struct represents the configuration for publishing messages to WebSocket.
 type WebSocketDestination struct {
-	URL string `json:"websocket_dest_url"`
+	URL string `json:"url" validate:"required" description:"WebSocket URL to connect to" example:"ws://localhost:8080/stream"`
 }
 
-// FetchData connects to WebSocket, retrieves data, and passes it through validation and transformation pipelines.
-func (ws WebSocketSource) FetchData(req interfaces.Request) (interface{}, error) {
-	logger.Infof("Connecting to WebSocket Source: URL=%s", req.WebSocketSourceURL)
+// FetchData connects to WebSocket and streams every received message through
+// the validation and transformation pipelines until the server closes the
+// connection or ctx is cancelled.
+func (ws WebSocketSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	logger.Infof("Connecting to WebSocket Source: URL=%s", ws.URL)
 
-	if req.WebSocketSourceURL == "" {
-		return nil, errors.New("missing WebSocket source details")
+	if ws.URL == "" {
+		return errors.New("missing WebSocket source details")
 	}
 
 	// Connect to WebSocket server
-	conn, _, err := websocket.DefaultDialer.Dial(req.WebSocketSourceURL, nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, ws.URL, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Close()
 
-	// Read message from WebSocket
-	_, msg, err := conn.ReadMessage()
-	if err != nil {
-		return nil, err
+	// Closing the connection unblocks a pending read
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		// Read message from WebSocket
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil // Server ended the stream
+			}
+			return err
+		}
+
+		logger.Infof("Message received from WebSocket: %s", msg)
+
+		// Validation
+		validatedData, err := validateWebSocketData(msg)
+		if err != nil {
+			return fmt.Errorf("validation failed for message: %s, Error: %w", msg, err)
+		}
+
+		// Transformation
+		if err := interfaces.Send(ctx, out, recordFromPayload(ws.URL, transformWebSocketData(validatedData))); err != nil {
+			return err
+		}
 	}
-
-	logger.Infof("Message received from WebSocket: %s", msg)
-
-	// Validation
-	validatedData, err := validateWebSocketData(msg)
-	if err != nil {
-		logger.Fatalf("Validation failed for message: %s, Error: %s", msg, err)
-		return nil, err
-	}
-
-	// Transformation
-	transformedData := transformWebSocketData(validatedData)
-
-	logger.Infof("Message successfully processed and routed: %s", transformedData)
-	return transformedData, nil
 }
 
-// SendData connects to WebSocket and publishes data to the specified WebSocket server.
-func (ws WebSocketDestination) SendData(data interface{}, req interfaces.Request) error {
-	logger.Infof("Connecting to WebSocket Destination: URL=%s", req.WebSocketDestURL)
+// SendData connects to WebSocket and publishes each streamed record to the
+// specified WebSocket server.
+func (ws WebSocketDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	logger.Infof("Connecting to WebSocket Destination: URL=%s", ws.URL)
 
-	if req.WebSocketDestURL == "" {
+	if ws.URL == "" {
 		return errors.New("missing WebSocket destination details")
 	}
 
 	// Connect to WebSocket server
-	conn, _, err := websocket.DefaultDialer.Dial(req.WebSocketDestURL, nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, ws.URL, nil)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	// Convert data to string if necessary
-	var msg string
-	switch v := data.(type) {
-	case string:
-		msg = v
-	case []byte:
-		msg = string(v)
-	default:
-		return errors.New("data should be a string or byte slice to send over WebSocket")
-	}
-
-	// Send the message to WebSocket
-	err = conn.WriteMessage(websocket.TextMessage, []byte(msg))
-	if err != nil {
-		return err
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			break
+		}
+		msg, err := payloadFromRecord(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode record for WebSocket: %w", err)
+		}
+
+		// Send the message to WebSocket
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return err
+		}
+
+		logger.Infof("Message sent to WebSocket server: %s", msg)
 	}
-
-	logger.Infof("Message sent to WebSocket server: %s", msg)
 	return nil
 }
 
@@ -97,6 +114,12 @@ func (ws WebSocketDestination) SendData(data interface{}, req interfaces.Request
 func init() {
 	registry.RegisterSource("WebSocket", WebSocketSource{})
 	registry.RegisterDestination("WebSocket", WebSocketDestination{})
+	registry.Describe("WebSocket", registry.Info{
+		DisplayName:  "WebSocket",
+		Description:  "Receives and sends text messages over a WebSocket connection.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityStreaming},
+	})
 }
 
 // validateWebSocketData ensures the input data meets the required criteria.
@@ -1,8 +1,11 @@
 package integrations
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
+	"io"
+	"os"
+	"time"
 
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/SkySingh04/fractal/logger"
@@ -12,61 +15,109 @@ import (
 
 // YAMLSource struct represents the configuration for reading data from a YAML file.
 type YAMLSource struct {
-	FilePath string `json:"yaml_source_file_path"`
+	FilePath string `json:"file_path" validate:"required" description:"Path of the YAML file" example:"records.yaml"`
 }
 
 // YAMLDestination struct represents the configuration for writing data to a YAML file.
 type YAMLDestination struct {
-	FilePath string `json:"yaml_output_file_path"`
+	FilePath string `json:"file_path" validate:"required" description:"Path of the YAML file" example:"records.yaml"`
 }
 
-// FetchData reads and processes data from a YAML source file.
-func (y YAMLSource) FetchData(req interfaces.Request) (interface{}, error) {
-	logger.Infof("Fetching data from YAML source: %s", req.YAMLSourceFilePath)
+// FetchData streams records from a YAML source file. Each document in the file
+// is decoded on its own; a document holding a sequence is emitted element by
+// element. Mappings keep their key order.
+func (y YAMLSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	logger.Infof("Fetching data from YAML source: %s", y.FilePath)
 
-	if req.YAMLSourceFilePath == "" {
-		return nil, errors.New("missing YAML source file path")
+	if y.FilePath == "" {
+		return errors.New("missing YAML source file path")
 	}
 
-	// Read the YAML file
-	data, err := ioutil.ReadFile(req.YAMLSourceFilePath)
+	file, err := os.Open(y.FilePath)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer file.Close()
 
-	// Validate and sanitize the YAML data
-	validatedData, err := ValidateYAMLData(data)
-	if err != nil {
-		logger.Fatalf("Validation error: %v", err)
-		return nil, err
-	}
+	collection := collectionName(y.FilePath)
+	emit := func(node *yaml.Node) error {
+		value, err := yamlNodeToValue(node)
+		if err != nil {
+			return err
+		}
 
-	// Transform the YAML data if necessary
-	transformedData, err := transformYAMLData(validatedData)
-	if err != nil {
-		logger.Fatalf("Transformation error: %v", err)
-		return nil, err
+		// Transform the YAML data if necessary
+		transformedData, err := transformYAMLData(value)
+		if err != nil {
+			logger.Fatalf("Transformation error: %v", err)
+			return err
+		}
+		if err := interfaces.Send(ctx, out, recordFromValue(collection, transformedData)); err != nil {
+			return err
+		}
+		return nil
 	}
 
-	return transformedData, nil
+	decoder := yaml.NewDecoder(file)
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return errors.New("invalid YAML format")
+		}
+		if len(document.Content) == 0 {
+			continue
+		}
+
+		root := document.Content[0]
+		items := []*yaml.Node{root}
+		if root.Kind == yaml.SequenceNode {
+			items = root.Content
+		}
+		for _, item := range items {
+			if err := emit(item); err != nil {
+				return err
+			}
+		}
+	}
 }
 
-// SendData writes the provided data to a YAML destination file.
-func (y YAMLDestination) SendData(data interface{}, req interfaces.Request) error {
-	logger.Infof("Sending data to YAML destination: %s", req.YAMLDestinationFilePath)
+// SendData writes each streamed record to a YAML destination file as its own
+// document.
+func (y YAMLDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	logger.Infof("Sending data to YAML destination: %s", y.FilePath)
 
-	if req.YAMLDestinationFilePath == "" {
+	if y.FilePath == "" {
 		return errors.New("missing YAML destination file path")
 	}
 
-	// Write the data to the YAML file
-	err := writeYAMLFile(req.YAMLDestinationFilePath, data)
+	file, err := os.Create(y.FilePath)
 	if err != nil {
-		logger.Fatalf("Error writing data to YAML file: %v", err)
 		return err
 	}
+	defer file.Close()
 
-	logger.Infof("Data successfully written to %s", req.YAMLDestinationFilePath)
+	encoder := yaml.NewEncoder(file)
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			break
+		}
+		if err := encoder.Encode(recordToYAMLNode(record)); err != nil {
+			logger.Fatalf("Error writing data to YAML file: %v", err)
+			return err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	logger.Infof("Data successfully written to %s", y.FilePath)
 	return nil
 }
 
@@ -83,7 +134,6 @@ func ValidateYAMLData(data []byte) (interface{}, error) {
 	return sanitizedData, nil
 }
 
-// sanitizeYAMLData recursively sanitizes the YAML data to ensure consistency
 // sanitizeYAMLData recursively sanitizes the YAML data to ensure consistency
 func sanitizeYAMLData(data interface{}) interface{} {
 	switch v := data.(type) {
@@ -109,27 +159,12 @@ func sanitizeYAMLData(data interface{}) interface{} {
 	}
 }
 
-// writeYAMLFile writes the provided data to a YAML file.
-func writeYAMLFile(filename string, data interface{}) error {
-	outputData, err := yaml.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	err = ioutil.WriteFile(filename, outputData, 0644)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // transformYAMLData applies transformations to the YAML data.
 func transformYAMLData(data interface{}) (interface{}, error) {
-	// Example transformation: Add a key-value pair if the data is a map
-	if yamlMap, ok := data.(map[string]interface{}); ok {
-		yamlMap["transformed"] = true
-		return yamlMap, nil
+	// Example transformation: Add a key-value pair if the data is a mapping
+	if record, ok := data.(*interfaces.Record); ok {
+		record.Set("transformed", true)
+		return record, nil
 	}
 
 	// If no transformation is required, return data as is
@@ -137,8 +172,94 @@ func transformYAMLData(data interface{}) (interface{}, error) {
 	return data, nil
 }
 
+// yamlNodeToValue converts a decoded YAML node into canonical values, keeping
+// the key order of mappings.
+func yamlNodeToValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlNodeToValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlNodeToValue(node.Alias)
+	case yaml.MappingNode:
+		record := interfaces.NewRecord()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := yamlNodeToValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			record.Set(node.Content[i].Value, value)
+		}
+		return record, nil
+	case yaml.SequenceNode:
+		items := make([]interface{}, len(node.Content))
+		for i, child := range node.Content {
+			item, err := yamlNodeToValue(child)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		// Let the YAML resolver pick the scalar type
+		var scalar interface{}
+		if err := node.Decode(&scalar); err != nil {
+			return nil, err
+		}
+		return interfaces.Normalize(scalar), nil
+	}
+}
+
+// recordToYAMLNode converts a record into a YAML mapping that keeps the field
+// order of the record.
+func recordToYAMLNode(record *interfaces.Record) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, field := range record.Fields {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Name},
+			valueToYAMLNode(field.Value))
+	}
+	return node
+}
+
+func valueToYAMLNode(value interface{}) *yaml.Node {
+	switch v := value.(type) {
+	case *interfaces.Record:
+		return recordToYAMLNode(v)
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			node.Content = append(node.Content, valueToYAMLNode(item))
+		}
+		return node
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: interfaces.ValueString(v)}
+	case int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: interfaces.ValueString(v)}
+	case float64, interfaces.Decimal:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: interfaces.ValueString(v)}
+	case []byte:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!binary", Value: interfaces.ValueString(v)}
+	case time.Time:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp", Value: interfaces.ValueString(v)}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: interfaces.ValueString(v)}
+	}
+}
+
 // Initialize the YAML integrations by registering them with the registry.
 func init() {
 	registry.RegisterSource("YAML", YAMLSource{})
 	registry.RegisterDestination("YAML", YAMLDestination{})
+	registry.Describe("YAML", registry.Info{
+		DisplayName:  "YAML",
+		Description:  "Reads and writes YAML files, one record per document.",
+		Version:      "1.0.0",
+		Capabilities: []registry.Capability{registry.CapabilityBatch},
+	})
 }
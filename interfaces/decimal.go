@@ -0,0 +1,110 @@
+package interfaces
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact base-10 number, used for values such as SQL NUMERIC
+// columns that cannot be represented as a float64 without losing precision.
+// Its value is Unscaled * 10^-Scale.
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+// ParseDecimal parses a decimal number such as "-12.340".
+func ParseDecimal(s string) (Decimal, error) {
+	text := strings.TrimSpace(s)
+	if text == "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	var scale int32
+	digits := text
+	if dot := strings.IndexByte(text, '.'); dot >= 0 {
+		fraction := text[dot+1:]
+		if strings.ContainsAny(fraction, "+-") {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		digits = text[:dot] + fraction
+		scale = int32(len(fraction))
+	}
+
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{Unscaled: unscaled, Scale: scale}, nil
+}
+
+// String formats the decimal in plain notation, keeping its scale.
+func (d Decimal) String() string {
+	if d.Unscaled == nil {
+		return "0"
+	}
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	sign := ""
+	if d.Unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if d.Scale <= 0 {
+		return sign + digits + strings.Repeat("0", int(-d.Scale))
+	}
+	if len(digits) <= int(d.Scale) {
+		digits = strings.Repeat("0", int(d.Scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(d.Scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Rat returns the decimal as an exact rational number.
+func (d Decimal) Rat() *big.Rat {
+	if d.Unscaled == nil {
+		return new(big.Rat)
+	}
+	r := new(big.Rat).SetInt(d.Unscaled)
+	if d.Scale == 0 {
+		return r
+	}
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs32(d.Scale))), nil)
+	if d.Scale > 0 {
+		return r.Quo(r, new(big.Rat).SetInt(exp))
+	}
+	return r.Mul(r, new(big.Rat).SetInt(exp))
+}
+
+// Float64 returns the nearest float64 to the decimal.
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// Add returns d + other exactly, with the larger of their scales.
+func (d Decimal) Add(other Decimal) Decimal {
+	scale := max(d.Scale, other.Scale)
+	return Decimal{Unscaled: new(big.Int).Add(d.scaled(scale), other.scaled(scale)), Scale: scale}
+}
+
+// scaled returns the unscaled value of d at a scale no smaller than its own.
+func (d Decimal) scaled(scale int32) *big.Int {
+	n := new(big.Int)
+	if d.Unscaled != nil {
+		n.Set(d.Unscaled)
+	}
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.Scale)), nil)
+	return n.Mul(n, exp)
+}
+
+// Cmp compares two decimals and returns -1, 0 or +1.
+func (d Decimal) Cmp(other Decimal) int {
+	return d.Rat().Cmp(other.Rat())
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
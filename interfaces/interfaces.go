@@ -1,65 +1,322 @@
 package interfaces
 
+import "context"
+
+// DataSource streams records out of an input. FetchData converts everything it
+// reads into Records, sends each one on out as soon as it has been read and
+// returns once the input is exhausted. The caller owns out and closes it after
+// FetchData returns, so a closed channel marks the end of the stream and a
+// non-nil error marks a failed one.
+//
+// FetchData must stop and return ctx.Err() once ctx is done. Use Send to
+// emit records so a cancelled migration never blocks on a full channel.
 type DataSource interface {
-	FetchData(req Request) (interface{}, error)
+	FetchData(ctx context.Context, req Request, out chan<- *Record) error
 }
 
+// DataDestination consumes a record stream. SendData converts each Record into
+// the destination's native format as it arrives, and returns once in has been
+// closed and drained, or on the first error. Like FetchData it must return
+// ctx.Err() once ctx is done; Receive does this while waiting for records.
 type DataDestination interface {
-	SendData(data interface{}, req Request) error
+	SendData(ctx context.Context, in <-chan *Record, req Request) error
+}
+
+// Send delivers record on out, giving up with ctx.Err() if ctx is done first.
+func Send(ctx context.Context, out chan<- *Record, record *Record) error {
+	select {
+	case out <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// Request struct to hold migration request data
+// Receive waits for the next record on in. It returns a nil record and a nil
+// error once in is closed, and ctx.Err() if ctx is done first.
+func Receive(ctx context.Context, in <-chan *Record) (*Record, error) {
+	select {
+	case record := <-in:
+		return record, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Request struct to hold migration request data. Connection details are not
+// part of the request: InputConfig and OutputConfig are decoded by the
+// registry into the config struct declared by each integration.
 type Request struct {
-	Input                   string `json:"input"`            // List of input types (Kafka, SQL, MongoDB, etc.)
-	Output                  string `json:"output"`           // List of output types (CSV, MongoDB, etc.)
-	ValidationRules         string `json:"validation_rules"` // Validation rules
-	TransformationRules     string `json:"transformation_rules"`
-	ErrorHandling           string `json:"error_handling"`
-	ConsumerURL             string `json:"consumer_url"`   // URL for Kafka
-	ConsumerTopic           string `json:"consumer_topic"` // Topic for Kafka
-	ProducerURL             string `json:"producer_url"`
-	ProducerTopic           string `json:"producer_topic"`
-	SQLSourceConnString     string `json:"sql_source_conn_string"`     // Source SQL connection string
-	SQLTargetConnString     string `json:"sql_target_conn_string"`     // Target SQL connection string
-	SourceMongoDBConnString string `json:"source_mongodb_conn_string"` // MongoDB source connection string
-	SourceMongoDBDatabase   string `json:"source_mongodb_database"`    // MongoDB source database
-	SourceMongoDBCollection string `json:"source_mongodb_collection"`  // MongoDB source collection
-	TargetMongoDBConnString string `json:"target_mongodb_conn_string"` // MongoDB target connection string
-	TargetMongoDBDatabase   string `json:"target_mongodb_database"`    // MongoDB target database
-	TargetMongoDBCollection string `json:"target_mongodb_collection"`  // MongoDB target collection
-	OutputFileName          string `json:"output_file_name"`           // Output file name for CSVs or other formats
-	// RabbitMQ
-	RabbitMQInputURL        string `json:"rabbitmq_input_url"`         // URL for RabbitMQ (consumer)
-	RabbitMQInputQueueName  string `json:"rabbitmq_input_queue_name"`  // Queue name for RabbitMQ input
-	RabbitMQOutputURL       string `json:"rabbitmq_output_url"`        // URL for RabbitMQ (producer)
-	RabbitMQOutputQueueName string `json:"rabbitmq_output_queue_name"` // Queue name for RabbitMQ output
-	// JSON
-	JSONSourceData     string `json:"json_source_data"`     // JSON source data (raw or file path)
-	JSONOutputFilename string `json:"json_output_filename"` // JSON output data (raw or file path)
-	// YAML
-	YAMLSourceFilePath      string `json:"yaml_source_file_path"`      // Source YAML file path
-	YAMLDestinationFilePath string `json:"yaml_destination_file_path"` // Destination YAML file path
-	// CSV
-	CSVSourceFileName      string `json:"csv_source_file_name"`      // Source CSV file name
-	CSVDestinationFileName string `json:"csv_destination_file_name"` // Destination CSV file name
-	// Dynamodb
-	DynamoDBSourceTable  string `json:"dynamodb_source_table"`  // Source DynamoDB table
-	DynamoDBTargetTable  string `json:"dynamodb_target_table"`  // Target DynamoDB table
-	DynamoDBSourceRegion string `json:"dynamodb_source_region"` // DynamoDB source region
-	DynamoDBTargetRegion string `json:"dynamodb_target_region"` // DynamoDB target region
-	// FTP
-	FTPFILEPATH        string `json:"ftp_file_path"`        // FTP file path
-	FTPURL             string `json:"ftp_url"`              // FTP URL
-	FTPUser            string `json:"ftp_user"`             // FTP user
-	FTPPassword        string `json:"ftp_password"`         // FTP password
-	SFTPFILEPATH       string `json:"sftp_file_path"`       // SFTP file path
-	SFTPURL            string `json:"sftp_url"`             // SFTP URL
-	SFTPUser           string `json:"sftp_user"`            // SFTP user
-	SFTPPassword       string `json:"sftp_password"`        // SFTP password
-	WebSocketSourceURL string `json:"websocket_source_url"` // WebSocket source URL
-	WebSocketDestURL   string `json:"websocket_dest_url"`   // WebSocket destination URL
-	// Firebase
-	CredentialFileAddr string `json:"firebase_credential_file"`
-	Collection         string `json:"firebase_collection"`
-	Document           string `json:"firebase_document"`
+	Input               string                 `json:"input"`         // Registered name of the source (Kafka, PostgreSQL, MongoDB, etc.)
+	Output              string                 `json:"output"`        // Registered name of the destination
+	InputConfig         map[string]interface{} `json:"input_config"`  // Options for the source
+	OutputConfig        map[string]interface{} `json:"output_config"` // Options for the destination
+	ValidationRules     string                 `json:"validation_rules"`
+	TransformationRules string                 `json:"transformation_rules"`
+	ErrorHandling       string                 `json:"error_handling"`
+
+	// Outputs lists the destinations of a fan-out migration, which reads the
+	// source once and writes every record to each output. When it is empty,
+	// Output and OutputConfig describe the only destination.
+	Outputs []Output `json:"outputs,omitempty"`
+	// FanOut decides what happens when one of several outputs fails:
+	// "all_or_nothing" (the default) stops the whole migration, while
+	// "best_effort" keeps writing to the outputs that still work.
+	FanOut string `json:"fan_out,omitempty"`
+
+	// Inputs lists the sources of a fan-in migration, whose records are
+	// combined into one stream as described by Merge. When it is empty,
+	// Input and InputConfig describe the only source.
+	Inputs []Input `json:"inputs,omitempty"`
+	Merge  Merge   `json:"merge"`
+
+	// Pipeline describes a migration as a DAG of named stages. When it has
+	// stages, it replaces every input and output field above.
+	Pipeline Pipeline `json:"pipeline"`
+
+	// Checkpoint lets a migration resume where the previous run stopped
+	// instead of starting over. It is enabled when Checkpoint.Store is set.
+	Checkpoint Checkpoint `json:"checkpoint"`
+
+	// References are the reference datasets the LOOKUP function of the
+	// rules reads, such as a table mapping country codes to names.
+	References []Reference `json:"references,omitempty"`
+
+	// Dedup drops the records of the source whose key was already seen,
+	// such as messages a broker delivered twice.
+	Dedup *Dedup `json:"dedup,omitempty"`
+
+	// Contract is the schema the records of the source must follow,
+	// declared, read from a JSON Schema or inferred from the first records.
+	Contract *Contract `json:"contract,omitempty"`
+}
+
+// Reference is a named reference dataset, read through a registered source
+// and held in memory while the migration runs.
+type Reference struct {
+	Name        string                 `json:"name"`  // Dataset name used by LOOKUP
+	Input       string                 `json:"input"` // Registered name of the source
+	InputConfig map[string]interface{} `json:"input_config"`
+	Refresh     string                 `json:"refresh,omitempty"` // How often the dataset is reloaded, such as 10m; once when empty
+}
+
+// Checkpoint configures where a migration records how far it has got.
+type Checkpoint struct {
+	Store string `json:"store"` // "file" or "sqlite"
+	// Name identifies the migration in the store. It defaults to the source
+	// and destinations, e.g. "PostgreSQL->MongoDB".
+	Name   string `json:"name"`
+	Path   string `json:"path"`   // File or database holding the checkpoints
+	Driver string `json:"driver"` // database/sql driver of the sqlite store, "sqlite" by default
+}
+
+// Pipeline is a DAG of stages. Each stage names the stages it reads from, and
+// records flow from sources through filters, transforms, routers and
+// aggregates to sinks.
+type Pipeline struct {
+	Stages []Stage `json:"stages,omitempty"`
+}
+
+// Stage is one named step of a pipeline. Which fields apply depends on Type.
+type Stage struct {
+	Name string `json:"name"`
+	// Type is source, filter, transform, router, aggregate, dedup, contract
+	// or sink
+	Type string `json:"type"`
+	// Inputs names the stages this stage reads from. A route of a router is
+	// named "<router>.<route>", and "<router>.default" receives the records
+	// no route matched.
+	Inputs []string `json:"inputs,omitempty"`
+
+	Integration string                 `json:"integration,omitempty"` // Registered name of the source or destination
+	Config      map[string]interface{} `json:"config,omitempty"`      // Options of the integration
+
+	Rules      string      `json:"rules,omitempty"` // Conditions of a filter, transformations of a transform or aggregation rules of an aggregate
+	Routes     []Route     `json:"routes,omitempty"`
+	GroupBy    []string    `json:"group_by,omitempty"`
+	Aggregates []Aggregate `json:"aggregates,omitempty"`
+	Window     *Window     `json:"window,omitempty"` // Windows of an aggregate reading a stream
+	Dedup      *Dedup      `json:"dedup,omitempty"`  // Keys and store of a dedup
+	Contract   *Contract   `json:"contract,omitempty"`
+}
+
+// Types of the stages of a pipeline.
+const (
+	// StageSource reads records from a registered source.
+	StageSource = "source"
+	// StageFilter keeps the records that pass its rules and drops the others.
+	StageFilter = "filter"
+	// StageTransform applies its transformation rules to every record.
+	StageTransform = "transform"
+	// StageRouter sends every record to the first of its routes it matches.
+	StageRouter = "router"
+	// StageAggregate reads every record and emits one summary record per
+	// group once its inputs are exhausted, or per group and window of time
+	// as each window closes.
+	StageAggregate = "aggregate"
+	// StageDedup drops the records whose key was already seen.
+	StageDedup = "dedup"
+	// StageContract checks every record against a schema and fails the
+	// pipeline on the first that does not follow it.
+	StageContract = "contract"
+	// StageSink writes records to a registered destination.
+	StageSink = "sink"
+)
+
+// Route sends the records of a router that satisfy When to the stages that
+// read "<router>.<Name>". Routes are tried in order and the first match wins.
+type Route struct {
+	Name string `json:"name"`
+	When string `json:"when"` // Validation rules a record must pass
+}
+
+// Aggregate is one summary field computed by an aggregate stage.
+type Aggregate struct {
+	Name     string `json:"name"`     // Field of the summary record
+	Function string `json:"function"` // count, sum, avg, min, max or distinct_count
+	Field    string `json:"field"`    // Aggregated field, not needed by count
+}
+
+// Window splits the records of an aggregate stage into windows of time, so
+// that a stream is summarised as it goes rather than once it ends.
+type Window struct {
+	Type      string `json:"type"`                 // tumbling or sliding
+	Size      string `json:"size"`                 // Length of a window, such as 5m or 1h
+	Slide     string `json:"slide,omitempty"`      // How often a sliding window starts
+	TimeField string `json:"time_field,omitempty"` // Field holding the time of a record, the time it is read when empty
+}
+
+// Dedup drops records whose key fields hold the same values as an earlier
+// record. Keep decides which of the records sharing a key is written.
+type Dedup struct {
+	Keys   []string `json:"keys"`             // Fields identifying a record
+	Window string   `json:"window,omitempty"` // How long a key is remembered, such as 24h; for the whole run when empty
+	Keep   string   `json:"keep,omitempty"`   // first (the default), last or merge
+	// Store is "memory" (the default), which remembers the keys of one run
+	// in an LRU of MaxKeys keys, or "file", which remembers them across
+	// runs in the JSON file at Path.
+	Store   string `json:"store,omitempty"`
+	MaxKeys int    `json:"max_keys,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+// Contract is the schema records must follow. Fields declares it,
+// JSONSchema reads it from a JSON Schema document, and Infer learns it from
+// the first records. A record whose fields differ from it has drifted, which
+// fails the migration unless OnDrift is "log".
+type Contract struct {
+	Fields     []ContractField `json:"fields,omitempty"`
+	JSONSchema string          `json:"json_schema,omitempty"` // A JSON Schema document, or the path of a file holding one
+	// Infer is the number of records sampled to infer the schema. The
+	// inferred schema is saved as a JSON Schema to Path, and later runs
+	// check records against the saved one.
+	Infer int    `json:"infer,omitempty"`
+	Path  string `json:"path,omitempty"`
+	// AllowNewFields accepts fields that declared or inferred fields do not
+	// list. A JSON Schema allows them unless additionalProperties is false.
+	AllowNewFields bool   `json:"allow_new_fields,omitempty"`
+	OnDrift        string `json:"on_drift,omitempty"` // fail (the default) or log
+}
+
+// ContractField is a field of a declared Contract.
+type ContractField struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type,omitempty"` // A FieldType other than null; any when empty
+	Nullable bool            `json:"nullable,omitempty"`
+	Optional bool            `json:"optional,omitempty"` // The field may be missing
+	Enum     []interface{}   `json:"enum,omitempty"`     // Values the field may hold
+	Fields   []ContractField `json:"fields,omitempty"`   // Fields of an object
+	Items    *ContractField  `json:"items,omitempty"`    // Elements of an array, without a name
+}
+
+// Input is one source of a fan-in migration.
+type Input struct {
+	Name        string                 `json:"name"`  // Label used in logs and field prefixes, defaults to Input
+	Input       string                 `json:"input"` // Registered name of the source
+	InputConfig map[string]interface{} `json:"input_config"`
+	Key         string                 `json:"key"` // Join key of this input, defaults to Merge.Key
+}
+
+// Merge describes how the records of several inputs are combined.
+type Merge struct {
+	// Mode is "union" (the default) to interleave the records of every
+	// input, or "inner_join"/"left_join" to join the first input with the
+	// others on Key.
+	Mode string `json:"mode"`
+	Key  string `json:"key"`
+	// MaxInMemory bounds the records of a lookup input held in memory.
+	// Beyond it a join fails, unless Spill allows it to continue on disk.
+	MaxInMemory int  `json:"max_in_memory"`
+	Spill       bool `json:"spill"`
+}
+
+// Sources returns the inputs of the migration: Inputs when it is set,
+// otherwise a single input built from Input and InputConfig.
+func (r Request) Sources() []Input {
+	if len(r.Inputs) > 0 {
+		inputs := make([]Input, len(r.Inputs))
+		for i, input := range r.Inputs {
+			if input.Name == "" {
+				input.Name = input.Input
+			}
+			if input.Key == "" {
+				input.Key = r.Merge.Key
+			}
+			inputs[i] = input
+		}
+		return inputs
+	}
+	return []Input{{Name: r.Input, Input: r.Input, InputConfig: r.InputConfig, Key: r.Merge.Key}}
+}
+
+// ForInput returns the request seen by the source of input.
+func (r Request) ForInput(input Input) Request {
+	r.Input = input.Input
+	r.InputConfig = input.InputConfig
+	r.Inputs = nil
+	return r
+}
+
+// Output is one destination of a fan-out migration. Each output has its own
+// configuration, transformation rules and error handling strategy.
+type Output struct {
+	Name                string                 `json:"name"`   // Label used in logs and results, defaults to Output
+	Output              string                 `json:"output"` // Registered name of the destination
+	OutputConfig        map[string]interface{} `json:"output_config"`
+	TransformationRules string                 `json:"transformation_rules"`
+	ErrorHandling       string                 `json:"error_handling"`
+}
+
+// Destinations returns the outputs of the migration: Outputs when it is set,
+// otherwise a single output built from Output, OutputConfig,
+// TransformationRules and ErrorHandling.
+func (r Request) Destinations() []Output {
+	if len(r.Outputs) > 0 {
+		outputs := make([]Output, len(r.Outputs))
+		for i, output := range r.Outputs {
+			if output.Name == "" {
+				output.Name = output.Output
+			}
+			outputs[i] = output
+		}
+		return outputs
+	}
+	return []Output{{
+		Name:                r.Output,
+		Output:              r.Output,
+		OutputConfig:        r.OutputConfig,
+		TransformationRules: r.TransformationRules,
+		ErrorHandling:       r.ErrorHandling,
+	}}
+}
+
+// ForOutput returns the request seen by the destination of output: the
+// output's own settings replace the top-level ones.
+func (r Request) ForOutput(output Output) Request {
+	r.Output = output.Output
+	r.OutputConfig = output.OutputConfig
+	r.TransformationRules = output.TransformationRules
+	r.ErrorHandling = output.ErrorHandling
+	r.Outputs = nil
+	return r
 }
@@ -0,0 +1,452 @@
+package interfaces
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Field is a single named value inside a Record.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Record is the canonical unit of data exchanged between integrations. Field
+// order is preserved so that tabular destinations write columns in the order
+// the source produced them.
+//
+// Values are always one of the canonical types: nil (null), bool, int64,
+// float64, string, []byte (binary), time.Time (timestamp), Decimal, *Record
+// (nested object) or []interface{} (array of canonical values). Set converts
+// native Go values into these types.
+type Record struct {
+	Fields []Field
+	// Schema optionally describes the collection the record belongs to. Sources
+	// set it so destinations can pick table names and column types.
+	Schema *Schema
+}
+
+// NewRecord creates an empty record.
+func NewRecord() *Record {
+	return &Record{}
+}
+
+// RecordFromMap builds a record from a map. Map keys carry no order, so fields
+// are sorted by name to keep the result deterministic.
+func RecordFromMap(m map[string]interface{}) *Record {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	record := &Record{Fields: make([]Field, 0, len(m))}
+	for _, name := range names {
+		record.Set(name, m[name])
+	}
+	return record
+}
+
+// Len returns the number of fields in the record.
+func (r *Record) Len() int {
+	return len(r.Fields)
+}
+
+// Index returns the position of the named field, or -1 if it is absent.
+func (r *Record) Index(name string) int {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of the named field.
+func (r *Record) Get(name string) (interface{}, bool) {
+	if i := r.Index(name); i >= 0 {
+		return r.Fields[i].Value, true
+	}
+	return nil, false
+}
+
+// Set assigns value to the named field, appending the field if it does not
+// exist yet. The value is converted to its canonical type.
+func (r *Record) Set(name string, value interface{}) {
+	value = Normalize(value)
+	if i := r.Index(name); i >= 0 {
+		r.Fields[i].Value = value
+		return
+	}
+	r.Fields = append(r.Fields, Field{Name: name, Value: value})
+}
+
+// Delete removes the named field and reports whether it existed.
+func (r *Record) Delete(name string) bool {
+	i := r.Index(name)
+	if i < 0 {
+		return false
+	}
+	r.Fields = append(r.Fields[:i], r.Fields[i+1:]...)
+	return true
+}
+
+// Rename changes the name of a field in place, keeping its position. It
+// reports whether the field existed.
+func (r *Record) Rename(oldName, newName string) bool {
+	i := r.Index(oldName)
+	if i < 0 {
+		return false
+	}
+	if j := r.Index(newName); j >= 0 && j != i {
+		// The new name replaces any existing field with that name
+		r.Fields = append(r.Fields[:j], r.Fields[j+1:]...)
+		if j < i {
+			i--
+		}
+	}
+	r.Fields[i].Name = newName
+	return true
+}
+
+// Names returns the field names in order.
+func (r *Record) Names() []string {
+	names := make([]string, len(r.Fields))
+	for i, field := range r.Fields {
+		names[i] = field.Name
+	}
+	return names
+}
+
+// Clone returns a deep copy of the record. The schema is shared.
+func (r *Record) Clone() *Record {
+	clone := &Record{Fields: make([]Field, len(r.Fields)), Schema: r.Schema}
+	for i, field := range r.Fields {
+		clone.Fields[i] = Field{Name: field.Name, Value: cloneValue(field.Value)}
+	}
+	return clone
+}
+
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *Record:
+		return v.Clone()
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = cloneValue(item)
+		}
+		return items
+	case []byte:
+		return append([]byte(nil), v...)
+	default:
+		return v
+	}
+}
+
+// ToMap converts the record into a map, recursively converting nested
+// records. Arrays and other canonical values are returned as-is.
+func (r *Record) ToMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(r.Fields))
+	for _, field := range r.Fields {
+		m[field.Name] = toNative(field.Value)
+	}
+	return m
+}
+
+func toNative(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *Record:
+		return v.ToMap()
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = toNative(item)
+		}
+		return items
+	default:
+		return v
+	}
+}
+
+// Normalize converts a native Go value into its canonical Record type. Maps
+// become nested records, slices become []interface{}, and any type that has
+// no canonical equivalent is stored as its string representation.
+func Normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int64, float64, string, []byte, time.Time, Decimal, *Record:
+		return v
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint:
+		return normalizeUint(uint64(v))
+	case uint64:
+		return normalizeUint(v)
+	case float32:
+		return float64(v)
+	case *Decimal:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case Record:
+		return &v
+	case json.Number:
+		return normalizeJSONNumber(v)
+	case map[string]interface{}:
+		return RecordFromMap(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = Normalize(item)
+		}
+		return items
+	}
+
+	// Fall back to reflection for named map, slice and pointer types
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return Normalize(rv.Elem().Interface())
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			record := &Record{}
+			keys := rv.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, key := range keys {
+				record.Set(key.String(), rv.MapIndex(key).Interface())
+			}
+			return record
+		}
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = Normalize(rv.Index(i).Interface())
+		}
+		return items
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func normalizeUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		d, _ := ParseDecimal(strconv.FormatUint(v, 10))
+		return d
+	}
+	return int64(v)
+}
+
+func normalizeJSONNumber(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if d, err := ParseDecimal(n.String()); err == nil && !bytes.ContainsAny([]byte(n), "eE") {
+		if f, err := n.Float64(); err != nil || strconv.FormatFloat(f, 'f', -1, 64) != d.String() {
+			return d // Keep precision that float64 cannot represent
+		}
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// ValueString renders a canonical value as text, for destinations and rules
+// that work with strings. Nulls render as the empty string.
+func ValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case Decimal:
+		return v.String()
+	case *Record, []interface{}:
+		data, err := marshalValue(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// MarshalJSON encodes the record as a JSON object with fields in order.
+// Timestamps are written in RFC 3339 format, binary values in base64 and
+// decimals as exact JSON numbers.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range r.Fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := marshalValue(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func marshalValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case *Record:
+		return v.MarshalJSON()
+	case []interface{}:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			data, err := marshalValue(item)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	case Decimal:
+		return []byte(v.String()), nil
+	case time.Time:
+		return json.Marshal(v.Format(time.RFC3339Nano))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return []byte("null"), nil
+		}
+		return json.Marshal(v)
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// UnmarshalJSON decodes a JSON object into the record, keeping the order of
+// its keys.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	value, err := ParseJSON(data)
+	if err != nil {
+		return err
+	}
+	record, ok := value.(*Record)
+	if !ok {
+		return errors.New("JSON value is not an object")
+	}
+	r.Fields = record.Fields
+	return nil
+}
+
+// ParseJSON decodes a JSON document into canonical values. Objects become
+// records with their keys in document order and numbers become int64 when
+// they are integral.
+func ParseJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return value, nil
+}
+
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			record := &Record{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				record.Set(key.(string), value)
+			}
+			_, err := decoder.Token() // Closing brace
+			return record, err
+		case '[':
+			items := []interface{}{}
+			for decoder.More() {
+				item, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			_, err := decoder.Token() // Closing bracket
+			return items, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	default:
+		return Normalize(t), nil
+	}
+}
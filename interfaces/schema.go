@@ -0,0 +1,96 @@
+package interfaces
+
+import "time"
+
+// FieldType is the canonical type of a Record value.
+type FieldType string
+
+const (
+	TypeNull      FieldType = "null"
+	TypeBool      FieldType = "bool"
+	TypeInt       FieldType = "int"
+	TypeFloat     FieldType = "float"
+	TypeDecimal   FieldType = "decimal"
+	TypeString    FieldType = "string"
+	TypeBinary    FieldType = "binary"
+	TypeTimestamp FieldType = "timestamp"
+	TypeObject    FieldType = "object"
+	TypeArray     FieldType = "array"
+	// TypeAny is used when a field holds values of different types.
+	TypeAny FieldType = "any"
+)
+
+// FieldSchema describes a single field of a Schema.
+type FieldSchema struct {
+	Name     string
+	Type     FieldType
+	Nullable bool
+	// Fields describes the nested fields of an object field.
+	Fields []FieldSchema
+}
+
+// Schema describes the records of a collection, such as a table, a file or a
+// topic. Name is the collection name, which destinations may use as the table
+// or collection to write to.
+type Schema struct {
+	Name   string
+	Fields []FieldSchema
+}
+
+// Field returns the schema of the named field.
+func (s *Schema) Field(name string) (FieldSchema, bool) {
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return FieldSchema{}, false
+}
+
+// TypeOf returns the canonical type of a Record value.
+func TypeOf(value interface{}) FieldType {
+	switch value.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBool
+	case int64:
+		return TypeInt
+	case float64:
+		return TypeFloat
+	case Decimal:
+		return TypeDecimal
+	case string:
+		return TypeString
+	case []byte:
+		return TypeBinary
+	case time.Time:
+		return TypeTimestamp
+	case *Record:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	default:
+		return TypeAny
+	}
+}
+
+// SchemaOf derives a schema from the fields of a single record.
+func SchemaOf(name string, record *Record) *Schema {
+	return &Schema{Name: name, Fields: fieldSchemas(record)}
+}
+
+func fieldSchemas(record *Record) []FieldSchema {
+	fields := make([]FieldSchema, len(record.Fields))
+	for i, field := range record.Fields {
+		fields[i] = FieldSchema{
+			Name:     field.Name,
+			Type:     TypeOf(field.Value),
+			Nullable: field.Value == nil,
+		}
+		if nested, ok := field.Value.(*Record); ok {
+			fields[i].Fields = fieldSchemas(nested)
+		}
+	}
+	return fields
+}
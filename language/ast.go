@@ -0,0 +1,374 @@
+package language
+
+import (
+	"regexp"
+	"time"
+)
+
+// Node is a node of the syntax tree of a rule set.
+type Node interface {
+	Pos() Pos
+}
+
+// RuleSet is a parsed rule text, holding one rule per line.
+type RuleSet struct {
+	Rules []Rule
+}
+
+// Rule is one rule of a rule set: a Check, an Operation, a Filter, or the
+// GroupBy or an Aggregate of aggregation rules.
+type Rule interface {
+	Node
+	rule()
+}
+
+// Check is a validation rule: a condition every record must satisfy.
+type Check struct {
+	At        Pos
+	Condition Expr
+}
+
+// Filter removes records from the migration: with DROP_IF, the records that
+// satisfy Condition, and with KEEP_IF, the records that do not. Filters may
+// appear in validation and transformation rules alike, and see the record as
+// the rules above them left it.
+type Filter struct {
+	At        Pos
+	Keep      bool // KEEP_IF rather than DROP_IF
+	Condition Expr
+}
+
+// Keyword returns DROP_IF or KEEP_IF.
+func (n *Filter) Keyword() string {
+	if n.Keep {
+		return "KEEP_IF"
+	}
+	return "DROP_IF"
+}
+
+// GroupBy groups the records of an aggregation by the values of Keys. Without
+// a GroupBy, every record is in the same group.
+type GroupBy struct {
+	At   Pos
+	Keys []*Key
+}
+
+// Key is a key of a GroupBy, called Name in the summary records. A key that
+// is a field is named after it unless AS gives it another name.
+type Key struct {
+	Name  string
+	Value Expr
+}
+
+// Aggregate computes Function, one of COUNT, SUM, AVG, MIN, MAX and
+// DISTINCT_COUNT, over the values of Value in each group, and stores the
+// result in the summary field Name. Null values are ignored, and COUNT()
+// without a value counts records.
+type Aggregate struct {
+	At       Pos
+	Function string
+	Value    Expr // nil for COUNT()
+	Name     string
+}
+
+// Operation is a transformation rule: RENAME, MAP, ADD_FIELD or IF.
+type Operation interface {
+	Rule
+	operation()
+}
+
+// Rename renames the field From to To, replacing any field called To. When
+// To is a plain name, the fields From selects keep their place in their
+// object; when it is a path, the field is moved there.
+type Rename struct {
+	At               Pos
+	From, To         string
+	FromPath, ToPath *Path
+}
+
+// Map replaces the value of Field by the value of the first entry whose key
+// matches it. Values without an entry are kept.
+type Map struct {
+	At      Pos
+	Field   string
+	Path    *Path
+	Entries []MapEntry
+}
+
+// MapEntry is one key-value pair of a Map.
+type MapEntry struct {
+	Key   *Literal
+	Value Expr
+}
+
+// AddField sets Field to Value, replacing the value of an existing field.
+// Objects on the path to a new nested field are created.
+type AddField struct {
+	At    Pos
+	Field string
+	Path  *Path
+	Value Expr
+}
+
+// If applies Then to the records that satisfy Condition.
+type If struct {
+	At        Pos
+	Condition Expr
+	Then      Operation
+}
+
+// Expr is an expression over the fields of a record: a condition, or a value
+// such as a literal, a field or a function call.
+type Expr interface {
+	Node
+	expr()
+}
+
+// Call calls a registered function, such as LOWER(FIELD("email")).
+type Call struct {
+	At   Pos
+	Name string
+	Args []Expr
+
+	function *Function
+}
+
+// Cast converts the value of X to Type, as in CAST(FIELD("price"),
+// DECIMAL(10, 2)). A null value stays null.
+type Cast struct {
+	At   Pos
+	X    Expr
+	Type *TypeSpec
+}
+
+// Comparison compares two values with ==, !=, <, <=, > or >=. Numbers are
+// compared numerically and other values by their text.
+type Comparison struct {
+	At          Pos
+	Op          string
+	Left, Right Expr
+}
+
+// Arithmetic combines two numbers with +, -, *, / or %. * / and % bind
+// tighter than + and -.
+type Arithmetic struct {
+	At          Pos
+	Op          string
+	Left, Right Expr
+}
+
+// Negate is the unary minus applied to a value other than a number literal.
+type Negate struct {
+	At Pos
+	X  Expr
+}
+
+// Logical combines two expressions with AND or OR. AND binds tighter than OR.
+type Logical struct {
+	At          Pos
+	Op          string // "AND" or "OR"
+	Left, Right Expr
+}
+
+// Not negates an expression.
+type Not struct {
+	At Pos
+	X  Expr
+}
+
+// FieldTest checks a field against one or more conditions, all of which must
+// hold, e.g. FIELD("age") TYPE(INT) RANGE(18, 65).
+type FieldTest struct {
+	Field      *FieldRef
+	Conditions []Condition
+}
+
+// FieldRef names a field of the record, as in FIELD("age"), or a path to
+// nested values, as in FIELD("$.user.age").
+type FieldRef struct {
+	At   Pos
+	Name string
+	Path *Path
+}
+
+// Condition is a test applied to the value of a field.
+type Condition interface {
+	Node
+	condition()
+}
+
+// TypeCondition checks that a value is of type Spec, or parses as one: STRING,
+// INT, FLOAT, BOOL, DATE or DECIMAL.
+type TypeCondition struct {
+	At   Pos
+	Type string // The name of the type
+	Spec *TypeSpec
+}
+
+// TypeSpec is a type written in a TYPE condition or a CAST, with the
+// parameters of DECIMAL and DATE, as in DECIMAL(10, 2) or
+// DATE("02/01/2006 15:04", "Europe/Paris").
+type TypeSpec struct {
+	At        Pos
+	Name      Type
+	Precision int    // Digits of a DECIMAL, 0 for any number of digits
+	Scale     int    // Digits of a DECIMAL after the point, when Precision is set
+	Layout    string // Go time layout of a DATE, empty for the layouts PARSE_DATE tries
+	Zone      string // Time zone of a DATE written without an offset, UTC when empty
+
+	location *time.Location
+}
+
+// RangeCondition checks that a value lies between Min and Max, inclusive.
+// The bounds are both numbers or both dates, and either may be nil when the
+// range is open on that side, as in RANGE(0, *).
+type RangeCondition struct {
+	At       Pos
+	Min, Max *Literal
+	min, max interface{} // An int64, an interfaces.Decimal or a time.Time, nil when open
+}
+
+// MatchesCondition checks a value against a regular expression, given as a
+// string or as the name of a predefined pattern such as EMAIL_REGEX.
+type MatchesCondition struct {
+	At      Pos
+	Pattern string // As written: the expression or the name of the pattern
+	Regexp  *regexp.Regexp
+}
+
+// InCondition checks that a value is one of Values.
+type InCondition struct {
+	At     Pos
+	Values []*Literal
+}
+
+// RequiredCondition checks that a value is not empty.
+type RequiredCondition struct {
+	At Pos
+}
+
+// LiteralKind is the kind of a literal value.
+type LiteralKind string
+
+const (
+	LiteralString LiteralKind = "STRING"
+	LiteralNumber LiteralKind = "NUMBER"
+	LiteralBool   LiteralKind = "BOOL"
+	LiteralNull   LiteralKind = "NULL"
+)
+
+// Literal is a string, number, TRUE, FALSE or NULL written in a rule.
+type Literal struct {
+	At    Pos
+	Kind  LiteralKind
+	Value string // Unquoted text of a string, digits of a number, TRUE or FALSE
+	value interface{}
+}
+
+func (n *Check) Pos() Pos             { return n.At }
+func (n *Filter) Pos() Pos            { return n.At }
+func (n *GroupBy) Pos() Pos           { return n.At }
+func (n *Aggregate) Pos() Pos         { return n.At }
+func (n *Rename) Pos() Pos            { return n.At }
+func (n *Map) Pos() Pos               { return n.At }
+func (n *AddField) Pos() Pos          { return n.At }
+func (n *If) Pos() Pos                { return n.At }
+func (n *Call) Pos() Pos              { return n.At }
+func (n *Cast) Pos() Pos              { return n.At }
+func (n *Comparison) Pos() Pos        { return n.At }
+func (n *Arithmetic) Pos() Pos        { return n.At }
+func (n *Negate) Pos() Pos            { return n.At }
+func (n *Logical) Pos() Pos           { return n.At }
+func (n *Not) Pos() Pos               { return n.At }
+func (n *FieldTest) Pos() Pos         { return n.Field.At }
+func (n *FieldRef) Pos() Pos          { return n.At }
+func (n *TypeCondition) Pos() Pos     { return n.At }
+func (n *TypeSpec) Pos() Pos          { return n.At }
+func (n *RangeCondition) Pos() Pos    { return n.At }
+func (n *MatchesCondition) Pos() Pos  { return n.At }
+func (n *InCondition) Pos() Pos       { return n.At }
+func (n *RequiredCondition) Pos() Pos { return n.At }
+func (n *Literal) Pos() Pos           { return n.At }
+
+func (*Check) rule()     {}
+func (*Filter) rule()    {}
+func (*GroupBy) rule()   {}
+func (*Aggregate) rule() {}
+func (*Rename) rule()    {}
+func (*Map) rule()       {}
+func (*AddField) rule()  {}
+func (*If) rule()        {}
+
+func (*Rename) operation()   {}
+func (*Map) operation()      {}
+func (*AddField) operation() {}
+func (*If) operation()       {}
+
+func (*Logical) expr()    {}
+func (*Not) expr()        {}
+func (*FieldTest) expr()  {}
+func (*FieldRef) expr()   {}
+func (*Literal) expr()    {}
+func (*Call) expr()       {}
+func (*Cast) expr()       {}
+func (*Comparison) expr() {}
+func (*Arithmetic) expr() {}
+func (*Negate) expr()     {}
+
+func (*TypeCondition) condition()     {}
+func (*RangeCondition) condition()    {}
+func (*MatchesCondition) condition()  {}
+func (*InCondition) condition()       {}
+func (*RequiredCondition) condition() {}
+
+// inspect calls fn for every expression of node, parents before children.
+func inspect(node Node, fn func(Expr)) {
+	switch n := node.(type) {
+	case *Check:
+		inspect(n.Condition, fn)
+	case *Filter:
+		inspect(n.Condition, fn)
+	case *GroupBy:
+		for _, key := range n.Keys {
+			inspect(key.Value, fn)
+		}
+	case *Aggregate:
+		if n.Value != nil {
+			inspect(n.Value, fn)
+		}
+	case *Map:
+		for _, entry := range n.Entries {
+			inspect(entry.Value, fn)
+		}
+	case *AddField:
+		inspect(n.Value, fn)
+	case *If:
+		inspect(n.Condition, fn)
+		inspect(n.Then, fn)
+	case Expr:
+		fn(n)
+		switch x := n.(type) {
+		case *Logical:
+			inspect(x.Left, fn)
+			inspect(x.Right, fn)
+		case *Comparison:
+			inspect(x.Left, fn)
+			inspect(x.Right, fn)
+		case *Arithmetic:
+			inspect(x.Left, fn)
+			inspect(x.Right, fn)
+		case *Not:
+			inspect(x.X, fn)
+		case *Negate:
+			inspect(x.X, fn)
+		case *Cast:
+			inspect(x.X, fn)
+		case *Call:
+			for _, arg := range x.Args {
+				inspect(arg, fn)
+			}
+		case *FieldTest:
+			inspect(x.Field, fn)
+		}
+	}
+}
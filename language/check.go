@@ -0,0 +1,184 @@
+package language
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of rule text. Validation rules are conditions, transformation rules
+// are operations, and aggregation rules are a GROUP BY and aggregates.
+// Filters belong to every kind.
+const (
+	Validation     = "validation"
+	Transformation = "transformation"
+	Aggregation    = "aggregation"
+)
+
+// kinds describes the rules of each kind in errors: what a rule of the kind
+// is, the words that start one and what they are together.
+var kinds = map[string]struct {
+	what     string
+	expected []string
+	plural   string
+}{
+	Validation:     {"a condition", []string{"a condition"}, "conditions"},
+	Transformation: {"RENAME, MAP, ADD_FIELD or IF", []string{"RENAME", "MAP", "ADD_FIELD", "IF"}, "operations"},
+	Aggregation:    {"GROUP BY or an aggregate", []string{"GROUP BY", "COUNT", "SUM", "AVG", "MIN", "MAX", "DISTINCT_COUNT"}, "GROUP BY and aggregates"},
+}
+
+// kindOf returns the kind of rule text rule belongs in, or an empty string
+// for a filter.
+func kindOf(rule Rule) (kind, what string) {
+	switch rule.(type) {
+	case *Check:
+		return Validation, "a condition"
+	case Operation:
+		return Transformation, "an operation"
+	case *GroupBy:
+		return Aggregation, "GROUP BY"
+	case *Aggregate:
+		return Aggregation, "an aggregate"
+	}
+	return "", ""
+}
+
+// Diagnose returns every error in a rule text of the given kind, or of any
+// kind when kind is empty. Unlike the Parse functions it does not stop at the
+// first error: the rest of a rule holding an error is skipped, and checking
+// goes on with the next rule. The errors are in the order of the text, and
+// there are none when the rules are valid.
+func Diagnose(rules, kind string) []*SyntaxError {
+	tokens, errs := NewLexer(rules).TokenizeAll()
+	set, parseErrs := NewParser().ParseAll(tokens)
+	errs = append(errs, parseErrs...)
+	errs = append(errs, checkKind(set, kind)...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i].Pos, errs[j].Pos
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return errs
+}
+
+// checkKind returns an error for every rule of set that does not belong in
+// rules of the given kind, or of either kind when kind is empty, and for
+// GROUP BY and aggregates that clash with each other.
+func checkKind(set *RuleSet, kind string) []*SyntaxError {
+	var errs []*SyntaxError
+	var group *GroupBy
+	names := make(map[string]bool)
+	name := func(at Pos, name string) {
+		if names[name] {
+			errs = append(errs, &SyntaxError{Pos: at, Message: fmt.Sprintf("duplicate name %q in aggregation rules", name), Token: fmt.Sprintf("%q", name)})
+		}
+		names[name] = true
+	}
+	for _, rule := range set.Rules {
+		if ruleKind, what := kindOf(rule); kind != "" && ruleKind != "" && ruleKind != kind {
+			errs = append(errs, &SyntaxError{
+				Pos:        rule.Pos(),
+				Message:    fmt.Sprintf("expected %s, found %s", kinds[kind].what, what),
+				Expected:   kinds[kind].expected,
+				Suggestion: fmt.Sprintf("%s belong in %s rules", kinds[ruleKind].plural, ruleKind),
+			})
+			continue
+		}
+		switch rule := rule.(type) {
+		case *GroupBy:
+			if group != nil {
+				errs = append(errs, &SyntaxError{
+					Pos:        rule.At,
+					Message:    fmt.Sprintf("GROUP BY is already on line %d", group.At.Line),
+					Token:      "GROUP",
+					Suggestion: "list every key in one GROUP BY",
+				})
+				continue
+			}
+			group = rule
+			for _, key := range rule.Keys {
+				name(key.Value.Pos(), key.Name)
+			}
+		case *Aggregate:
+			name(rule.At, rule.Name)
+		}
+	}
+	return errs
+}
+
+// suggest sets the suggestion of e, unless it already has one.
+func (e *SyntaxError) suggest(suggestion string) *SyntaxError {
+	if e.Suggestion == "" {
+		e.Suggestion = suggestion
+	}
+	return e
+}
+
+// vocabulary returns the words of the rule language: keywords, functions,
+// CAST, types, patterns and the TRUE, FALSE and NULL values.
+func vocabulary() []string {
+	words := []string{"TRUE", "FALSE", "NULL", "CAST"}
+	for word := range keywords {
+		words = append(words, word)
+	}
+	words = append(words, functionNames()...)
+	words = append(words, sortedKeys(types)...)
+	words = append(words, sortedKeys(patterns)...)
+	sort.Strings(words)
+	return words
+}
+
+// didYouMean suggests the candidate closest to a misspelt word, or returns
+// an empty string when none is close enough. Case is ignored, and a longer
+// candidate may be further away.
+func didYouMean(word string, candidates []string) string {
+	best, bestDistance := "", 0
+	for _, candidate := range candidates {
+		if candidate == word {
+			continue
+		}
+		d := distance(strings.ToUpper(word), strings.ToUpper(candidate))
+		if d > 2 || d*3 > len(candidate) {
+			continue
+		}
+		if best == "" || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf("did you mean %s?", best)
+}
+
+// distance is the Levenshtein distance between a and b: the number of
+// characters to insert, delete or replace to turn one into the other.
+func distance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	previous := make([]int, len(y)+1)
+	current := make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(y)]
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,65 @@
+package language
+
+import "sync"
+
+// maxCompiled bounds the number of rule texts whose compiled form is kept.
+const maxCompiled = 256
+
+// compiled caches rule sets by kind and text. A RuleSet never changes once
+// parsed, so one is shared by every migration, stage and goroutine that uses
+// the same rules.
+var compiled = struct {
+	sync.Mutex
+	sets map[compiledKey]*RuleSet
+}{sets: make(map[compiledKey]*RuleSet)}
+
+type compiledKey struct {
+	kind  string
+	rules string
+}
+
+// CompileValidation is ParseValidation, except that each distinct text is
+// only parsed once. Regular expressions, literals and range bounds are
+// prepared by the parser, so evaluating a compiled rule set does no parsing.
+func CompileValidation(rules string) (*RuleSet, error) {
+	return compile(Validation, rules, ParseValidation)
+}
+
+// CompileTransformation is ParseTransformation, except that each distinct
+// text is only parsed once.
+func CompileTransformation(rules string) (*RuleSet, error) {
+	return compile(Transformation, rules, ParseTransformation)
+}
+
+// CompileAggregation is ParseAggregation, except that each distinct text is
+// only parsed once.
+func CompileAggregation(rules string) (*RuleSet, error) {
+	return compile(Aggregation, rules, ParseAggregation)
+}
+
+func compile(kind, rules string, parse func(string) (*RuleSet, error)) (*RuleSet, error) {
+	key := compiledKey{kind: kind, rules: rules}
+	compiled.Lock()
+	set, ok := compiled.sets[key]
+	compiled.Unlock()
+	if ok {
+		return set, nil
+	}
+
+	set, err := parse(rules)
+	if err != nil {
+		return nil, err
+	}
+	compiled.Lock()
+	defer compiled.Unlock()
+	if len(compiled.sets) >= maxCompiled {
+		// Rules come from configuration, so this only happens to a
+		// long-running server; start over rather than track usage
+		compiled.sets = make(map[compiledKey]*RuleSet)
+	}
+	if cached, ok := compiled.sets[key]; ok {
+		return cached, nil
+	}
+	compiled.sets[key] = set
+	return set, nil
+}
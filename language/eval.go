@@ -0,0 +1,617 @@
+package language
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// Parse tokenizes and parses a rule string. It returns nil when there are no
+// rules.
+func Parse(rules string) (*RuleSet, error) {
+	lexer := NewLexer(rules)
+	tokens, err := lexer.Tokenize()
+	if err != nil {
+		return nil, err
+	}
+
+	parser := NewParser()
+	set, err := parser.ParseRules(tokens)
+	if err != nil || len(set.Rules) == 0 {
+		return nil, err
+	}
+	return set, nil
+}
+
+// ParseValidation parses validation rules, which must all be conditions.
+func ParseValidation(rules string) (*RuleSet, error) {
+	return parseKind(rules, Validation)
+}
+
+// ParseTransformation parses transformation rules, which must all be
+// operations.
+func ParseTransformation(rules string) (*RuleSet, error) {
+	return parseKind(rules, Transformation)
+}
+
+// ParseAggregation parses aggregation rules: at most one GROUP BY and the
+// aggregates computed for each group.
+func ParseAggregation(rules string) (*RuleSet, error) {
+	return parseKind(rules, Aggregation)
+}
+
+func parseKind(rules, kind string) (*RuleSet, error) {
+	set, err := Parse(rules)
+	if err != nil || set == nil {
+		return nil, err
+	}
+	if errs := checkKind(set, kind); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return set, nil
+}
+
+// DropError is returned by Validate and Transform when a DROP_IF or KEEP_IF
+// rule removes the record. It is not a failure: the record is left out of
+// the migration instead of being reported as invalid.
+type DropError struct {
+	Rule *Filter
+}
+
+func (e *DropError) Error() string {
+	return fmt.Sprintf("record dropped by %s on line %d", e.Rule.Keyword(), e.Rule.At.Line)
+}
+
+// Validate checks a record against every parsed validation rule and returns
+// the first rule that fails, or a DropError when a filter removes the record
+// before a rule fails.
+func Validate(record *interfaces.Record, rules *RuleSet) error {
+	return ValidateWith(record, rules, nil)
+}
+
+// ValidateWith is Validate, with LOOKUP reading the reference datasets of
+// datasets.
+func ValidateWith(record *interfaces.Record, rules *RuleSet, datasets Datasets) error {
+	if errs := validate(record, rules, datasets, false); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidateAll is ValidateWith, except that it goes on after a failed rule
+// and returns the error of every rule the record fails, in order. A filter
+// removing the record ends the list with its DropError.
+func ValidateAll(record *interfaces.Record, rules *RuleSet, datasets Datasets) []error {
+	return validate(record, rules, datasets, true)
+}
+
+func validate(record *interfaces.Record, rules *RuleSet, datasets Datasets, all bool) []error {
+	env := &env{record: record, now: time.Now().UTC(), datasets: datasets}
+	var errs []error
+	for _, rule := range rules.Rules {
+		switch rule := rule.(type) {
+		case *Check:
+			if err := env.test(rule.Condition); err != nil {
+				errs = append(errs, fmt.Errorf("rule on line %d: %w", rule.At.Line, err))
+				if !all {
+					return errs
+				}
+			}
+		case *Filter:
+			if err := env.filter(rule); err != nil {
+				return append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// Transform applies every parsed transformation rule to a record, in order.
+// The record is changed in place and returned. CURRENT_TIME() has the same
+// value in every rule applied to a record.
+func Transform(record *interfaces.Record, rules *RuleSet) (*interfaces.Record, error) {
+	return TransformWith(record, rules, nil)
+}
+
+// TransformWith is Transform, with LOOKUP reading the reference datasets of
+// datasets.
+func TransformWith(record *interfaces.Record, rules *RuleSet, datasets Datasets) (*interfaces.Record, error) {
+	env := &env{record: record, now: time.Now().UTC(), datasets: datasets}
+	for _, rule := range rules.Rules {
+		switch rule := rule.(type) {
+		case Operation:
+			if err := env.apply(rule); err != nil {
+				return nil, fmt.Errorf("rule on line %d: %w", rule.Pos().Line, err)
+			}
+		case *Filter:
+			if err := env.filter(rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return record, nil
+}
+
+// Evaluate computes the value of x, such as the key of a GroupBy or the value
+// of an Aggregate, for record.
+func Evaluate(record *interfaces.Record, x Expr) (interface{}, error) {
+	return EvaluateWith(record, x, nil)
+}
+
+// EvaluateWith is Evaluate, with LOOKUP reading the reference datasets of
+// datasets.
+func EvaluateWith(record *interfaces.Record, x Expr, datasets Datasets) (interface{}, error) {
+	env := &env{record: record, now: time.Now().UTC(), datasets: datasets}
+	return env.value(x)
+}
+
+// env is the state of the evaluation of rules against a record.
+type env struct {
+	record   *interfaces.Record
+	now      time.Time
+	datasets Datasets // nil when there are none
+}
+
+// filter returns a DropError when rule removes the record. A condition that
+// cannot be evaluated, such as one on a missing field, does not hold.
+func (e *env) filter(rule *Filter) error {
+	holds := e.test(rule.Condition) == nil
+	if holds != rule.Keep {
+		return &DropError{Rule: rule}
+	}
+	return nil
+}
+
+func (e *env) apply(operation Operation) error {
+	switch op := operation.(type) {
+	case *Rename:
+		return e.rename(op)
+	case *Map:
+		for _, l := range op.Path.locate(e.record) {
+			text := interfaces.ValueString(l.get())
+			for _, entry := range op.Entries {
+				if matches(text, entry.Key) {
+					value, err := e.value(entry.Value)
+					if err != nil {
+						return err
+					}
+					l.set(value)
+					break
+				}
+			}
+		}
+	case *AddField:
+		value, err := e.value(op.Value)
+		if err != nil {
+			return err
+		}
+		return op.Path.set(e.record, value)
+	case *If:
+		if e.test(op.Condition) == nil {
+			return e.apply(op.Then)
+		}
+	default:
+		return fmt.Errorf("unsupported operation %T", operation)
+	}
+	return nil
+}
+
+// rename renames the fields op.From selects in place, or moves the field to
+// the path op.To.
+func (e *env) rename(op *Rename) error {
+	locations := op.FromPath.locate(e.record)
+	if op.ToPath.simple() {
+		for _, l := range locations {
+			l.object.Rename(l.name, op.To)
+		}
+		return nil
+	}
+	if len(locations) == 0 {
+		return nil
+	}
+	l := locations[0]
+	value := l.get()
+	l.object.Delete(l.name)
+	return op.ToPath.set(e.record, value)
+}
+
+// value computes the value of an expression. A missing field is null, and a
+// path with wildcards or recursive descent gives the array of the values it
+// selects.
+func (e *env) value(x Expr) (interface{}, error) {
+	switch x := x.(type) {
+	case *Literal:
+		return x.value, nil
+	case *FieldRef:
+		values := x.Path.Get(e.record)
+		if !x.Path.Definite() {
+			return values, nil
+		}
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[0], nil
+	case *Call:
+		return e.call(x)
+	case *Cast:
+		value, err := e.value(x.X)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		converted, err := x.Type.convert(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", Print(x), err)
+		}
+		return converted, nil
+	case *Negate:
+		value, err := e.value(x.X)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		n, ok := number(value)
+		if !ok {
+			return nil, fmt.Errorf("%s is '%s', not a number", Print(x.X), interfaces.ValueString(value))
+		}
+		switch n := n.(type) {
+		case int64:
+			return -n, nil
+		case interfaces.Decimal:
+			return interfaces.Decimal{Unscaled: new(big.Int).Neg(unscaled(n)), Scale: n.Scale}, nil
+		}
+		return -n.(float64), nil
+	case *Arithmetic:
+		return e.arithmetic(x)
+	}
+	return nil, fmt.Errorf("%T is not a value", x)
+}
+
+// arithmetic computes x. Integers stay integers and decimals stay exact,
+// except that / always returns a float, as does % on decimals. A float
+// operand gives a float, and null operands give null.
+func (e *env) arithmetic(x *Arithmetic) (interface{}, error) {
+	operands := make([]interface{}, 2)
+	for i, operand := range []Expr{x.Left, x.Right} {
+		value, err := e.value(operand)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		n, ok := number(value)
+		if !ok {
+			return nil, fmt.Errorf("%s is '%s', not a number", Print(operand), interfaces.ValueString(value))
+		}
+		operands[i] = n
+	}
+
+	a, aInt := operands[0].(int64)
+	b, bInt := operands[1].(int64)
+	if aInt && bInt && x.Op != "/" {
+		if x.Op == "%" {
+			if b == 0 {
+				return nil, fmt.Errorf("%s: division by zero", Print(x))
+			}
+			return a % b, nil
+		}
+		if n, ok := intArithmetic(x.Op, a, b); ok {
+			return n, nil
+		}
+		// Results past the range of int64 are kept exactly as decimals
+		return decimalArithmetic(x.Op, interfaces.Decimal{Unscaled: big.NewInt(a)}, interfaces.Decimal{Unscaled: big.NewInt(b)}), nil
+	}
+	_, aDecimal := operands[0].(interfaces.Decimal)
+	_, bDecimal := operands[1].(interfaces.Decimal)
+	_, aFloat := operands[0].(float64)
+	_, bFloat := operands[1].(float64)
+	if (aDecimal || bDecimal) && !aFloat && !bFloat && x.Op != "/" && x.Op != "%" {
+		c, _ := decimal(operands[0])
+		d, _ := decimal(operands[1])
+		return decimalArithmetic(x.Op, c, d), nil
+	}
+
+	f, g := toFloat(operands[0]), toFloat(operands[1])
+	switch x.Op {
+	case "+":
+		return f + g, nil
+	case "-":
+		return f - g, nil
+	case "*":
+		return f * g, nil
+	case "/", "%":
+		if g == 0 {
+			return nil, fmt.Errorf("%s: division by zero", Print(x))
+		}
+		if x.Op == "/" {
+			return f / g, nil
+		}
+		return math.Mod(f, g), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", x.Op)
+}
+
+// compare reports whether a op b holds. Numbers are compared numerically,
+// exactly when either is a decimal,
+// times chronologically and other values by their text. Null equals only
+// null and is neither less nor greater than any value.
+func compare(op string, a, b interface{}) bool {
+	if a == nil || b == nil {
+		switch op {
+		case "==":
+			return a == nil && b == nil
+		case "!=":
+			return (a == nil) != (b == nil)
+		}
+		return false
+	}
+
+	order := Compare(a, b)
+	switch op {
+	case "==":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
+
+// Compare orders two values that are not null as comparisons do: numbers
+// numerically, times chronologically and other values by their text. It
+// returns -1, 0 or +1.
+func Compare(a, b interface{}) int {
+	x, xNumber := number(a)
+	y, yNumber := number(b)
+	s, sTime := a.(time.Time)
+	t, tTime := b.(time.Time)
+	switch {
+	case xNumber && yNumber:
+		return compareNumbers(x, y)
+	case sTime && tTime:
+		return s.Compare(t)
+	}
+	return strings.Compare(text(a), text(b))
+}
+
+func compareNumbers(x, y interface{}) int {
+	_, xDecimal := x.(interfaces.Decimal)
+	_, yDecimal := y.(interfaces.Decimal)
+	if xDecimal || yDecimal {
+		c, _ := decimal(x)
+		d, _ := decimal(y)
+		return c.Cmp(d)
+	}
+	a, aInt := x.(int64)
+	b, bInt := y.(int64)
+	if !aInt || !bInt {
+		f, g := toFloat(x), toFloat(y)
+		switch {
+		case f < g:
+			return -1
+		case f > g:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// number converts a value to an int64 or a float64, keeping decimals. Strings
+// holding a number are numbers, so values read from a CSV file can be
+// compared numerically.
+func number(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int64, float64, interfaces.Decimal:
+		return v, true
+	case bool, time.Time, nil:
+		return nil, false
+	}
+	s := text(value)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f, true
+	}
+	return nil, false
+}
+
+func toFloat(n interface{}) float64 {
+	switch n := n.(type) {
+	case int64:
+		return float64(n)
+	case interfaces.Decimal:
+		return n.Float64()
+	}
+	return n.(float64)
+}
+
+// text renders a value as rules compare it: without surrounding blanks or
+// quotes.
+func text(value interface{}) string {
+	return strings.Trim(strings.TrimSpace(interfaces.ValueString(value)), "'\"")
+}
+
+// test returns nil when the record satisfies x, or an error saying why it
+// does not.
+func (e *env) test(x Expr) error {
+	switch x := x.(type) {
+	case *Logical:
+		left := e.test(x.Left)
+		if x.Op == "AND" {
+			if left != nil {
+				return left
+			}
+			return e.test(x.Right)
+		}
+		if left == nil {
+			return nil
+		}
+		right := e.test(x.Right)
+		if right == nil {
+			return nil
+		}
+		return fmt.Errorf("%v, and %v", left, right)
+	case *Not:
+		if e.test(x.X) == nil {
+			return errors.New("NOT condition failed")
+		}
+		return nil
+	case *FieldTest:
+		values := x.Field.Path.Get(e.record)
+		if len(values) == 0 {
+			return fmt.Errorf("field %s not found", x.Field.Name)
+		}
+		// Every value a path selects must satisfy the conditions. TYPE and
+		// RANGE see the value itself, the other conditions its text
+		for _, value := range values {
+			text := text(value)
+			for _, condition := range x.Conditions {
+				if err := evaluateCondition(condition, value, text); err != nil {
+					return fmt.Errorf("field %s: %w", x.Field.Name, err)
+				}
+			}
+		}
+		return nil
+	case *Comparison:
+		left, err := e.value(x.Left)
+		if err != nil {
+			return err
+		}
+		right, err := e.value(x.Right)
+		if err != nil {
+			return err
+		}
+		if !compare(x.Op, left, right) {
+			return fmt.Errorf("%s %s %s is false for %s %s %s", Print(x.Left), x.Op, Print(x.Right), valueString(left), x.Op, valueString(right))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown expression %T", x)
+}
+
+// valueString renders a value in error messages.
+func valueString(value interface{}) string {
+	if value == nil {
+		return "NULL"
+	}
+	if _, ok := number(value); ok {
+		return text(value)
+	}
+	return strconv.Quote(interfaces.ValueString(value))
+}
+
+func evaluateCondition(condition Condition, value interface{}, text string) error {
+	switch c := condition.(type) {
+	case *TypeCondition:
+		return evaluateTypeCondition(value, c.Spec)
+	case *RangeCondition:
+		return evaluateRangeCondition(value, c)
+	case *MatchesCondition:
+		if !c.Regexp.MatchString(text) {
+			return fmt.Errorf("value '%s' does not match pattern", text)
+		}
+		return nil
+	case *InCondition:
+		return evaluateInCondition(text, c.Values)
+	case *RequiredCondition:
+		if text == "" {
+			return errors.New("field is required and cannot be empty")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported condition %T", condition)
+}
+
+// evaluateTypeCondition checks that value is of type t, or is a string
+// holding a value of type t. A decimal must fit t without rounding.
+func evaluateTypeCondition(value interface{}, t *TypeSpec) error {
+	if _, err := t.convert(value, false); err == nil {
+		return nil
+	}
+	text := text(value)
+	switch t.Name {
+	case TypeInt:
+		return fmt.Errorf("value '%s' is not an integer", text)
+	case TypeFloat:
+		return fmt.Errorf("value '%s' is not a float", text)
+	case TypeBool:
+		return fmt.Errorf("value '%s' is not a boolean", text)
+	case TypeDate:
+		return fmt.Errorf("value '%s' is not a valid date", text)
+	case TypeDecimal:
+		if _, ok := decimal(value); ok {
+			return fmt.Errorf("value '%s' does not fit in %s", text, t)
+		}
+		return fmt.Errorf("value '%s' is not a decimal", text)
+	}
+	return fmt.Errorf("unknown type: %s", t)
+}
+
+// evaluateRangeCondition checks that value lies within the bounds of c:
+// numerically when they are numbers, chronologically when they are dates.
+func evaluateRangeCondition(value interface{}, c *RangeCondition) error {
+	bound := c.min
+	if bound == nil {
+		bound = c.max
+	}
+	var v interface{}
+	var ok bool
+	if _, isDate := bound.(time.Time); isDate {
+		if v, ok = ToDate(value); !ok {
+			return errors.New("field value should be a date")
+		}
+	} else if v, ok = number(value); !ok {
+		return errors.New("field value should be a number")
+	}
+	if c.min != nil && order(v, c.min) < 0 || c.max != nil && order(v, c.max) > 0 {
+		return fmt.Errorf("value '%s' not in range", text(value))
+	}
+	return nil
+}
+
+// order compares two numbers or two times, returning -1, 0 or +1.
+func order(a, b interface{}) int {
+	if t, ok := a.(time.Time); ok {
+		return t.Compare(b.(time.Time))
+	}
+	return compareNumbers(a, b)
+}
+
+func evaluateInCondition(value string, allowed []*Literal) error {
+	for _, literal := range allowed {
+		if matches(value, literal) {
+			return nil
+		}
+	}
+	return fmt.Errorf("value '%s' not in allowed list", value)
+}
+
+// matches compares the text of a value with a literal, numerically when both
+// are numbers, so 1.0 matches 1.
+func matches(value string, literal *Literal) bool {
+	if value == literal.Value {
+		return true
+	}
+	if literal.Kind != LiteralNumber {
+		return false
+	}
+	x, err := strconv.ParseFloat(value, 64)
+	return err == nil && x == toFloat(literal.value)
+}
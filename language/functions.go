@@ -0,0 +1,529 @@
+package language
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/registry"
+	"github.com/google/uuid"
+)
+
+// Type is the type of an argument or the result of a function.
+type Type string
+
+const (
+	TypeAny     Type = "ANY" // Any value, including null
+	TypeString  Type = "STRING"
+	TypeInt     Type = "INT"
+	TypeFloat   Type = "FLOAT" // A number, integers included
+	TypeBool    Type = "BOOL"
+	TypeDate    Type = "DATE"    // A time.Time
+	TypeDecimal Type = "DECIMAL" // An interfaces.Decimal
+)
+
+// Function is a function rules can call, such as LOWER(FIELD("email")).
+//
+// The parser checks the number of arguments of every call, and the type of
+// the arguments whose type is known when the rules are parsed, such as
+// literals and the results of other functions. When the rules run, each
+// argument is converted to the declared type before Call receives it, so an
+// INT argument is an int64 even if the field holds the text "42". A null
+// argument gives a null result without calling the function, except where
+// the argument type is TypeAny.
+type Function struct {
+	Name     string // Matched case-insensitively
+	Args     []Type
+	Optional int  // How many of the last Args may be left out
+	Variadic bool // Whether the last of Args may be repeated
+	Result   Type
+	Call     func(args []interface{}) (interface{}, error)
+
+	call  func(e *env, args []interface{}) (interface{}, error) // Built-in functions that need the evaluation state
+	check func(x *Call) error                                   // Built-in functions with arguments to check beyond their types
+	exact bool                                                  // Built-in functions passed decimals as they are for FLOAT arguments
+}
+
+// minArgs returns the number of arguments a call needs, and maxArgs the
+// number it accepts, or -1 when there is no limit.
+func (f *Function) minArgs() int { return len(f.Args) - f.Optional }
+
+func (f *Function) maxArgs() int {
+	if f.Variadic {
+		return -1
+	}
+	return len(f.Args)
+}
+
+// arg returns the type of argument i.
+func (f *Function) arg(i int) Type {
+	if i >= len(f.Args) {
+		return f.Args[len(f.Args)-1]
+	}
+	return f.Args[i]
+}
+
+// arity describes the number of arguments f takes.
+func (f *Function) arity() string {
+	min, max := f.minArgs(), f.maxArgs()
+	switch {
+	case max < 0:
+		return "at least " + plural(min, "argument")
+	case min == max && min == 0:
+		return "no arguments"
+	case min == max:
+		return plural(min, "argument")
+	case max == min+1:
+		return fmt.Sprintf("%d or %d arguments", min, max)
+	}
+	return fmt.Sprintf("%d to %d arguments", min, max)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+var (
+	functionsMu sync.RWMutex
+	functions   = make(map[string]*Function) // Upper-cased name -> function
+)
+
+var functionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RegisterFunction makes a function callable from rules. It panics if the
+// function is invalid or a function with the same name, ignoring case, is
+// already registered. Register functions before the rules calling them are
+// parsed, typically from an init function.
+func RegisterFunction(f Function) {
+	if err := AddFunction(f); err != nil {
+		panic(err)
+	}
+}
+
+// AddFunction registers a function like RegisterFunction, but reports an
+// invalid function as an error, and a duplicate name as an error wrapping
+// registry.ErrDuplicate.
+func AddFunction(f Function) error {
+	name := strings.ToUpper(f.Name)
+	switch {
+	case !functionName.MatchString(f.Name):
+		return fmt.Errorf("invalid function name %q", f.Name)
+	case keywords[name] != "" || name == "TRUE" || name == "FALSE" || name == "NULL" || name == "CAST":
+		return fmt.Errorf("function %s: %s is a reserved word", f.Name, name)
+	case f.Call == nil && f.call == nil:
+		return fmt.Errorf("function %s has no Call", f.Name)
+	case f.Optional < 0 || f.Optional > len(f.Args):
+		return fmt.Errorf("function %s: Optional must be between 0 and the number of Args", f.Name)
+	case f.Variadic && len(f.Args) == 0:
+		return fmt.Errorf("function %s: a variadic function needs Args", f.Name)
+	}
+	if f.Result == "" {
+		f.Result = TypeAny
+	}
+	for _, t := range append([]Type{f.Result}, f.Args...) {
+		if !knownType(t) {
+			return fmt.Errorf("function %s: unknown type %s", f.Name, t)
+		}
+	}
+
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	if _, exists := functions[name]; exists {
+		return fmt.Errorf("function %s: %w", f.Name, registry.ErrDuplicate)
+	}
+	f.Name = name
+	f.Args = append([]Type(nil), f.Args...)
+	functions[name] = &f
+	return nil
+}
+
+// UnregisterFunction removes the function registered under name, ignoring
+// case, and reports whether it was registered. Rules parsed before keep
+// calling it.
+func UnregisterFunction(name string) bool {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	name = strings.ToUpper(name)
+	if _, exists := functions[name]; !exists {
+		return false
+	}
+	delete(functions, name)
+	return true
+}
+
+// Functions returns every registered function, sorted by name.
+func Functions() []Function {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	list := make([]Function, 0, len(functions))
+	for _, f := range functions {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// lookupFunction returns the function registered under name.
+func lookupFunction(name string) (*Function, bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	f, ok := functions[strings.ToUpper(name)]
+	return f, ok
+}
+
+// functionNames returns the names of the registered functions, in order.
+func functionNames() []string {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	return sortedKeys(functions)
+}
+
+func knownType(t Type) bool {
+	switch t {
+	case TypeAny, TypeString, TypeInt, TypeFloat, TypeBool, TypeDate, TypeDecimal:
+		return true
+	}
+	return false
+}
+
+// staticType returns the type of x as far as it is known before the rules
+// run. Fields can hold anything, so their type is TypeAny.
+func staticType(x Expr) Type {
+	switch x := x.(type) {
+	case *Literal:
+		switch x.Kind {
+		case LiteralString:
+			return TypeString
+		case LiteralBool:
+			return TypeBool
+		case LiteralNumber:
+			if _, ok := x.value.(int64); ok {
+				return TypeInt
+			}
+			return TypeFloat
+		}
+	case *Call:
+		if x.function.exact && len(x.Args) > 0 && staticType(x.Args[0]) == TypeDecimal {
+			return TypeDecimal
+		}
+		return x.function.Result
+	case *Cast:
+		return x.Type.Name
+	case *Negate:
+		return staticType(x.X)
+	case *Arithmetic:
+		left, right := staticType(x.Left), staticType(x.Right)
+		switch {
+		case left == TypeAny || right == TypeAny:
+			return TypeAny
+		case left == TypeInt && right == TypeInt && x.Op != "/":
+			return TypeInt
+		case (left == TypeDecimal || right == TypeDecimal) && left != TypeFloat && right != TypeFloat && x.Op != "/" && x.Op != "%":
+			return TypeDecimal
+		}
+		return TypeFloat
+	}
+	return TypeAny
+}
+
+// assignable reports whether a value of type actual can be passed as an
+// argument of type param. Integers and decimals are numbers, and strings are
+// parsed as dates and decimals when the rules run.
+func assignable(param, actual Type) bool {
+	return param == TypeAny || actual == TypeAny || param == actual ||
+		param == TypeFloat && (actual == TypeInt || actual == TypeDecimal) ||
+		param == TypeDecimal && (actual == TypeInt || actual == TypeFloat || actual == TypeString) ||
+		param == TypeDate && actual == TypeString
+}
+
+// convert converts a value to type t, reporting whether it holds a value of
+// that type.
+func convert(value interface{}, t Type) (interface{}, bool) {
+	switch t {
+	case TypeString:
+		return interfaces.ValueString(value), true
+	case TypeInt:
+		n, ok := number(value)
+		if !ok {
+			return nil, false
+		}
+		i, ok := integer(n)
+		return i, ok
+	case TypeFloat:
+		n, ok := number(value)
+		if !ok {
+			return nil, false
+		}
+		return toFloat(n), true
+	case TypeBool:
+		if b, ok := value.(bool); ok {
+			return b, true
+		}
+		b, err := strconv.ParseBool(text(value))
+		return b, err == nil
+	case TypeDate:
+		if t, ok := value.(time.Time); ok {
+			return t, true
+		}
+		return parseDate(text(value), "", nil)
+	case TypeDecimal:
+		d, ok := decimal(value)
+		return d, ok
+	}
+	return value, true
+}
+
+// ToDate converts a date, or a string in one of the layouts PARSE_DATE tries
+// by default, to a time.
+func ToDate(value interface{}) (time.Time, bool) {
+	date, ok := convert(value, TypeDate)
+	if !ok {
+		return time.Time{}, false
+	}
+	return date.(time.Time), true
+}
+
+// ToNumber converts a number, or a string holding one, to an int64, a
+// float64 or a decimal, as arithmetic and comparisons do.
+func ToNumber(value interface{}) (interface{}, bool) {
+	return number(value)
+}
+
+// ToDecimal converts a number, or a string holding one, to an exact decimal.
+func ToDecimal(value interface{}) (interfaces.Decimal, bool) {
+	return decimal(value)
+}
+
+// dateLayouts are the layouts PARSE_DATE tries when it is given none.
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseDate parses s with layout, a Go time layout, or with the first of
+// dateLayouts that fits. A date without an offset is in location, or in UTC
+// when location is nil.
+func parseDate(s, layout string, location *time.Location) (time.Time, bool) {
+	layouts := dateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, location); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// call calls the function of x with the values of its arguments.
+func (e *env) call(x *Call) (interface{}, error) {
+	f := x.function
+	args := make([]interface{}, len(x.Args))
+	for i, arg := range x.Args {
+		value, err := e.value(arg)
+		if err != nil {
+			return nil, err
+		}
+		if t := f.arg(i); t != TypeAny {
+			if value == nil {
+				return nil, nil
+			}
+			if _, ok := value.(interfaces.Decimal); ok && t == TypeFloat && f.exact {
+				args[i] = value
+				continue
+			}
+			converted, ok := convert(value, t)
+			if !ok {
+				return nil, fmt.Errorf("argument %d of %s is '%s', not %s", i+1, f.Name, interfaces.ValueString(value), article(t))
+			}
+			value = converted
+		}
+		args[i] = value
+	}
+
+	var result interface{}
+	var err error
+	if f.call != nil {
+		result, err = f.call(e, args)
+	} else {
+		result, err = f.Call(args)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", Print(x), err)
+	}
+	return result, nil
+}
+
+// article names a type with its indefinite article.
+func article(t Type) string {
+	if t == TypeInt {
+		return "an INT"
+	}
+	return "a " + string(t)
+}
+
+func init() {
+	current := func(e *env, args []interface{}) (interface{}, error) { return e.now, nil }
+	for _, f := range []Function{
+		{Name: "CURRENT_TIME", Result: TypeDate, call: current},
+		{Name: "NOW", Result: TypeDate, call: current},
+		{Name: "LOWER", Args: []Type{TypeString}, Result: TypeString, Call: func(args []interface{}) (interface{}, error) {
+			return strings.ToLower(args[0].(string)), nil
+		}},
+		{Name: "TRIM", Args: []Type{TypeString}, Result: TypeString, Call: func(args []interface{}) (interface{}, error) {
+			return strings.TrimSpace(args[0].(string)), nil
+		}},
+		{Name: "CONCAT", Args: []Type{TypeAny}, Variadic: true, Result: TypeString, Call: concat},
+		{Name: "SUBSTR", Args: []Type{TypeString, TypeInt, TypeInt}, Optional: 1, Result: TypeString, Call: substr},
+		{Name: "COALESCE", Args: []Type{TypeAny}, Variadic: true, Result: TypeAny, Call: coalesce},
+		{Name: "HASH_SHA256", Args: []Type{TypeString}, Result: TypeString, Call: func(args []interface{}) (interface{}, error) {
+			sum := sha256.Sum256([]byte(args[0].(string)))
+			return hex.EncodeToString(sum[:]), nil
+		}},
+		{Name: "PARSE_DATE", Args: []Type{TypeString, TypeString, TypeString}, Optional: 2, Result: TypeDate, Call: func(args []interface{}) (interface{}, error) {
+			layout := ""
+			if len(args) > 1 {
+				layout = args[1].(string)
+			}
+			var location *time.Location
+			if len(args) > 2 {
+				var err error
+				if location, err = loadZone(args[2].(string)); err != nil {
+					return nil, err
+				}
+			}
+			t, ok := parseDate(strings.TrimSpace(args[0].(string)), layout, location)
+			if !ok {
+				return nil, fmt.Errorf("'%s' is not a date", args[0])
+			}
+			return t, nil
+		}},
+		{Name: "UUID", Result: TypeString, Call: func(args []interface{}) (interface{}, error) {
+			return uuid.NewString(), nil
+		}},
+		{Name: "TO_INT", Args: []Type{TypeAny}, Result: TypeInt, Call: toInt},
+		{Name: "ROUND", Args: []Type{TypeFloat, TypeInt}, Optional: 1, Result: TypeFloat, Call: round, exact: true},
+		{Name: "LOOKUP", Args: []Type{TypeAny, TypeString, TypeString, TypeString, TypeAny}, Optional: 1, Result: TypeAny, call: lookup, check: checkLookup},
+	} {
+		RegisterFunction(f)
+	}
+}
+
+// concat joins the text of its arguments, skipping nulls.
+func concat(args []interface{}) (interface{}, error) {
+	var b strings.Builder
+	for _, arg := range args {
+		b.WriteString(interfaces.ValueString(arg))
+	}
+	return b.String(), nil
+}
+
+// substr returns length characters of a string from start, counted from 1,
+// or from the end when negative. Without length it returns the rest of the
+// string.
+func substr(args []interface{}) (interface{}, error) {
+	s := []rune(args[0].(string))
+	start := int(args[1].(int64))
+	switch {
+	case start > 0:
+		start--
+	case start < 0:
+		start = max(len(s)+start, 0)
+	}
+	start = min(start, len(s))
+	end := len(s)
+	if len(args) > 2 {
+		length := int(args[2].(int64))
+		if length < 0 {
+			return nil, errors.New("the length cannot be negative")
+		}
+		end = min(start+length, len(s))
+	}
+	return string(s[start:end]), nil
+}
+
+// coalesce returns its first argument that is neither null nor empty.
+func coalesce(args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil && arg != "" {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+// toInt converts a number, or a string holding one, to an integer, dropping
+// any fractional part. Booleans are 1 or 0.
+func toInt(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	}
+	n, ok := number(args[0])
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a number", interfaces.ValueString(args[0]))
+	}
+	switch v := n.(type) {
+	case int64:
+		return v, nil
+	case interfaces.Decimal:
+		r := v.Rat()
+		i := new(big.Int).Quo(r.Num(), r.Denom())
+		if !i.IsInt64() {
+			return nil, fmt.Errorf("%s does not fit in an INT", interfaces.ValueString(args[0]))
+		}
+		return i.Int64(), nil
+	}
+	f := math.Trunc(n.(float64))
+	if math.Abs(f) > math.MaxInt64 {
+		return nil, fmt.Errorf("%s does not fit in an INT", interfaces.ValueString(args[0]))
+	}
+	return int64(f), nil
+}
+
+// maxDecimalPlaces bounds the places ROUND rounds a decimal to.
+const maxDecimalPlaces = 1000
+
+// round rounds a number half away from zero to a number of decimal places,
+// none by default. Negative places round to tens, hundreds and so on. A
+// decimal is rounded exactly, to a decimal with that many places.
+func round(args []interface{}) (interface{}, error) {
+	places := int64(0)
+	if len(args) > 1 {
+		places = args[1].(int64)
+	}
+	if d, ok := args[0].(interfaces.Decimal); ok {
+		if places < -maxDecimalPlaces || places > maxDecimalPlaces {
+			return nil, fmt.Errorf("%d places is out of range", places)
+		}
+		if places < 0 {
+			return rescale(rescale(d, int32(places)), 0), nil
+		}
+		return rescale(d, int32(places)), nil
+	}
+	f := args[0].(float64)
+	if places > 15 {
+		return f, nil // Beyond the precision of a float
+	}
+	scale := math.Pow(10, float64(places))
+	return math.Round(f*scale) / scale, nil
+}
@@ -2,8 +2,9 @@ package language
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType represents the type of a token
@@ -13,64 +14,356 @@ const (
 	TokenField     TokenType = "FIELD"
 	TokenCondition TokenType = "CONDITION"
 	TokenOperator  TokenType = "OPERATOR"
-	TokenValue     TokenType = "VALUE"
+	TokenString    TokenType = "STRING"
+	TokenNumber    TokenType = "NUMBER"
+	TokenIdent     TokenType = "IDENT" // Bare words such as INT or EMAIL_REGEX
 	TokenLogical   TokenType = "LOGICAL"
 	TokenSeparator TokenType = "SEPARATOR"
+	TokenLParen    TokenType = "("
+	TokenRParen    TokenType = ")"
+	TokenLBrace    TokenType = "{"
+	TokenRBrace    TokenType = "}"
+	TokenColon     TokenType = ":"
 	TokenTransform TokenType = "TRANSFORM"
+	TokenFilter    TokenType = "FILTER" // DROP_IF or KEEP_IF
+	TokenGroup     TokenType = "GROUP"  // GROUP, BY or AS
+	TokenAggregate TokenType = "AGGREGATE"
+	TokenNewline   TokenType = "NEWLINE" // End of a rule
+	TokenEOF       TokenType = "EOF"
 	TokenInvalid   TokenType = "INVALID"
 )
 
-// Token represents a single token
+// keywords maps the reserved words of the rule language to their token type.
+// Keywords are case-insensitive.
+var keywords = map[string]TokenType{
+	"FIELD":          TokenField,
+	"TYPE":           TokenCondition,
+	"RANGE":          TokenCondition,
+	"MATCHES":        TokenCondition,
+	"IN":             TokenCondition,
+	"REQUIRED":       TokenCondition,
+	"AND":            TokenLogical,
+	"OR":             TokenLogical,
+	"NOT":            TokenLogical,
+	"RENAME":         TokenTransform,
+	"MAP":            TokenTransform,
+	"ADD_FIELD":      TokenTransform,
+	"IF":             TokenTransform,
+	"THEN":           TokenTransform,
+	"DROP_IF":        TokenFilter,
+	"KEEP_IF":        TokenFilter,
+	"GROUP":          TokenGroup,
+	"BY":             TokenGroup,
+	"AS":             TokenGroup,
+	"COUNT":          TokenAggregate,
+	"SUM":            TokenAggregate,
+	"AVG":            TokenAggregate,
+	"MIN":            TokenAggregate,
+	"MAX":            TokenAggregate,
+	"DISTINCT_COUNT": TokenAggregate,
+}
+
+// Pos is the position of a token in the rule text. Lines and columns start
+// at 1, and columns count characters.
+type Pos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Token represents a single token. The value of a string token is its
+// unquoted text, and keywords are upper-cased.
 type Token struct {
 	Type  TokenType
 	Value string
+	Pos   Pos
+}
+
+// SyntaxError is an error in the text of a rule. Besides the message, it
+// names the offending token, what would have been valid in its place and,
+// when there is an obvious fix, a suggestion, so editors can show them apart.
+type SyntaxError struct {
+	Pos        Pos      `json:"position"`
+	Message    string   `json:"message"`
+	Token      string   `json:"token,omitempty"`
+	Expected   []string `json:"expected,omitempty"`
+	Suggestion string   `json:"suggestion,omitempty"`
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("%s: %s; %s", e.Pos, e.Message, e.Suggestion)
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
 }
 
 // Lexer for parsing rules
 type Lexer struct {
-	input string
-	pos   int
+	input    string
+	offset   int
+	pos      Pos
+	depth    int       // Open parentheses and braces, inside which line breaks are ignored
+	comments []comment // Comments skipped so far, kept for Format
+}
+
+// comment is a comment of the rule text, from # to the end of the line.
+type comment struct {
+	At    Pos
+	Text  string
+	Alone bool // Nothing but blanks before it on its line
 }
 
 // NewLexer initializes a lexer with the input string
 func NewLexer(input string) *Lexer {
 	return &Lexer{
-		input: strings.TrimSpace(input),
-		pos:   0,
+		input: input,
+		pos:   Pos{Line: 1, Column: 1},
 	}
 }
 
-// Tokenize splits the input into tokens
-func (l *Lexer) Tokenize(input string) ([]Token, error) {
+// Tokenize splits the input into tokens, ending with an EOF token. Each line
+// break outside parentheses and braces ends a rule and yields a NEWLINE
+// token, and comments run from # to the end of the line.
+func (l *Lexer) Tokenize() ([]Token, error) {
+	tokens, errs := l.tokenize(true)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return tokens, nil
+}
+
+// TokenizeAll is Tokenize, except that it carries on after an error and
+// returns every error. The rule holding an error is dropped up to the end of
+// the line, so the rules after it are still tokenized.
+func (l *Lexer) TokenizeAll() ([]Token, []*SyntaxError) {
+	return l.tokenize(false)
+}
+
+func (l *Lexer) tokenize(stop bool) ([]Token, []*SyntaxError) {
 	var tokens []Token
-	pos := 0
-	patterns := map[TokenType]*regexp.Regexp{
-		TokenField:     regexp.MustCompile(`^FIELD\("([^"]+)"\)`),                    // Match FIELD("field_name")
-		TokenCondition: regexp.MustCompile(`^(TYPE|RANGE|MATCHES|IN|REQUIRED)`),      // Custom conditions
-		TokenValue:     regexp.MustCompile(`^"([^"]*)"|'([^']*)'|[\d\.]+|\([^)]*\)`), // Match strings, numbers, lists
-		TokenLogical:   regexp.MustCompile(`^(AND|OR|NOT)`),                          // Logical operators
-		TokenSeparator: regexp.MustCompile(`^,`),                                     // Separators
-	}
-
-	for pos < len(input) {
-		input = strings.TrimSpace(input[pos:])
-		pos = 0
-
-		matched := false
-		for tokenType, pattern := range patterns {
-			if loc := pattern.FindStringIndex(input); loc != nil && loc[0] == 0 {
-				value := input[loc[0]:loc[1]]
-				tokens = append(tokens, Token{Type: tokenType, Value: value})
-				pos += len(value)
-				matched = true
-				break
+	var errs []*SyntaxError
+	for {
+		token, err := l.next()
+		if err != nil {
+			errs = append(errs, err)
+			if stop {
+				return nil, errs
 			}
+			tokens = dropRule(tokens)
+			l.skipLine()
+			continue
+		}
+		// Blank lines and comments do not separate rules twice
+		if token.Type == TokenNewline && (len(tokens) == 0 || tokens[len(tokens)-1].Type == TokenNewline) {
+			continue
+		}
+		tokens = append(tokens, token)
+		if token.Type == TokenEOF {
+			return tokens, errs
 		}
+	}
+}
+
+// dropRule removes the tokens of the rule being tokenized.
+func dropRule(tokens []Token) []Token {
+	for len(tokens) > 0 && tokens[len(tokens)-1].Type != TokenNewline {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// skipLine skips to the end of the current line, which then ends the rule
+// whatever parentheses are still open.
+func (l *Lexer) skipLine() {
+	l.scanWhile(func(r rune) bool { return r != '\n' })
+	l.depth = 0
+}
+
+// next scans the token at the current offset.
+func (l *Lexer) next() (Token, *SyntaxError) {
+	l.skipSpace()
+	start := l.pos
+	if l.offset >= len(l.input) {
+		return Token{Type: TokenEOF, Pos: start}, nil
+	}
 
-		if !matched {
-			return nil, fmt.Errorf("unexpected token at: %s", input)
+	r := l.peek()
+	switch {
+	case r == '\n':
+		l.advance()
+		return Token{Type: TokenNewline, Pos: start}, nil
+	case r == '(' || r == '{':
+		l.advance()
+		l.depth++
+		return Token{Type: TokenType(r), Value: string(r), Pos: start}, nil
+	case r == ')' || r == '}':
+		l.advance()
+		if l.depth > 0 {
+			l.depth--
+		}
+		return Token{Type: TokenType(r), Value: string(r), Pos: start}, nil
+	case r == ',':
+		l.advance()
+		return Token{Type: TokenSeparator, Value: ",", Pos: start}, nil
+	case r == ':':
+		l.advance()
+		return Token{Type: TokenColon, Value: ":", Pos: start}, nil
+	case r == '"' || r == '\'':
+		return l.scanString(start)
+	case isDigit(r):
+		return l.scanNumber(start), nil
+	case strings.ContainsRune("=!<>+-*/%", r):
+		return l.scanOperator(start)
+	case r == '_' || unicode.IsLetter(r):
+		word := l.scanWhile(func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) })
+		if tokenType, ok := keywords[strings.ToUpper(word)]; ok {
+			return Token{Type: tokenType, Value: strings.ToUpper(word), Pos: start}, nil
 		}
+		return Token{Type: TokenIdent, Value: word, Pos: start}, nil
 	}
+	return Token{}, unexpectedCharacter(start, r)
+}
 
-	return tokens, nil
+// unexpectedCharacter reports a character that starts no token, suggesting
+// the keyword or operator meant by the symbols of other languages.
+func unexpectedCharacter(at Pos, r rune) *SyntaxError {
+	err := &SyntaxError{Pos: at, Message: fmt.Sprintf("unexpected character %q", r), Token: string(r)}
+	switch r {
+	case '=':
+		err.Suggestion = "use == to compare values"
+	case '!':
+		err.Suggestion = "use NOT to negate a condition, or != to compare values"
+	case '&':
+		err.Suggestion = "use AND to combine conditions"
+	case '|':
+		err.Suggestion = "use OR to combine conditions"
+	case '[', ']':
+		err.Suggestion = `write the path of a nested field as a string, as in FIELD("items[0]")`
+	}
+	return err
+}
+
+// scanString scans a string quoted with " or '. A backslash escapes the quote
+// or another backslash and is kept as is before any other character, so
+// regular expressions need no double escaping.
+func (l *Lexer) scanString(start Pos) (Token, *SyntaxError) {
+	quote := l.advance()
+	var value strings.Builder
+	for l.offset < len(l.input) && l.peek() != '\n' {
+		r := l.advance()
+		switch {
+		case r == quote:
+			return Token{Type: TokenString, Value: value.String(), Pos: start}, nil
+		case r == '\\' && (l.peek() == quote || l.peek() == '\\'):
+			value.WriteRune(l.advance())
+		default:
+			value.WriteRune(r)
+		}
+	}
+	return Token{}, &SyntaxError{
+		Pos:        start,
+		Message:    "unterminated string",
+		Token:      string(quote) + value.String(),
+		Expected:   []string{string(quote)},
+		Suggestion: fmt.Sprintf("close the string with %c before the end of the line", quote),
+	}
+}
+
+// scanNumber scans an integer or decimal number. A minus sign is an operator,
+// which the parser folds into the number that follows it.
+func (l *Lexer) scanNumber(start Pos) Token {
+	begin := l.offset
+	l.scanWhile(isDigit)
+	if l.peek() == '.' && isDigit(l.peekAt(1)) {
+		l.advance()
+		l.scanWhile(isDigit)
+	}
+	return Token{Type: TokenNumber, Value: l.input[begin:l.offset], Pos: start}
+}
+
+// operators are the comparison and arithmetic operators.
+var operators = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"+": true, "-": true, "*": true, "/": true, "%": true,
+}
+
+// scanOperator scans the longest operator at the current offset.
+func (l *Lexer) scanOperator(start Pos) (Token, *SyntaxError) {
+	r := l.advance()
+	op := string(r)
+	if next := l.peek(); next == '=' && operators[op+"="] {
+		l.advance()
+		op += "="
+	}
+	if !operators[op] {
+		return Token{}, unexpectedCharacter(start, r)
+	}
+	return Token{Type: TokenOperator, Value: op, Pos: start}, nil
+}
+
+// skipSpace skips blanks, comments and, inside parentheses and braces, line
+// breaks.
+func (l *Lexer) skipSpace() {
+	for l.offset < len(l.input) {
+		r := l.peek()
+		switch {
+		case r == '#':
+			at := l.pos
+			alone := strings.TrimSpace(l.input[strings.LastIndexByte(l.input[:l.offset], '\n')+1:l.offset]) == ""
+			text := l.scanWhile(func(r rune) bool { return r != '\n' })
+			l.comments = append(l.comments, comment{At: at, Text: strings.TrimRightFunc(text, unicode.IsSpace), Alone: alone})
+		case r == '\n' && l.depth == 0:
+			return
+		case unicode.IsSpace(r):
+			l.advance()
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) scanWhile(accept func(rune) bool) string {
+	begin := l.offset
+	for l.offset < len(l.input) && accept(l.peek()) {
+		l.advance()
+	}
+	return l.input[begin:l.offset]
+}
+
+func (l *Lexer) peek() rune {
+	return l.peekAt(0)
+}
+
+// peekAt returns the character n characters ahead, or 0 past the end.
+func (l *Lexer) peekAt(n int) rune {
+	offset := l.offset
+	for ; n > 0 && offset < len(l.input); n-- {
+		_, size := utf8.DecodeRuneInString(l.input[offset:])
+		offset += size
+	}
+	if offset >= len(l.input) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[offset:])
+	return r
+}
+
+// advance consumes one character and returns it.
+func (l *Lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(l.input[l.offset:])
+	l.offset += size
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	return r
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
 }
@@ -0,0 +1,69 @@
+package language
+
+import "fmt"
+
+// Datasets holds the reference datasets LOOKUP reads, such as a table of
+// products giving the category of each SKU.
+type Datasets interface {
+	// Lookup returns the value of field in the record of dataset whose
+	// field key holds value, and whether there is such a record.
+	Lookup(dataset, key string, value interface{}, field string) (interface{}, bool, error)
+}
+
+// Datasets returns the names of the reference datasets read by the LOOKUP
+// calls of the rules, in order.
+func (s *RuleSet) Datasets() []string {
+	if s == nil {
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, rule := range s.Rules {
+		inspect(rule, func(x Expr) {
+			if call, ok := x.(*Call); ok && call.Name == "LOOKUP" {
+				names[call.Args[1].(*Literal).Value] = true
+			}
+		})
+	}
+	return sortedKeys(names)
+}
+
+// lookup finds a value in a reference dataset, as in LOOKUP(FIELD("sku"),
+// "products", "sku", "category", "unknown"). A null key, or one no record of
+// the dataset holds, gives the default, or null without one.
+func lookup(e *env, args []interface{}) (interface{}, error) {
+	var fallback interface{}
+	if len(args) > 4 {
+		fallback = args[4]
+	}
+	if args[0] == nil {
+		return fallback, nil
+	}
+	dataset := args[1].(string)
+	if e.datasets == nil {
+		return nil, fmt.Errorf("unknown dataset %q, no reference datasets are loaded", dataset)
+	}
+	value, found, err := e.datasets.Lookup(dataset, args[2].(string), args[0], args[3].(string))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return fallback, nil
+	}
+	return value, nil
+}
+
+// checkLookup checks that the dataset and the fields of a LOOKUP are quoted
+// strings, so the datasets rules read are known before they run.
+func checkLookup(x *Call) error {
+	for i := 1; i <= 3; i++ {
+		if l, ok := x.Args[i].(*Literal); !ok || l.Kind != LiteralString {
+			return &SyntaxError{
+				Pos:      x.Args[i].Pos(),
+				Message:  fmt.Sprintf("argument %d of LOOKUP must be a quoted string, found %s", i+1, Print(x.Args[i])),
+				Token:    Print(x.Args[i]),
+				Expected: []string{"a string"},
+			}
+		}
+	}
+	return nil
+}
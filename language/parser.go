@@ -3,60 +3,961 @@ package language
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Node represents a node in the Abstract Syntax Tree (AST)
-type Node struct {
-	Type     TokenType
-	Value    string
-	Children []*Node
+// Types accepted by the TYPE condition and CAST.
+var types = map[string]bool{"STRING": true, "INT": true, "FLOAT": true, "BOOL": true, "DATE": true, "DECIMAL": true}
+
+// patterns are the predefined regular expressions MATCHES accepts by name.
+var patterns = map[string]*regexp.Regexp{
+	"EMAIL_REGEX": regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`),
 }
 
-// Parser for validation and transformation rules
-type Parser struct{}
+// Parser for validation and transformation rules. It is a recursive-descent
+// parser for the grammar
+//
+//	rules      = { rule NEWLINE } EOF
+//	rule       = operation | ( DROP_IF | KEEP_IF ) or | groupBy | aggregate | or
+//	operation  = RENAME "(" STRING "," STRING ")"
+//	           | MAP "(" STRING "," "{" [ literal ":" sum { "," literal ":" sum } ] "}" ")"
+//	           | ADD_FIELD "(" STRING "," sum ")"
+//	           | IF or THEN operation
+//	groupBy    = GROUP BY sum [ AS STRING ] { "," sum [ AS STRING ] }
+//	aggregate  = ( COUNT | SUM | AVG | MIN | MAX | DISTINCT_COUNT ) "(" [ sum ] ")" AS STRING
+//	or         = and { OR and }
+//	and        = not { AND not }
+//	not        = NOT not | comparison
+//	comparison = sum [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) sum ]
+//	sum        = product { ( "+" | "-" ) product }
+//	product    = unary { ( "*" | "/" | "%" ) unary }
+//	unary      = "-" unary | primary
+//	primary    = "(" or ")" | field { condition } | literal | TRUE | FALSE | NULL
+//	           | CAST "(" sum "," type ")" | IDENT "(" [ sum { "," sum } ] ")"
+//	field      = FIELD "(" STRING ")"                        (a name or a path, see Path)
+//	condition  = TYPE "(" type ")" | RANGE "(" bound "," bound ")"
+//	           | MATCHES "(" STRING | IDENT ")" | IN "(" literal { "," literal } ")"
+//	           | REQUIRED
+//	type       = IDENT                                       (STRING, INT, FLOAT, BOOL, DATE or DECIMAL)
+//	           | DECIMAL "(" NUMBER [ "," NUMBER ] ")"      (precision and scale)
+//	           | DATE "(" STRING [ "," STRING ] ")"         (layout and time zone)
+//	bound      = number | STRING | "*"                       (a date, or * for no bound)
+//	literal    = STRING | number
+//	number     = [ "-" ] NUMBER
+//
+// so arithmetic binds tighter than comparisons, which bind tighter than NOT,
+// AND and OR. Conditions must be tests, such as a field with conditions or a
+// comparison, and operands of operators must be values.
+type Parser struct {
+	tokens []Token
+	pos    int
+}
 
 // NewParser initializes a parser
 func NewParser() *Parser {
 	return &Parser{}
 }
 
-func (p *Parser) ParseRules(tokens []Token) (*Node, error) {
-	if len(tokens) < 3 {
-		return nil, errors.New("insufficient parameters")
+// ParseRules parses the tokens of a rule text, as returned by
+// Lexer.Tokenize.
+func (p *Parser) ParseRules(tokens []Token) (*RuleSet, error) {
+	set, errs := p.parse(tokens, true)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return set, nil
+}
+
+// ParseAll is ParseRules, except that it carries on after an error and
+// returns every error. The rule holding an error is skipped, and the rule set
+// holds the valid rules.
+func (p *Parser) ParseAll(tokens []Token) (*RuleSet, []*SyntaxError) {
+	return p.parse(tokens, false)
+}
+
+func (p *Parser) parse(tokens []Token, stop bool) (*RuleSet, []*SyntaxError) {
+	p.tokens = tokens
+	p.pos = 0
+	set := &RuleSet{}
+	var errs []*SyntaxError
+	for p.peek().Type != TokenEOF {
+		if p.peek().Type == TokenNewline {
+			p.pos++
+			continue
+		}
+		rule, err := p.parseRule()
+		if next := p.peek(); err == nil && next.Type != TokenNewline && next.Type != TokenEOF {
+			err = p.unexpected(next, "the end of the rule", "a new line").suggest("write one rule per line")
+		}
+		if err != nil {
+			errs = append(errs, p.syntaxError(err))
+			if stop {
+				return nil, errs
+			}
+			p.skipRule()
+			continue
+		}
+		set.Rules = append(set.Rules, rule)
+	}
+	return set, errs
+}
+
+// skipRule skips the tokens up to the end of the current rule.
+func (p *Parser) skipRule() {
+	for next := p.peek().Type; next != TokenNewline && next != TokenEOF; next = p.peek().Type {
+		p.next()
+	}
+}
+
+// syntaxError returns err as a SyntaxError, placing other errors at the
+// current token.
+func (p *Parser) syntaxError(err error) *SyntaxError {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr
+	}
+	return &SyntaxError{Pos: p.peek().Pos, Message: err.Error()}
+}
+
+func (p *Parser) parseRule() (Rule, error) {
+	switch p.peek().Type {
+	case TokenTransform:
+		return p.parseOperation()
+	case TokenFilter:
+		keyword := p.next()
+		condition, err := p.parseTest()
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{At: keyword.Pos, Keep: keyword.Value == "KEEP_IF", Condition: condition}, nil
+	case TokenGroup:
+		return p.parseGroupBy()
+	case TokenAggregate:
+		return p.parseAggregate()
+	}
+	start := p.peek().Pos
+	condition, err := p.parseTest()
+	if err != nil {
+		return nil, err
+	}
+	return &Check{At: start, Condition: condition}, nil
+}
+
+func (p *Parser) parseOperation() (Operation, error) {
+	keyword := p.next()
+	if keyword.Type != TokenTransform || keyword.Value == "THEN" {
+		return nil, p.unexpected(keyword, "RENAME, MAP, ADD_FIELD or IF", "RENAME", "MAP", "ADD_FIELD", "IF")
+	}
+	if keyword.Value == "IF" {
+		condition, err := p.parseTest()
+		if err != nil {
+			return nil, err
+		}
+		if then := p.peek(); then.Type != TokenTransform || then.Value != "THEN" {
+			return nil, p.unexpected(then, "THEN after the condition of IF", "THEN")
+		}
+		p.next()
+		operation, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		return &If{At: keyword.Pos, Condition: condition, Then: operation}, nil
+	}
+
+	open, err := p.expect(TokenLParen, fmt.Sprintf(`"(" after %s`, keyword.Value))
+	if err != nil {
+		return nil, err
+	}
+	field, err := p.expect(TokenString, "a quoted field name")
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.parsePath(field)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenSeparator, fmt.Sprintf(`"," after the field name of %s`, keyword.Value)); err != nil {
+		return nil, err
 	}
 
-	root := &Node{Type: "ROOT", Children: []*Node{}}
-	var currentField string
+	var operation Operation
+	switch keyword.Value {
+	case "RENAME":
+		to, err := p.expect(TokenString, "the quoted new name of the field")
+		if err != nil {
+			return nil, err
+		}
+		toPath, err := p.parsePath(to)
+		if err != nil {
+			return nil, err
+		}
+		if !path.Leaf() {
+			return nil, p.errorf(field, "RENAME needs a path ending with a field name, found %s", describe(field))
+		}
+		if !toPath.simple() && !path.Definite() {
+			return nil, p.errorf(to, "RENAME from a path with wildcards can only give the fields a new name, not move them to %s", describe(to))
+		}
+		operation = &Rename{At: keyword.Pos, From: field.Value, To: to.Value, FromPath: path, ToPath: toPath}
+	case "MAP":
+		entries, err := p.parseMapping()
+		if err != nil {
+			return nil, err
+		}
+		operation = &Map{At: keyword.Pos, Field: field.Value, Path: path, Entries: entries}
+	case "ADD_FIELD":
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		operation = &AddField{At: keyword.Pos, Field: field.Value, Path: path, Value: value}
+	}
 
-	for i := 0; i < len(tokens); i++ {
-		token := tokens[i]
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
 
-		if token.Type == "FIELD" {
-			// Set the current field and continue to the next token
-			currentField = token.Value
-		} else if token.Type == "CONDITION" {
-			// Ensure there is a following value
-			if i+1 >= len(tokens) {
-				return nil, errors.New("expected value after condition")
+func (p *Parser) parseGroupBy() (*GroupBy, error) {
+	group := p.next()
+	if group.Value != "GROUP" {
+		return nil, p.unexpected(group, "a rule", "GROUP BY")
+	}
+	if by := p.next(); by.Value != "BY" {
+		return nil, p.unexpected(by, "BY after GROUP", "BY")
+	}
+	rule := &GroupBy{At: group.Pos}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		key := &Key{Value: value}
+		if field, ok := value.(*FieldRef); ok {
+			key.Name = field.Name
+		}
+		if p.peek().Type == TokenGroup && p.peek().Value == "AS" {
+			p.next()
+			name, err := p.expect(TokenString, "the quoted name of the key")
+			if err != nil {
+				return nil, err
 			}
+			key.Name = name.Value
+		} else if key.Name == "" {
+			return nil, p.unexpected(p.peek(), "AS and a name for "+Print(value), "AS")
+		}
+		rule.Keys = append(rule.Keys, key)
+		if p.peek().Type != TokenSeparator {
+			return rule, nil
+		}
+		p.next()
+	}
+}
 
-			condition := token
-			value := tokens[i+1] // Next token is the value
+func (p *Parser) parseAggregate() (*Aggregate, error) {
+	function := p.next()
+	open, err := p.expect(TokenLParen, fmt.Sprintf(`"(" after %s`, function.Value))
+	if err != nil {
+		return nil, err
+	}
+	rule := &Aggregate{At: function.Pos, Function: function.Value}
+	if p.peek().Type != TokenRParen || function.Value != "COUNT" {
+		if rule.Value, err = p.parseValue(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	if as := p.peek(); as.Type != TokenGroup || as.Value != "AS" {
+		return nil, p.unexpected(as, fmt.Sprintf("AS and a name for %s", function.Value), "AS")
+	}
+	p.next()
+	name, err := p.expect(TokenString, "the quoted name of the aggregate")
+	if err != nil {
+		return nil, err
+	}
+	rule.Name = name.Value
+	return rule, nil
+}
 
-			node := &Node{Type: "EXPRESSION", Children: []*Node{
-				{Type: "FIELD", Value: currentField},
-				{Type: "CONDITION", Value: condition.Value},
-				{Type: "VALUE", Value: value.Value},
-			}}
+// parseMapping parses the {key: value, ...} mapping of MAP.
+func (p *Parser) parseMapping() ([]MapEntry, error) {
+	open, err := p.expect(TokenLBrace, `"{" to start the mapping`)
+	if err != nil {
+		return nil, err
+	}
+	var entries []MapEntry
+	keys := make(map[string]bool)
+	for p.peek().Type != TokenRBrace {
+		key, err := p.parseLiteral("a string or a number as key")
+		if err != nil {
+			return nil, err
+		}
+		if keys[key.Value] {
+			return nil, p.errorf(Token{Pos: key.At}, "duplicate key %s in mapping", describeLiteral(key))
+		}
+		keys[key.Value] = true
+		if _, err := p.expect(TokenColon, `":" after the key`); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, MapEntry{Key: key, Value: value})
+		if p.peek().Type != TokenSeparator {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(TokenRBrace, `"}" to end the mapping`); err != nil {
+		return nil, p.syntaxError(err).suggest(fmt.Sprintf("close the mapping opened at %s", open.Pos))
+	}
+	return entries, nil
+}
+
+// parseValue parses a value, such as a literal, a field, a function call or
+// an arithmetic expression.
+func (p *Parser) parseValue() (Expr, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return x, p.checkValue(x)
+}
 
-			root.Children = append(root.Children, node)
+// parseCall parses the arguments of a call to the function named by name.
+func (p *Parser) parseCall(name Token) (Expr, error) {
+	f, ok := lookupFunction(name.Value)
+	if !ok {
+		return nil, p.errorf(name, "unknown function %s", name.Value).suggest(didYouMean(name.Value, functionNames()))
+	}
+	open, err := p.expect(TokenLParen, fmt.Sprintf(`"(" after %s`, name.Value))
+	if err != nil {
+		return nil, err
+	}
+	call := &Call{At: name.Pos, Name: f.Name, function: f}
+	for p.peek().Type != TokenRParen {
+		arg, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if p.peek().Type != TokenSeparator {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	if n := len(call.Args); n < f.minArgs() || f.maxArgs() >= 0 && n > f.maxArgs() {
+		return nil, p.errorf(name, "%s takes %s, found %d", f.Name, f.arity(), n)
+	}
+	for i, arg := range call.Args {
+		if t := staticType(arg); !assignable(f.arg(i), t) {
+			return nil, &SyntaxError{
+				Pos:      arg.Pos(),
+				Message:  fmt.Sprintf("argument %d of %s must be %s, found %s, %s", i+1, f.Name, article(f.arg(i)), Print(arg), article(t)),
+				Token:    Print(arg),
+				Expected: []string{string(f.arg(i))},
+			}
+		}
+	}
+	if f.check != nil {
+		if err := f.check(call); err != nil {
+			return nil, err
+		}
+	}
+	return call, nil
+}
+
+// parseTest parses an expression that must be a test.
+func (p *Parser) parseTest() (Expr, error) {
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return x, p.checkTest(x)
+}
+
+func (p *Parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Type == TokenLogical && p.peek().Value == "OR" {
+		if err := p.checkTest(left); err != nil {
+			return nil, err
+		}
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkTest(right); err != nil {
+			return nil, err
+		}
+		left = &Logical{At: op.Pos, Op: op.Value, Left: left, Right: right}
+	}
+	return left, nil
+}
 
-			// Move past the value token
-			i++
+func (p *Parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Type == TokenLogical && p.peek().Value == "AND" {
+		if err := p.checkTest(left); err != nil {
+			return nil, err
+		}
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkTest(right); err != nil {
+			return nil, err
+		}
+		left = &Logical{At: op.Pos, Op: op.Value, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseNot() (Expr, error) {
+	if p.peek().Type == TokenLogical && p.peek().Value == "NOT" {
+		op := p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkTest(x); err != nil {
+			return nil, err
+		}
+		return &Not{At: op.Pos, X: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *Parser) parseComparison() (Expr, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if !p.atOperator("==", "!=", "<", "<=", ">", ">=") {
+		return left, nil
+	}
+	if err := p.checkValue(left); err != nil {
+		return nil, err
+	}
+	op := p.next()
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkValue(right); err != nil {
+		return nil, err
+	}
+	if next := p.peek(); p.atOperator("==", "!=", "<", "<=", ">", ">=") {
+		return nil, p.errorf(next, "comparisons cannot be chained").suggest("combine them with AND")
+	}
+	return &Comparison{At: op.Pos, Op: op.Value, Left: left, Right: right}, nil
+}
+
+func (p *Parser) parseSum() (Expr, error) {
+	return p.parseArithmetic(p.parseProduct, "+", "-")
+}
+
+func (p *Parser) parseProduct() (Expr, error) {
+	return p.parseArithmetic(p.parseUnary, "*", "/", "%")
+}
+
+// parseArithmetic parses operands joined by any of ops, from left to right.
+func (p *Parser) parseArithmetic(operand func() (Expr, error), ops ...string) (Expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.atOperator(ops...) {
+		if err := p.checkValue(left); err != nil {
+			return nil, err
+		}
+		op := p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkValue(right); err != nil {
+			return nil, err
+		}
+		left = &Arithmetic{At: op.Pos, Op: op.Value, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseUnary() (Expr, error) {
+	if !p.atOperator("-") {
+		return p.parsePrimary()
+	}
+	minus := p.next()
+	if number := p.peek(); number.Type == TokenNumber {
+		p.next()
+		return newLiteral(minus.Pos, LiteralNumber, "-"+number.Value), nil
+	}
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkValue(x); err != nil {
+		return nil, err
+	}
+	return &Negate{At: minus.Pos, X: x}, nil
+}
+
+func (p *Parser) parsePrimary() (Expr, error) {
+	switch token := p.peek(); token.Type {
+	case TokenLParen:
+		open := p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectClose(open); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case TokenField:
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().Type != TokenCondition {
+			return field, nil
+		}
+		test := &FieldTest{Field: field}
+		for p.peek().Type == TokenCondition {
+			condition, err := p.parseCondition()
+			if err != nil {
+				return nil, err
+			}
+			test.Conditions = append(test.Conditions, condition)
+		}
+		return test, nil
+	case TokenString, TokenNumber:
+		p.next()
+		return literal(token), nil
+	case TokenIdent:
+		p.next()
+		switch name := strings.ToUpper(token.Value); name {
+		case "TRUE", "FALSE":
+			return newLiteral(token.Pos, LiteralBool, name), nil
+		case "NULL":
+			return newLiteral(token.Pos, LiteralNull, name), nil
+		case "CAST":
+			return p.parseCast(token)
+		}
+		return p.parseCall(token)
+	case TokenAggregate:
+		return nil, p.unexpected(token, "FIELD, a value or a parenthesis", "FIELD", "a value", "(").suggest(token.Value + " is an aggregate, which belongs in aggregation rules")
+	default:
+		return nil, p.unexpected(token, "FIELD, a value or a parenthesis", "FIELD", "a value", "(")
+	}
+}
+
+// parseCast parses the value and the type of a CAST.
+func (p *Parser) parseCast(name Token) (Expr, error) {
+	open, err := p.expect(TokenLParen, `"(" after CAST`)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenSeparator, `"," between the value and the type of CAST`); err != nil {
+		return nil, err
+	}
+	spec, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	return &Cast{At: name.Pos, X: value, Type: spec}, nil
+}
+
+// parseType parses a type of TYPE or CAST. DECIMAL may have a precision and
+// a scale, and DATE a layout and a time zone.
+func (p *Parser) parseType() (*TypeSpec, error) {
+	name, err := p.expect(TokenIdent, "a type")
+	if err != nil {
+		return nil, err
+	}
+	typeName := strings.ToUpper(name.Value)
+	if !types[typeName] {
+		err := p.errorf(name, "unknown type %s, expected STRING, INT, FLOAT, BOOL, DATE or DECIMAL", name.Value)
+		err.Expected = sortedKeys(types)
+		return nil, err.suggest(didYouMean(name.Value, err.Expected))
+	}
+	spec := &TypeSpec{At: name.Pos, Name: Type(typeName)}
+	if p.peek().Type != TokenLParen {
+		return spec, nil
+	}
+	open := p.next()
+	switch spec.Name {
+	case TypeDecimal:
+		precision, err := p.expect(TokenNumber, "the precision of DECIMAL")
+		if err != nil {
+			return nil, err
+		}
+		if spec.Precision, err = strconv.Atoi(precision.Value); err != nil || spec.Precision < 1 {
+			return nil, p.errorf(precision, "the precision of DECIMAL must be a positive integer, found %s", precision.Value)
+		}
+		if p.peek().Type == TokenSeparator {
+			p.next()
+			scale, err := p.expect(TokenNumber, "the scale of DECIMAL")
+			if err != nil {
+				return nil, err
+			}
+			if spec.Scale, err = strconv.Atoi(scale.Value); err != nil || spec.Scale > spec.Precision {
+				return nil, p.errorf(scale, "the scale of DECIMAL must be an integer from 0 to the precision %d, found %s", spec.Precision, scale.Value)
+			}
+		}
+	case TypeDate:
+		layout, err := p.expect(TokenString, "the quoted layout of DATE")
+		if err != nil {
+			return nil, err
+		}
+		spec.Layout = layout.Value
+		if p.peek().Type == TokenSeparator {
+			p.next()
+			zone, err := p.expect(TokenString, "the quoted time zone of DATE")
+			if err != nil {
+				return nil, err
+			}
+			if spec.location, err = loadZone(zone.Value); err != nil {
+				return nil, p.errorf(zone, "%v", err).suggest("use an IANA name such as Europe/Paris, or an offset such as +05:30")
+			}
+			spec.Zone = zone.Value
+		}
+	default:
+		return nil, p.errorf(open, "%s takes no parameters", typeName)
+	}
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// checkTest reports an error unless x, which has just been parsed, is a
+// test.
+func (p *Parser) checkTest(x Expr) error {
+	switch x := x.(type) {
+	case *Logical, *Not, *FieldTest, *Comparison:
+		return nil
+	case *FieldRef:
+		return p.unexpected(p.peek(), fmt.Sprintf("a condition or a comparison after FIELD(%q)", x.Name), "TYPE", "RANGE", "MATCHES", "IN", "REQUIRED", "a comparison")
+	}
+	return p.unexpected(p.peek(), "a comparison after "+Print(x), "==", "!=", "<", "<=", ">", ">=")
+}
+
+// checkValue reports an error unless x is a value.
+func (p *Parser) checkValue(x Expr) error {
+	switch x.(type) {
+	case *Logical, *Not, *FieldTest, *Comparison:
+		return &SyntaxError{Pos: x.Pos(), Message: "expected a value, found a condition"}
+	}
+	return nil
+}
+
+// atOperator reports whether the next token is one of ops.
+func (p *Parser) atOperator(ops ...string) bool {
+	token := p.peek()
+	if token.Type != TokenOperator {
+		return false
+	}
+	for _, op := range ops {
+		if token.Value == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) parseField() (*FieldRef, error) {
+	field := p.next()
+	open, err := p.expect(TokenLParen, `"(" after FIELD`)
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.expect(TokenString, "a quoted field name")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	path, err := p.parsePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldRef{At: field.Pos, Name: name.Value, Path: path}, nil
+}
+
+// parsePath parses the field path held by a string token.
+func (p *Parser) parsePath(token Token) (*Path, error) {
+	path, err := ParsePath(token.Value)
+	if err != nil {
+		return nil, p.errorf(token, "%v", err)
+	}
+	return path, nil
+}
+
+func (p *Parser) parseCondition() (Condition, error) {
+	keyword := p.next()
+	if keyword.Value == "REQUIRED" {
+		return &RequiredCondition{At: keyword.Pos}, nil
+	}
+	open, err := p.expect(TokenLParen, fmt.Sprintf(`"(" after %s`, keyword.Value))
+	if err != nil {
+		return nil, err
+	}
+
+	var condition Condition
+	switch keyword.Value {
+	case "TYPE":
+		spec, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		condition = &TypeCondition{At: keyword.Pos, Type: string(spec.Name), Spec: spec}
+	case "RANGE":
+		r := &RangeCondition{At: keyword.Pos}
+		if r.Min, r.min, err = p.parseBound(); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TokenSeparator, `"," between the bounds of RANGE`); err != nil {
+			return nil, err
+		}
+		if r.Max, r.max, err = p.parseBound(); err != nil {
+			return nil, err
+		}
+		if err := p.checkBounds(r); err != nil {
+			return nil, err
+		}
+		condition = r
+	case "MATCHES":
+		token := p.next()
+		matches := &MatchesCondition{At: keyword.Pos, Pattern: token.Value}
+		switch token.Type {
+		case TokenString:
+			re, err := regexp.Compile(token.Value)
+			if err != nil {
+				return nil, p.errorf(token, "invalid regular expression: %v", err)
+			}
+			matches.Regexp = re
+		case TokenIdent:
+			re, ok := patterns[token.Value]
+			if !ok {
+				err := p.errorf(token, "unknown pattern %s", token.Value)
+				err.Expected = sortedKeys(patterns)
+				return nil, err.suggest(didYouMean(token.Value, err.Expected)).suggest("quote the regular expression")
+			}
+			matches.Regexp = re
+		default:
+			return nil, p.unexpected(token, "a quoted regular expression", "a string")
+		}
+		condition = matches
+	case "IN":
+		in := &InCondition{At: keyword.Pos}
+		for {
+			value, err := p.parseLiteral("a string or a number")
+			if err != nil {
+				return nil, err
+			}
+			in.Values = append(in.Values, value)
+			if p.peek().Type != TokenSeparator {
+				break
+			}
+			p.next()
+		}
+		condition = in
+	}
+
+	if err := p.expectClose(open); err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+// parseBound parses a bound of RANGE: a number, a quoted date, or * when the
+// range is open on that side. It returns the bound as written and its value,
+// which are nil for *.
+func (p *Parser) parseBound() (*Literal, interface{}, error) {
+	if p.atOperator("*") {
+		p.next()
+		return nil, nil, nil
+	}
+	bound, err := p.parseLiteral("a number, a quoted date or *")
+	if err != nil {
+		return nil, nil, err
+	}
+	if bound.Kind == LiteralString {
+		date, ok := parseDate(bound.Value, "", nil)
+		if !ok {
+			return nil, nil, p.errorf(Token{Pos: bound.At}, "range bounds must be numbers or dates, found %s", describeLiteral(bound)).suggest("write dates as 2006-01-02 or 2006-01-02T15:04:05Z")
+		}
+		return bound, date, nil
+	}
+	if i, ok := bound.value.(int64); ok {
+		return bound, i, nil
+	}
+	// Other numbers are compared exactly, as decimals
+	d, _ := decimal(bound.Value)
+	return bound, d, nil
+}
+
+// checkBounds checks that a range has a bound, that both bounds are numbers
+// or both dates, and that they are in order.
+func (p *Parser) checkBounds(r *RangeCondition) error {
+	switch {
+	case r.Min == nil && r.Max == nil:
+		return p.errorf(Token{Pos: r.At}, "RANGE needs at least one bound").suggest("use * for one bound only")
+	case r.Min == nil || r.Max == nil:
+		return nil
+	}
+	_, minDate := r.min.(time.Time)
+	_, maxDate := r.max.(time.Time)
+	if minDate != maxDate {
+		return p.errorf(Token{Pos: r.Max.At}, "range bounds must both be numbers or both be dates, found %s and %s", describeLiteral(r.Min), describeLiteral(r.Max))
+	}
+	if order(r.min, r.max) > 0 {
+		return p.errorf(Token{Pos: r.Min.At}, "the lower bound %s of RANGE is greater than the upper bound %s", describeLiteral(r.Min), describeLiteral(r.Max))
+	}
+	return nil
+}
+
+// parseLiteral parses a string or a number with an optional minus sign.
+func (p *Parser) parseLiteral(what string) (*Literal, error) {
+	token := p.next()
+	if token.Type == TokenOperator && token.Value == "-" && p.peek().Type == TokenNumber {
+		number := p.next()
+		return newLiteral(token.Pos, LiteralNumber, "-"+number.Value), nil
+	}
+	if token.Type != TokenString && token.Type != TokenNumber {
+		return nil, p.unexpected(token, what)
+	}
+	return literal(token), nil
+}
+
+func literal(token Token) *Literal {
+	kind := LiteralString
+	if token.Type == TokenNumber {
+		kind = LiteralNumber
+	}
+	return newLiteral(token.Pos, kind, token.Value)
+}
+
+// newLiteral creates a literal and computes its value once, so evaluating a
+// rule never parses numbers again. Numbers become an int64 or a float64.
+func newLiteral(at Pos, kind LiteralKind, text string) *Literal {
+	l := &Literal{At: at, Kind: kind, Value: text}
+	switch kind {
+	case LiteralNumber:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			l.value = i
 		} else {
-			return nil, fmt.Errorf("unexpected token: %s", token.Value)
+			l.value, _ = strconv.ParseFloat(text, 64)
 		}
+	case LiteralBool:
+		l.value = text == "TRUE"
+	case LiteralNull:
+	default:
+		l.value = text
+	}
+	return l
+}
+
+// expect consumes the next token if it has the given type, which what
+// describes.
+func (p *Parser) expect(tokenType TokenType, what string) (Token, error) {
+	token := p.peek()
+	if token.Type != tokenType {
+		return token, p.unexpected(token, what)
+	}
+	return p.next(), nil
+}
+
+// expectClose consumes the parenthesis closing open.
+func (p *Parser) expectClose(open Token) error {
+	if token := p.peek(); token.Type != TokenRParen {
+		return p.unexpected(token, "a closing parenthesis", ")").suggest(fmt.Sprintf("close the parenthesis opened at %s", open.Pos))
+	}
+	p.next()
+	return nil
+}
+
+func (p *Parser) peek() Token {
+	if p.pos >= len(p.tokens) {
+		return Token{Type: TokenEOF}
 	}
+	return p.tokens[p.pos]
+}
+
+func (p *Parser) next() Token {
+	token := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return token
+}
 
-	return root, nil
+func (p *Parser) errorf(token Token, format string, args ...interface{}) *SyntaxError {
+	err := &SyntaxError{Pos: token.Pos, Message: fmt.Sprintf(format, args...)}
+	if token.Type != "" {
+		err.Token = describe(token)
+	}
+	return err
+}
+
+// unexpected reports that token is not one of expected, which what
+// describes. With no expected tokens, what is the only one. A misspelt
+// keyword gets a suggestion.
+func (p *Parser) unexpected(token Token, what string, expected ...string) *SyntaxError {
+	if len(expected) == 0 {
+		expected = []string{what}
+	}
+	err := p.errorf(token, "expected %s, found %s", what, describe(token))
+	err.Expected = expected
+	if token.Type == TokenIdent {
+		err.Suggestion = didYouMean(token.Value, vocabulary())
+	}
+	return err
+}
+
+// describeLiteral names a literal in error messages.
+func describeLiteral(l *Literal) string {
+	if l.Kind == LiteralString {
+		return strconv.Quote(l.Value)
+	}
+	return l.Value
+}
+
+// describe names a token in error messages.
+func describe(token Token) string {
+	switch token.Type {
+	case TokenEOF:
+		return "the end of the rules"
+	case TokenNewline:
+		return "the end of the line"
+	case TokenString:
+		return strconv.Quote(token.Value)
+	}
+	return token.Value
 }
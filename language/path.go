@@ -0,0 +1,337 @@
+package language
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// Path locates values inside a record, so rules can reach the fields of
+// nested objects and arrays. It is written either as a JSONPath starting with
+// $, such as $.user.age, $.items[0].price, $.items[*].price or $..id, or as
+// dotted names such as user.age or items[0].price.
+//
+// A name without dots or brackets is a top-level field. A dotted path that is
+// also the exact name of a top-level field, such as a CSV column called
+// "user.age", selects that field.
+//
+// A Path is safe for concurrent use. It remembers where each field was found
+// in the last record, since the records of a source usually share their
+// layout, and looks there first.
+type Path struct {
+	Text   string
+	rooted bool // Written with a leading $
+	steps  []step
+	hints  []atomic.Int32 // Position of the field each step last found
+}
+
+// step selects values from the objects and arrays matched by the previous
+// step: the field called name, the element at index (counted from the end
+// when negative), or every field or element.
+type step struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+	descend  bool // Applies to every object and array below, as in $..name
+}
+
+// ParsePath parses the path of a field.
+func ParsePath(text string) (*Path, error) {
+	p := &Path{Text: text}
+	rest := text
+	if strings.HasPrefix(rest, "$") {
+		p.rooted = true
+		rest = rest[1:]
+		if rest == "" {
+			return nil, fmt.Errorf("path %q selects the whole record, name a field after $", text)
+		}
+	} else if rest == "" {
+		return nil, fmt.Errorf("empty field name")
+	} else if !strings.ContainsAny(rest, ".[") {
+		p.steps = []step{{name: rest}}
+		p.hints = make([]atomic.Int32, 1)
+		return p, nil
+	} else {
+		rest = "." + rest
+	}
+
+	for rest != "" {
+		var s step
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			s.descend = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(rest, "."):
+			if !s.descend {
+				rest = rest[1:]
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			s.name, rest = rest[:end], rest[end:]
+			if s.name == "" {
+				return nil, fmt.Errorf("path %q: expected a field name after %q", text, strings.TrimSuffix(text, rest))
+			}
+			if s.name == "*" {
+				s.name, s.wildcard = "", true
+			}
+			p.steps = append(p.steps, s)
+			continue
+		case !strings.HasPrefix(rest, "["):
+			return nil, fmt.Errorf("path %q: expected . or [ at %q", text, rest)
+		}
+
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("path %q: missing ]", text)
+		}
+		selector := strings.TrimSpace(rest[1:end])
+		rest = rest[end+1:]
+		switch {
+		case selector == "*":
+			s.wildcard = true
+		case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+			s.name = selector[1 : len(selector)-1]
+		default:
+			index, err := strconv.Atoi(selector)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: expected an index, * or a quoted name in brackets, found %q", text, selector)
+			}
+			s.index, s.isIndex = index, true
+		}
+		p.steps = append(p.steps, s)
+	}
+	p.hints = make([]atomic.Int32, len(p.steps))
+	return p, nil
+}
+
+// Definite reports whether the path selects at most one value, that is it has
+// no wildcards and no recursive descent.
+func (p *Path) Definite() bool {
+	for _, s := range p.steps {
+		if s.wildcard || s.descend {
+			return false
+		}
+	}
+	return true
+}
+
+// Leaf reports whether the path ends with a field name rather than an array
+// index or a wildcard.
+func (p *Path) Leaf() bool {
+	last := p.steps[len(p.steps)-1]
+	return !last.isIndex && !last.wildcard
+}
+
+// simple reports whether the path is a plain field name, without dots,
+// brackets or $.
+func (p *Path) simple() bool {
+	return !p.rooted && len(p.steps) == 1 && !strings.ContainsAny(p.Text, ".[")
+}
+
+// Get returns the values the path selects in record, in document order.
+func (p *Path) Get(record *interfaces.Record) []interface{} {
+	locations := p.locate(record)
+	values := make([]interface{}, len(locations))
+	for i, l := range locations {
+		values[i] = l.get()
+	}
+	return values
+}
+
+// location is a value inside a record: the field at index in object, or the
+// element at index in array.
+type location struct {
+	object *interfaces.Record
+	array  []interface{}
+	name   string
+	index  int
+}
+
+func (l location) get() interface{} {
+	if l.object != nil {
+		return l.object.Fields[l.index].Value
+	}
+	return l.array[l.index]
+}
+
+func (l location) set(value interface{}) {
+	if l.object != nil {
+		l.object.Fields[l.index].Value = interfaces.Normalize(value)
+		return
+	}
+	l.array[l.index] = interfaces.Normalize(value)
+}
+
+// locate returns the location of every value the path selects.
+func (p *Path) locate(record *interfaces.Record) []location {
+	if !p.rooted && len(p.steps) > 1 {
+		if i := record.Index(p.Text); i >= 0 {
+			return []location{{object: record, name: p.Text, index: i}}
+		}
+	}
+	containers := []interface{}{record}
+	var matched []location
+	for i, s := range p.steps {
+		if s.descend {
+			var all []interface{}
+			for _, c := range containers {
+				all = appendContainers(all, c)
+			}
+			containers = all
+		}
+		matched = nil
+		for _, c := range containers {
+			matched = s.apply(matched, c, &p.hints[i])
+		}
+		if i == len(p.steps)-1 {
+			break
+		}
+		containers = nil
+		for _, l := range matched {
+			switch value := l.get().(type) {
+			case *interfaces.Record, []interface{}:
+				containers = append(containers, value)
+			}
+		}
+	}
+	return matched
+}
+
+// apply appends the locations s selects in container. hint is where the
+// field was found last time.
+func (s step) apply(matched []location, container interface{}, hint *atomic.Int32) []location {
+	switch c := container.(type) {
+	case *interfaces.Record:
+		if s.wildcard {
+			for i, field := range c.Fields {
+				matched = append(matched, location{object: c, name: field.Name, index: i})
+			}
+		} else if !s.isIndex {
+			if i := fieldIndex(c, s.name, hint); i >= 0 {
+				matched = append(matched, location{object: c, name: s.name, index: i})
+			}
+		}
+	case []interface{}:
+		if s.wildcard {
+			for i := range c {
+				matched = append(matched, location{array: c, index: i})
+			}
+		} else if s.isIndex {
+			index := s.index
+			if index < 0 {
+				index += len(c)
+			}
+			if index >= 0 && index < len(c) {
+				matched = append(matched, location{array: c, index: index})
+			}
+		}
+	}
+	return matched
+}
+
+// fieldIndex returns the position of the field name in object, or -1, looking
+// first at the position hint holds.
+func fieldIndex(object *interfaces.Record, name string, hint *atomic.Int32) int {
+	if i := int(hint.Load()); i < len(object.Fields) && object.Fields[i].Name == name {
+		return i
+	}
+	i := object.Index(name)
+	if i >= 0 {
+		hint.Store(int32(i))
+	}
+	return i
+}
+
+// appendContainers appends value and every object and array below it, in
+// document order.
+func appendContainers(all []interface{}, value interface{}) []interface{} {
+	switch v := value.(type) {
+	case *interfaces.Record:
+		all = append(all, v)
+		for _, field := range v.Fields {
+			all = appendContainers(all, field.Value)
+		}
+	case []interface{}:
+		all = append(all, v)
+		for _, element := range v {
+			all = appendContainers(all, element)
+		}
+	}
+	return all
+}
+
+// set stores value at every location the path selects. When a definite path
+// selects nothing, the objects leading to it are created as needed.
+func (p *Path) set(record *interfaces.Record, value interface{}) error {
+	if locations := p.locate(record); len(locations) > 0 {
+		for _, l := range locations {
+			l.set(value)
+		}
+		return nil
+	}
+	if !p.Definite() {
+		return nil
+	}
+
+	var container interface{} = record
+	for i, s := range p.steps {
+		last := i == len(p.steps)-1
+		object, ok := container.(*interfaces.Record)
+		if s.isIndex || !ok {
+			// Array elements are never created
+			matched := s.apply(nil, container, &p.hints[i])
+			if len(matched) == 0 {
+				return fmt.Errorf("cannot set %s: %s does not exist", p.Text, p.prefix(i+1))
+			}
+			if last {
+				matched[0].set(value)
+				return nil
+			}
+			container = matched[0].get()
+			continue
+		}
+		if last {
+			object.Set(s.name, value)
+			return nil
+		}
+		next, exists := object.Get(s.name)
+		if !exists || next == nil {
+			next = interfaces.NewRecord()
+			object.Set(s.name, next)
+		}
+		container = next
+	}
+	return nil
+}
+
+// prefix renders the first n steps of the path.
+func (p *Path) prefix(n int) string {
+	var b strings.Builder
+	if p.rooted {
+		b.WriteString("$")
+	}
+	for i, s := range p.steps[:n] {
+		switch {
+		case s.isIndex:
+			fmt.Fprintf(&b, "[%d]", s.index)
+		case s.wildcard:
+			b.WriteString("[*]")
+		default:
+			if p.rooted || i > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(s.name)
+		}
+	}
+	return b.String()
+}
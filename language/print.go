@@ -0,0 +1,244 @@
+package language
+
+import (
+	"strings"
+)
+
+// Print returns the canonical text of a node: keywords in upper case,
+// strings in double quotes, single spaces around operators and after commas,
+// and parentheses only where the grouping of the tree needs them. Parsing
+// the text of a rule gives the rule back.
+func Print(node Node) string {
+	switch n := node.(type) {
+	case *Check:
+		return Print(n.Condition)
+	case *Filter:
+		return n.Keyword() + " " + Print(n.Condition)
+	case *GroupBy:
+		keys := make([]string, len(n.Keys))
+		for i, key := range n.Keys {
+			keys[i] = Print(key.Value)
+			if field, ok := key.Value.(*FieldRef); !ok || field.Name != key.Name {
+				keys[i] += " AS " + quote(key.Name)
+			}
+		}
+		return "GROUP BY " + strings.Join(keys, ", ")
+	case *Aggregate:
+		value := ""
+		if n.Value != nil {
+			value = Print(n.Value)
+		}
+		return n.Function + "(" + value + ") AS " + quote(n.Name)
+	case *Rename:
+		return "RENAME(" + quote(n.From) + ", " + quote(n.To) + ")"
+	case *Map:
+		entries := make([]string, len(n.Entries))
+		for i, entry := range n.Entries {
+			entries[i] = Print(entry.Key) + ": " + Print(entry.Value)
+		}
+		return "MAP(" + quote(n.Field) + ", {" + strings.Join(entries, ", ") + "})"
+	case *AddField:
+		return "ADD_FIELD(" + quote(n.Field) + ", " + Print(n.Value) + ")"
+	case *If:
+		return "IF " + Print(n.Condition) + " THEN " + Print(n.Then)
+	case *Logical:
+		return group(n.Left, level(n)) + " " + n.Op + " " + group(n.Right, level(n)+1)
+	case *Not:
+		return "NOT " + group(n.X, level(n))
+	case *Comparison:
+		return Print(n.Left) + " " + n.Op + " " + Print(n.Right)
+	case *Arithmetic:
+		// The parser groups from the left, so a right operand of the same
+		// precedence was written in parentheses
+		return group(n.Left, level(n)) + " " + n.Op + " " + group(n.Right, level(n)+1)
+	case *Negate:
+		return "-" + group(n.X, level(n))
+	case *FieldTest:
+		text := Print(n.Field)
+		for _, condition := range n.Conditions {
+			text += " " + Print(condition)
+		}
+		return text
+	case *FieldRef:
+		return "FIELD(" + quote(n.Name) + ")"
+	case *Call:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = Print(arg)
+		}
+		return n.Name + "(" + strings.Join(args, ", ") + ")"
+	case *Cast:
+		return "CAST(" + Print(n.X) + ", " + Print(n.Type) + ")"
+	case *TypeSpec:
+		return n.String()
+	case *TypeCondition:
+		if n.Spec == nil {
+			return "TYPE(" + n.Type + ")"
+		}
+		return "TYPE(" + n.Spec.String() + ")"
+	case *RangeCondition:
+		return "RANGE(" + bound(n.Min) + ", " + bound(n.Max) + ")"
+	case *MatchesCondition:
+		// A predefined pattern is written by its name
+		if re, ok := patterns[n.Pattern]; ok && re == n.Regexp {
+			return "MATCHES(" + n.Pattern + ")"
+		}
+		return "MATCHES(" + quote(n.Pattern) + ")"
+	case *InCondition:
+		values := make([]string, len(n.Values))
+		for i, value := range n.Values {
+			values[i] = Print(value)
+		}
+		return "IN(" + strings.Join(values, ", ") + ")"
+	case *RequiredCondition:
+		return "REQUIRED"
+	case *Literal:
+		if n.Kind == LiteralString {
+			return quote(n.Value)
+		}
+		return n.Value
+	}
+	return ""
+}
+
+// String returns the canonical text of the rules, one per line.
+func (s *RuleSet) String() string {
+	lines := make([]string, len(s.Rules))
+	for i, rule := range s.Rules {
+		lines[i] = Print(rule)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// level is the precedence of an expression, from OR, which binds loosest, to
+// the operands that need no parentheses.
+func level(x Expr) int {
+	switch x := x.(type) {
+	case *Logical:
+		if x.Op == "OR" {
+			return 1
+		}
+		return 2
+	case *Not:
+		return 3
+	case *Comparison:
+		return 4
+	case *Arithmetic:
+		return 4 + precedence(x.Op)
+	case *Negate:
+		return 7
+	}
+	return 8
+}
+
+func precedence(op string) int {
+	if op == "+" || op == "-" {
+		return 1
+	}
+	return 2
+}
+
+// group prints x, in parentheses when it binds looser than min.
+func group(x Expr, min int) string {
+	if level(x) < min {
+		return "(" + Print(x) + ")"
+	}
+	return Print(x)
+}
+
+func bound(l *Literal) string {
+	if l == nil {
+		return "*"
+	}
+	return Print(l)
+}
+
+// quote writes s as a string the lexer reads back as s. A backslash escapes
+// a double quote, and a backslash before another backslash, a double quote
+// or the end of the string; other backslashes are kept as they are, as in
+// regular expressions.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			b.WriteString(`\"`)
+		case c == '\\' && (i+1 == len(s) || s[i+1] == '\\' || s[i+1] == '"'):
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Format parses rules of the given kind, or of any kind when kind is empty,
+// and returns their canonical text: one rule per line, each printed by
+// Print. Comments are kept, at the end of the rule they end or on their own
+// line above it, and blank lines between rules are kept as one.
+func Format(rules, kind string) (string, error) {
+	lexer := NewLexer(rules)
+	tokens, err := lexer.Tokenize()
+	if err != nil {
+		return "", err
+	}
+	set, err := NewParser().ParseRules(tokens)
+	if err != nil {
+		return "", err
+	}
+	if errs := checkKind(set, kind); len(errs) > 0 {
+		return "", errs[0]
+	}
+
+	// The lines each rule spans, from the tokens between line breaks
+	type span struct{ first, last int }
+	var spans []span
+	for i, token := range tokens {
+		if token.Type == TokenNewline || token.Type == TokenEOF {
+			continue
+		}
+		if i == 0 || tokens[i-1].Type == TokenNewline {
+			spans = append(spans, span{token.Pos.Line, token.Pos.Line})
+		}
+		spans[len(spans)-1].last = token.Pos.Line
+	}
+
+	var lines []string
+	last := 0 // Source line of the last rule or comment written
+	write := func(line int, text string) {
+		if last > 0 && line > last+1 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, text)
+		last = line
+	}
+	comments := lexer.comments
+	for i, rule := range set.Rules {
+		s := spans[i]
+		for len(comments) > 0 && comments[0].At.Line < s.first {
+			write(comments[0].At.Line, comments[0].Text)
+			comments = comments[1:]
+		}
+		trailing := ""
+		for len(comments) > 0 && comments[0].At.Line <= s.last {
+			if c := comments[0]; c.At.Line == s.last && !c.Alone {
+				trailing = "  " + c.Text
+			} else {
+				// Comments within a rule that spans lines go above it
+				write(s.first, c.Text)
+			}
+			comments = comments[1:]
+		}
+		write(s.first, Print(rule)+trailing)
+		last = s.last
+	}
+	for _, c := range comments {
+		write(c.At.Line, c.Text)
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,221 @@
+package language
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+	_ "time/tzdata" // Time zones must not depend on the zone files of the host
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// String renders t as it is written in rules.
+func (t *TypeSpec) String() string {
+	switch {
+	case t.Name == TypeDecimal && t.Precision > 0:
+		return fmt.Sprintf("DECIMAL(%d, %d)", t.Precision, t.Scale)
+	case t.Name == TypeDate && t.Zone != "":
+		return "DATE(" + quote(t.Layout) + ", " + quote(t.Zone) + ")"
+	case t.Name == TypeDate && t.Layout != "":
+		return "DATE(" + quote(t.Layout) + ")"
+	}
+	return string(t.Name)
+}
+
+// article names t with its indefinite article.
+func (t *TypeSpec) article() string {
+	if t.Name == TypeInt {
+		return "an INT"
+	}
+	return "a " + t.String()
+}
+
+// convert converts value to type t, as CAST does. A DECIMAL is rounded half
+// away from zero to the scale of t when round is set, and must already have
+// that scale otherwise.
+func (t *TypeSpec) convert(value interface{}, round bool) (interface{}, error) {
+	var converted interface{}
+	ok := false
+	switch t.Name {
+	case TypeInt:
+		converted, ok = integer(value)
+	case TypeDate:
+		converted, ok = t.date(value)
+	case TypeDecimal:
+		d, isDecimal := decimal(value)
+		if isDecimal {
+			return t.fit(d, round)
+		}
+	default:
+		converted, ok = convert(value, t.Name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not %s", interfaces.ValueString(value), t.article())
+	}
+	return converted, nil
+}
+
+// date converts a time, or a string in the layout of t, to a time. Times
+// are moved to the zone of t, when it has one, and strings without an offset
+// are read in it.
+func (t *TypeSpec) date(value interface{}) (time.Time, bool) {
+	if date, ok := value.(time.Time); ok {
+		if t.location != nil {
+			return date.In(t.location), true
+		}
+		return date, true
+	}
+	return parseDate(text(value), t.Layout, t.location)
+}
+
+// fit returns d with the scale of t, failing when it has more digits than
+// the precision of t.
+func (t *TypeSpec) fit(d interfaces.Decimal, round bool) (interface{}, error) {
+	if t.Precision == 0 {
+		return d, nil
+	}
+	fitted := rescale(d, int32(t.Scale))
+	if !round && fitted.Cmp(d) != 0 {
+		return nil, fmt.Errorf("'%s' has more than %s after the point", d, plural(t.Scale, "digit"))
+	}
+	if len(new(big.Int).Abs(fitted.Unscaled).String()) > t.Precision {
+		return nil, fmt.Errorf("'%s' does not fit in %s", d, t)
+	}
+	return fitted, nil
+}
+
+// integer converts a whole number, or a string holding one, to an int64.
+// Unlike TO_INT, it does not drop fractions.
+func integer(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case interfaces.Decimal:
+		r := v.Rat()
+		if !r.IsInt() || !r.Num().IsInt64() {
+			return 0, false
+		}
+		return r.Num().Int64(), true
+	case bool, time.Time, nil:
+		return 0, false
+	}
+	i, err := strconv.ParseInt(text(value), 10, 64)
+	return i, err == nil
+}
+
+// decimal converts a number, or a string holding one, to a decimal. A float
+// becomes the shortest decimal that reads back as the same float, so 0.1 is
+// exactly 0.1.
+func decimal(value interface{}) (interfaces.Decimal, bool) {
+	switch v := value.(type) {
+	case interfaces.Decimal:
+		return v, true
+	case int64:
+		return interfaces.Decimal{Unscaled: big.NewInt(v)}, true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return interfaces.Decimal{}, false
+		}
+		d, err := interfaces.ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+		return d, err == nil
+	case bool, time.Time, nil:
+		return interfaces.Decimal{}, false
+	}
+	if d, err := interfaces.ParseDecimal(text(value)); err == nil {
+		return d, true
+	}
+	// Numbers with an exponent, such as 1e3
+	if n, ok := number(value); ok {
+		return decimal(n)
+	}
+	return interfaces.Decimal{}, false
+}
+
+// unscaled returns the unscaled value of d, which is nil in a zero Decimal.
+func unscaled(d interfaces.Decimal) *big.Int {
+	if d.Unscaled == nil {
+		return new(big.Int)
+	}
+	return d.Unscaled
+}
+
+// rescale returns d with scale digits after the point, rounded half away
+// from zero.
+func rescale(d interfaces.Decimal, scale int32) interfaces.Decimal {
+	n := unscaled(d)
+	if d.Scale <= scale {
+		factor := pow10(scale - d.Scale)
+		return interfaces.Decimal{Unscaled: new(big.Int).Mul(n, factor), Scale: scale}
+	}
+	divisor := pow10(d.Scale - scale)
+	quotient, remainder := new(big.Int).QuoRem(n, divisor, new(big.Int))
+	if remainder.Abs(remainder).Lsh(remainder, 1).Cmp(divisor) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(n.Sign())))
+	}
+	return interfaces.Decimal{Unscaled: quotient, Scale: scale}
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// decimalArithmetic computes a op b exactly, for +, - and *.
+func decimalArithmetic(op string, a, b interfaces.Decimal) interfaces.Decimal {
+	if op == "*" {
+		return interfaces.Decimal{Unscaled: new(big.Int).Mul(unscaled(a), unscaled(b)), Scale: a.Scale + b.Scale}
+	}
+	if op == "-" {
+		b = interfaces.Decimal{Unscaled: new(big.Int).Neg(unscaled(b)), Scale: b.Scale}
+	}
+	return a.Add(b)
+}
+
+// intArithmetic applies op, one of + - *, to a and b. It reports false when
+// the result overflows int64.
+func intArithmetic(op string, a, b int64) (int64, bool) {
+	switch op {
+	case "+":
+		n := a + b
+		return n, (n > a) == (b > 0)
+	case "-":
+		n := a - b
+		return n, (n < a) == (b > 0)
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		n := a * b
+		return n, n/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	}
+	return 0, false
+}
+
+// zones caches the time zones loaded by name.
+var zones sync.Map
+
+// loadZone returns the time zone called name: an IANA name such as
+// Europe/Paris, UTC, or a fixed offset such as +05:30.
+func loadZone(name string) (*time.Location, error) {
+	if location, ok := zones.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		offset, offsetErr := time.Parse("-07:00", name)
+		if offsetErr != nil {
+			return nil, fmt.Errorf("unknown time zone %q", name)
+		}
+		_, seconds := offset.Zone()
+		location = time.FixedZone(name, seconds)
+	}
+	zones.Store(name, location)
+	return location, nil
+}
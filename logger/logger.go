@@ -19,10 +19,10 @@ func Fatalf(format string, args ...any) {
 
 func Errorf(format string, args ...any) {
 	logger := gofr.New().Logger()
-	logger.Fatalf("[ERROR] "+format, args...)
+	logger.Errorf("[ERROR] "+format, args...)
 }
 
 func Warnf(format string, args ...any) {
 	logger := gofr.New().Logger()
-	logger.Fatalf("[WARN] "+format, args...)
+	logger.Warnf("[WARN] "+format, args...)
 }
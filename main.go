@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/SkySingh04/fractal/checkpoint"
 	"github.com/SkySingh04/fractal/config"
 	"github.com/SkySingh04/fractal/controller"
+	"github.com/SkySingh04/fractal/factory"
 	_ "github.com/SkySingh04/fractal/integrations"
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/SkySingh04/fractal/logger"
+	"github.com/SkySingh04/fractal/openapi"
 	"github.com/SkySingh04/fractal/opentele"
+	"github.com/SkySingh04/fractal/pipeline"
 	"github.com/SkySingh04/fractal/registry"
 	"gofr.dev/pkg/gofr"
 )
@@ -48,6 +57,11 @@ func processData(data []string) ([]string, error) {
 }
 
 func main() {
+	// The rules subcommand checks, formats and tests rules without the prompts below
+	if len(os.Args) > 1 && os.Args[1] == "rules" {
+		os.Exit(runRules(os.Args[2:], os.Stdout))
+	}
+
 	// Initialize OpenTelemetry tracing
 	cleanup, err := opentele.InitTracing()
 	if err != nil {
@@ -85,6 +99,13 @@ func main() {
 
 		// Register other routes as necessary
 		app.POST("/api/migration", controller.MigrationHandler)
+		app.GET("/integrations", controller.IntegrationsHandler)
+		app.POST("/rules/check", controller.RulesCheckHandler)
+
+		// Regenerate the OpenAPI document so it lists every registered integration
+		if err := openapi.Write(openapi.DefaultPath); err != nil {
+			logger.Errorf("Failed to generate OpenAPI document: %v", err)
+		}
 
 		// Default port 8000
 		app.Run()
@@ -98,7 +119,7 @@ func main() {
 			if err != nil {
 				logger.Fatalf("Failed to set up configuration: %v", err)
 			}
-			configuration := make(map[string]interface{})
+			configuration = make(map[string]interface{})
 			for key, value := range configMap {
 				switch v := value.(type) {
 				case string:
@@ -112,20 +133,33 @@ func main() {
 				}
 			}
 		}
-		logger.Infof("Configuration loaded successfully: %+v", configuration)
-		if _, ok := configuration["inputconfig"]; !ok {
+		// A pipeline of stages replaces the input and output settings
+		stages, err := getPipeline(configuration)
+		if err != nil {
+			logger.Fatalf("Invalid 'pipeline' in configuration: %v", err)
+		}
+		usesPipeline := len(stages.Stages) > 0
+		contract, err := getContract(configuration)
+		if err != nil {
+			logger.Fatalf("Invalid 'contract' in configuration: %v", err)
+		}
+
+		inputs := getInputs(configuration)
+		if _, ok := configuration["inputconfig"]; !ok && len(inputs) == 0 && !usesPipeline {
 			logger.Fatalf("Missing 'inputconfig' in configuration")
 		}
 
-		if _, ok := configuration["outputconfig"]; !ok {
+		outputs := getOutputs(configuration)
+		if _, ok := configuration["outputconfig"]; !ok && len(outputs) == 0 && !usesPipeline {
 			logger.Fatalf("Missing 'outputconfig' in configuration")
 		}
 
 		// logger.Infof("Configuration loaded successfully: %+v", configuration)
 
 		// Get the input and output methods from the configuration
-		inputMethod, inputconfig := configuration["inputMethod"], configuration["inputconfig"].(map[string]interface{})
-		outputMethod, outputconfig := configuration["outputMethod"], configuration["outputconfig"].(map[string]interface{})
+		inputMethod := configuration["inputMethod"]
+		inputconfig, _ := configuration["inputconfig"].(map[string]interface{})
+		outputconfig, _ := configuration["outputconfig"].(map[string]interface{})
 		if _, ok := configuration["errorhandling"]; !ok {
 
 			logger.Fatalf("Missing 'errorhandling' in configuration")
@@ -137,54 +171,95 @@ func main() {
 		if _, ok := configuration["transformations"]; !ok {
 			logger.Warnf("Missing 'transformations' in configuration")
 		}
+		// Build the integrations once so configuration errors surface before the first run
+		req := interfaces.Request{
+			Input:               getStringField(configuration, "inputMethod", ""),
+			Output:              getStringField(configuration, "outputMethod", ""),
+			InputConfig:         inputconfig,
+			OutputConfig:        outputconfig,
+			ValidationRules:     getStringField(configuration, "validations", ""),
+			TransformationRules: getStringField(configuration, "transformations", ""),
+			ErrorHandling:       getStringField(configuration, "errorhandling", ""),
+			Outputs:             outputs,
+			FanOut:              getStringField(configuration, "fanout", ""),
+			Inputs:              inputs,
+			Merge:               getMerge(configuration),
+			Pipeline:            stages,
+			Checkpoint:          getCheckpoint(configuration),
+			References:          getReferences(configuration),
+			Dedup:               getDedup(configuration),
+			Contract:            contract,
+		}
+		// Reference datasets stay in memory between scheduled runs
+		references, err := factory.CreateReferences(req)
+		if err != nil {
+			logger.Fatalf("Failed to create references: %v", err)
+		}
+		var migrate func(ctx context.Context) error
+		if usesPipeline {
+			graph, err := factory.CreateGraph(req)
+			if err != nil {
+				logger.Fatalf("Failed to create pipeline: %v", err)
+			}
+			logger.Infof("Pipeline loaded successfully: %d stages", len(req.Pipeline.Stages))
+			migrate = func(ctx context.Context) error {
+				_, err := graph.Run(ctx)
+				return err
+			}
+		} else {
+			inputIntegration, err := factory.CreateInputs(req)
+			if err != nil {
+				logger.Fatalf("Failed to create input method %s: %v", inputMethod, err)
+			}
+			targets, err := factory.CreateTargets(req)
+			if err != nil {
+				logger.Fatalf("Failed to create outputs: %v", err)
+			}
+			logger.Infof("Configuration loaded successfully: input=%s %+v", req.Input, registry.Redact(inputIntegration))
+			for _, target := range targets {
+				logger.Infof("Output %s: %s %+v", target.Name, target.Request.Output, registry.Redact(target.Destination))
+			}
+			// Every scheduled run resumes where the previous one stopped
+			tracker, err := factory.CreateTracker(context.Background(), req)
+			if err != nil {
+				logger.Fatalf("Failed to load checkpoint: %v", err)
+			}
+			if tracker != nil {
+				defer tracker.Close()
+			}
+			migrate = func(ctx context.Context) error {
+				if tracker != nil {
+					ctx = checkpoint.WithTracker(ctx, tracker)
+				}
+				_, err := pipeline.FanOut(ctx, inputIntegration, targets, req, req.FanOut)
+				return err
+			}
+		}
+
+		// Interrupting the process stops the running migration and the schedule
+		rootCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
 		// Define the task to be executed
 		task := func() {
 			// Create a root span for the entire task
-			ctx, span := opentele.CreateSpan(context.Background(), "cron-job")
+			ctx, span := opentele.CreateSpan(rootCtx, "cron-job")
 			defer span.End()
 
 			logger.Infof("Cron job triggered at: %s", time.Now().Format(time.RFC3339))
 
-
-		// Fetch data from the input method
-		_, fetchSpan := opentele.CreateSpan(ctx, "fetch-data")
-		inputIntegration, found := registry.GetSource(inputMethod.(string))
-		if !found {
-			fetchSpan.RecordError(fmt.Errorf("input method %s not registered", inputMethod))
-			fetchSpan.End()
-			logger.Fatalf("Input method %s not registered", inputMethod)
-
-		}
-
-		// logger.Infof("Fetching data from %s...", inputMethod)
-		// logger.Infof("Input configuration: %+v", inputconfig)
-
-		inputRequest := mapConfigToRequest(inputconfig)
-		data, err := inputIntegration.FetchData(inputRequest)
-
-		if err != nil {
-			fetchSpan.RecordError(err)
-			fetchSpan.End()
-			logger.Fatalf("Failed to fetch data from %s: %v", inputMethod, err)
-		}
-		fetchSpan.End()
-
-			// Send data to output integration
-			_, sendSpan := opentele.CreateSpan(ctx, "send-data")
-			outputIntegration, found := registry.GetDestination(outputMethod.(string))
-			if !found {
-				sendSpan.RecordError(fmt.Errorf("output method %s not registered", outputMethod))
-				sendSpan.End()
-				logger.Fatalf("Output method %s not registered", outputMethod)
-			}
-			outputRequest := mapConfigToRequest(outputconfig)
-			err = outputIntegration.SendData(data, outputRequest)
-			if err != nil {
-				sendSpan.RecordError(err)
-				sendSpan.End()
-				logger.Fatalf("Failed to send data to %s: %v", outputMethod, err)
+			// Stream data from the input method to every output
+			streamCtx, streamSpan := opentele.CreateSpan(ctx, "stream-data")
+			if err := migrate(pipeline.WithReferences(streamCtx, references)); err != nil {
+				streamSpan.RecordError(err)
+				streamSpan.End()
+				if rootCtx.Err() != nil {
+					logger.Infof("Migration interrupted: %v", err)
+					return
+				}
+				logger.Fatalf("Failed to stream data: %v", err)
 			}
-			sendSpan.End()
+			streamSpan.End()
 
 			logger.Infof("Data sent successfully")
 		}
@@ -196,13 +271,183 @@ func main() {
 		ticker := time.NewTicker(time.Duration(intervalSec) * time.Second) // Adjust the interval as needed
 		defer ticker.Stop()
 
-		// Infinite loop to keep executing the task every interval
-		for range ticker.C {
-			// Execute the task on each tick
-			task()
+		// Keep executing the task every interval until the process is interrupted
+		for {
+			select {
+			case <-rootCtx.Done():
+				logger.Infof("Shutting down")
+				return
+			case <-ticker.C:
+				// Execute the task on each tick
+				task()
+			}
+		}
+
+	}
+}
+
+// getPipeline reads the optional DAG of stages under pipeline.stages. Stages
+// are decoded like the JSON body of the HTTP API, which matches keys
+// case-insensitively.
+func getPipeline(config map[string]interface{}) (interfaces.Pipeline, error) {
+	var stages interfaces.Pipeline
+	if config["pipeline"] == nil {
+		return stages, nil
+	}
+	data, err := json.Marshal(config["pipeline"])
+	if err != nil {
+		return stages, err
+	}
+	err = json.Unmarshal(data, &stages)
+	return stages, err
+}
+
+// getInputs reads the optional list of fan-in inputs. Each entry uses the
+// same keys as the top level, name, inputMethod and inputconfig, plus the
+// join key of the input.
+func getInputs(config map[string]interface{}) []interfaces.Input {
+	items, _ := config["inputs"].([]interface{})
+	inputs := make([]interfaces.Input, 0, len(items))
+	for _, item := range items {
+		entry := lowerKeys(item)
+		inputConfig, _ := entry["inputconfig"].(map[string]interface{})
+		inputs = append(inputs, interfaces.Input{
+			Name:        getStringField(entry, "name", ""),
+			Input:       getStringField(entry, "inputmethod", ""),
+			InputConfig: inputConfig,
+			Key:         getStringField(entry, "key", ""),
+		})
+	}
+	return inputs
+}
+
+// getMerge reads how fan-in inputs are combined: mode, key, max_in_memory
+// and spill.
+func getMerge(config map[string]interface{}) interfaces.Merge {
+	entry := lowerKeys(config["merge"])
+	merge := interfaces.Merge{
+		Mode: getStringField(entry, "mode", ""),
+		Key:  getStringField(entry, "key", ""),
+	}
+	switch limit := entry["max_in_memory"].(type) {
+	case int:
+		merge.MaxInMemory = limit
+	case float64:
+		merge.MaxInMemory = int(limit)
+	}
+	merge.Spill, _ = entry["spill"].(bool)
+	return merge
+}
+
+// getCheckpoint reads where the migration records its progress: store,
+// name, path and driver.
+func getCheckpoint(config map[string]interface{}) interfaces.Checkpoint {
+	entry := lowerKeys(config["checkpoint"])
+	return interfaces.Checkpoint{
+		Store:  getStringField(entry, "store", ""),
+		Name:   getStringField(entry, "name", ""),
+		Path:   getStringField(entry, "path", ""),
+		Driver: getStringField(entry, "driver", ""),
+	}
+}
+
+// getReferences reads the optional list of reference datasets read by
+// LOOKUP. Each entry has a name, an inputMethod and inputconfig like an
+// input, and how often it is reloaded under refresh.
+func getReferences(config map[string]interface{}) []interfaces.Reference {
+	items, _ := config["references"].([]interface{})
+	references := make([]interfaces.Reference, 0, len(items))
+	for _, item := range items {
+		entry := lowerKeys(item)
+		inputConfig, _ := entry["inputconfig"].(map[string]interface{})
+		references = append(references, interfaces.Reference{
+			Name:        getStringField(entry, "name", ""),
+			Input:       getStringField(entry, "inputmethod", ""),
+			InputConfig: inputConfig,
+			Refresh:     getStringField(entry, "refresh", ""),
+		})
+	}
+	return references
+}
+
+// getContract reads the optional contract of the source. Like the stages of
+// a pipeline, it is decoded like the JSON body of the HTTP API, so declared
+// fields may nest.
+func getContract(config map[string]interface{}) (*interfaces.Contract, error) {
+	if config["contract"] == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(config["contract"])
+	if err != nil {
+		return nil, err
+	}
+	contract := &interfaces.Contract{}
+	if err := json.Unmarshal(data, contract); err != nil {
+		return nil, err
+	}
+	return contract, nil
+}
+
+// getDedup reads the optional dedup of the source: keys, window, keep,
+// store, max_keys and path.
+func getDedup(config map[string]interface{}) *interfaces.Dedup {
+	if config["dedup"] == nil {
+		return nil
+	}
+	entry := lowerKeys(config["dedup"])
+	dedup := &interfaces.Dedup{
+		Window: getStringField(entry, "window", ""),
+		Keep:   getStringField(entry, "keep", ""),
+		Store:  getStringField(entry, "store", ""),
+		Path:   getStringField(entry, "path", ""),
+	}
+	switch keys := entry["keys"].(type) {
+	case string:
+		dedup.Keys = []string{keys}
+	case []interface{}:
+		for _, key := range keys {
+			dedup.Keys = append(dedup.Keys, fmt.Sprint(key))
 		}
+	}
+	switch limit := entry["max_keys"].(type) {
+	case int:
+		dedup.MaxKeys = limit
+	case float64:
+		dedup.MaxKeys = int(limit)
+	}
+	return dedup
+}
+
+// getOutputs reads the optional list of fan-out outputs. Each entry uses the
+// same keys as the top level: name, outputMethod, outputconfig,
+// transformations and errorhandling.
+func getOutputs(configuration map[string]interface{}) []interfaces.Output {
+	items, _ := configuration["outputs"].([]interface{})
+	outputs := make([]interfaces.Output, 0, len(items))
+	for _, item := range items {
+		entry := lowerKeys(item)
+		outputConfig, _ := entry["outputconfig"].(map[string]interface{})
+		outputs = append(outputs, interfaces.Output{
+			Name:                getStringField(entry, "name", ""),
+			Output:              getStringField(entry, "outputmethod", ""),
+			OutputConfig:        outputConfig,
+			TransformationRules: config.Rules(entry["transformations"]),
+			ErrorHandling:       getStringField(entry, "errorhandling", ""),
+		})
+	}
+	return outputs
+}
 
+// lowerKeys returns a copy of a config map with lowercased keys. Keys inside
+// lists keep their case in viper, so they are matched case-insensitively.
+func lowerKeys(item interface{}) map[string]interface{} {
+	entry := make(map[string]interface{})
+	if m, ok := item.(map[string]interface{}); ok {
+		for key, value := range m {
+			entry[strings.ToLower(key)] = value
+		}
 	}
+	return entry
 }
 
 func getStringField(config map[string]interface{}, field string, defaultValue string) string {
@@ -224,52 +469,3 @@ func getStringField(config map[string]interface{}, field string, defaultValue st
 	}
 	return defaultValue
 }
-
-func mapConfigToRequest(config map[string]interface{}) interfaces.Request {
-
-	return interfaces.Request{
-		Input:                   getStringField(config, "inputmethod", ""),
-		Output:                  getStringField(config, "outputmethod", ""),
-		ValidationRules:         getStringField(config, "validations", ""),
-		TransformationRules:     getStringField(config, "transformations", ""),
-		ErrorHandling:           getStringField(config, "errorhandling", ""),
-		RabbitMQInputURL:        getStringField(config, "url", ""),
-		RabbitMQInputQueueName:  getStringField(config, "queuename", ""),
-		RabbitMQOutputURL:       getStringField(config, "url", ""),
-		RabbitMQOutputQueueName: getStringField(config, "queuename", ""),
-		ConsumerURL:             getStringField(config, "url", ""),
-		ConsumerTopic:           getStringField(config, "topic", ""), // Default is empty if "topic" is missing
-		ProducerURL:             getStringField(config, "url", ""),
-		ProducerTopic:           getStringField(config, "topic", ""),
-		SQLSourceConnString:     getStringField(config, "connstring", ""),
-		SQLTargetConnString:     getStringField(config, "connstring", ""),
-		SourceMongoDBConnString: getStringField(config, "connstring", ""),
-		SourceMongoDBDatabase:   getStringField(config, "database", ""),
-		SourceMongoDBCollection: getStringField(config, "collection", ""),
-		TargetMongoDBConnString: getStringField(config, "connstring", ""),
-		TargetMongoDBDatabase:   getStringField(config, "database", ""),
-		TargetMongoDBCollection: getStringField(config, "collection", ""),
-		OutputFileName:          getStringField(config, "filename", ""),
-		CSVSourceFileName:       getStringField(config, "csvsourcefilename", ""),
-		CSVDestinationFileName:  getStringField(config, "csvdestinationfilename", ""),
-		JSONSourceData:          getStringField(config, "data", ""),
-		JSONOutputFilename:      getStringField(config, "filename", ""),
-		YAMLSourceFilePath:      getStringField(config, "filepath", ""),
-		YAMLDestinationFilePath: getStringField(config, "filepath", ""),
-		DynamoDBSourceTable:     getStringField(config, "tablename", ""),
-		DynamoDBTargetTable:     getStringField(config, "tablename", ""),
-		DynamoDBSourceRegion:    getStringField(config, "region", ""),
-		DynamoDBTargetRegion:    getStringField(config, "region", ""),
-		FTPURL:                  getStringField(config, "url", ""),
-		FTPUser:                 getStringField(config, "user", ""),
-		FTPPassword:             getStringField(config, "password", ""),
-		SFTPURL:                 getStringField(config, "url", ""),
-		SFTPUser:                getStringField(config, "user", ""),
-		SFTPPassword:            getStringField(config, "password", ""),
-		WebSocketSourceURL:      getStringField(config, "url", ""),
-		WebSocketDestURL:        getStringField(config, "url", ""),
-		CredentialFileAddr:      getStringField(config, "credentialfileaddr", "firebaseConfig.json"),
-		Document:                getStringField(config, "document", "sampledata"),
-		Collection:              getStringField(config, "collection", "1"),
-	}
-}
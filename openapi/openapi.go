@@ -0,0 +1,696 @@
+package openapi
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/SkySingh04/fractal/registry"
+)
+
+// DefaultPath is where gofr serves the OpenAPI document from.
+const DefaultPath = "static/openapi.json"
+
+// Document builds the OpenAPI document of the Fractal API. The request body
+// of /migrate and the configuration schema of every integration are generated
+// from the registry metadata, so the document never lags behind the code.
+func Document() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Integration":   integrationSchema(),
+		"ConfigOption":  configOptionSchema(),
+		"Stage":         stageSchema(),
+		"Dedup":         dedupSchema(),
+		"Contract":      contractSchema(),
+		"ContractField": contractFieldSchema(),
+		"Diagnostic":    diagnosticSchema(),
+	}
+
+	var sourceNames, destinationNames []string
+	var sourceConfigs, destinationConfigs []interface{}
+	for _, metadata := range registry.Sources() {
+		name := schemaName(metadata)
+		schemas[name] = configSchema(metadata)
+		sourceNames = append(sourceNames, metadata.Name)
+		sourceConfigs = append(sourceConfigs, ref(name))
+	}
+	for _, metadata := range registry.Destinations() {
+		name := schemaName(metadata)
+		schemas[name] = configSchema(metadata)
+		destinationNames = append(destinationNames, metadata.Name)
+		destinationConfigs = append(destinationConfigs, ref(name))
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "Fractal API",
+			"version":     "1.0.0",
+			"description": "API documentation for the Fractal migration service",
+		},
+		"paths": map[string]interface{}{
+			"/migrate":      migratePath(sourceNames, destinationNames, sourceConfigs, destinationConfigs),
+			"/integrations": integrationsPath(),
+			"/rules/check":  rulesCheckPath(),
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+// Write generates the OpenAPI document and writes it to path.
+func Write(path string) error {
+	data, err := json.MarshalIndent(Document(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func migratePath(sourceNames, destinationNames []string, sourceConfigs, destinationConfigs []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Perform data migration",
+			"description": "This endpoint performs data migration from the source to the destination based on the provided configuration.",
+			"operationId": "postMigration",
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"input": map[string]interface{}{
+									"type":        "string",
+									"enum":        sourceNames,
+									"description": "The registered name of the source integration.",
+								},
+								"output": map[string]interface{}{
+									"type":        "string",
+									"enum":        destinationNames,
+									"description": "The registered name of the destination integration.",
+								},
+								"input_config": map[string]interface{}{
+									"oneOf":       sourceConfigs,
+									"description": "Options of the source integration. The schema that applies is the one named after input.",
+								},
+								"output_config": map[string]interface{}{
+									"oneOf":       destinationConfigs,
+									"description": "Options of the destination integration. The schema that applies is the one named after output.",
+								},
+								"outputs": map[string]interface{}{
+									"type":        "array",
+									"description": "Destinations of a fan-out migration. The source is read once and every record is written to each output. Replaces output and output_config.",
+									"items":       outputSchema(destinationNames, destinationConfigs),
+								},
+								"fan_out": map[string]interface{}{
+									"type":        "string",
+									"enum":        []string{pipeline.AllOrNothing, pipeline.BestEffort},
+									"default":     pipeline.AllOrNothing,
+									"description": "What happens when an output fails: all_or_nothing stops the migration, best_effort keeps writing to the other outputs.",
+								},
+								"inputs": map[string]interface{}{
+									"type":        "array",
+									"description": "Sources of a fan-in migration, combined into one stream as described by merge. Replaces input and input_config.",
+									"items":       inputSchema(sourceNames, sourceConfigs),
+								},
+								"merge":      mergeSchema(),
+								"checkpoint": checkpointSchema(),
+								"dedup":      ref("Dedup"),
+								"contract":   ref("Contract"),
+								"references": map[string]interface{}{
+									"type":        "array",
+									"description": "Reference datasets read by the LOOKUP function of the rules. Each is held in memory while the migration runs.",
+									"items":       referenceSchema(sourceNames, sourceConfigs),
+								},
+								"pipeline": map[string]interface{}{
+									"type":        "object",
+									"description": "A DAG of named stages. When it has stages, it replaces every input and output field.",
+									"properties": map[string]interface{}{
+										"stages": map[string]interface{}{
+											"type":  "array",
+											"items": ref("Stage"),
+										},
+									},
+								},
+								"validation_rules": map[string]interface{}{
+									"type":        "string",
+									"description": "Validation rules applied to every record.",
+								},
+								"transformation_rules": map[string]interface{}{
+									"type":        "string",
+									"description": "Transformation rules applied to every record.",
+								},
+								"error_handling": map[string]interface{}{
+									"type":        "string",
+									"description": "The error handling strategy (e.g., LOG_AND_CONTINUE).",
+								},
+							},
+							"required": []string{"input"},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Migration finished. The status is partial when a best_effort migration lost outputs. dropped counts the records removed by each DROP_IF and KEEP_IF rule and dedup, and is left out when none were.",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"example": map[string]interface{}{
+								"status": "partial",
+								"outputs": []interface{}{
+									map[string]interface{}{"name": "warehouse", "records": 1200},
+									map[string]interface{}{"name": "announce", "records": 17, "error": "kafka: topic not found"},
+								},
+								"dropped": []interface{}{
+									map[string]interface{}{"rule": "DROP_IF on line 2 of the validation rules", "records": 35},
+								},
+							},
+						},
+					},
+				},
+				"400": map[string]interface{}{
+					"description": "Invalid input",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"example": map[string]interface{}{
+								"status":  "error",
+								"message": "Detailed error message here",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func inputSchema(sourceNames []string, sourceConfigs []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Label used in logs and to prefix colliding field names. Defaults to input and must be unique.",
+			},
+			"input": map[string]interface{}{
+				"type": "string",
+				"enum": sourceNames,
+			},
+			"input_config": map[string]interface{}{"oneOf": sourceConfigs},
+			"key": map[string]interface{}{
+				"type":        "string",
+				"description": "Join key of this input. Defaults to merge.key.",
+			},
+		},
+		"required": []string{"input"},
+	}
+}
+
+func referenceSchema(sourceNames []string, sourceConfigs []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the dataset in LOOKUP. Must be unique.",
+			},
+			"input": map[string]interface{}{
+				"type": "string",
+				"enum": sourceNames,
+			},
+			"input_config": map[string]interface{}{"oneOf": sourceConfigs},
+			"refresh": map[string]interface{}{
+				"type":        "string",
+				"description": "How often the dataset is reloaded while the migration runs, such as 10m. It is loaded once when empty.",
+			},
+		},
+		"required": []string{"name", "input"},
+	}
+}
+
+func mergeSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "How the records of several inputs are combined.",
+		"properties": map[string]interface{}{
+			"mode": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{pipeline.Union, pipeline.InnerJoin, pipeline.LeftJoin},
+				"default":     pipeline.Union,
+				"description": "union interleaves every input. The joins stream the first input and look up each record in the others by key.",
+			},
+			"key": map[string]interface{}{
+				"type":        "string",
+				"description": "Join key shared by every input.",
+			},
+			"max_in_memory": map[string]interface{}{
+				"type":        "integer",
+				"default":     pipeline.DefaultMaxInMemory,
+				"description": "Records of a lookup input held in memory by a join.",
+			},
+			"spill": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Partition larger lookup inputs to temporary files instead of failing.",
+			},
+		},
+	}
+}
+
+func checkpointSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "Where the migration records how far it has got, so a restarted migration resumes instead of starting over. Pipelines of stages are not checkpointed.",
+		"properties": map[string]interface{}{
+			"store": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"file", "sqlite"},
+				"description": "Store holding the checkpoints. Checkpoints are off when empty.",
+			},
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Identifies the migration in the store. Defaults to the input and output names, e.g. PostgreSQL->MongoDB.",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File or SQLite database holding the checkpoints. Defaults to " + checkpoint.DefaultPath + " or " + checkpoint.DefaultDatabase + ".",
+			},
+			"driver": map[string]interface{}{
+				"type":        "string",
+				"default":     "sqlite3",
+				"description": "database/sql driver of the sqlite store. It must be linked into the binary.",
+			},
+		},
+	}
+}
+
+func dedupSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "Drops the records whose key fields hold the same values as an earlier record. The duplicates dropped are counted in the response.",
+		"properties": map[string]interface{}{
+			"keys": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Fields identifying a record. A record with a missing or null key field is never a duplicate.",
+			},
+			"window": map[string]interface{}{
+				"type":        "string",
+				"description": "How long a key is remembered after its first record, such as 24h. For the whole run, or for good in a file store, when empty.",
+			},
+			"keep": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{pipeline.KeepFirst, pipeline.KeepLast, pipeline.KeepMerge},
+				"default":     pipeline.KeepFirst,
+				"description": "first writes the first record of a key at once. last and merge hold the records of a key back until its window ends, then write the last one, or the first one with the non-null fields of the later ones set over it.",
+			},
+			"store": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{pipeline.DedupMemory, pipeline.DedupFile},
+				"default":     pipeline.DedupMemory,
+				"description": "memory remembers the keys of one run. file remembers them across runs, and saves them when a run succeeds.",
+			},
+			"max_keys": map[string]interface{}{
+				"type":        "integer",
+				"default":     pipeline.DefaultMaxKeys,
+				"description": "Keys held by the memory store. Beyond it the least recently seen key is forgotten.",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File of the file store. Defaults to " + pipeline.DefaultDedupPath + ", or .fractal/dedup_<stage>.json for a dedup stage.",
+			},
+		},
+		"required": []string{"keys"},
+	}
+}
+
+func contractSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "Schema the records of the source must follow, given by exactly one of fields, json_schema and infer. A record whose fields drifted from it, being new, missing or retyped, fails the migration with a schema drift error unless on_drift is log. A null in a field that is not nullable, or a value outside an enum, fails it with a schema violation error.",
+		"properties": map[string]interface{}{
+			"fields": map[string]interface{}{
+				"type":        "array",
+				"items":       ref("ContractField"),
+				"description": "Declared fields.",
+			},
+			"json_schema": map[string]interface{}{
+				"type":        "string",
+				"description": "A JSON Schema document describing an object, or the path of a file holding one. Fields not in its properties are drift only when additionalProperties is false.",
+			},
+			"infer": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of records sampled to infer the schema. They are held back until the sample is complete. The inferred schema is saved as a JSON Schema to path, and later runs check records against the saved one.",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File the inferred schema is saved to. Defaults to " + pipeline.DefaultContractPath + ", or .fractal/contract_<stage>.json for a contract stage.",
+			},
+			"allow_new_fields": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Accept fields that declared or inferred fields do not list.",
+			},
+			"on_drift": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{pipeline.DriftFail, pipeline.DriftLog},
+				"default":     pipeline.DriftFail,
+				"description": "fail stops the migration at the first record that drifted. log logs each change and passes the records on.",
+			},
+		},
+	}
+}
+
+func contractFieldSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "A declared field of a contract, or the elements of an array field.",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string"},
+			"type": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"string", "int", "float", "decimal", "bool", "timestamp", "binary", "object", "array", "any"},
+				"description": "float and decimal fields hold every number, and timestamp fields also hold RFC 3339 timestamps and dates written as text. Defaults to any.",
+			},
+			"nullable": map[string]interface{}{"type": "boolean", "default": false},
+			"optional": map[string]interface{}{"type": "boolean", "default": false, "description": "The field may be missing."},
+			"enum":     map[string]interface{}{"type": "array", "items": map[string]interface{}{}, "description": "Values the field may hold, compared by their text."},
+			"fields":   map[string]interface{}{"type": "array", "items": ref("ContractField"), "description": "Fields of an object."},
+			"items":    ref("ContractField"),
+		},
+	}
+}
+
+func outputSchema(destinationNames []string, destinationConfigs []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Label used in logs and results. Defaults to output and must be unique.",
+			},
+			"output": map[string]interface{}{
+				"type": "string",
+				"enum": destinationNames,
+			},
+			"output_config":        map[string]interface{}{"oneOf": destinationConfigs},
+			"transformation_rules": map[string]interface{}{"type": "string"},
+			"error_handling":       map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"output"},
+	}
+}
+
+func stageSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "One step of a pipeline. Which fields apply depends on type.",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Unique name, used by other stages to read from this one.",
+			},
+			"type": map[string]interface{}{
+				"type": "string",
+				"enum": []string{interfaces.StageSource, interfaces.StageFilter, interfaces.StageTransform, interfaces.StageRouter, interfaces.StageAggregate, interfaces.StageDedup, interfaces.StageContract, interfaces.StageSink},
+			},
+			"inputs": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Stages this stage reads from. A route of a router is named <router>.<route>, and <router>.default receives the records no route matched.",
+			},
+			"integration": map[string]interface{}{
+				"type":        "string",
+				"description": "Registered name of the source or destination of a source or sink.",
+			},
+			"config": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": true,
+				"description":          "Options of the integration, as described by its config schema.",
+			},
+			"rules": map[string]interface{}{
+				"type":        "string",
+				"description": "Conditions a record must pass to be kept by a filter, the transformation rules of a transform, or the GROUP BY and aggregates of an aggregate, used instead of group_by and aggregates.",
+			},
+			"routes": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"name": map[string]interface{}{"type": "string"},
+						"when": map[string]interface{}{"type": "string", "description": "Conditions a record must pass to take the route."},
+					},
+				},
+				"description": "Routes of a router, tried in order. A record takes the first route it matches.",
+			},
+			"group_by": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Fields grouping the records of an aggregate.",
+			},
+			"aggregates": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"name":     map[string]interface{}{"type": "string"},
+						"function": map[string]interface{}{"type": "string", "enum": []string{pipeline.Count, pipeline.Sum, pipeline.Avg, pipeline.Min, pipeline.Max, pipeline.DistinctCount}},
+						"field":    map[string]interface{}{"type": "string"},
+					},
+				},
+				"description": "Summary fields of an aggregate, computed once per group.",
+			},
+			"window": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"type":       map[string]interface{}{"type": "string", "enum": []string{pipeline.Tumbling, pipeline.Sliding}},
+					"size":       map[string]interface{}{"type": "string", "description": "Length of a window, such as 5m or 1h."},
+					"slide":      map[string]interface{}{"type": "string", "description": "How often a sliding window starts."},
+					"time_field": map[string]interface{}{"type": "string", "description": "Field holding the time of a record. Records are placed by the time they are read when empty."},
+				},
+				"required":    []string{"type", "size"},
+				"description": "Splits the records of an aggregate reading a stream into windows of time, each emitted as it closes.",
+			},
+			"dedup":    ref("Dedup"),
+			"contract": ref("Contract"),
+		},
+		"required": []string{"name", "type"},
+	}
+}
+
+func integrationsPath() map[string]interface{} {
+	list := map[string]interface{}{
+		"type":  "array",
+		"items": ref("Integration"),
+	}
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "List integrations",
+			"description": "Describes every registered source and destination, including the configuration options each one accepts.",
+			"operationId": "getIntegrations",
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Registered integrations",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"sources":      list,
+									"destinations": list,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func rulesCheckPath() map[string]interface{} {
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Check rules",
+			"description": "Reports every error in a rule text, so editors can check rules before a migration is scheduled.",
+			"operationId": "postRulesCheck",
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"rules": map[string]interface{}{"type": "string"},
+								"kind": map[string]interface{}{
+									"type":        "string",
+									"enum":        []string{language.Validation, language.Transformation, language.Aggregation},
+									"description": "Rules of any kind are accepted when empty.",
+								},
+							},
+							"required": []string{"rules"},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Errors in the rules, in the order of the text",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"valid": map[string]interface{}{"type": "boolean"},
+									"diagnostics": map[string]interface{}{
+										"type":  "array",
+										"items": ref("Diagnostic"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func diagnosticSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "An error in a rule text.",
+		"properties": map[string]interface{}{
+			"position": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"line":   map[string]interface{}{"type": "integer"},
+					"column": map[string]interface{}{"type": "integer"},
+				},
+				"description": "Where the error is. Lines and columns start at 1 and columns count characters.",
+			},
+			"message":  map[string]interface{}{"type": "string"},
+			"token":    map[string]interface{}{"type": "string", "description": "The offending token."},
+			"expected": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "What would have been valid instead."},
+			"suggestion": map[string]interface{}{
+				"type":        "string",
+				"description": "A likely fix, such as a keyword the token is a misspelling of.",
+			},
+		},
+	}
+}
+
+func integrationSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":         map[string]interface{}{"type": "string"},
+			"kind":         map[string]interface{}{"type": "string", "enum": []string{"source", "destination"}},
+			"display_name": map[string]interface{}{"type": "string"},
+			"description":  map[string]interface{}{"type": "string"},
+			"version":      map[string]interface{}{"type": "string"},
+			"capabilities": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "string",
+					"enum": []registry.Capability{
+						registry.CapabilityBatch,
+						registry.CapabilityStreaming,
+						registry.CapabilitySchemaAware,
+						registry.CapabilityUpsert,
+					},
+				},
+			},
+			"config": map[string]interface{}{
+				"type":  "array",
+				"items": ref("ConfigOption"),
+			},
+		},
+	}
+}
+
+func configOptionSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":        map[string]interface{}{"type": "string"},
+			"type":        map[string]interface{}{"type": "string"},
+			"required":    map[string]interface{}{"type": "boolean"},
+			"default":     map[string]interface{}{"type": "string"},
+			"secret":      map[string]interface{}{"type": "boolean"},
+			"description": map[string]interface{}{"type": "string"},
+			"example":     map[string]interface{}{"type": "string"},
+		},
+	}
+}
+
+// configSchema describes the configuration options of an integration.
+func configSchema(metadata registry.Metadata) map[string]interface{} {
+	properties := make(map[string]interface{}, len(metadata.Config))
+	required := []string{}
+	for _, field := range metadata.Config {
+		property := propertySchema(field.Type)
+		if field.Description != "" {
+			property["description"] = field.Description
+		}
+		if field.Default != "" {
+			property["default"] = field.Default
+		}
+		if field.Example != "" {
+			property["example"] = field.Example
+		}
+		if field.Secret {
+			property["format"] = "password"
+			property["writeOnly"] = true
+		}
+		if field.Required && field.Default == "" {
+			required = append(required, field.Name)
+		}
+		properties[field.Name] = property
+	}
+
+	schema := map[string]interface{}{
+		"type":        "object",
+		"title":       metadata.DisplayName + " " + metadata.Kind,
+		"description": metadata.Description,
+		"properties":  properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// propertySchema maps a config field type, as reported by registry.ConfigFields,
+// to a JSON schema.
+func propertySchema(fieldType string) map[string]interface{} {
+	if item, isList := strings.CutPrefix(fieldType, "list of "); isList {
+		return map[string]interface{}{"type": "array", "items": propertySchema(item)}
+	}
+	switch fieldType {
+	case "integer", "number", "string":
+		return map[string]interface{}{"type": fieldType}
+	case "bool":
+		return map[string]interface{}{"type": "boolean"}
+	case "map":
+		return map[string]interface{}{"type": "object", "additionalProperties": true}
+	case "duration":
+		return map[string]interface{}{"type": "string", "example": "30s"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// schemaName names the config schema of an integration, e.g. KafkaSourceConfig.
+func schemaName(metadata registry.Metadata) string {
+	return metadata.Name + strings.ToUpper(metadata.Kind[:1]) + metadata.Kind[1:] + "Config"
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
@@ -0,0 +1,494 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// Window types of an aggregate stage reading a stream.
+const (
+	// Tumbling windows follow each other without overlapping, so each
+	// record is in exactly one window.
+	Tumbling = "tumbling"
+	// Sliding windows start every slide and overlap when the slide is
+	// shorter than the size, so a record may be in several windows.
+	Sliding = "sliding"
+)
+
+// Fields holding the bounds of a window in the records of a windowed
+// aggregate.
+const (
+	WindowStart = "window_start"
+	WindowEnd   = "window_end"
+)
+
+// aggregation is what an aggregate stage computes, from its group_by and
+// aggregates settings or from its aggregation rules.
+type aggregation struct {
+	rules      *language.RuleSet // Aggregation rules, if any
+	filters    *language.RuleSet // Rules holding the DROP_IF and KEEP_IF rules, if any
+	keys       []column
+	aggregates []column
+	window     *window
+}
+
+// column is a group-by key or an aggregate of an aggregation.
+type column struct {
+	name     string
+	function string      // Aggregate function, empty for a key
+	value    columnValue // nil for a count of records
+}
+
+// columnValue computes a key or the value of an aggregate for a record,
+// with LOOKUP reading references.
+type columnValue func(record *interfaces.Record, references *References) (interface{}, error)
+
+// field returns the function reading the field called name.
+func field(name string) columnValue {
+	return func(record *interfaces.Record, _ *References) (interface{}, error) {
+		value, _ := record.Get(name)
+		return value, nil
+	}
+}
+
+// expression returns the function evaluating x.
+func expression(x language.Expr) columnValue {
+	return func(record *interfaces.Record, references *References) (interface{}, error) {
+		return language.EvaluateWith(record, x, references)
+	}
+}
+
+// newAggregation checks the settings of an aggregate stage and prepares its
+// aggregation.
+func newAggregation(definition interfaces.Stage) (*aggregation, error) {
+	a := &aggregation{}
+	if definition.Window != nil {
+		var err error
+		if a.window, err = newWindow(definition.Window); err != nil {
+			return nil, err
+		}
+	}
+	if strings.TrimSpace(definition.Rules) != "" {
+		if len(definition.GroupBy) > 0 || len(definition.Aggregates) > 0 {
+			return nil, errors.New("an aggregate takes either rules or group_by and aggregates, not both")
+		}
+		set, err := language.CompileAggregation(definition.Rules)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = set
+		for _, rule := range set.Rules {
+			switch rule := rule.(type) {
+			case *language.Filter:
+				a.filters = set
+			case *language.GroupBy:
+				for _, key := range rule.Keys {
+					a.keys = append(a.keys, column{name: key.Name, value: expression(key.Value)})
+				}
+			case *language.Aggregate:
+				c := column{name: rule.Name, function: strings.ToLower(rule.Function)}
+				if rule.Value != nil {
+					c.value = expression(rule.Value)
+				}
+				a.aggregates = append(a.aggregates, c)
+			}
+		}
+		if len(a.keys) == 0 && len(a.aggregates) == 0 {
+			return nil, errors.New("aggregation rules need GROUP BY or an aggregate")
+		}
+		return a, nil
+	}
+
+	if len(definition.Aggregates) == 0 {
+		return nil, errors.New("an aggregate needs aggregates")
+	}
+	for _, name := range definition.GroupBy {
+		a.keys = append(a.keys, column{name: name, value: field(name)})
+	}
+	for _, aggregate := range definition.Aggregates {
+		if aggregate.Name == "" {
+			return nil, errors.New("every aggregate needs a name")
+		}
+		switch aggregate.Function {
+		case Count:
+		case Sum, Avg, Min, Max, DistinctCount:
+			if aggregate.Field == "" {
+				return nil, fmt.Errorf("aggregate %s: %s needs a field", aggregate.Name, aggregate.Function)
+			}
+		default:
+			return nil, fmt.Errorf("aggregate %s: unknown function %q, expected %s, %s, %s, %s, %s or %s", aggregate.Name, aggregate.Function, Count, Sum, Avg, Min, Max, DistinctCount)
+		}
+		c := column{name: aggregate.Name, function: aggregate.Function}
+		if aggregate.Field != "" {
+			c.value = field(aggregate.Field)
+		}
+		a.aggregates = append(a.aggregates, c)
+	}
+	return a, nil
+}
+
+// window splits the records of an aggregate by time.
+type window struct {
+	size, slide time.Duration
+	timeField   string
+}
+
+func newWindow(definition *interfaces.Window) (*window, error) {
+	w := &window{timeField: definition.TimeField}
+	var err error
+	if w.size, err = time.ParseDuration(definition.Size); err != nil || w.size <= 0 {
+		return nil, fmt.Errorf("invalid window size %q, expected a duration such as 5m or 1h", definition.Size)
+	}
+	switch definition.Type {
+	case Tumbling:
+		if definition.Slide != "" {
+			return nil, errors.New("a tumbling window has no slide, use a sliding window")
+		}
+		w.slide = w.size
+	case Sliding:
+		if w.slide, err = time.ParseDuration(definition.Slide); err != nil || w.slide <= 0 {
+			return nil, fmt.Errorf("invalid window slide %q, expected a duration such as 5m or 1h", definition.Slide)
+		}
+		if w.slide > w.size {
+			return nil, fmt.Errorf("window slide %s is longer than its size %s, so records would be missed", w.slide, w.size)
+		}
+	default:
+		return nil, fmt.Errorf("unknown window type %q, expected %s or %s", definition.Type, Tumbling, Sliding)
+	}
+	return w, nil
+}
+
+// time returns the time of record in UTC: the value of the time field, or
+// the current time when the window has none.
+func (w *window) time(record *interfaces.Record) (time.Time, error) {
+	if w.timeField == "" {
+		return time.Now().UTC(), nil
+	}
+	value, _ := record.Get(w.timeField)
+	t, ok := language.ToDate(value)
+	if !ok {
+		return time.Time{}, fmt.Errorf("time field %s is '%s', not a date", w.timeField, interfaces.ValueString(value))
+	}
+	return t.UTC(), nil
+}
+
+// starts returns the start of every window holding t, in order. Windows are
+// aligned on multiples of the slide since the zero time, so hourly windows
+// start on the hour.
+func (w *window) starts(t time.Time) []time.Time {
+	var starts []time.Time
+	for start := t.Truncate(w.slide); start.Add(w.size).After(t); start = start.Add(-w.slide) {
+		starts = append([]time.Time{start}, starts...)
+	}
+	return starts
+}
+
+// groups are the groups of an aggregation, in the order they were first
+// seen.
+type groups struct {
+	index map[string]*group
+	order []*group
+}
+
+func newGroups() *groups {
+	return &groups{index: make(map[string]*group)}
+}
+
+// group holds the running aggregates of the records sharing a group key.
+type group struct {
+	keys         []interfaces.Field
+	accumulators []*accumulator
+}
+
+// accumulator computes one aggregate of a group.
+type accumulator struct {
+	column   column
+	count    int64
+	exact    interfaces.Decimal // Sum of the integers and decimals
+	floats   float64            // Sum of the floats
+	decimal  bool               // A value was a decimal, so the sum is a decimal
+	float    bool               // A value was a float, so the sum is a float
+	best     interface{}
+	distinct map[string]bool
+}
+
+// avgDigits is how many more digits after the point the average of decimals
+// has than their sum.
+const avgDigits = 4
+
+// values computes the keys and the values of the aggregates of record. It
+// returns nil keys when a filter rule drops the record.
+func (e *execution) values(s *stage, record *interfaces.Record) ([]interfaces.Field, []interface{}, error) {
+	a := s.aggregation
+	if a.filters != nil {
+		if err := language.ValidateWith(record, a.filters, e.references); err != nil {
+			if e.dropped(s, err, language.Aggregation) {
+				return nil, nil, nil
+			}
+			return nil, nil, err
+		}
+	}
+	keys := make([]interfaces.Field, len(a.keys))
+	for i, key := range a.keys {
+		value, err := key.value(record, e.references)
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %s: %w", key.name, err)
+		}
+		keys[i] = interfaces.Field{Name: key.name, Value: value}
+	}
+	values := make([]interface{}, len(a.aggregates))
+	for i, aggregate := range a.aggregates {
+		if aggregate.value == nil {
+			continue
+		}
+		value, err := aggregate.value(record, e.references)
+		if err != nil {
+			return nil, nil, fmt.Errorf("aggregate %s: %w", aggregate.name, err)
+		}
+		values[i] = value
+	}
+	return keys, values, nil
+}
+
+// add folds the values of a record into the group of its keys.
+func (g *groups) add(a *aggregation, keys []interfaces.Field, values []interface{}) error {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = interfaces.ValueString(key.Value)
+	}
+	index := strings.Join(parts, "\x00")
+	current := g.index[index]
+	if current == nil {
+		current = &group{keys: keys}
+		for _, aggregate := range a.aggregates {
+			current.accumulators = append(current.accumulators, &accumulator{column: aggregate})
+		}
+		g.index[index] = current
+		g.order = append(g.order, current)
+	}
+	for i, acc := range current.accumulators {
+		if err := acc.add(values[i]); err != nil {
+			return fmt.Errorf("aggregate %s: %w", acc.column.name, err)
+		}
+	}
+	return nil
+}
+
+// emitGroups sends one record per group, holding fields first, then the keys
+// and the aggregates.
+func (e *execution) emitGroups(s *stage, g *groups, fields ...interfaces.Field) error {
+	for _, group := range g.order {
+		record := &interfaces.Record{Fields: append(append([]interfaces.Field(nil), fields...), group.keys...)}
+		for _, a := range group.accumulators {
+			record.Set(a.column.name, a.result())
+		}
+		if err := e.emit(s, "", record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregate reads every record of s and then emits one record per group, in
+// the order the groups were first seen. Each record holds the group-by keys
+// followed by the aggregates. A windowed aggregate emits the groups of each
+// window instead, as the window closes.
+func (e *execution) aggregate(s *stage) error {
+	if s.aggregation.window != nil {
+		return e.aggregateWindows(s)
+	}
+	g := newGroups()
+	err := e.each(s, func(record *interfaces.Record) error {
+		keys, values, err := e.values(s, record)
+		if err != nil || keys == nil {
+			return err
+		}
+		return g.add(s.aggregation, keys, values)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Aggregate %s reduced %d records to %d groups", s.Stage.Name, atomic.LoadInt64(e.received[s]), len(g.order))
+	return e.emitGroups(s, g)
+}
+
+// aggregateWindows aggregates the records of s in windows of time. A window
+// is emitted once it is over: when a record at or after its end arrives, for
+// windows on a time field, or when the clock reaches its end otherwise. The
+// windows still open when the input ends are emitted then. A record whose
+// windows have all been emitted is late, and is left out.
+func (e *execution) aggregateWindows(s *stage) error {
+	w := s.aggregation.window
+	open := make(map[time.Time]*groups)
+	var closed time.Time // Windows ending at or before closed have been emitted
+	var emitted, late int64
+
+	// flush emits the windows ending at or before now, in order
+	flush := func(now time.Time) error {
+		var starts []time.Time
+		for start := range open {
+			if !start.Add(w.size).After(now) {
+				starts = append(starts, start)
+			}
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for _, start := range starts {
+			bounds := []interfaces.Field{{Name: WindowStart, Value: start}, {Name: WindowEnd, Value: start.Add(w.size)}}
+			if err := e.emitGroups(s, open[start], bounds...); err != nil {
+				return err
+			}
+			delete(open, start)
+			emitted++
+		}
+		if now.After(closed) {
+			closed = now
+		}
+		return nil
+	}
+
+	var tick <-chan time.Time
+	if w.timeField == "" {
+		ticker := time.NewTicker(min(w.slide, time.Second))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-e.ctx.Done():
+			return e.ctx.Err()
+		case now := <-tick:
+			if err := flush(now.UTC()); err != nil {
+				return err
+			}
+		case record, ok := <-e.inbox[s]:
+			if !ok {
+				// The input is over, so every window is
+				if err := flush(time.Unix(1<<62, 0)); err != nil {
+					return err
+				}
+				logger.Infof("Aggregate %s emitted %d windows of %d records, leaving out %d late records", s.Stage.Name, emitted, atomic.LoadInt64(e.received[s]), late)
+				return nil
+			}
+			keys, values, err := e.values(s, record)
+			if err != nil {
+				return err
+			}
+			if keys == nil {
+				continue
+			}
+			t, err := w.time(record)
+			if err != nil {
+				return err
+			}
+			placed := false
+			for _, start := range w.starts(t) {
+				if !start.Add(w.size).After(closed) {
+					continue
+				}
+				if open[start] == nil {
+					open[start] = newGroups()
+				}
+				if err := open[start].add(s.aggregation, keys, values); err != nil {
+					return err
+				}
+				placed = true
+			}
+			if !placed {
+				late++
+			}
+			if w.timeField != "" {
+				if err := flush(t); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// add folds a value into the aggregate. Null values are ignored, except by a
+// count without a value, which counts records.
+func (a *accumulator) add(value interface{}) error {
+	if a.column.value == nil {
+		a.count++
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	a.count++
+
+	switch a.column.function {
+	case Sum, Avg:
+		number, ok := language.ToNumber(value)
+		if !ok {
+			return fmt.Errorf("value %q is not a number", interfaces.ValueString(value))
+		}
+		if f, ok := number.(float64); ok {
+			a.floats += f
+			a.float = true
+			return nil
+		}
+		if _, ok := number.(interfaces.Decimal); ok {
+			a.decimal = true
+		}
+		d, _ := language.ToDecimal(number)
+		a.exact = a.exact.Add(d)
+	case Min, Max:
+		if a.best == nil {
+			a.best = value
+			return nil
+		}
+		order := language.Compare(value, a.best)
+		if (a.column.function == Min && order < 0) || (a.column.function == Max && order > 0) {
+			a.best = value
+		}
+	case DistinctCount:
+		if a.distinct == nil {
+			a.distinct = make(map[string]bool)
+		}
+		a.distinct[interfaces.ValueString(value)] = true
+	}
+	return nil
+}
+
+// result returns the value of the aggregate, or nil when an avg, min or max
+// saw no values.
+func (a *accumulator) result() interface{} {
+	switch a.column.function {
+	case Count:
+		return a.count
+	case DistinctCount:
+		return int64(len(a.distinct))
+	case Sum:
+		switch {
+		case a.float:
+			return a.exact.Float64() + a.floats
+		case a.decimal || !a.exact.Rat().Num().IsInt64():
+			return a.exact
+		}
+		return a.exact.Rat().Num().Int64()
+	case Avg:
+		if a.count == 0 {
+			return nil
+		}
+		avg := new(big.Rat).Quo(a.exact.Rat(), big.NewRat(a.count, 1))
+		if a.decimal && !a.float {
+			d, _ := interfaces.ParseDecimal(avg.FloatString(max(int(a.exact.Scale), 0) + avgDigits))
+			return d
+		}
+		f, _ := avg.Float64()
+		return f + a.floats/float64(a.count)
+	default:
+		return a.best
+	}
+}
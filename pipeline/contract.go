@@ -0,0 +1,276 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SkySingh04/fractal/contract"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// What a contract does with a record that drifted from its schema.
+const (
+	// DriftFail fails the migration with a contract.DriftError.
+	DriftFail = "fail"
+	// DriftLog logs each change the first time a record shows it, counts
+	// the records showing it, and passes them on.
+	DriftLog = "log"
+)
+
+// DefaultContractPath is where the contract of a migration saves the schema
+// it inferred when no path is set. A contract stage saves it to
+// .fractal/contract_<stage>.json.
+const DefaultContractPath = ".fractal/contract.json"
+
+// contractCheck checks records against a schema, as configured by a
+// contract stage or the contract of a migration.
+type contractCheck struct {
+	name     string           // e.g. "contract of stage orders", in logs
+	declared *contract.Schema // Nil when the schema is inferred
+	given    string           // How the declared schema is given, in logs
+	infer    int              // Records sampled to infer the schema
+	path     string           // File the inferred schema is saved to
+	closed   bool             // An inferred schema accepts no new fields
+	onDrift  string
+}
+
+// newContract checks the settings of a contract and reads its declared
+// schema. scope describes where it is set, such as " of stage orders", and
+// path is the file an inferred schema is saved to when the settings have
+// none.
+func newContract(config *interfaces.Contract, scope, path string) (*contractCheck, error) {
+	if config == nil {
+		return nil, errors.New("a contract needs fields, a json_schema or infer")
+	}
+	given := 0
+	for _, set := range []bool{len(config.Fields) > 0, config.JSONSchema != "", config.Infer != 0} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return nil, errors.New("a contract needs exactly one of fields, json_schema and infer")
+	}
+	c := &contractCheck{name: "contract" + scope, closed: !config.AllowNewFields, onDrift: config.OnDrift}
+	switch c.onDrift {
+	case "":
+		c.onDrift = DriftFail
+	case DriftFail, DriftLog:
+	default:
+		return nil, fmt.Errorf("unknown contract on_drift %q, expected %s or %s", config.OnDrift, DriftFail, DriftLog)
+	}
+	if config.Path != "" && config.Infer == 0 {
+		return nil, errors.New("path is where a contract saves the schema it infers, set infer to use it")
+	}
+
+	var err error
+	switch {
+	case len(config.Fields) > 0:
+		c.declared, err = contract.Declare(config.Fields, c.closed)
+		c.given = "declared fields"
+	case config.JSONSchema != "":
+		if config.AllowNewFields {
+			return nil, errors.New("allow_new_fields does not apply to a json_schema, which allows new fields unless additionalProperties is false")
+		}
+		c.declared, err = contract.LoadJSONSchema(config.JSONSchema)
+		c.given = "JSON Schema"
+	default:
+		if config.Infer < 0 {
+			return nil, fmt.Errorf("invalid contract infer %d, expected a number of records", config.Infer)
+		}
+		c.infer = config.Infer
+		if c.path = config.Path; c.path == "" {
+			c.path = path
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checking is the state of a contract during one run.
+type checking struct {
+	*contractCheck
+	schema  *contract.Schema     // Nil while the records inferring it are sampled
+	sample  []*interfaces.Record // Records held back to infer the schema
+	read    int64
+	drifted int64
+	changes map[string]int64 // Records showing each change, in log mode
+	order   []string         // Changes in the order first seen
+}
+
+// open starts a run of the contract. An inferred contract reads the schema
+// an earlier run saved, or samples the records of this run to infer one.
+func (c *contractCheck) open() (*checking, error) {
+	k := &checking{contractCheck: c, schema: c.declared, changes: make(map[string]int64)}
+	if k.schema != nil {
+		return k, nil
+	}
+	schema, err := contract.LoadJSONSchema(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid inferred schema: %w", err)
+	}
+	k.schema = schema
+	return k, nil
+}
+
+// add checks record and returns the records to emit. While the schema is
+// inferred, records are held back until the sample is complete, then
+// checked and emitted together.
+func (k *checking) add(record *interfaces.Record) ([]*interfaces.Record, error) {
+	k.read++
+	if k.schema == nil {
+		k.sample = append(k.sample, record)
+		if len(k.sample) < k.infer {
+			return nil, nil
+		}
+		return k.flush()
+	}
+	if err := k.check(record, k.read); err != nil {
+		return nil, err
+	}
+	return []*interfaces.Record{record}, nil
+}
+
+// flush infers the schema from the records sampled so far, when it is not
+// known yet, saves it and returns the sampled records once checked. A run
+// with fewer records than the sample infers the schema from those it read.
+func (k *checking) flush() ([]*interfaces.Record, error) {
+	if k.schema != nil || len(k.sample) == 0 {
+		return nil, nil
+	}
+	k.schema = contract.Infer(k.sample, k.closed)
+	data, err := k.schema.JSONSchema()
+	if err == nil {
+		err = writeFile(k.path, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save the schema the %s inferred: %w", k.name, err)
+	}
+	logger.Infof("The %s inferred the schema of %d records and saved it to %s", k.name, len(k.sample), k.path)
+
+	sample := k.sample
+	k.sample = nil
+	first := k.read - int64(len(sample))
+	for i, record := range sample {
+		if err := k.check(record, first+int64(i)+1); err != nil {
+			return nil, err
+		}
+	}
+	return sample, nil
+}
+
+// check checks the n-th record read by the contract.
+func (k *checking) check(record *interfaces.Record, n int64) error {
+	err := k.schema.Check(record)
+	var drift *contract.DriftError
+	if errors.As(err, &drift) && k.onDrift == DriftLog {
+		k.drifted++
+		for _, change := range drift.Changes {
+			text := change.String()
+			if k.changes[text] == 0 {
+				k.order = append(k.order, text)
+				logger.Warnf("The %s found schema drift in record %d: %s", k.name, n, text)
+			}
+			k.changes[text]++
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("record %d does not follow the %s: %w", n, k.name, err)
+	}
+	return nil
+}
+
+// report logs the records that drifted from the schema during the run.
+func (k *checking) report() {
+	logger.Infof("The %s checked %d records against its %s", k.name, k.read, k.describe())
+	if k.drifted == 0 {
+		return
+	}
+	logger.Warnf("%d records drifted from the schema of the %s", k.drifted, k.name)
+	for _, change := range k.order {
+		logger.Warnf("  %s: %d records", change, k.changes[change])
+	}
+}
+
+func (k *checking) describe() string {
+	if k.given != "" {
+		return k.given
+	}
+	return "schema inferred in " + k.path
+}
+
+// openContract opens the contract of req, when it has one, and returns
+// source with its records checked against it.
+func openContract(req interfaces.Request, source interfaces.DataSource) (interfaces.DataSource, error) {
+	if req.Contract == nil {
+		return source, nil
+	}
+	c, err := newContract(req.Contract, "", DefaultContractPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract: %w", err)
+	}
+	return contractSource{source: source, contract: c}, nil
+}
+
+// contractSource checks the records of a source against a contract in the
+// order they are read. A record that does not follow it fails the
+// migration. Records are only held back, never dropped or reordered, so
+// checkpoint positions need no adjustment.
+type contractSource struct {
+	source   interfaces.DataSource
+	contract *contractCheck
+}
+
+func (s contractSource) FetchData(parent context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	k, err := s.contract.open()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		fetchErr <- s.source.FetchData(ctx, req, records)
+	}()
+
+	send := func(records []*interfaces.Record, err error) error {
+		for _, record := range records {
+			if err != nil {
+				break
+			}
+			err = interfaces.Send(ctx, out, record)
+		}
+		return err
+	}
+	for record := range records {
+		if err != nil {
+			continue // Drain the source so it can exit
+		}
+		if err = send(k.add(record)); err != nil {
+			cancel()
+		}
+	}
+	if sourceErr := <-fetchErr; err == nil {
+		err = sourceErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := send(k.flush()); err != nil {
+		return err
+	}
+	k.report()
+	return nil
+}
@@ -0,0 +1,583 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// DefaultRoute is the route of a router that receives the records no other
+// route matched.
+const DefaultRoute = "default"
+
+// Aggregate functions of an aggregate stage.
+const (
+	Count = "count"
+	Sum   = "sum"
+	Avg   = "avg"
+	Min   = "min"
+	Max   = "max"
+
+	DistinctCount = "distinct_count"
+)
+
+// Node binds a stage of a pipeline to the integration it runs. Sources set
+// Source and sinks set Destination.
+type Node struct {
+	Stage       interfaces.Stage
+	Source      interfaces.DataSource
+	Destination interfaces.DataDestination
+	Request     interfaces.Request // Passed to FetchData or SendData
+}
+
+// Graph is a validated pipeline, ready to run as often as needed.
+type Graph struct {
+	stages []*stage // In the order they were declared
+}
+
+// stage is a node of a Graph with its rules parsed and its consumers resolved.
+type stage struct {
+	Node
+	rules       *language.RuleSet
+	routes      []route
+	aggregation *aggregation
+	dedup       *dedup
+	contract    *contractCheck
+	consumers   map[string][]*stage // Stages reading each route, "" for a stage that is not a router
+	upstream    int                 // Number of edges into the stage
+}
+
+type route struct {
+	name string
+	when *language.RuleSet
+}
+
+// ValidateStages checks the structure of a pipeline: every stage has a unique
+// name and a known type with the settings it needs, every input names an
+// existing stage or route, rules parse, and the stages form a DAG in which
+// every record ends up in a sink.
+func ValidateStages(stages []interfaces.Stage) error {
+	_, err := compile(make([]Node, len(stages)), stages)
+	return err
+}
+
+// NewGraph validates nodes like ValidateStages and checks that every source
+// and sink has its integration.
+func NewGraph(nodes []Node) (*Graph, error) {
+	definitions := make([]interfaces.Stage, len(nodes))
+	for i, node := range nodes {
+		definitions[i] = node.Stage
+		if node.Stage.Type == interfaces.StageSource && node.Source == nil {
+			return nil, fmt.Errorf("source %s has no data source", node.Stage.Name)
+		}
+		if node.Stage.Type == interfaces.StageSink && node.Destination == nil {
+			return nil, fmt.Errorf("sink %s has no data destination", node.Stage.Name)
+		}
+	}
+	stages, err := compile(nodes, definitions)
+	if err != nil {
+		return nil, err
+	}
+	return &Graph{stages: stages}, nil
+}
+
+// compile checks the stage definitions and returns the nodes as stages.
+func compile(nodes []Node, definitions []interfaces.Stage) ([]*stage, error) {
+	if len(definitions) == 0 {
+		return nil, errors.New("pipeline has no stages")
+	}
+	byName := make(map[string]*stage, len(definitions))
+	stages := make([]*stage, len(definitions))
+	for i, definition := range definitions {
+		if definition.Name == "" {
+			return nil, fmt.Errorf("stage %d has no name", i+1)
+		}
+		if strings.Contains(definition.Name, ".") {
+			return nil, fmt.Errorf("stage name %s cannot contain a dot", definition.Name)
+		}
+		if byName[definition.Name] != nil {
+			return nil, fmt.Errorf("duplicate stage name %s", definition.Name)
+		}
+		node := nodes[i]
+		node.Stage = definition
+		s := &stage{Node: node, consumers: make(map[string][]*stage)}
+		if err := s.parse(); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", definition.Name, err)
+		}
+		stages[i] = s
+		byName[definition.Name] = s
+	}
+
+	// Connect every input to the stage, or route, it names
+	for _, s := range stages {
+		seen := make(map[string]bool, len(s.Stage.Inputs))
+		for _, input := range s.Stage.Inputs {
+			if seen[input] {
+				return nil, fmt.Errorf("stage %s reads from %s twice", s.Stage.Name, input)
+			}
+			seen[input] = true
+			name, port, _ := strings.Cut(input, ".")
+			producer := byName[name]
+			switch {
+			case producer == nil:
+				return nil, fmt.Errorf("stage %s reads from unknown stage %s", s.Stage.Name, name)
+			case producer.Stage.Type == interfaces.StageSink:
+				return nil, fmt.Errorf("stage %s cannot read from sink %s", s.Stage.Name, name)
+			case producer.Stage.Type == interfaces.StageRouter && !producer.hasRoute(port):
+				return nil, fmt.Errorf("stage %s reads from unknown route %q of router %s, expected one of %s", s.Stage.Name, port, name, strings.Join(producer.routeNames(), ", "))
+			case producer.Stage.Type != interfaces.StageRouter && port != "":
+				return nil, fmt.Errorf("stage %s reads from %s, but only routers have routes", s.Stage.Name, input)
+			}
+			producer.consumers[port] = append(producer.consumers[port], s)
+			s.upstream++
+		}
+	}
+	for _, s := range stages {
+		if s.Stage.Type != interfaces.StageSink && len(s.consumers) == 0 {
+			return nil, fmt.Errorf("stage %s is not read by any stage, every path must end in a sink", s.Stage.Name)
+		}
+		// A record sent to a route no stage reads would be lost
+		if s.Stage.Type == interfaces.StageRouter {
+			for _, r := range s.routeNames() {
+				if len(s.consumers[r]) == 0 {
+					return nil, fmt.Errorf("route %s of router %s is not read by any stage, every route must end in a sink", r, s.Stage.Name)
+				}
+			}
+		}
+	}
+	if err := findCycle(stages); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// parse checks the settings of a stage for its type and parses its rules.
+func (s *stage) parse() error {
+	definition := s.Stage
+	if definition.Type == interfaces.StageSource {
+		if len(definition.Inputs) > 0 {
+			return errors.New("a source cannot have inputs")
+		}
+	} else if len(definition.Inputs) == 0 {
+		return errors.New("no inputs")
+	}
+
+	var err error
+	switch definition.Type {
+	case interfaces.StageSource, interfaces.StageSink:
+		if definition.Integration == "" {
+			return fmt.Errorf("a %s needs an integration", definition.Type)
+		}
+	case interfaces.StageFilter, interfaces.StageTransform:
+		if strings.TrimSpace(definition.Rules) == "" {
+			return fmt.Errorf("a %s needs rules", definition.Type)
+		}
+		if definition.Type == interfaces.StageFilter {
+			s.rules, err = language.CompileValidation(definition.Rules)
+		} else {
+			s.rules, err = language.CompileTransformation(definition.Rules)
+		}
+		return err
+	case interfaces.StageRouter:
+		if len(definition.Routes) == 0 {
+			return errors.New("a router needs routes")
+		}
+		for _, r := range definition.Routes {
+			if r.Name == "" || r.Name == DefaultRoute || s.hasRoute(r.Name) {
+				return fmt.Errorf("invalid route name %q, route names must be unique and not %s", r.Name, DefaultRoute)
+			}
+			when, err := language.CompileValidation(r.When)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", r.Name, err)
+			}
+			if when == nil {
+				return fmt.Errorf("route %s has no condition", r.Name)
+			}
+			s.routes = append(s.routes, route{name: r.Name, when: when})
+		}
+	case interfaces.StageAggregate:
+		s.aggregation, err = newAggregation(definition)
+		return err
+	case interfaces.StageDedup:
+		s.dedup, err = newDedup(definition.Dedup, " of stage "+definition.Name, ".fractal/dedup_"+definition.Name+".json")
+		return err
+	case interfaces.StageContract:
+		s.contract, err = newContract(definition.Contract, " of stage "+definition.Name, ".fractal/contract_"+definition.Name+".json")
+		return err
+	default:
+		return fmt.Errorf("unknown type %q, expected %s, %s, %s, %s, %s, %s, %s or %s", definition.Type, interfaces.StageSource, interfaces.StageFilter, interfaces.StageTransform, interfaces.StageRouter, interfaces.StageAggregate, interfaces.StageDedup, interfaces.StageContract, interfaces.StageSink)
+	}
+	return nil
+}
+
+// hasRoute reports whether name is a route of the router, including the
+// default route.
+func (s *stage) hasRoute(name string) bool {
+	if name == DefaultRoute {
+		return true
+	}
+	for _, r := range s.routes {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stage) routeNames() []string {
+	names := make([]string, 0, len(s.routes)+1)
+	for _, r := range s.routes {
+		names = append(names, r.name)
+	}
+	return append(names, DefaultRoute)
+}
+
+// findCycle reports a cycle of stages such as "a -> b -> a".
+func findCycle(stages []*stage) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*stage]int, len(stages))
+	var path []string
+
+	var visit func(s *stage) error
+	visit = func(s *stage) error {
+		switch state[s] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != s.Stage.Name {
+				start++
+			}
+			cycle := append(path[start:], s.Stage.Name)
+			return fmt.Errorf("pipeline has a cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[s] = visiting
+		path = append(path, s.Stage.Name)
+		for _, r := range append([]string{""}, s.routeNames()...) {
+			for _, consumer := range s.consumers[r] {
+				if err := visit(consumer); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[s] = visited
+		return nil
+	}
+	for _, s := range stages {
+		if err := visit(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run executes every stage of the pipeline concurrently. Stages are connected
+// by channels of BufferSize records, so memory stays bounded except for the
+// groups held by aggregates, the keys held by dedups and the records sampled
+// by contracts inferring their schema. A stage read by
+// several stages sends each a copy of its records, and a stage reading
+// several stages receives their records interleaved.
+//
+// The first stage to fail stops the whole pipeline. The returned Result lists
+// every sink, in the order they were declared, with the number of records handed
+// to it. The keys of the file store of a dedup are saved only when the whole
+// pipeline succeeds.
+//
+// Pipelines are not checkpointed: a tracker carried by ctx is ignored. LOOKUP
+// reads the reference datasets ctx carries.
+func (g *Graph) Run(parent context.Context) (Result, error) {
+	ctx, cancel := context.WithCancel(checkpoint.WithTracker(parent, nil))
+	defer cancel()
+
+	e := &execution{
+		ctx:        ctx,
+		inbox:      make(map[*stage]chan *interfaces.Record, len(g.stages)),
+		received:   make(map[*stage]*int64, len(g.stages)),
+		pending:    make(map[*stage]*sync.WaitGroup, len(g.stages)),
+		drops:      &drops{},
+		references: referencesFrom(parent),
+		seen:       make(map[*stage]*seen),
+		checking:   make(map[*stage]*checking),
+	}
+	if err := e.references.start(ctx, g.ruleSets()...); err != nil {
+		return Result{}, err
+	}
+	for _, s := range g.stages {
+		if s.dedup != nil {
+			seen, err := s.dedup.open(e.drops)
+			if err != nil {
+				return Result{}, fmt.Errorf("stage %s: failed to open dedup: %w", s.Stage.Name, err)
+			}
+			e.seen[s] = seen
+		}
+		if s.contract != nil {
+			checking, err := s.contract.open()
+			if err != nil {
+				return Result{}, fmt.Errorf("stage %s: %w", s.Stage.Name, err)
+			}
+			e.checking[s] = checking
+		}
+	}
+	for _, s := range g.stages {
+		e.received[s] = new(int64)
+		if s.upstream == 0 {
+			continue
+		}
+		inbox := make(chan *interfaces.Record, BufferSize)
+		pending := &sync.WaitGroup{}
+		pending.Add(s.upstream)
+		e.inbox[s], e.pending[s] = inbox, pending
+		// The inbox closes once every stage it reads from is done
+		go func() {
+			pending.Wait()
+			close(inbox)
+		}()
+	}
+
+	errs := make([]error, len(g.stages))
+	var wg sync.WaitGroup
+	for i, s := range g.stages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = e.run(s)
+			if errs[i] != nil {
+				cancel()
+			}
+			// Keep draining a stage that stopped early, so its producers can finish
+			if inbox := e.inbox[s]; inbox != nil {
+				for range inbox {
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := Result{Dropped: e.drops.result()}
+	for i, s := range g.stages {
+		if s.Stage.Type == interfaces.StageSink {
+			result.Outputs = append(result.Outputs, OutputResult{Name: s.Stage.Name, Records: int(atomic.LoadInt64(e.received[s])), Err: errs[i]})
+		}
+	}
+	if parent.Err() != nil {
+		return result, fmt.Errorf("pipeline stopped: %w", parent.Err())
+	}
+	// A stage cancelled because another one failed is not the cause
+	for _, cancelled := range []bool{false, true} {
+		for i, err := range errs {
+			if err != nil && errors.Is(err, context.Canceled) == cancelled {
+				return result, fmt.Errorf("stage %s failed: %w", g.stages[i].Stage.Name, err)
+			}
+		}
+	}
+	// The keys of a failed run are not saved, so the next run writes its records again
+	for _, s := range g.stages {
+		if err := e.seen[s].save(); err != nil {
+			return result, fmt.Errorf("stage %s: failed to save dedup keys: %w", s.Stage.Name, err)
+		}
+	}
+	logger.Infof("Pipeline of %d stages completed", len(g.stages))
+	return result, nil
+}
+
+// ruleSets returns the rules of every stage.
+func (g *Graph) ruleSets() []*language.RuleSet {
+	var sets []*language.RuleSet
+	for _, s := range g.stages {
+		sets = append(sets, s.rules)
+		for _, r := range s.routes {
+			sets = append(sets, r.when)
+		}
+		if s.aggregation != nil {
+			sets = append(sets, s.aggregation.rules)
+		}
+	}
+	return sets
+}
+
+// execution is the state of one run of a Graph.
+type execution struct {
+	ctx        context.Context
+	inbox      map[*stage]chan *interfaces.Record
+	received   map[*stage]*int64          // Records sent to each stage
+	pending    map[*stage]*sync.WaitGroup // Stages each inbox still waits for
+	drops      *drops
+	references *References          // Datasets LOOKUP reads
+	seen       map[*stage]*seen     // State of each dedup stage
+	checking   map[*stage]*checking // State of each contract stage
+}
+
+// run executes a stage until its inputs are exhausted, then releases the
+// stages reading from it.
+func (e *execution) run(s *stage) error {
+	defer func() {
+		for _, consumers := range s.consumers {
+			for _, consumer := range consumers {
+				e.pending[consumer].Done()
+			}
+		}
+	}()
+	switch s.Stage.Type {
+	case interfaces.StageSource:
+		return e.source(s)
+	case interfaces.StageSink:
+		return s.Destination.SendData(e.ctx, e.inbox[s], s.Request)
+	case interfaces.StageAggregate:
+		return e.aggregate(s)
+	case interfaces.StageDedup:
+		return e.deduplicate(s)
+	case interfaces.StageContract:
+		return e.check(s)
+	}
+
+	dropped := 0
+	err := e.each(s, func(record *interfaces.Record) error {
+		switch s.Stage.Type {
+		case interfaces.StageFilter:
+			if err := language.ValidateWith(record, s.rules, e.references); err != nil {
+				e.dropped(s, err, language.Validation)
+				dropped++
+				return nil
+			}
+		case interfaces.StageTransform:
+			transformed, err := language.TransformWith(record, s.rules, e.references)
+			if err != nil {
+				if !e.dropped(s, err, language.Transformation) {
+					return err
+				}
+				dropped++
+				return nil
+			}
+			record = transformed
+		case interfaces.StageRouter:
+			for _, r := range s.routes {
+				if language.ValidateWith(record, r.when, e.references) == nil {
+					return e.emit(s, r.name, record)
+				}
+			}
+			return e.emit(s, DefaultRoute, record)
+		}
+		return e.emit(s, "", record)
+	})
+	if err == nil && s.Stage.Type != interfaces.StageRouter {
+		logger.Infof("Stage %s dropped %d of %d records", s.Stage.Name, dropped, atomic.LoadInt64(e.received[s]))
+	}
+	return err
+}
+
+// dropped reports whether err is a DROP_IF or KEEP_IF rule of stage s
+// dropping a record, and counts the drop.
+func (e *execution) dropped(s *stage, err error, kind string) bool {
+	return rules{drops: e.drops, scope: " of stage " + s.Stage.Name}.dropped(err, kind)
+}
+
+// deduplicate drops the duplicate records s receives, and emits the records
+// it holds back once its inputs are exhausted.
+func (e *execution) deduplicate(s *stage) error {
+	seen := e.seen[s]
+	emit := func(record *interfaces.Record) error {
+		return e.emit(s, "", record)
+	}
+	if err := seen.run(e.ctx, e.inbox[s], emit); err != nil {
+		return err
+	}
+	for _, record := range seen.flush() {
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+	seen.report()
+	return nil
+}
+
+// check checks the records s receives against its contract, and emits them
+// once checked. The records sampled to infer the schema are emitted once
+// the sample is complete, or the inputs are exhausted.
+func (e *execution) check(s *stage) error {
+	checking := e.checking[s]
+	send := func(records []*interfaces.Record, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := e.emit(s, "", record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := e.each(s, func(record *interfaces.Record) error {
+		return send(checking.add(record))
+	}); err != nil {
+		return err
+	}
+	if err := send(checking.flush()); err != nil {
+		return err
+	}
+	checking.report()
+	return nil
+}
+
+// source streams the records of a source stage to its consumers.
+func (e *execution) source(s *stage) error {
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		fetchErr <- s.Source.FetchData(e.ctx, s.Request, records)
+	}()
+	var sendErr error
+	for record := range records {
+		if sendErr == nil {
+			sendErr = e.emit(s, "", record)
+		}
+	}
+	if err := <-fetchErr; err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// each calls fn for every record received by s.
+func (e *execution) each(s *stage, fn func(*interfaces.Record) error) error {
+	for {
+		record, err := interfaces.Receive(e.ctx, e.inbox[s])
+		if err != nil || record == nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// emit sends record to every stage reading the given route of s. All but the
+// last consumer get a copy, so a stage that changes a record does not affect
+// the others.
+func (e *execution) emit(s *stage, port string, record *interfaces.Record) error {
+	consumers := s.consumers[port]
+	for i, consumer := range consumers {
+		r := record
+		if i != len(consumers)-1 {
+			r = record.Clone()
+		}
+		if err := interfaces.Send(e.ctx, e.inbox[consumer], r); err != nil {
+			return err
+		}
+		atomic.AddInt64(e.received[consumer], 1)
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// Records a dedup keeps among those sharing a key.
+const (
+	// KeepFirst writes the first record of a key as soon as it arrives and
+	// drops the others.
+	KeepFirst = "first"
+	// KeepLast holds the records of a key back until its window ends, or
+	// the input does, and writes the last one.
+	KeepLast = "last"
+	// KeepMerge holds the records of a key back like KeepLast, and writes
+	// the first one with the non-null fields of the later ones set over it.
+	KeepMerge = "merge"
+)
+
+// Stores remembering the keys a dedup has seen.
+const (
+	// DedupMemory remembers the keys of one run in memory.
+	DedupMemory = "memory"
+	// DedupFile remembers keys across runs in a JSON file.
+	DedupFile = "file"
+)
+
+// DefaultMaxKeys is the number of keys the memory store of a dedup holds
+// when no max_keys is set. Beyond it the least recently seen key is
+// forgotten.
+const DefaultMaxKeys = 100000
+
+// DefaultDedupPath is where the file store of the dedup of a migration keeps
+// its keys when no path is set. A dedup stage keeps them in
+// .fractal/dedup_<stage>.json.
+const DefaultDedupPath = ".fractal/dedup.json"
+
+// dedup drops the records whose key fields hold the same values as an
+// earlier record, as configured by a dedup stage or the dedup of a
+// migration.
+type dedup struct {
+	name    string // e.g. "dedup on order_id of stage orders", in logs and drop counts
+	keys    []string
+	window  time.Duration // Zero to remember keys for the whole run, or for good in a file
+	keep    string
+	maxKeys int    // Keys held by the memory store
+	path    string // File of the file store, empty for the memory store
+}
+
+// newDedup checks the settings of a dedup. scope describes where it is set,
+// such as " of stage orders", and path is the file of its file store when
+// the settings have none.
+func newDedup(config *interfaces.Dedup, scope, path string) (*dedup, error) {
+	if config == nil || len(config.Keys) == 0 {
+		return nil, errors.New("a dedup needs keys")
+	}
+	for _, key := range config.Keys {
+		if key == "" {
+			return nil, errors.New("dedup keys cannot be empty")
+		}
+	}
+	d := &dedup{name: "dedup on " + strings.Join(config.Keys, ", ") + scope, keys: config.Keys, keep: config.Keep}
+	if config.Window != "" {
+		var err error
+		if d.window, err = time.ParseDuration(config.Window); err != nil || d.window <= 0 {
+			return nil, fmt.Errorf("invalid dedup window %q, expected a duration such as 10m or 24h", config.Window)
+		}
+	}
+	switch d.keep {
+	case "":
+		d.keep = KeepFirst
+	case KeepFirst, KeepLast, KeepMerge:
+	default:
+		return nil, fmt.Errorf("unknown dedup keep %q, expected %s, %s or %s", config.Keep, KeepFirst, KeepLast, KeepMerge)
+	}
+	switch config.Store {
+	case "", DedupMemory:
+		if config.Path != "" {
+			return nil, fmt.Errorf("the %s store of a dedup has no path, use the %s store to remember keys across runs", DedupMemory, DedupFile)
+		}
+		if d.maxKeys = config.MaxKeys; d.maxKeys == 0 {
+			d.maxKeys = DefaultMaxKeys
+		}
+		if d.maxKeys < 0 {
+			return nil, fmt.Errorf("invalid dedup max_keys %d, expected a positive number", config.MaxKeys)
+		}
+	case DedupFile:
+		if config.MaxKeys != 0 {
+			return nil, fmt.Errorf("max_keys bounds the %s store of a dedup only, the %s store keeps every key", DedupMemory, DedupFile)
+		}
+		if d.path = config.Path; d.path == "" {
+			d.path = path
+		}
+	default:
+		return nil, fmt.Errorf("unknown dedup store %q, expected %s or %s", config.Store, DedupMemory, DedupFile)
+	}
+	return d, nil
+}
+
+// key returns the text of the key fields of record. Records with a missing
+// or null key field have no key, and are never duplicates.
+func (d *dedup) key(record *interfaces.Record) (string, bool) {
+	parts := make([]string, len(d.keys))
+	for i, name := range d.keys {
+		value, exists := record.Get(name)
+		if !exists || value == nil {
+			return "", false
+		}
+		parts[i] = keyText(value)
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// seen is the state of a dedup during one run: the keys it has seen, and
+// the records held back by keep last or merge.
+type seen struct {
+	*dedup
+	drops   *drops
+	index   map[string]*list.Element // Elements of recent by key
+	recent  *list.List               // Keys, least recently seen first
+	held    *list.List               // Keys holding a record, first seen first
+	read    int64
+	dropped int64
+	evicted int64
+}
+
+// seenKey is a key a dedup has seen.
+type seenKey struct {
+	text   string
+	first  time.Time          // When the key was first seen, which starts its window
+	record *interfaces.Record // Record held back by keep last or merge
+	held   *list.Element      // Element of the key in held, while it holds a record
+}
+
+// open starts a run of the dedup, with the keys earlier runs left in its
+// file store. Duplicates are counted in drops.
+func (d *dedup) open(drops *drops) (*seen, error) {
+	s := &seen{dedup: d, drops: drops, index: make(map[string]*list.Element), recent: list.New(), held: list.New()}
+	if d.path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string]time.Time
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid dedup file %s: %w", d.path, err)
+	}
+	now := time.Now()
+	for text, first := range stored {
+		if !s.expired(first, now) {
+			s.index[text] = s.recent.PushBack(&seenKey{text: text, first: first})
+		}
+	}
+	return s, nil
+}
+
+// expired reports whether the window of a key first seen at first is over.
+func (s *seen) expired(first, now time.Time) bool {
+	return s.window > 0 && now.Sub(first) >= s.window
+}
+
+// add passes record through the dedup at time now, and returns the records
+// to emit: record itself when it is the first of its key and kept at once,
+// and the records held back by the keys forgotten to make room for it.
+func (s *seen) add(record *interfaces.Record, now time.Time) []*interfaces.Record {
+	s.read++
+	text, ok := s.key(record)
+	if !ok {
+		return []*interfaces.Record{record}
+	}
+	var emitted []*interfaces.Record
+	if element := s.index[text]; element != nil {
+		k := element.Value.(*seenKey)
+		if !s.expired(k.first, now) {
+			s.recent.MoveToBack(element)
+			s.dropped++
+			switch {
+			case k.record == nil:
+				// Written already, by this run or an earlier one
+			case s.keep == KeepLast:
+				k.record = record
+			case s.keep == KeepMerge:
+				merge(k.record, record)
+			}
+			return nil
+		}
+		if held := s.forget(element); held != nil {
+			emitted = append(emitted, held)
+		}
+	}
+
+	k := &seenKey{text: text, first: now}
+	s.index[text] = s.recent.PushBack(k)
+	if s.keep == KeepFirst {
+		emitted = append(emitted, record)
+	} else {
+		k.record = record
+		k.held = s.held.PushBack(k)
+	}
+	for s.maxKeys > 0 && len(s.index) > s.maxKeys {
+		if held := s.forget(s.recent.Front()); held != nil {
+			emitted = append(emitted, held)
+		}
+		s.evicted++
+	}
+	return emitted
+}
+
+// forget removes a key, and returns the record it held back, if any.
+func (s *seen) forget(element *list.Element) *interfaces.Record {
+	k := element.Value.(*seenKey)
+	s.recent.Remove(element)
+	delete(s.index, k.text)
+	if k.held != nil {
+		s.held.Remove(k.held)
+	}
+	return k.record
+}
+
+// expire forgets the keys whose window is over at now, and returns the
+// records they held back.
+func (s *seen) expire(now time.Time) []*interfaces.Record {
+	var emitted []*interfaces.Record
+	for front := s.held.Front(); front != nil && s.expired(front.Value.(*seenKey).first, now); front = s.held.Front() {
+		emitted = append(emitted, s.forget(s.index[front.Value.(*seenKey).text]))
+	}
+	return emitted
+}
+
+// flush returns every record still held back, in the order their keys were
+// first seen. The keys are kept, to be saved.
+func (s *seen) flush() []*interfaces.Record {
+	var emitted []*interfaces.Record
+	for front := s.held.Front(); front != nil; front = s.held.Front() {
+		k := s.held.Remove(front).(*seenKey)
+		emitted = append(emitted, k.record)
+		k.record, k.held = nil, nil
+	}
+	return emitted
+}
+
+// merge sets the non-null fields of record over held.
+func merge(held, record *interfaces.Record) {
+	for _, field := range record.Fields {
+		if field.Value != nil {
+			held.Set(field.Name, field.Value)
+		}
+	}
+}
+
+// run passes the records of in through the dedup and the others to emit,
+// until in is closed. Records held back are emitted as their window ends,
+// checked at least every second; the caller flushes the others once the
+// input is over.
+func (s *seen) run(ctx context.Context, in <-chan *interfaces.Record, emit func(*interfaces.Record) error) error {
+	var tick <-chan time.Time
+	if s.keep != KeepFirst && s.window > 0 {
+		ticker := time.NewTicker(min(s.window, time.Second))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var passed int64
+	send := func(records []*interfaces.Record) error {
+		for _, record := range records {
+			if err := emit(record); err != nil {
+				return err
+			}
+			passed++
+		}
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-tick:
+			if err := send(s.expire(now)); err != nil {
+				return err
+			}
+		case record, ok := <-in:
+			if !ok {
+				return nil
+			}
+			dropped := s.dropped
+			if err := send(s.add(record, time.Now())); err != nil {
+				return err
+			}
+			if s.dropped > dropped {
+				checkpoint.Skip(ctx, passed)
+			}
+		}
+	}
+}
+
+// report logs and counts the duplicates the run dropped.
+func (s *seen) report() {
+	s.drops.count(s.name, s.dropped)
+	logger.Infof("%s dropped %d duplicates of %d records", s.name, s.dropped, s.read)
+	if s.evicted > 0 {
+		logger.Warnf("%s forgot %d keys beyond max_keys %d, so later duplicates of them were kept", s.name, s.evicted, s.maxKeys)
+	}
+}
+
+// save writes the keys whose window is not over to the file store, for the
+// next runs. It does nothing for the memory store or a nil *seen.
+func (s *seen) save() error {
+	if s == nil || s.path == "" {
+		return nil
+	}
+	now := time.Now()
+	stored := make(map[string]time.Time, len(s.index))
+	for text, element := range s.index {
+		if first := element.Value.(*seenKey).first; !s.expired(first, now) {
+			stored[text] = first.UTC()
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data)
+}
+
+// writeFile replaces the file at path with data atomically, like the
+// checkpoint file, creating its directory if needed.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// openDedup opens the dedup of req, when it has one, and returns source with
+// its duplicates dropped.
+func openDedup(req interfaces.Request, source interfaces.DataSource, drops *drops) (*seen, interfaces.DataSource, error) {
+	if req.Dedup == nil {
+		return nil, source, nil
+	}
+	d, err := newDedup(req.Dedup, "", DefaultDedupPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dedup: %w", err)
+	}
+	s, err := d.open(drops)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open dedup: %w", err)
+	}
+	return s, dedupSource{source: source, seen: s}, nil
+}
+
+// dedupSource drops the duplicate records of a source. Keeping the last or
+// merged record reorders records, so such a source does not report
+// checkpoint positions.
+type dedupSource struct {
+	source interfaces.DataSource
+	seen   *seen
+}
+
+func (d dedupSource) FetchData(parent context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	if d.seen.keep != KeepFirst {
+		if checkpoint.Enabled(ctx) {
+			logger.Warnf("The %s keeps the %s record of each key, which reorders records, so the migration is not checkpointed", d.seen.name, d.seen.keep)
+		}
+		ctx = checkpoint.WithTracker(ctx, nil)
+	}
+
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		fetchErr <- d.source.FetchData(ctx, req, records)
+	}()
+
+	send := func(record *interfaces.Record) error {
+		return interfaces.Send(ctx, out, record)
+	}
+	err := d.seen.run(ctx, records, send)
+	if err != nil {
+		cancel()
+		for range records {
+		}
+	}
+	if sourceErr := <-fetchErr; err == nil {
+		err = sourceErr
+	}
+	if err != nil {
+		return err
+	}
+	// Records held back are only written when the whole input was read
+	for _, record := range d.seen.flush() {
+		if err := send(record); err != nil {
+			return err
+		}
+	}
+	d.seen.report()
+	return nil
+}
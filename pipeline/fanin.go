@@ -0,0 +1,421 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// Merge modes combine the records of several inputs.
+const (
+	// Union interleaves the records of every input as they arrive.
+	Union = "union"
+	// InnerJoin emits a record of the first input once for every matching
+	// record of each other input, and drops it if one of them has no match.
+	InnerJoin = "inner_join"
+	// LeftJoin is InnerJoin, except that a record of the first input without
+	// a match is emitted unchanged.
+	LeftJoin = "left_join"
+)
+
+// DefaultMaxInMemory is the number of records of a lookup input a join holds
+// in memory when Merge.MaxInMemory is not set.
+const DefaultMaxInMemory = 100000
+
+// Input is one source of a fan-in migration.
+type Input struct {
+	Name    string
+	Source  interfaces.DataSource
+	Request interfaces.Request // Passed to the source's FetchData
+	Key     string             // Join key of this input
+}
+
+// Combine returns a source that merges the records of inputs as described by
+// merge. The result is an ordinary DataSource, so it can feed Run or FanOut
+// like any registered source. A single input is returned unchanged.
+//
+// Joins read the first input as a stream and build an in-memory hash table
+// from each other input, keyed by its join key. Keys are compared by their
+// textual form, so the integer 7 from PostgreSQL matches the string "7" from
+// a CSV file, and null keys never match. A lookup input larger than
+// merge.MaxInMemory fails the join unless merge.Spill is set, in which case
+// both sides are partitioned to temporary files and joined one partition at
+// a time.
+func Combine(inputs []Input, merge interfaces.Merge) (interfaces.DataSource, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("migration has no inputs")
+	}
+	mode := merge.Mode
+	if mode == "" {
+		mode = Union
+	}
+	switch mode {
+	case Union:
+		if len(inputs) == 1 {
+			return inputs[0].Source, nil
+		}
+		return unionSource{inputs: inputs}, nil
+	case InnerJoin, LeftJoin:
+		if len(inputs) < 2 {
+			return nil, fmt.Errorf("%s needs at least two inputs", mode)
+		}
+		for _, input := range inputs {
+			if input.Key == "" {
+				return nil, fmt.Errorf("%s: input %s has no join key", mode, input.Name)
+			}
+		}
+		limit := merge.MaxInMemory
+		if limit <= 0 {
+			limit = DefaultMaxInMemory
+		}
+		return joinSource{inputs: inputs, left: mode == LeftJoin, limit: limit, spill: merge.Spill}, nil
+	default:
+		return nil, fmt.Errorf("unknown merge mode %q, expected %s, %s or %s", mode, Union, InnerJoin, LeftJoin)
+	}
+}
+
+// fetch runs the FetchData of input in a goroutine and returns the channel it
+// streams into and a channel that receives its error once it is done.
+func fetch(ctx context.Context, input Input) (<-chan *interfaces.Record, <-chan error) {
+	records := make(chan *interfaces.Record, BufferSize)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(records)
+		if err := input.Source.FetchData(ctx, input.Request, records); err != nil {
+			errCh <- fmt.Errorf("input %s: %w", input.Name, err)
+			return
+		}
+		errCh <- nil
+	}()
+	return records, errCh
+}
+
+// unionSource interleaves the records of every input.
+type unionSource struct {
+	inputs []Input
+}
+
+func (u unionSource) FetchData(parent context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	// Interleaved inputs have no single position to resume from
+	ctx, cancel := context.WithCancel(checkpoint.WithTracker(parent, nil))
+	defer cancel()
+
+	errs := make([]error, len(u.inputs))
+	var wg sync.WaitGroup
+	for i, input := range u.inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := input.Source.FetchData(ctx, input.Request, out)
+			if err != nil {
+				errs[i] = fmt.Errorf("input %s: %w", input.Name, err)
+				// One failed input fails the union, stop the others
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	logger.Infof("Union of %d inputs completed", len(u.inputs))
+	return nil
+}
+
+// joinSource joins the first input with each of the others in turn.
+type joinSource struct {
+	inputs []Input
+	left   bool
+	limit  int
+	spill  bool
+}
+
+func (j joinSource) FetchData(parent context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	// Joined records have no single position to resume from
+	ctx, cancel := context.WithCancel(checkpoint.WithTracker(parent, nil))
+	defer cancel()
+
+	// Build the lookup tables concurrently while nothing is streamed yet
+	lookups := j.inputs[1:]
+	tables := make([]*hashTable, len(lookups))
+	errs := make([]error, len(lookups))
+	var wg sync.WaitGroup
+	for i, lookup := range lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tables[i], errs[i] = buildTable(ctx, lookup, j.limit, j.spill)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, table := range tables {
+			if table != nil {
+				table.close()
+			}
+		}
+	}()
+	if err := firstError(parent, errs); err != nil {
+		return err
+	}
+
+	// Chain one probe stage per lookup table behind the streamed input
+	var stageErrs []<-chan error
+	stream, fetchErr := fetch(ctx, j.inputs[0])
+	for i, table := range tables {
+		next := make(chan *interfaces.Record, BufferSize)
+		errCh := make(chan error, 1)
+		go func(in <-chan *interfaces.Record, probe join) {
+			defer close(next)
+			err := probe.run(ctx, in, next)
+			if err != nil {
+				cancel()
+			}
+			errCh <- err
+		}(stream, join{table: table, key: j.inputs[0].Key, lookup: lookups[i], left: j.left})
+		stream = next
+		stageErrs = append(stageErrs, errCh)
+	}
+
+	count := 0
+	var sendErr error
+	for record := range stream {
+		if sendErr != nil {
+			continue // Drain so the stages can finish
+		}
+		if sendErr = interfaces.Send(ctx, out, record); sendErr == nil {
+			count++
+		} else {
+			cancel()
+		}
+	}
+
+	errs = []error{<-fetchErr}
+	for _, errCh := range stageErrs {
+		errs = append(errs, <-errCh)
+	}
+	errs = append(errs, sendErr)
+	if err := firstError(parent, errs); err != nil {
+		return err
+	}
+	logger.Infof("Join of %d inputs produced %d records", len(j.inputs), count)
+	return nil
+}
+
+// firstError returns the cancellation of parent, or else the first error
+// that is not a cancellation caused by another error.
+func firstError(parent context.Context, errs []error) error {
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// join probes a lookup table with every record of a stream.
+type join struct {
+	table  *hashTable
+	key    string
+	lookup Input
+	left   bool
+}
+
+func (j join) run(ctx context.Context, in <-chan *interfaces.Record, out chan<- *interfaces.Record) error {
+	if !j.table.spilled() {
+		for record := range in {
+			if err := j.emit(ctx, record, j.table.memory, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The table is on disk: partition the stream the same way, then join
+	// each pair of partitions in memory
+	stream, err := newSpill(len(j.table.partitions.files))
+	if err != nil {
+		return err
+	}
+	defer stream.close()
+	for record := range in {
+		if err := stream.write(joinKey(record, j.key), record); err != nil {
+			return err
+		}
+	}
+	for p := range stream.files {
+		matches, err := j.table.partitions.load(p, j.lookup.Key)
+		if err != nil {
+			return err
+		}
+		err = stream.each(p, func(record *interfaces.Record) error {
+			return j.emit(ctx, record, matches, out)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit sends record combined with each of its matches in table.
+func (j join) emit(ctx context.Context, record *interfaces.Record, table map[string][]*interfaces.Record, out chan<- *interfaces.Record) error {
+	key := joinKey(record, j.key)
+	matches := table[key]
+	if key == "" || len(matches) == 0 {
+		if !j.left {
+			return nil
+		}
+		return interfaces.Send(ctx, out, record)
+	}
+	for i, match := range matches {
+		combined := record
+		if i < len(matches)-1 {
+			combined = record.Clone()
+		}
+		// Copy the match too, since it may join with other records
+		if err := interfaces.Send(ctx, out, combineRecords(combined, match.Clone(), j.lookup)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// combineRecords adds the fields of match to record, except its join key.
+// A field that record already has is added as "<input>_<field>".
+func combineRecords(record, match *interfaces.Record, lookup Input) *interfaces.Record {
+	var fields []interfaces.FieldSchema
+	if record.Schema != nil {
+		fields = append(fields, record.Schema.Fields...)
+	}
+	for _, field := range match.Fields {
+		if field.Name == lookup.Key {
+			continue
+		}
+		name := field.Name
+		if record.Index(name) >= 0 {
+			name = lookup.Name + "_" + name
+		}
+		record.Set(name, field.Value)
+		if record.Schema != nil && match.Schema != nil {
+			if fieldSchema, ok := match.Schema.Field(field.Name); ok {
+				fieldSchema.Name = name
+				fields = append(fields, fieldSchema)
+			}
+		}
+	}
+	if record.Schema != nil {
+		record.Schema = &interfaces.Schema{Name: record.Schema.Name, Fields: fields}
+	}
+	return record
+}
+
+// joinKey returns the textual form of the key field of record, or "" when
+// the field is missing or null.
+func joinKey(record *interfaces.Record, key string) string {
+	value, ok := record.Get(key)
+	if !ok || value == nil {
+		return ""
+	}
+	return interfaces.ValueString(value)
+}
+
+// hashTable holds the records of a lookup input by join key, in memory or,
+// once spilled, in partition files.
+type hashTable struct {
+	memory     map[string][]*interfaces.Record
+	partitions *spill
+}
+
+func (t *hashTable) spilled() bool {
+	return t.partitions != nil
+}
+
+func (t *hashTable) close() {
+	if t.partitions != nil {
+		t.partitions.close()
+	}
+}
+
+// buildTable reads every record of lookup into a hash table. Records with a
+// null key can never match and are not kept.
+func buildTable(ctx context.Context, lookup Input, limit int, allowSpill bool) (*hashTable, error) {
+	records, errCh := fetch(ctx, lookup)
+	table := &hashTable{memory: make(map[string][]*interfaces.Record)}
+	count := 0
+	var buildErr error
+	for record := range records {
+		if buildErr != nil {
+			continue // Drain so the source can finish
+		}
+		key := joinKey(record, lookup.Key)
+		if key == "" {
+			continue
+		}
+		count++
+		if table.spilled() {
+			buildErr = table.partitions.write(key, record)
+			continue
+		}
+		table.memory[key] = append(table.memory[key], record)
+		if count > limit {
+			if !allowSpill {
+				buildErr = fmt.Errorf("lookup input %s has more than %d records, raise max_in_memory or enable spill", lookup.Name, limit)
+				continue
+			}
+			buildErr = table.spillToDisk()
+		}
+	}
+	if err := <-errCh; err != nil {
+		table.close()
+		return nil, err
+	}
+	if buildErr != nil {
+		table.close()
+		return nil, buildErr
+	}
+	if table.spilled() {
+		logger.Infof("Lookup input %s spilled %d records to disk", lookup.Name, count)
+	}
+	return table, nil
+}
+
+// spillToDisk moves the records held in memory to partition files.
+func (t *hashTable) spillToDisk() error {
+	partitions, err := newSpill(spillPartitions)
+	if err != nil {
+		return err
+	}
+	t.partitions = partitions
+	for key, records := range t.memory {
+		for _, record := range records {
+			if err := partitions.write(key, record); err != nil {
+				return err
+			}
+		}
+	}
+	t.memory = nil
+	return nil
+}
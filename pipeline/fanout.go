@@ -0,0 +1,301 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// Fan-out policies decide what happens when one of several outputs fails.
+const (
+	// AllOrNothing stops the source and every other output as soon as one
+	// output fails. Records already written are not rolled back.
+	AllOrNothing = "all_or_nothing"
+	// BestEffort detaches a failing output and keeps writing to the others.
+	// The migration fails only if the source fails or every output fails.
+	BestEffort = "best_effort"
+)
+
+// Target is one destination of a fan-out migration.
+type Target struct {
+	Name        string
+	Destination interfaces.DataDestination
+	Request     interfaces.Request // Passed to the destination's SendData
+	// ErrorHandling replaces the fan-out policy for this target when set:
+	// AllOrNothing or BestEffort, or the error handling strategies STOP_ON_ERROR
+	// and LOG_AND_CONTINUE, which stand for them.
+	ErrorHandling string
+}
+
+// targetPolicy returns the policy deciding what a failure of target does.
+func targetPolicy(target Target, policy string) (string, error) {
+	switch strings.ToLower(target.ErrorHandling) {
+	case "":
+		return policy, nil
+	case AllOrNothing, "stop_on_error":
+		return AllOrNothing, nil
+	case BestEffort, "log_and_continue":
+		return BestEffort, nil
+	}
+	return "", fmt.Errorf("output %s: unknown error handling %q, expected %s, %s, STOP_ON_ERROR or LOG_AND_CONTINUE", target.Name, target.ErrorHandling, AllOrNothing, BestEffort)
+}
+
+// Result reports what happened to each output of a fan-out migration.
+type Result struct {
+	Outputs []OutputResult
+	Dropped []Drop // Records removed by each DROP_IF and KEEP_IF rule and dedup
+}
+
+// OutputResult is the outcome of a single output.
+type OutputResult struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"` // Records handed to the destination
+	Err     error  `json:"-"`
+}
+
+// Failed returns the outputs that failed.
+func (r Result) Failed() []OutputResult {
+	var failed []OutputResult
+	for _, output := range r.Outputs {
+		if output.Err != nil {
+			failed = append(failed, output)
+		}
+	}
+	return failed
+}
+
+// FanOut reads source once and streams every record to each target. Each
+// target has its own bounded channel, so the slowest target sets the pace and
+// memory stays bounded by BufferSize records per target. Targets other than
+// the last receive a copy of every record, so a destination that changes a
+// record does not affect the others.
+//
+// policy is AllOrNothing or BestEffort; an empty policy means AllOrNothing.
+// The ErrorHandling of a target replaces it for that target, so a failure of
+// a best-effort target only detaches it while a failure of an all-or-nothing
+// one stops the migration. The returned Result always lists every target,
+// with the error of each failed one, even when the migration as a whole
+// succeeds.
+//
+// When ctx carries a checkpoint tracker, a position is committed once every
+// target has acknowledged it. A target that failed under BestEffort never
+// acknowledges again, so the checkpoint stops advancing until it is fixed.
+//
+// The validation rules of req are applied once, at the source. Each target
+// then applies the transformation rules of its own Request. When req has
+// several Outputs, its top-level transformation rules are applied first, to
+// the records every output receives. The records removed by DROP_IF and
+// KEEP_IF rules are counted in the Result, and LOOKUP reads the reference
+// datasets ctx carries.
+//
+// The records of source are checked against the contract of req, when it
+// has one, before any rule. The dedup of req drops duplicate records at the
+// source, after the rules applied there, and counts them in the Result. The
+// keys of its file store are saved only when the migration succeeds, so a
+// failed run is written again in full by the next one.
+func FanOut(parent context.Context, source interfaces.DataSource, targets []Target, req interfaces.Request, policy string) (Result, error) {
+	if policy == "" {
+		policy = AllOrNothing
+	}
+	if policy != AllOrNothing && policy != BestEffort {
+		return Result{}, fmt.Errorf("unknown fan-out policy %q, expected %s or %s", policy, AllOrNothing, BestEffort)
+	}
+	if len(targets) == 0 {
+		return Result{}, errors.New("migration has no outputs")
+	}
+	shared := ""
+	if len(req.Outputs) > 0 {
+		shared = req.TransformationRules
+	}
+	sourceRules, err := parseRules(req.ValidationRules, shared)
+	if err != nil {
+		return Result{}, err
+	}
+	dropped := &drops{}
+	references := referencesFrom(parent)
+	sourceRules.drops, sourceRules.references = dropped, references
+	if source, err = openContract(req, source); err != nil {
+		return Result{}, err
+	}
+	source = sourceRules.source(source)
+	dedup, source, err := openDedup(req, source, dropped)
+	if err != nil {
+		return Result{}, err
+	}
+	sets := []*language.RuleSet{sourceRules.validation, sourceRules.transformation}
+	destinations := make([]interfaces.DataDestination, len(targets))
+	policies := make([]string, len(targets))
+	for i, target := range targets {
+		if policies[i], err = targetPolicy(target, policy); err != nil {
+			return Result{}, err
+		}
+		outputRules, err := parseRules("", target.Request.TransformationRules)
+		if err != nil {
+			return Result{}, fmt.Errorf("output %s: %w", target.Name, err)
+		}
+		outputRules.drops, outputRules.references = dropped, references
+		sets = append(sets, outputRules.transformation)
+		if len(req.Outputs) > 0 {
+			outputRules.scope = " of output " + target.Name
+		}
+		destinations[i] = outputRules.destination(target.Destination)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	if err := references.start(ctx, sets...); err != nil {
+		return Result{}, err
+	}
+	checkpoint.Start(ctx, len(targets))
+
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		fetchErr <- source.FetchData(ctx, req, records)
+	}()
+
+	// Start every destination on its own channel
+	result := Result{Outputs: make([]OutputResult, len(targets))}
+	channels := make([]chan *interfaces.Record, len(targets))
+	done := make([]chan struct{}, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		result.Outputs[i].Name = target.Name
+		channels[i] = make(chan *interfaces.Record, BufferSize)
+		done[i] = make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+			destCtx := checkpoint.ForDestination(ctx, i)
+			err := destinations[i].SendData(destCtx, channels[i], target.Request)
+			if err == nil {
+				checkpoint.Complete(destCtx)
+				return
+			}
+			result.Outputs[i].Err = err
+			if policies[i] == AllOrNothing {
+				cancel()
+				return
+			}
+			logger.Errorf("Output %s failed, continuing with the other outputs: %v", target.Name, err)
+		}()
+	}
+
+	// Copy every record to the outputs that are still running
+	live := len(targets)
+	alive := make([]bool, len(targets))
+	for i := range alive {
+		alive[i] = true
+	}
+	for record := range records {
+		for i := range targets {
+			if !alive[i] {
+				continue
+			}
+			r := record
+			if i != len(targets)-1 {
+				r = record.Clone()
+			}
+			select {
+			case channels[i] <- r:
+				result.Outputs[i].Records++
+			case <-done[i]:
+				alive[i] = false
+				live--
+			case <-ctx.Done():
+			}
+		}
+		if live == 0 || ctx.Err() != nil {
+			// Nobody is left to write to, stop reading
+			cancel()
+			for range records {
+			}
+			break
+		}
+	}
+	for i := range channels {
+		close(channels[i])
+	}
+	wg.Wait()
+	err = <-fetchErr
+	saveErr := checkpoint.Finish(ctx)
+	result.Dropped = dropped.result()
+
+	if parent.Err() != nil {
+		return result, fmt.Errorf("migration from %s stopped: %w", req.Input, parent.Err())
+	}
+	failed := result.Failed()
+	stopped := len(failed) == len(targets)
+	for i, output := range result.Outputs {
+		if output.Err != nil && policies[i] == AllOrNothing {
+			stopped = true
+		}
+	}
+	if len(failed) > 0 && stopped {
+		return result, &OutputError{Failed: failed}
+	}
+	// A source stopped by the cancellation above has already been reported
+	if err != nil && !(errors.Is(err, context.Canceled) && len(failed) > 0) {
+		return result, fmt.Errorf("failed to fetch data from source: %w", err)
+	}
+	if saveErr != nil {
+		return result, fmt.Errorf("failed to save checkpoint: %w", saveErr)
+	}
+	if err := dedup.save(); err != nil {
+		return result, fmt.Errorf("failed to save dedup keys: %w", err)
+	}
+
+	if len(failed) > 0 {
+		logger.Warnf("Stream from %s completed with failed outputs: %s", req.Input, (&OutputError{Failed: failed}).describe())
+	} else {
+		logger.Infof("Stream from %s to %d outputs completed", req.Input, len(targets))
+	}
+	return result, nil
+}
+
+// OutputError is returned by FanOut when outputs fail. Failed includes the
+// outputs that were only cancelled because another output failed.
+type OutputError struct {
+	Failed []OutputResult
+}
+
+func (e *OutputError) Error() string {
+	return "failed to send data to " + e.describe()
+}
+
+// Unwrap returns the error of every failed output.
+func (e *OutputError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, output := range e.Failed {
+		errs[i] = output.Err
+	}
+	return errs
+}
+
+// describe lists failed outputs with their errors, e.g.
+// "MongoDB: connection refused; Kafka: topic not found". Outputs that were
+// only cancelled are left out when another output reported a real error.
+func (e *OutputError) describe() string {
+	var causes, cancelled []string
+	for _, output := range e.Failed {
+		part := fmt.Sprintf("%s: %v", output.Name, output.Err)
+		if errors.Is(output.Err, context.Canceled) {
+			cancelled = append(cancelled, part)
+		} else {
+			causes = append(causes, part)
+		}
+	}
+	if len(causes) == 0 {
+		causes = cancelled
+	}
+	return strings.Join(causes, "; ")
+}
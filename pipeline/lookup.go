@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// Reference is a reference dataset read by the LOOKUP function of rules,
+// with the source it is loaded from.
+type Reference struct {
+	Name    string
+	Source  interfaces.DataSource
+	Request interfaces.Request // Passed to the source's FetchData
+	Refresh time.Duration      // How often the dataset is reloaded, once when zero
+}
+
+// References holds the reference datasets of a migration in memory. A
+// dataset is loaded when a migration whose rules read it starts, and
+// reloaded every Refresh while one runs, so the same References can serve
+// every scheduled run of a migration. A nil *References holds no dataset.
+type References struct {
+	datasets map[string]*dataset
+}
+
+// NewReferences returns the reference datasets, without loading them yet.
+func NewReferences(references []Reference) (*References, error) {
+	r := &References{datasets: make(map[string]*dataset, len(references))}
+	for _, reference := range references {
+		if reference.Name == "" {
+			return nil, fmt.Errorf("reference of %s has no name", reference.Request.Input)
+		}
+		if r.datasets[reference.Name] != nil {
+			return nil, fmt.Errorf("duplicate reference name %s", reference.Name)
+		}
+		r.datasets[reference.Name] = &dataset{Reference: reference}
+	}
+	return r, nil
+}
+
+type referencesKey struct{}
+
+// WithReferences returns a context whose migrations read their LOOKUP
+// datasets from references.
+func WithReferences(ctx context.Context, references *References) context.Context {
+	return context.WithValue(ctx, referencesKey{}, references)
+}
+
+func referencesFrom(ctx context.Context) *References {
+	r, _ := ctx.Value(referencesKey{}).(*References)
+	return r
+}
+
+// Lookup returns the value of field in the first record of dataset whose
+// field key holds value. Keys are compared by their text, so the number 42
+// matches the string "42".
+func (r *References) Lookup(dataset, key string, value interface{}, field string) (interface{}, bool, error) {
+	d := r.dataset(dataset)
+	if d == nil {
+		return nil, false, fmt.Errorf("unknown dataset %q", dataset)
+	}
+	record := d.index(key)[keyText(value)]
+	if record == nil {
+		return nil, false, nil
+	}
+	result, _ := record.Get(field)
+	return result, true, nil
+}
+
+func (r *References) dataset(name string) *dataset {
+	if r == nil {
+		return nil
+	}
+	return r.datasets[name]
+}
+
+// start loads the datasets sets read that are not loaded yet, or are due for
+// a refresh, and keeps refreshing them until ctx is done. A dataset the
+// rules read but the migration does not define is an error.
+func (r *References) start(ctx context.Context, sets ...*language.RuleSet) error {
+	used := make(map[string]bool)
+	var names []string
+	for _, set := range sets {
+		for _, name := range set.Datasets() {
+			if !used[name] {
+				used[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		d := r.dataset(name)
+		if d == nil {
+			return fmt.Errorf("rules look up dataset %q, which is not in the references of the migration", name)
+		}
+		if d.stale() {
+			if err := d.load(ctx); err != nil {
+				return err
+			}
+		}
+		if d.Refresh > 0 {
+			go d.refresh(ctx)
+		}
+	}
+	return nil
+}
+
+// dataset is a reference dataset and the indexes built on its fields.
+type dataset struct {
+	Reference
+	loading sync.Mutex // Held while the dataset loads
+
+	mu      sync.RWMutex
+	records []*interfaces.Record
+	indexes map[string]map[string]*interfaces.Record // Key field -> key text -> first record holding it
+	loaded  time.Time
+}
+
+// stale reports whether the dataset has never been loaded or is due for a
+// refresh.
+func (d *dataset) stale() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.loaded.IsZero() || d.Refresh > 0 && time.Since(d.loaded) >= d.Refresh
+}
+
+// load reads every record of the dataset from its source and replaces the
+// records held so far.
+func (d *dataset) load(ctx context.Context) error {
+	d.loading.Lock()
+	defer d.loading.Unlock()
+
+	// Reading a reference is not part of the migration's progress
+	ctx, cancel := context.WithCancel(checkpoint.WithTracker(ctx, nil))
+	defer cancel()
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		fetchErr <- d.Source.FetchData(ctx, d.Request, records)
+	}()
+	var loaded []*interfaces.Record
+	for record := range records {
+		loaded = append(loaded, record)
+	}
+	if err := <-fetchErr; err != nil {
+		return fmt.Errorf("failed to load reference %s: %w", d.Name, err)
+	}
+
+	d.mu.Lock()
+	d.records, d.indexes, d.loaded = loaded, make(map[string]map[string]*interfaces.Record), time.Now()
+	d.mu.Unlock()
+	logger.Infof("Loaded %d records of reference %s", len(loaded), d.Name)
+	return nil
+}
+
+// refresh reloads the dataset every Refresh until ctx is done. A failed
+// reload is logged, and the records loaded before are kept until the next
+// one.
+func (d *dataset) refresh(ctx context.Context) {
+	ticker := time.NewTicker(d.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !d.stale() {
+				continue // Another migration reloaded it
+			}
+			if err := d.load(ctx); err != nil && ctx.Err() == nil {
+				logger.Warnf("%v, still using the records loaded before", err)
+			}
+		}
+	}
+}
+
+// index returns the records of the dataset by the text of their field key,
+// building the index on first use.
+func (d *dataset) index(key string) map[string]*interfaces.Record {
+	d.mu.RLock()
+	index, ok := d.indexes[key]
+	d.mu.RUnlock()
+	if ok {
+		return index
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if index, ok := d.indexes[key]; ok {
+		return index
+	}
+	index = make(map[string]*interfaces.Record, len(d.records))
+	for _, record := range d.records {
+		value, exists := record.Get(key)
+		if !exists || value == nil {
+			continue
+		}
+		if text := keyText(value); index[text] == nil {
+			index[text] = record
+		}
+	}
+	if d.indexes != nil {
+		d.indexes[key] = index
+	}
+	return index
+}
+
+// keyText is the text of a lookup key, without surrounding blanks.
+func keyText(value interface{}) string {
+	return strings.TrimSpace(interfaces.ValueString(value))
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// BufferSize is the number of records that may be in flight between a source
+// and a destination. It bounds the memory used by a migration regardless of
+// the size of the input.
+const BufferSize = 100
+
+// Run streams every record produced by source into destination. The source
+// and the destination run concurrently and are connected by a bounded
+// channel, so a migration holds at most BufferSize records in memory.
+//
+// Cancelling ctx stops both sides. If the destination fails, the source is
+// cancelled too instead of being left to read input nobody will write.
+//
+// When ctx carries a checkpoint tracker, the position the destination has
+// acknowledged is saved as the migration runs and once more when it ends.
+//
+// The validation and transformation rules of req are applied to every record
+// between the source and the destination, in the order they are written.
+// Records dropped by DROP_IF and KEEP_IF rules are counted and logged when
+// the migration ends, like the duplicates the dedup of req drops after the
+// rules. LOOKUP reads the reference datasets ctx carries, see
+// WithReferences. Before any rule, the records of source are checked
+// against the contract of req, when it has one.
+func Run(parent context.Context, source interfaces.DataSource, destination interfaces.DataDestination, req interfaces.Request) error {
+	rules, err := parseRules(req.ValidationRules, req.TransformationRules)
+	if err != nil {
+		return err
+	}
+	rules.drops = &drops{}
+	rules.references = referencesFrom(parent)
+	if source, err = openContract(req, source); err != nil {
+		return err
+	}
+	source = rules.source(source)
+	dedup, source, err := openDedup(req, source, rules.drops)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	if err := rules.references.start(ctx, rules.validation, rules.transformation); err != nil {
+		return err
+	}
+	checkpoint.Start(ctx, 1)
+
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+
+	go func() {
+		defer close(records)
+		fetchErr <- source.FetchData(ctx, req, records)
+	}()
+
+	destCtx := checkpoint.ForDestination(ctx, 0)
+	sendErr := destination.SendData(destCtx, records, req)
+	if sendErr == nil {
+		checkpoint.Complete(destCtx)
+	} else {
+		cancel()
+		// Keep draining so a source that ignores ctx is not left blocked on a full channel.
+		for range records {
+		}
+	}
+	err = <-fetchErr
+	saveErr := checkpoint.Finish(ctx)
+
+	if parent.Err() != nil {
+		return fmt.Errorf("migration from %s to %s stopped: %w", req.Input, req.Output, parent.Err())
+	}
+	// A source stopped by the cancellation above reports the destination's error
+	if sendErr != nil && (err == nil || errors.Is(err, context.Canceled)) {
+		return fmt.Errorf("failed to send data to destination: %w", sendErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch data from source: %w", err)
+	}
+	if sendErr != nil {
+		return fmt.Errorf("failed to send data to destination: %w", sendErr)
+	}
+	if saveErr != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", saveErr)
+	}
+	if err := dedup.save(); err != nil {
+		return fmt.Errorf("failed to save dedup keys: %w", err)
+	}
+
+	rules.drops.result()
+	logger.Infof("Stream from %s to %s completed", req.Input, req.Output)
+	return nil
+}
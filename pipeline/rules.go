@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/logger"
+)
+
+// rules are the parsed validation and transformation rules of a migration.
+// Either may be nil. Records removed by DROP_IF and KEEP_IF rules are
+// counted in drops, when set, with the rule described as in scope. LOOKUP
+// reads the datasets of references.
+type rules struct {
+	validation     *language.RuleSet
+	transformation *language.RuleSet
+	drops          *drops
+	scope          string // e.g. " of output archive"
+	references     *References
+}
+
+// parseRules parses validation and transformation rules, so a typo fails the
+// migration before any integration is started.
+func parseRules(validation, transformation string) (rules, error) {
+	var r rules
+	var err error
+	if r.validation, err = language.CompileValidation(validation); err != nil {
+		return rules{}, fmt.Errorf("failed to parse validation rules: %w", err)
+	}
+	if r.transformation, err = language.CompileTransformation(transformation); err != nil {
+		return rules{}, fmt.Errorf("failed to parse transformation rules: %w", err)
+	}
+	return r, nil
+}
+
+func (r rules) empty() bool {
+	return r.validation == nil && r.transformation == nil
+}
+
+// apply validates record, then transforms it. It returns a nil record when
+// a filter rule drops it.
+func (r rules) apply(record *interfaces.Record) (*interfaces.Record, error) {
+	if r.validation != nil {
+		if err := language.ValidateWith(record, r.validation, r.references); err != nil {
+			if r.dropped(err, language.Validation) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("record failed validation: %w", err)
+		}
+	}
+	if r.transformation != nil {
+		transformed, err := language.TransformWith(record, r.transformation, r.references)
+		if err != nil {
+			if r.dropped(err, language.Transformation) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to transform record: %w", err)
+		}
+		record = transformed
+	}
+	return record, nil
+}
+
+// dropped reports whether err is a filter rule dropping a record of rules
+// of the given kind, and counts the drop.
+func (r rules) dropped(err error, kind string) bool {
+	var drop *language.DropError
+	if !errors.As(err, &drop) {
+		return false
+	}
+	r.drops.add(fmt.Sprintf("%s on line %d of the %s rules%s", drop.Rule.Keyword(), drop.Rule.At.Line, kind, r.scope))
+	return true
+}
+
+// Drop is the number of records a DROP_IF or KEEP_IF rule, or a dedup,
+// removed.
+type Drop struct {
+	Rule    string `json:"rule"` // e.g. DROP_IF on line 2 of the validation rules, or dedup on order_id
+	Records int64  `json:"records"`
+}
+
+// drops counts the records dropped by each filter rule and dedup of a
+// migration, in the order they first dropped one. A nil *drops counts nothing.
+type drops struct {
+	mu    sync.Mutex
+	rules []Drop
+}
+
+func (d *drops) add(rule string) {
+	d.count(rule, 1)
+}
+
+// count adds n records dropped by rule. Nothing is counted when n is zero.
+func (d *drops) count(rule string, n int64) {
+	if d == nil || n == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.rules {
+		if d.rules[i].Rule == rule {
+			d.rules[i].Records += n
+			return
+		}
+	}
+	d.rules = append(d.rules, Drop{Rule: rule, Records: n})
+}
+
+// result returns the counts and logs them.
+func (d *drops) result() []Drop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, drop := range d.rules {
+		logger.Infof("%s dropped %d records", drop.Rule, drop.Records)
+	}
+	return append([]Drop(nil), d.rules...)
+}
+
+// source returns a source that applies the rules to every record of source.
+func (r rules) source(source interfaces.DataSource) interfaces.DataSource {
+	if r.empty() {
+		return source
+	}
+	return ruleSource{source: source, rules: r}
+}
+
+// destination returns a destination that applies the rules to every record
+// before destination writes it.
+func (r rules) destination(destination interfaces.DataDestination) interfaces.DataDestination {
+	if r.empty() {
+		return destination
+	}
+	return ruleDestination{destination: destination, rules: r}
+}
+
+// ruleSource applies rules to the records of a source in the order they are
+// read. A record that fails validation fails the migration. Records dropped
+// by filter rules are reported to the checkpoint tracker, so positions stay
+// aligned with the records the destinations acknowledge.
+type ruleSource struct {
+	source interfaces.DataSource
+	rules  rules
+}
+
+func (s ruleSource) FetchData(parent context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	records := make(chan *interfaces.Record, BufferSize)
+	fetchErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		fetchErr <- s.source.FetchData(ctx, req, records)
+	}()
+
+	var err error
+	var passed int64
+	for record := range records {
+		if err != nil {
+			continue // Drain the source so it can exit
+		}
+		if record, err = s.rules.apply(record); err == nil {
+			if record == nil {
+				checkpoint.Skip(ctx, passed)
+				continue
+			}
+			err = interfaces.Send(ctx, out, record)
+			passed++
+		}
+		if err != nil {
+			cancel()
+		}
+	}
+	if sourceErr := <-fetchErr; err == nil {
+		err = sourceErr
+	}
+	return err
+}
+
+// ruleDestination applies rules to each record before its destination
+// receives it.
+type ruleDestination struct {
+	destination interfaces.DataDestination
+	rules       rules
+}
+
+func (d ruleDestination) SendData(parent context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	records := make(chan *interfaces.Record, BufferSize)
+	sendErr := make(chan error, 1)
+	go func() {
+		err := d.destination.SendData(ctx, records, req)
+		if err != nil {
+			// Stop feeding a destination that gave up
+			cancel()
+		}
+		sendErr <- err
+	}()
+
+	err := d.forward(ctx, in, records)
+	if err != nil {
+		cancel()
+	}
+	close(records)
+	// A destination stopped by the cancellation above reports the rule's error
+	if destErr := <-sendErr; destErr != nil && (err == nil || errors.Is(err, context.Canceled)) {
+		return destErr
+	}
+	return err
+}
+
+// forward transforms the records of in and sends them to out until in is
+// closed.
+func (d ruleDestination) forward(ctx context.Context, in <-chan *interfaces.Record, out chan<- *interfaces.Record) error {
+	var passed int64
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil || record == nil {
+			return err
+		}
+		if record, err = d.rules.apply(record); err != nil {
+			return err
+		}
+		if record == nil {
+			checkpoint.Skip(ctx, passed)
+			continue
+		}
+		if err := interfaces.Send(ctx, out, record); err != nil {
+			return err
+		}
+		passed++
+	}
+}
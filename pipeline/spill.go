@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// spillPartitions is the number of files a spilled join is split into. Each
+// partition of a lookup input must fit in memory when it is joined.
+const spillPartitions = 64
+
+func init() {
+	// Record values are stored in interface fields, so gob needs their types
+	gob.Register(interfaces.Decimal{})
+	gob.Register(&interfaces.Record{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+	gob.Register([]byte{})
+}
+
+// spill stores records in temporary files, partitioned by the hash of a key
+// so that records with the same key always land in the same partition.
+type spill struct {
+	dir      string
+	files    []*os.File
+	writers  []*bufio.Writer
+	encoders []*gob.Encoder
+}
+
+func newSpill(partitions int) (*spill, error) {
+	dir, err := os.MkdirTemp("", "fractal-join-")
+	if err != nil {
+		return nil, err
+	}
+	s := &spill{dir: dir}
+	for p := 0; p < partitions; p++ {
+		file, err := os.Create(filepath.Join(dir, strconv.Itoa(p)))
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		writer := bufio.NewWriter(file)
+		s.files = append(s.files, file)
+		s.writers = append(s.writers, writer)
+		s.encoders = append(s.encoders, gob.NewEncoder(writer))
+	}
+	return s, nil
+}
+
+// write appends record to the partition of key.
+func (s *spill) write(key string, record *interfaces.Record) error {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return s.encoders[int(hash.Sum32())%len(s.files)].Encode(record)
+}
+
+// each calls fn with every record of partition p, in the order they were written.
+func (s *spill) each(p int, fn func(*interfaces.Record) error) error {
+	if err := s.writers[p].Flush(); err != nil {
+		return err
+	}
+	file, err := os.Open(s.files[p].Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(bufio.NewReader(file))
+	for {
+		record := &interfaces.Record{}
+		if err := decoder.Decode(record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// load reads partition p into a hash table keyed by the key field.
+func (s *spill) load(p int, key string) (map[string][]*interfaces.Record, error) {
+	table := make(map[string][]*interfaces.Record)
+	err := s.each(p, func(record *interfaces.Record) error {
+		k := joinKey(record, key)
+		table[k] = append(table[k], record)
+		return nil
+	})
+	return table, err
+}
+
+// close removes the partition files.
+func (s *spill) close() {
+	for _, file := range s.files {
+		file.Close()
+	}
+	os.RemoveAll(s.dir)
+}
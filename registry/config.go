@@ -0,0 +1,378 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces the value of secret fields in logs and API responses.
+const redactedValue = "******"
+
+// ConfigField describes one configuration option of an integration, as
+// declared by the tags on its struct field:
+//
+//	json:"name"          the key used in config.yaml and request bodies
+//	validate:"required"  the option must be set
+//	default:"value"      the value used when the option is not set
+//	secret:"true"        the value is masked in logs and prompts
+//	description:"text"   what the option controls
+//	example:"value"      a sample value shown in prompts and API docs
+type ConfigField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default,omitempty"`
+	Secret      bool   `json:"secret,omitempty"`
+	Description string `json:"description,omitempty"`
+	Example     string `json:"example,omitempty"`
+
+	index []int
+}
+
+// ConfigFields lists the configuration options declared by an integration.
+func ConfigFields(integration interface{}) []ConfigField {
+	t := reflect.TypeOf(integration)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []ConfigField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, ConfigField{
+			Name:        name,
+			Type:        typeName(field.Type),
+			Required:    hasTagOption(field.Tag.Get("validate"), "required"),
+			Default:     field.Tag.Get("default"),
+			Secret:      field.Tag.Get("secret") == "true",
+			Description: field.Tag.Get("description"),
+			Example:     field.Tag.Get("example"),
+			index:       field.Index,
+		})
+	}
+	return fields
+}
+
+// Decode builds a new instance of the same type as prototype from a
+// configuration map. Keys are matched case-insensitively and without
+// underscores, so "queue_name", "queueName" and "queuename" are equivalent.
+// Defaults are applied to missing options and every missing required option
+// is reported in a single error.
+func Decode(prototype interface{}, config map[string]interface{}) (interface{}, error) {
+	t := reflect.TypeOf(prototype)
+	if t == nil {
+		return nil, errors.New("integration is nil")
+	}
+	isPtr := t.Kind() == reflect.Ptr
+	if isPtr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("integration %s is not a struct", t)
+	}
+
+	values := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		values[normalizeKey(key)] = value
+	}
+
+	instance := reflect.New(t).Elem()
+	var missing []string
+	for _, field := range ConfigFields(prototype) {
+		target := instance.FieldByIndex(field.index)
+		value, ok := values[normalizeKey(field.Name)]
+		if !ok || value == nil || value == "" {
+			if field.Default == "" {
+				if field.Required {
+					missing = append(missing, field.Name)
+				}
+				continue
+			}
+			value = field.Default
+		}
+		if err := assign(target, value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", field.Name, err)
+		}
+		if field.Required && target.IsZero() {
+			missing = append(missing, field.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required config: %s", strings.Join(missing, ", "))
+	}
+
+	if isPtr {
+		return instance.Addr().Interface(), nil
+	}
+	return instance.Interface(), nil
+}
+
+// Redact returns the configuration of an integration as a map, with the
+// values of secret fields masked so it can be logged safely.
+func Redact(integration interface{}) map[string]interface{} {
+	v := reflect.ValueOf(integration)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	redacted := make(map[string]interface{})
+	for _, field := range ConfigFields(integration) {
+		value := v.FieldByIndex(field.index)
+		if field.Secret && !value.IsZero() {
+			redacted[field.Name] = redactedValue
+			continue
+		}
+		redacted[field.Name] = value.Interface()
+	}
+	return redacted
+}
+
+// RedactConfig masks the secret options of a raw configuration map, using the
+// fields declared by integration to decide which options are secret.
+func RedactConfig(integration interface{}, config map[string]interface{}) map[string]interface{} {
+	secrets := make(map[string]bool)
+	for _, field := range ConfigFields(integration) {
+		if field.Secret {
+			secrets[normalizeKey(field.Name)] = true
+		}
+	}
+	redacted := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		if secrets[normalizeKey(key)] {
+			value = redactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", ""))
+}
+
+func hasTagOption(tag, option string) bool {
+	for _, part := range strings.Split(tag, ",") {
+		if strings.TrimSpace(part) == option {
+			return true
+		}
+	}
+	return false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func typeName(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return "duration"
+	case t.Kind() == reflect.Slice:
+		return "list of " + typeName(t.Elem())
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return "integer"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "number"
+	default:
+		return t.Kind().String()
+	}
+}
+
+// assign converts a configuration value to the type of target. Values read
+// from YAML or JSON may arrive as strings, numbers or lists.
+func assign(target reflect.Value, value interface{}) error {
+	if target.Type() == durationType {
+		switch v := value.(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			target.SetInt(int64(d))
+			return nil
+		case int, int64, float64:
+			// Plain numbers are seconds
+			target.SetInt(int64(toFloat(v) * float64(time.Second)))
+			return nil
+		}
+		return fmt.Errorf("expected a duration, got %T", value)
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(text(value))
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			target.SetBool(v)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			target.SetBool(b)
+		default:
+			return fmt.Errorf("expected a boolean, got %T", value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := integer(value)
+		if err != nil {
+			return err
+		}
+		if !n.IsInt64() || target.OverflowInt(n.Int64()) {
+			return fmt.Errorf("%s is out of range", n)
+		}
+		target.SetInt(n.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := integer(value)
+		if err != nil {
+			return err
+		}
+		if !n.IsUint64() || target.OverflowUint(n.Uint64()) {
+			return fmt.Errorf("%s is out of range", n)
+		}
+		target.SetUint(n.Uint64())
+	case reflect.Float32, reflect.Float64:
+		f, err := number(value)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	case reflect.Slice:
+		var items []interface{}
+		switch v := value.(type) {
+		case []interface{}:
+			items = v
+		case []string:
+			for _, item := range v {
+				items = append(items, item)
+			}
+		case string:
+			// Comma separated lists are accepted for convenience
+			for _, item := range strings.Split(v, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+		default:
+			return fmt.Errorf("expected a list, got %T", value)
+		}
+		slice := reflect.MakeSlice(target.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := assign(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Map, reflect.Interface:
+		v := reflect.ValueOf(value)
+		if !v.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("expected %s, got %T", target.Type(), value)
+		}
+		target.Set(v)
+	default:
+		return fmt.Errorf("unsupported config type %s", target.Type())
+	}
+	return nil
+}
+
+// text returns a string or a number as text. Floats are written without an
+// exponent, since JSON request bodies decode every number, such as 1000000,
+// to a float64.
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// integer converts a whole number, or a string holding one, to an integer.
+func integer(value interface{}) (*big.Int, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) || f != math.Trunc(f) {
+			return nil, fmt.Errorf("expected an integer, got %s", text(value))
+		}
+		n, _ := big.NewFloat(f).Int(nil)
+		return n, nil
+	}
+	if s, ok := value.(json.Number); ok {
+		value = s.String()
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected an integer, got %T", value)
+	}
+	n, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok {
+		return nil, fmt.Errorf("expected an integer, got %q", s)
+	}
+	return n, nil
+}
+
+// number converts a number, or a string holding one, to a float64.
+func number(value interface{}) (float64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	if s, ok := value.(json.Number); ok {
+		value = s.String()
+	}
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"strings"
+)
+
+// Capability is a feature an integration supports.
+type Capability string
+
+const (
+	// CapabilityBatch reads or writes a bounded data set and then finishes.
+	CapabilityBatch Capability = "batch"
+	// CapabilityStreaming consumes or publishes an unbounded stream of messages.
+	CapabilityStreaming Capability = "streaming"
+	// CapabilitySchemaAware attaches field types to records, or uses them when writing.
+	CapabilitySchemaAware Capability = "schema-aware"
+	// CapabilityUpsert replaces existing entries with the same key instead of duplicating them.
+	CapabilityUpsert Capability = "upsert"
+)
+
+// Info describes an integration to people choosing one: in the interactive
+// setup, the GET /integrations endpoint and the OpenAPI document.
+type Info struct {
+	DisplayName  string
+	Description  string
+	Version      string
+	Capabilities []Capability
+}
+
+// Metadata is the full description of a registered source or destination.
+type Metadata struct {
+	Name         string        `json:"name"`
+	Aliases      []string      `json:"aliases,omitempty"`
+	Kind         string        `json:"kind"` // "source" or "destination"
+	DisplayName  string        `json:"display_name"`
+	Description  string        `json:"description,omitempty"`
+	Version      string        `json:"version,omitempty"`
+	Capabilities []Capability  `json:"capabilities"`
+	Config       []ConfigField `json:"config"`
+}
+
+// Has reports whether the integration supports capability.
+func (m Metadata) Has(capability Capability) bool {
+	for _, c := range m.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe records the description of the integration registered under name.
+// It applies to both the source and the destination of that name.
+func Describe(name string, info Info) {
+	mu.Lock()
+	defer mu.Unlock()
+	infos[resolve(name)] = info
+}
+
+// SourceMetadata describes the source registered under name.
+func SourceMetadata(name string) (Metadata, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	source, exists := dataSources[resolve(name)]
+	if !exists {
+		return Metadata{}, false
+	}
+	return newMetadata("source", source), true
+}
+
+// DestinationMetadata describes the destination registered under name.
+func DestinationMetadata(name string) (Metadata, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	destination, exists := dataDestinations[resolve(name)]
+	if !exists {
+		return Metadata{}, false
+	}
+	return newMetadata("destination", destination), true
+}
+
+// Sources describes every registered source, sorted by name.
+func Sources() []Metadata {
+	mu.RLock()
+	defer mu.RUnlock()
+	return describeAll("source", dataSources)
+}
+
+// Destinations describes every registered destination, sorted by name.
+func Destinations() []Metadata {
+	mu.RLock()
+	defer mu.RUnlock()
+	return describeAll("destination", dataDestinations)
+}
+
+func describeAll(kind string, entries map[string]entry) []Metadata {
+	list := make([]Metadata, 0, len(entries))
+	for _, name := range sortedNames(entries) {
+		list = append(list, newMetadata(kind, entries[strings.ToLower(name)]))
+	}
+	return list
+}
+
+func newMetadata(kind string, e entry) Metadata {
+	key := strings.ToLower(e.name)
+	info := infos[key]
+	metadata := Metadata{
+		Name:         e.name,
+		Aliases:      aliasesOf(key),
+		Kind:         kind,
+		DisplayName:  info.DisplayName,
+		Description:  info.Description,
+		Version:      info.Version,
+		Capabilities: info.Capabilities,
+		Config:       ConfigFields(e.prototype),
+	}
+	if metadata.DisplayName == "" {
+		metadata.DisplayName = e.name
+	}
+	if metadata.Capabilities == nil {
+		metadata.Capabilities = []Capability{}
+	}
+	if metadata.Config == nil {
+		metadata.Config = []ConfigField{}
+	}
+	return metadata
+}
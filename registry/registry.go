@@ -1,36 +1,264 @@
 package registry
 
-import "github.com/SkySingh04/fractal/interfaces"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/SkySingh04/fractal/interfaces"
+)
+
+// ErrDuplicate is returned when a name or alias is already registered.
+var ErrDuplicate = errors.New("already registered")
+
+// entry is a registered integration. Names are matched case-insensitively,
+// so entries are keyed by their lowercased name and keep the original
+// spelling for display.
+type entry struct {
+	name      string
+	prototype interface{}
+}
 
 var (
-	dataSources      = make(map[string]interfaces.DataSource)
-	dataDestinations = make(map[string]interfaces.DataDestination)
+	mu               sync.RWMutex
+	dataSources      = make(map[string]entry)
+	dataDestinations = make(map[string]entry)
+	aliases          = make(map[string]string) // lowercased alias -> lowercased name
+	infos            = make(map[string]Info)   // lowercased name -> description
 )
 
+// RegisterSource registers a source under name. The registered value is a
+// prototype: its type declares the configuration the source accepts, and
+// NewSource creates a configured copy for every migration. It panics if a
+// source with the same name, ignoring case, is already registered.
 func RegisterSource(name string, source interfaces.DataSource) {
-	dataSources[name] = source
+	if err := AddSource(name, source); err != nil {
+		panic(err)
+	}
 }
 
+// RegisterDestination registers a destination prototype under name. It
+// panics if a destination with the same name is already registered.
 func RegisterDestination(name string, destination interfaces.DataDestination) {
-	dataDestinations[name] = destination
+	if err := AddDestination(name, destination); err != nil {
+		panic(err)
+	}
+}
+
+// AddSource registers a source like RegisterSource, but reports a duplicate
+// name as an error wrapping ErrDuplicate. Use it when registering sources at
+// runtime.
+func AddSource(name string, source interfaces.DataSource) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return add(dataSources, "source", name, source)
+}
+
+// AddDestination registers a destination like RegisterDestination, but
+// reports a duplicate name as an error wrapping ErrDuplicate.
+func AddDestination(name string, destination interfaces.DataDestination) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return add(dataDestinations, "destination", name, destination)
+}
+
+func add(entries map[string]entry, kind, name string, prototype interface{}) error {
+	if name == "" {
+		return fmt.Errorf("%s name is empty", kind)
+	}
+	if prototype == nil {
+		return fmt.Errorf("%s %s is nil", kind, name)
+	}
+	key := strings.ToLower(name)
+	if existing, exists := entries[key]; exists {
+		return fmt.Errorf("%s %s: %w as %s", kind, name, ErrDuplicate, existing.name)
+	}
+	if target, exists := aliases[key]; exists {
+		return fmt.Errorf("%s %s: %w as an alias of %s", kind, name, ErrDuplicate, target)
+	}
+	entries[key] = entry{name: name, prototype: prototype}
+	return nil
+}
+
+// UnregisterSource removes the source registered under name and reports
+// whether it was registered. Aliases of name are kept, since they may still
+// resolve to a destination.
+func UnregisterSource(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return remove(dataSources, name)
+}
+
+// UnregisterDestination removes the destination registered under name and
+// reports whether it was registered.
+func UnregisterDestination(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return remove(dataDestinations, name)
+}
+
+// remove deletes the entry registered under name, and its description once
+// neither a source nor a destination is left under the name.
+func remove(entries map[string]entry, name string) bool {
+	key := resolve(name)
+	if _, exists := entries[key]; !exists {
+		return false
+	}
+	delete(entries, key)
+	_, source := dataSources[key]
+	_, destination := dataDestinations[key]
+	if !source && !destination {
+		delete(infos, key)
+	}
+	return true
+}
+
+// RegisterAlias makes alias another name for the source and destination
+// registered under name, such as "postgres" for "PostgreSQL". Like names,
+// aliases are matched case-insensitively. It panics if alias is taken.
+func RegisterAlias(alias, name string) {
+	if err := AddAlias(alias, name); err != nil {
+		panic(err)
+	}
+}
+
+// AddAlias registers an alias like RegisterAlias, but reports a taken alias
+// as an error wrapping ErrDuplicate.
+func AddAlias(alias, name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := strings.ToLower(alias)
+	if target, exists := aliases[key]; exists {
+		return fmt.Errorf("alias %s: %w for %s", alias, ErrDuplicate, target)
+	}
+	_, isSource := dataSources[key]
+	_, isDestination := dataDestinations[key]
+	if isSource || isDestination {
+		return fmt.Errorf("alias %s: %w as an integration", alias, ErrDuplicate)
+	}
+	aliases[key] = resolve(name)
+	return nil
+}
+
+// resolve returns the registry key of name, following aliases.
+func resolve(name string) string {
+	key := strings.ToLower(name)
+	if target, exists := aliases[key]; exists {
+		return target
+	}
+	return key
 }
 
 func GetSource(name string) (interfaces.DataSource, bool) {
-	source, exists := dataSources[name]
-	return source, exists
+	mu.RLock()
+	defer mu.RUnlock()
+	source, exists := dataSources[resolve(name)]
+	if !exists {
+		return nil, false
+	}
+	return source.prototype.(interfaces.DataSource), true
 }
 
 func GetDestination(name string) (interfaces.DataDestination, bool) {
-	destination, exists := dataDestinations[name]
-	return destination, exists
+	mu.RLock()
+	defer mu.RUnlock()
+	destination, exists := dataDestinations[resolve(name)]
+	if !exists {
+		return nil, false
+	}
+	return destination.prototype.(interfaces.DataDestination), true
+}
+
+// NewSource creates the source registered under name, configured from config.
+func NewSource(name string, config map[string]interface{}) (interfaces.DataSource, error) {
+	prototype, exists := GetSource(name)
+	if !exists {
+		return nil, fmt.Errorf("source %s not found", name)
+	}
+	instance, err := Decode(prototype, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s source config: %w", name, err)
+	}
+	return instance.(interfaces.DataSource), nil
+}
+
+// NewDestination creates the destination registered under name, configured
+// from config.
+func NewDestination(name string, config map[string]interface{}) (interfaces.DataDestination, error) {
+	prototype, exists := GetDestination(name)
+	if !exists {
+		return nil, fmt.Errorf("destination %s not found", name)
+	}
+	instance, err := Decode(prototype, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s destination config: %w", name, err)
+	}
+	return instance.(interfaces.DataDestination), nil
 }
 
-// GetSources returns all registered data sources
+// GetSources returns a snapshot of the registered data sources, keyed by
+// their registered names.
 func GetSources() map[string]interfaces.DataSource {
-	return dataSources
+	mu.RLock()
+	defer mu.RUnlock()
+	sources := make(map[string]interfaces.DataSource, len(dataSources))
+	for _, source := range dataSources {
+		sources[source.name] = source.prototype.(interfaces.DataSource)
+	}
+	return sources
 }
 
-// GetDestinations returns all registered data destinations
+// GetDestinations returns a snapshot of the registered data destinations,
+// keyed by their registered names.
 func GetDestinations() map[string]interfaces.DataDestination {
-	return dataDestinations
+	mu.RLock()
+	defer mu.RUnlock()
+	destinations := make(map[string]interfaces.DataDestination, len(dataDestinations))
+	for _, destination := range dataDestinations {
+		destinations[destination.name] = destination.prototype.(interfaces.DataDestination)
+	}
+	return destinations
+}
+
+// SourceNames returns the registered source names in sorted order.
+func SourceNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sortedNames(dataSources)
+}
+
+// DestinationNames returns the registered destination names in sorted order.
+func DestinationNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sortedNames(dataDestinations)
+}
+
+// aliasesOf returns the aliases of the registry key, in sorted order.
+func aliasesOf(key string) []string {
+	var names []string
+	for alias, target := range aliases {
+		if target == key {
+			names = append(names, alias)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedNames sorts case-insensitively, the same way names are matched.
+func sortedNames(entries map[string]entry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = entries[key].name
+	}
+	return names
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/SkySingh04/fractal/config"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/ruletest"
+)
+
+const rulesUsage = "usage: fractal rules check [config.yaml] | fractal rules fmt [config.yaml] | fractal rules test [rules_test.yaml ...]"
+
+// runRules runs a subcommand working on rules without starting a migration,
+// and returns the exit code of the process.
+func runRules(args []string, out io.Writer) int {
+	if len(args) > 0 && args[0] == "test" {
+		files := args[1:]
+		if len(files) == 0 {
+			files = []string{"rules_test.yaml"}
+		}
+		return testRules(files, out)
+	}
+	if len(args) == 0 || len(args) > 2 || args[0] != "check" && args[0] != "fmt" {
+		fmt.Fprintln(out, rulesUsage)
+		return 2
+	}
+	file := "config.yaml"
+	if len(args) == 2 {
+		file = args[1]
+	}
+	if args[0] == "fmt" {
+		return formatRules(file, out)
+	}
+	configuration, err := config.LoadConfig(file)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", file, err)
+		return 2
+	}
+	texts, err := configRules(configuration)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", file, err)
+		return 2
+	}
+	return checkRules(file, texts, out)
+}
+
+// ruleText is one of the rule texts of a configuration.
+type ruleText struct {
+	source string // Where the rules are set, such as outputs[archive].transformations
+	kind   string // language.Validation or language.Transformation
+	rules  string
+}
+
+// configRules returns every rule text of a configuration: the validations
+// and transformations, the transformations of each output, and the rules of
+// the filters, transforms, routers and aggregates of a pipeline.
+func configRules(configuration map[string]interface{}) ([]ruleText, error) {
+	texts := []ruleText{
+		{source: "validations", kind: language.Validation, rules: getStringField(configuration, "validations", "")},
+		{source: "transformations", kind: language.Transformation, rules: getStringField(configuration, "transformations", "")},
+	}
+	for i, output := range getOutputs(configuration) {
+		name := output.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		texts = append(texts, ruleText{source: fmt.Sprintf("outputs[%s].transformations", name), kind: language.Transformation, rules: output.TransformationRules})
+	}
+
+	stages, err := getPipeline(configuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'pipeline': %w", err)
+	}
+	for _, stage := range stages.Stages {
+		source := fmt.Sprintf("pipeline.stages[%s]", stage.Name)
+		switch stage.Type {
+		case interfaces.StageFilter:
+			texts = append(texts, ruleText{source: source + ".rules", kind: language.Validation, rules: stage.Rules})
+		case interfaces.StageTransform:
+			texts = append(texts, ruleText{source: source + ".rules", kind: language.Transformation, rules: stage.Rules})
+		case interfaces.StageAggregate:
+			texts = append(texts, ruleText{source: source + ".rules", kind: language.Aggregation, rules: stage.Rules})
+		case interfaces.StageRouter:
+			for _, route := range stage.Routes {
+				texts = append(texts, ruleText{source: fmt.Sprintf("%s.routes[%s].when", source, route.Name), kind: language.Validation, rules: route.When})
+			}
+		}
+	}
+	return texts, nil
+}
+
+// checkRules prints every error in texts, one per line and prefixed with the
+// file and the setting holding it, and returns 1 when there are errors.
+func checkRules(file string, texts []ruleText, out io.Writer) int {
+	var checked, failed int
+	for _, text := range texts {
+		if text.rules == "" {
+			continue
+		}
+		checked++
+		for _, err := range language.Diagnose(text.rules, text.kind) {
+			fmt.Fprintf(out, "%s: %s: %v\n", file, text.source, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(out, "%s: %d errors in %d rule texts\n", file, failed, checked)
+		return 1
+	}
+	fmt.Fprintf(out, "%s: %d rule texts are valid\n", file, checked)
+	return 0
+}
+
+// formatRules rewrites the rules of a configuration file in place with
+// their canonical text, at the places configRules reads them. It
+// returns 1 when a rule is invalid and 2 when the file cannot be read or
+// written.
+func formatRules(file string, out io.Writer) int {
+	info, err := os.Stat(file)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", file, err)
+		return 2
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", file, err)
+		return 2
+	}
+	formatted, err := config.FormatRules(data)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", file, err)
+		return 1
+	}
+	if bytes.Equal(formatted, data) {
+		fmt.Fprintf(out, "%s: rules are already formatted\n", file)
+		return 0
+	}
+	if err := os.WriteFile(file, formatted, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(out, "%s: %v\n", file, err)
+		return 2
+	}
+	fmt.Fprintf(out, "%s: rules formatted\n", file)
+	return 0
+}
+
+// testRules runs the rule test suites in files, printing each failed case
+// with its diff and a summary per file. It returns 1 when a case fails and
+// 2 when a suite cannot be read.
+func testRules(files []string, out io.Writer) int {
+	code := 0
+	for _, file := range files {
+		suite, err := ruletest.Load(file)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", file, err)
+			code = 2
+			continue
+		}
+		passed := 0
+		for _, result := range suite.Run() {
+			if result.Passed() {
+				passed++
+				continue
+			}
+			fmt.Fprintf(out, "%s:%d: FAIL %s\n", file, result.Case.Line, result.Case.Name)
+			if result.Err != nil {
+				fmt.Fprintf(out, "    %v\n", result.Err)
+			}
+			for _, line := range result.Diff {
+				fmt.Fprintf(out, "    %s\n", line)
+			}
+		}
+		fmt.Fprintf(out, "%s: %d of %d cases passed\n", file, passed, len(suite.Cases))
+		if passed < len(suite.Cases) && code == 0 {
+			code = 1
+		}
+	}
+	return code
+}
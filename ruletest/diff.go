@@ -0,0 +1,229 @@
+package ruletest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+)
+
+// outcome is what the rules gave a record: dropped by a filter, failed with
+// errors, or passed with an output record.
+type outcome struct {
+	dropped *language.DropError
+	errors  []string
+	output  *interfaces.Record
+}
+
+// got returns the outcome of a record the rules left as record, with errs.
+// A record fails at its first error in a migration, so a filter dropping it
+// after a failed rule does not count.
+func got(record *interfaces.Record, errs []error) outcome {
+	var drop *language.DropError
+	if len(errs) > 0 && errors.As(errs[0], &drop) {
+		return outcome{dropped: drop}
+	}
+	if len(errs) == 0 {
+		return outcome{output: record}
+	}
+	var o outcome
+	for _, err := range errs {
+		if !errors.As(err, &drop) {
+			o.errors = append(o.errors, err.Error())
+		}
+	}
+	return o
+}
+
+// lines describes the outcome for a diff, prefixed with "+ ".
+func (o outcome) lines() []string {
+	switch {
+	case o.dropped != nil:
+		return []string{"+ " + o.dropped.Error()}
+	case o.output != nil:
+		var lines []string
+		for _, l := range leaves(o.output) {
+			lines = append(lines, "+ "+l.String())
+		}
+		return lines
+	}
+	lines := make([]string, len(o.errors))
+	for i, err := range o.errors {
+		lines[i] = "+ error: " + err
+	}
+	return lines
+}
+
+// diff compares the outcome a case expects with the actual one.
+func diff(c *Case, actual outcome) []string {
+	switch {
+	case c.Dropped:
+		if actual.dropped != nil {
+			return nil
+		}
+		return append([]string{"- dropped by a DROP_IF or KEEP_IF rule"}, actual.lines()...)
+	case len(c.Errors) > 0:
+		if actual.errors == nil {
+			var lines []string
+			for _, err := range c.Errors {
+				lines = append(lines, "- error: "+err)
+			}
+			return append(lines, actual.lines()...)
+		}
+		return errorDiff(c.Errors, actual.errors)
+	case c.Output != nil:
+		if actual.output == nil {
+			var lines []string
+			for _, l := range leaves(c.Output.Record) {
+				lines = append(lines, "- "+l.String())
+			}
+			return append(lines, actual.lines()...)
+		}
+		return recordDiff(c.Output.Record, actual.output)
+	}
+	if actual.output != nil {
+		return nil
+	}
+	return append([]string{"- no errors"}, actual.lines()...)
+}
+
+// errorDiff compares expected errors with actual ones, in order. An
+// expected error matches an actual error that contains it.
+func errorDiff(expected, actual []string) []string {
+	var lines []string
+	for i := 0; i < max(len(expected), len(actual)); i++ {
+		if i < len(expected) && i < len(actual) && strings.Contains(actual[i], expected[i]) {
+			continue
+		}
+		if i < len(expected) {
+			lines = append(lines, "- error: "+expected[i])
+		}
+		if i < len(actual) {
+			lines = append(lines, "+ error: "+actual[i])
+		}
+	}
+	return lines
+}
+
+// recordDiff compares the fields of an expected record with those of an
+// actual one, in any order. Nested fields are compared one by one, so only
+// those that differ are listed.
+func recordDiff(expected, actual *interfaces.Record) []string {
+	if expected == nil {
+		expected = interfaces.NewRecord()
+	}
+	want, have := leaves(expected), leaves(actual)
+	found := make(map[string]leaf, len(have))
+	for _, l := range have {
+		found[l.path] = l
+	}
+	var lines []string
+	seen := make(map[string]bool, len(want))
+	for _, w := range want {
+		seen[w.path] = true
+		h, ok := found[w.path]
+		if ok && equal(w.value, h.value) {
+			continue
+		}
+		lines = append(lines, "- "+w.String())
+		if ok {
+			lines = append(lines, "+ "+h.String())
+		}
+	}
+	for _, h := range have {
+		if !seen[h.path] {
+			lines = append(lines, "+ "+h.String())
+		}
+	}
+	return lines
+}
+
+// leaf is a value of a record that is not a non-empty object or array, with
+// the path to it, such as customer.tags[0].
+type leaf struct {
+	path  string
+	value interface{}
+}
+
+func (l leaf) String() string {
+	return l.path + ": " + render(l.value)
+}
+
+func leaves(record *interfaces.Record) []leaf {
+	var result []leaf
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		switch v := value.(type) {
+		case *interfaces.Record:
+			if v.Len() > 0 {
+				for _, field := range v.Fields {
+					walk(join(path, field.Name), field.Value)
+				}
+				return
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				for i, item := range v {
+					walk(fmt.Sprintf("%s[%d]", path, i), item)
+				}
+				return
+			}
+		}
+		result = append(result, leaf{path: path, value: value})
+	}
+	for _, field := range record.Fields {
+		walk(field.Name, field.Value)
+	}
+	return result
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// render writes a value like YAML would, with strings quoted so that the
+// number 1 and the text "1" differ.
+func render(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case *interfaces.Record:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return interfaces.ValueString(value)
+}
+
+// equal reports whether two values are the same. Numbers are equal when
+// their values are, whatever their types, and timestamps when they are the
+// same instant.
+func equal(a, b interface{}) bool {
+	if x, ok := rat(a); ok {
+		y, ok := rat(b)
+		return ok && x.Cmp(y) == 0
+	}
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	}
+	return interfaces.TypeOf(a) == interfaces.TypeOf(b) && interfaces.ValueString(a) == interfaces.ValueString(b)
+}
+
+func rat(value interface{}) (*big.Rat, bool) {
+	switch interfaces.TypeOf(value) {
+	case interfaces.TypeInt, interfaces.TypeFloat, interfaces.TypeDecimal:
+		return new(big.Rat).SetString(interfaces.ValueString(value))
+	}
+	return nil, false
+}
@@ -0,0 +1,82 @@
+package ruletest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"gopkg.in/yaml.v3"
+)
+
+// Record is a record written in YAML. Its values are decoded like those of
+// the YAML source, keeping the order of its fields.
+type Record struct {
+	*interfaces.Record
+}
+
+func (r *Record) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: a record is a mapping of fields to values", node.Line)
+	}
+	value, err := nodeValue(node)
+	if err != nil {
+		return err
+	}
+	r.Record = value.(*interfaces.Record)
+	return nil
+}
+
+// nodeValue converts a YAML node into canonical values.
+func nodeValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return nodeValue(node.Alias)
+	case yaml.MappingNode:
+		record := interfaces.NewRecord()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := nodeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			record.Set(node.Content[i].Value, value)
+		}
+		return record, nil
+	case yaml.SequenceNode:
+		items := make([]interface{}, len(node.Content))
+		for i, child := range node.Content {
+			item, err := nodeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		var scalar interface{}
+		if err := node.Decode(&scalar); err != nil {
+			return nil, err
+		}
+		return interfaces.Normalize(scalar), nil
+	}
+}
+
+// datasets holds the reference datasets of a suite.
+type datasets map[string][]Record
+
+// Lookup returns the value of field in the first record of dataset whose
+// field key holds value. Keys are compared by their text, as migrations
+// compare them.
+func (d datasets) Lookup(dataset, key string, value interface{}, field string) (interface{}, bool, error) {
+	records, ok := d[dataset]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown dataset %q, the suite has no such dataset", dataset)
+	}
+	text := strings.TrimSpace(interfaces.ValueString(value))
+	for _, record := range records {
+		if k, ok := record.Get(key); ok && k != nil && strings.TrimSpace(interfaces.ValueString(k)) == text {
+			result, _ := record.Get(field)
+			return result, true, nil
+		}
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,172 @@
+package ruletest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/SkySingh04/fractal/config"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"gopkg.in/yaml.v3"
+)
+
+// Suite is a YAML file of test cases for rules. A case runs the validations
+// and transformations it sets, or else those of the suite, or else those of
+// the configuration file named by Config, so the rules a migration runs can
+// be tested where they are kept.
+type Suite struct {
+	Config          string              `yaml:"config"` // Relative to the suite file
+	Validations     string              `yaml:"validations"`
+	Transformations string              `yaml:"transformations"`
+	Datasets        map[string][]Record `yaml:"datasets"` // Reference datasets read by LOOKUP
+	Cases           []Case              `yaml:"cases"`
+}
+
+// Case is an input record and the outcome the rules must give it: the
+// Output record, the Errors of the rules it fails, or being Dropped by a
+// DROP_IF or KEEP_IF rule. A case expecting none of them expects the record
+// to pass every rule, whatever its output.
+type Case struct {
+	Name            string   `yaml:"name"`
+	Validations     *string  `yaml:"validations"` // Replaces the rules of the suite, even when empty
+	Transformations *string  `yaml:"transformations"`
+	Input           Record   `yaml:"input"`
+	Output          *Record  `yaml:"output"`
+	Errors          []string `yaml:"errors"` // Each is part of the error of a failed rule, in order
+	Dropped         bool     `yaml:"dropped"`
+	Line            int      `yaml:"-"` // Line of the case in its file
+}
+
+// caseFields are the settings of a case. Decoding a case on its own drops
+// the check for unknown settings of the suite, so they are checked here.
+var caseFields = map[string]bool{
+	"name": true, "validations": true, "transformations": true,
+	"input": true, "output": true, "errors": true, "dropped": true,
+}
+
+func (c *Case) UnmarshalYAML(node *yaml.Node) error {
+	type plain Case
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if key := node.Content[i]; !caseFields[key.Value] {
+				return fmt.Errorf("line %d: unknown case setting %s, expected name, validations, transformations, input, output, errors or dropped", key.Line, key.Value)
+			}
+		}
+	}
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	c.Line = node.Line
+	return nil
+}
+
+// Load reads the suite in file. Unknown settings are errors, so a
+// misspelt expectation does not pass unnoticed.
+func Load(file string) (*Suite, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	suite := &Suite{}
+	if err := decoder.Decode(suite); err != nil {
+		return nil, err
+	}
+	if len(suite.Cases) == 0 {
+		return nil, errors.New("the suite has no cases")
+	}
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case %d", i+1)
+		}
+		expected := 0
+		for _, set := range []bool{c.Output != nil, len(c.Errors) > 0, c.Dropped} {
+			if set {
+				expected++
+			}
+		}
+		if expected > 1 {
+			return nil, fmt.Errorf("line %d: %s expects several outcomes, give one of output, errors and dropped", c.Line, c.Name)
+		}
+	}
+	if suite.Config != "" {
+		path := suite.Config
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+		configuration, err := config.LoadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", suite.Config, err)
+		}
+		if suite.Validations == "" {
+			suite.Validations, _ = configuration["validations"].(string)
+		}
+		if suite.Transformations == "" {
+			suite.Transformations, _ = configuration["transformations"].(string)
+		}
+	}
+	return suite, nil
+}
+
+// Result is the outcome of a case.
+type Result struct {
+	Case *Case
+	// Diff lists what the case expected, prefixed with "- ", and what the
+	// rules gave instead, prefixed with "+ ". It is empty when they agree.
+	Diff []string
+	Err  error // Rules that do not parse
+}
+
+// Passed reports whether the rules gave the case the outcome it expects.
+func (r Result) Passed() bool {
+	return r.Err == nil && len(r.Diff) == 0
+}
+
+// Run runs every case of the suite with the parser and evaluator of the
+// language package, without any source or destination.
+func (s *Suite) Run() []Result {
+	results := make([]Result, len(s.Cases))
+	for i := range s.Cases {
+		results[i] = s.run(&s.Cases[i])
+	}
+	return results
+}
+
+func (s *Suite) run(c *Case) Result {
+	validations, transformations := s.Validations, s.Transformations
+	if c.Validations != nil {
+		validations = *c.Validations
+	}
+	if c.Transformations != nil {
+		transformations = *c.Transformations
+	}
+	validation, err := language.ParseValidation(validations)
+	if err != nil {
+		return Result{Case: c, Err: fmt.Errorf("invalid validations: %w", err)}
+	}
+	transformation, err := language.ParseTransformation(transformations)
+	if err != nil {
+		return Result{Case: c, Err: fmt.Errorf("invalid transformations: %w", err)}
+	}
+
+	record := interfaces.NewRecord()
+	if c.Input.Record != nil {
+		record = c.Input.Clone()
+	}
+	datasets := datasets(s.Datasets)
+	var errs []error
+	if validation != nil {
+		errs = language.ValidateAll(record, validation, datasets)
+	}
+	if len(errs) == 0 && transformation != nil {
+		if _, err := language.TransformWith(record, transformation, datasets); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return Result{Case: c, Diff: diff(c, got(record, errs))}
+}
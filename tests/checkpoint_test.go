@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/SkySingh04/fractal/checkpoint"
+	"github.com/SkySingh04/fractal/integrations"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// positionSource streams records numbered from 1 to count, reporting each
+// number as its position and resuming after the checkpointed one.
+type positionSource struct {
+	count int
+}
+
+func (p positionSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	first, _ := strconv.Atoi(checkpoint.Resume(ctx)["id"])
+	for i := first + 1; i <= p.count; i++ {
+		record := interfaces.NewRecord()
+		record.Set("id", i)
+		if err := checkpoint.Send(ctx, out, record, "id", strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ackingDestination acknowledges every record it receives and fails after
+// failAfter records.
+type ackingDestination struct {
+	failAfter int
+}
+
+func (a ackingDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	for received := 0; ; received++ {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil || record == nil {
+			return err
+		}
+		if received == a.failAfter {
+			return errors.New("destination unavailable")
+		}
+		checkpoint.Ack(ctx, 1)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	newTracker := func(t *testing.T, store checkpoint.Store) *checkpoint.Tracker {
+		tracker, err := checkpoint.NewTracker(context.Background(), store, "test")
+		assert.NoError(t, err)
+		return tracker
+	}
+
+	t.Run("file store saves positions by name", func(t *testing.T) {
+		store := checkpoint.NewFileStore(filepath.Join(t.TempDir(), "state", "checkpoints.json"))
+		position, err := store.Load(context.Background(), "orders")
+		assert.NoError(t, err)
+		assert.Nil(t, position)
+
+		assert.NoError(t, store.Save(context.Background(), "orders", checkpoint.Position{"orders": "42"}))
+		assert.NoError(t, store.Save(context.Background(), "users", checkpoint.Position{"users": "7"}))
+		position, err = store.Load(context.Background(), "orders")
+		assert.NoError(t, err)
+		assert.Equal(t, checkpoint.Position{"orders": "42"}, position)
+
+		_, err = checkpoint.Open(interfaces.Checkpoint{Store: "redis"})
+		assert.EqualError(t, err, `unknown checkpoint store "redis", expected one of file, sqlite`)
+		t.Logf("%s Positions saved", greenTick)
+	})
+
+	t.Run("sqlite store saves positions by name", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state", "checkpoints.db")
+		store, err := checkpoint.Open(interfaces.Checkpoint{Store: "sqlite", Path: path})
+		assert.NoError(t, err)
+		position, err := store.Load(context.Background(), "orders")
+		assert.NoError(t, err)
+		assert.Nil(t, position)
+
+		assert.NoError(t, store.Save(context.Background(), "orders", checkpoint.Position{"orders": "41"}))
+		assert.NoError(t, store.Save(context.Background(), "orders", checkpoint.Position{"orders": "42"}))
+		assert.NoError(t, store.Save(context.Background(), "users", checkpoint.Position{"users": "7"}))
+		assert.NoError(t, store.Close())
+
+		// The positions outlive the store that saved them
+		store, err = checkpoint.Open(interfaces.Checkpoint{Store: "sqlite", Path: path})
+		assert.NoError(t, err)
+		defer store.Close()
+		position, err = store.Load(context.Background(), "orders")
+		assert.NoError(t, err)
+		assert.Equal(t, checkpoint.Position{"orders": "42"}, position)
+		t.Logf("%s Positions saved in SQLite", greenTick)
+	})
+
+	t.Run("commits only what every destination acknowledged", func(t *testing.T) {
+		store := checkpoint.NewFileStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+		tracker := newTracker(t, store)
+		ctx := checkpoint.WithTracker(context.Background(), tracker)
+
+		targets := []pipeline.Target{
+			{Name: "fast", Destination: ackingDestination{failAfter: -1}},
+			{Name: "slow", Destination: ackingDestination{failAfter: 150}},
+		}
+		_, err := pipeline.FanOut(ctx, positionSource{count: 1000}, targets, interfaces.Request{}, pipeline.BestEffort)
+		assert.NoError(t, err)
+
+		// Positions are committed in steps, never past the slowest destination
+		committed, _ := strconv.Atoi(tracker.Position()["id"])
+		assert.Greater(t, committed, 0)
+		assert.LessOrEqual(t, committed, 150)
+		saved, err := store.Load(context.Background(), "test")
+		assert.NoError(t, err)
+		assert.Equal(t, tracker.Position(), saved)
+
+		// A restarted migration resumes after the committed position
+		resumed := &collectDestination{}
+		err = pipeline.Run(checkpoint.WithTracker(context.Background(), newTracker(t, store)), positionSource{count: 1000}, resumed, interfaces.Request{})
+		assert.NoError(t, err)
+		assert.Len(t, resumed.received, 1000-committed)
+		t.Logf("%s Resumed after record %d", greenTick, committed)
+	})
+
+	t.Run("records dropped by filter rules are committed", func(t *testing.T) {
+		tracker := newTracker(t, checkpoint.NewFileStore(filepath.Join(t.TempDir(), "checkpoints.json")))
+		ctx := checkpoint.WithTracker(context.Background(), tracker)
+
+		// The destination acknowledges 150 even records, which stand for
+		// the first 300 records of the source
+		req := interfaces.Request{ValidationRules: `KEEP_IF FIELD("id") MATCHES("[02468]$")`}
+		err := pipeline.Run(ctx, positionSource{count: 1000}, ackingDestination{failAfter: 150}, req)
+		assert.ErrorContains(t, err, "destination unavailable")
+		committed, _ := strconv.Atoi(tracker.Position()["id"])
+		assert.GreaterOrEqual(t, committed, 200)
+		assert.LessOrEqual(t, committed, 301)
+
+		// Records dropped by the rules of one output are counted for it alone
+		tracker = newTracker(t, checkpoint.NewFileStore(filepath.Join(t.TempDir(), "checkpoints.json")))
+		targets := []pipeline.Target{
+			{Name: "all", Destination: ackingDestination{failAfter: -1}},
+			{Name: "recent", Destination: ackingDestination{failAfter: 150}},
+		}
+		targets[1].Request.TransformationRules = `KEEP_IF FIELD("id") > 500`
+		_, err = pipeline.FanOut(checkpoint.WithTracker(context.Background(), tracker), positionSource{count: 1000}, targets, interfaces.Request{}, pipeline.BestEffort)
+		assert.NoError(t, err)
+		committed, _ = strconv.Atoi(tracker.Position()["id"])
+		assert.GreaterOrEqual(t, committed, 600)
+		assert.LessOrEqual(t, committed, 650)
+		t.Logf("%s Committed record %d", greenTick, committed)
+	})
+
+	t.Run("CSV source reads appended rows", func(t *testing.T) {
+		dir := t.TempDir()
+		fileName := filepath.Join(dir, "orders.csv")
+		assert.NoError(t, os.WriteFile(fileName, []byte("id,total\n1,10\n2,20\n3,30\n"), 0o644))
+		tracker := newTracker(t, checkpoint.NewFileStore(filepath.Join(dir, "checkpoints.json")))
+		ctx := checkpoint.WithTracker(context.Background(), tracker)
+		source := integrations.CSVSource{FileName: fileName}
+
+		first := &collectDestination{}
+		assert.NoError(t, pipeline.Run(ctx, source, first, interfaces.Request{}))
+		assert.Len(t, first.received, 3)
+
+		file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0o644)
+		assert.NoError(t, err)
+		_, err = file.WriteString("4,40\n5,50\n")
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+
+		second := &collectDestination{}
+		assert.NoError(t, pipeline.Run(ctx, source, second, interfaces.Request{}))
+		assert.Len(t, second.received, 2)
+		id, _ := second.received[0].Get("id")
+		assert.Equal(t, "4", id)
+		assert.Equal(t, []string{"id", "total"}, second.received[0].Names())
+		t.Logf("%s Read %d appended rows", greenTick, len(second.received))
+	})
+
+	t.Run("fan-in migrations are not checkpointed", func(t *testing.T) {
+		tracker := newTracker(t, checkpoint.NewFileStore(filepath.Join(t.TempDir(), "checkpoints.json")))
+		source, err := pipeline.Combine([]pipeline.Input{
+			{Name: "a", Source: positionSource{count: 3}},
+			{Name: "b", Source: positionSource{count: 3}},
+		}, interfaces.Merge{})
+		assert.NoError(t, err)
+
+		destination := &collectDestination{}
+		err = pipeline.Run(checkpoint.WithTracker(context.Background(), tracker), source, destination, interfaces.Request{})
+		assert.NoError(t, err)
+		assert.Len(t, destination.received, 6)
+		assert.Empty(t, tracker.Position())
+		t.Logf("%s Fan-in ran without a checkpoint", greenTick)
+	})
+}
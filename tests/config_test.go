@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/config"
+	"github.com/SkySingh04/fractal/integrations"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+type sampleConfig struct {
+	URL       string        `json:"url" validate:"required"`
+	QueueName string        `json:"queue_name" validate:"required"`
+	Password  string        `json:"password" secret:"true"`
+	Retries   int           `json:"retries" default:"3"`
+	Timeout   time.Duration `json:"timeout" default:"5s"`
+	Brokers   []string      `json:"brokers"`
+	Verbose   bool          `json:"verbose"`
+}
+
+func TestIntegrationConfig(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("decodes keys case-insensitively and applies defaults", func(t *testing.T) {
+		decoded, err := registry.Decode(sampleConfig{}, map[string]interface{}{
+			"URL":       "amqp://localhost",
+			"queuename": "jobs",
+			"password":  "hunter2",
+			"brokers":   "a:9092, b:9092",
+			"verbose":   "true",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, sampleConfig{
+			URL:       "amqp://localhost",
+			QueueName: "jobs",
+			Password:  "hunter2",
+			Retries:   3,
+			Timeout:   5 * time.Second,
+			Brokers:   []string{"a:9092", "b:9092"},
+			Verbose:   true,
+		}, decoded)
+		t.Logf("%s Config decoded", greenTick)
+	})
+
+	t.Run("reports every missing required option", func(t *testing.T) {
+		_, err := registry.Decode(sampleConfig{}, map[string]interface{}{})
+		assert.EqualError(t, err, "missing required config: url, queue_name")
+
+		_, err = registry.Decode(sampleConfig{}, map[string]interface{}{"url": "x", "queue_name": "y", "retries": "many"})
+		assert.ErrorContains(t, err, "invalid value for retries")
+		t.Logf("%s Invalid config rejected", greenTick)
+	})
+
+	t.Run("decodes numbers from JSON bodies", func(t *testing.T) {
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(`{"url": 1000000, "queue_name": 1e21, "retries": 1000000, "brokers": [1, 2.5]}`), &body))
+		decoded, err := registry.Decode(sampleConfig{}, body)
+		assert.NoError(t, err)
+		assert.Equal(t, sampleConfig{
+			URL:       "1000000",
+			QueueName: "1000000000000000000000",
+			Retries:   1000000,
+			Timeout:   5 * time.Second,
+			Brokers:   []string{"1", "2.5"},
+		}, decoded)
+
+		_, err = registry.Decode(sampleConfig{}, map[string]interface{}{"url": "x", "queue_name": "y", "retries": 2.5})
+		assert.EqualError(t, err, "invalid value for retries: expected an integer, got 2.5")
+		_, err = registry.Decode(sampleConfig{}, map[string]interface{}{"url": "x", "queue_name": "y", "retries": 1e21})
+		assert.EqualError(t, err, "invalid value for retries: 1000000000000000000000 is out of range")
+		t.Logf("%s JSON numbers decoded", greenTick)
+	})
+
+	t.Run("masks secrets", func(t *testing.T) {
+		redacted := registry.Redact(sampleConfig{URL: "amqp://localhost", Password: "hunter2"})
+		assert.Equal(t, "******", redacted["password"])
+		assert.Equal(t, "amqp://localhost", redacted["url"])
+
+		raw := registry.RedactConfig(integrations.PostgreSQLSource{}, map[string]interface{}{"connstring": "postgres://user:pass@db"})
+		assert.Equal(t, "******", raw["connstring"])
+		t.Logf("%s Secrets redacted", greenTick)
+	})
+
+	t.Run("creates configured integrations from the registry", func(t *testing.T) {
+		source, err := registry.NewSource("Kafka", map[string]interface{}{"url": "localhost:9092", "topic": "events"})
+		assert.NoError(t, err)
+		assert.Equal(t, integrations.KafkaSource{URL: "localhost:9092", Topic: "events", GroupID: "fractal-group"}, source)
+
+		_, err = registry.NewDestination("MongoDB", map[string]interface{}{"database": "db"})
+		assert.EqualError(t, err, "invalid MongoDB destination config: missing required config: conn_string, collection")
+		t.Logf("%s Integrations created", greenTick)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	// load writes content to a configuration file and loads it
+	load := func(t *testing.T, content string) map[string]interface{} {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		configuration, err := config.LoadConfig(path)
+		assert.NoError(t, err)
+		return configuration
+	}
+
+	t.Run("loads reference datasets", func(t *testing.T) {
+		configuration := load(t, `
+inputMethod: CSV
+references:
+  - name: products
+    inputMethod: JSON
+    inputconfig:
+      data: '[{"sku": 1}]'
+    refresh: 10m
+`)
+		references, ok := configuration["references"].([]interface{})
+		assert.True(t, ok)
+		assert.Len(t, references, 1)
+		assert.Equal(t, map[string]interface{}{
+			"name":        "products",
+			"inputmethod": "JSON",
+			"inputconfig": map[string]interface{}{"data": `[{"sku": 1}]`},
+			"refresh":     "10m",
+		}, references[0])
+		t.Logf("%s References loaded", greenTick)
+	})
+
+	t.Run("loads the dedup of the source", func(t *testing.T) {
+		configuration := load(t, `
+inputMethod: CSV
+dedup:
+  keys: [order_id]
+  window: 24h
+  keep: first
+  max_keys: 100000
+`)
+		assert.Equal(t, map[string]interface{}{
+			"keys":     []interface{}{"order_id"},
+			"window":   "24h",
+			"keep":     "first",
+			"max_keys": 100000,
+		}, configuration["dedup"])
+		assert.Nil(t, load(t, "inputMethod: CSV\n")["dedup"])
+		t.Logf("%s Dedup loaded", greenTick)
+	})
+
+	t.Run("loads the contract of the source", func(t *testing.T) {
+		configuration := load(t, `
+inputMethod: CSV
+contract:
+  allow_new_fields: true
+  fields:
+    - name: id
+      type: int
+    - name: customer
+      type: object
+      optional: true
+      fields:
+        - name: email
+          type: string
+`)
+		// The contract is decoded like the JSON body of the HTTP API
+		data, err := json.Marshal(configuration["contract"])
+		assert.NoError(t, err)
+		var contract interfaces.Contract
+		assert.NoError(t, json.Unmarshal(data, &contract))
+		assert.Equal(t, interfaces.Contract{
+			AllowNewFields: true,
+			Fields: []interfaces.ContractField{
+				{Name: "id", Type: "int"},
+				{Name: "customer", Type: "object", Optional: true, Fields: []interfaces.ContractField{{Name: "email", Type: "string"}}},
+			},
+		}, contract)
+
+		configuration = load(t, "contract:\n  json_schema: contracts/order.schema.json\n")
+		assert.Equal(t, map[string]interface{}{"json_schema": "contracts/order.schema.json"}, configuration["contract"])
+		t.Logf("%s Contract loaded", greenTick)
+	})
+}
@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/contract"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContract(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	order := func(fields map[string]interface{}) *interfaces.Record {
+		return interfaces.RecordFromMap(fields)
+	}
+	customer := func(city interface{}) *interfaces.Record {
+		return interfaces.RecordFromMap(map[string]interface{}{"name": "Ada", "city": city})
+	}
+	drift := func(err error) []contract.Change {
+		var drift *contract.DriftError
+		if !errors.As(err, &drift) {
+			return nil
+		}
+		return drift.Changes
+	}
+
+	t.Run("reports drift apart from violations", func(t *testing.T) {
+		schema, err := contract.Declare([]interfaces.ContractField{
+			{Name: "id", Type: "int"},
+			{Name: "total", Type: "decimal"},
+			{Name: "status", Type: "string", Enum: []interface{}{"new", "paid"}},
+			{Name: "note", Type: "string", Nullable: true, Optional: true},
+			{Name: "customer", Type: "object", Fields: []interfaces.ContractField{{Name: "name", Type: "string"}, {Name: "city", Type: "string"}}},
+			{Name: "tags", Type: "array", Items: &interfaces.ContractField{Type: "string"}},
+		}, true)
+		assert.NoError(t, err)
+
+		valid := map[string]interface{}{"id": int64(1), "total": 9.5, "status": "new", "customer": customer("Paris"), "tags": []interface{}{"a"}}
+		assert.NoError(t, schema.Check(order(valid)))
+
+		changed := map[string]interface{}{"id": "1", "total": int64(9), "status": "new", "customer": customer(int64(75)), "tags": []interface{}{true}, "vip": true}
+		assert.Equal(t, []contract.Change{
+			{Kind: contract.RetypedField, Field: "id", Expected: interfaces.TypeInt, Found: interfaces.TypeString},
+			{Kind: contract.RetypedField, Field: "customer.city", Expected: interfaces.TypeString, Found: interfaces.TypeInt},
+			{Kind: contract.RetypedField, Field: "tags[]", Expected: interfaces.TypeString, Found: interfaces.TypeBool},
+			{Kind: contract.NewField, Field: "vip", Found: interfaces.TypeBool},
+		}, drift(schema.Check(order(changed))))
+
+		missing := map[string]interface{}{"total": 9.5, "status": "new", "customer": customer("Paris"), "tags": []interface{}{}}
+		assert.EqualError(t, schema.Check(order(missing)), "schema drift: missing field id")
+
+		cases := map[string]map[string]interface{}{
+			"schema violation: field status holds \"lost\", which is not one of new, paid": {"id": int64(1), "total": 1.0, "status": "lost", "customer": customer("Paris"), "tags": nil},
+			"schema violation: field customer.city is null, but not nullable":              {"id": int64(1), "total": 1.0, "status": "paid", "customer": customer(nil), "tags": []interface{}{}},
+		}
+		for message, fields := range cases {
+			err := schema.Check(order(fields))
+			var violation *contract.ViolationError
+			assert.True(t, errors.As(err, &violation), message)
+			assert.EqualError(t, err, message)
+		}
+
+		_, err = contract.Declare([]interfaces.ContractField{{Name: "at", Type: "date"}}, true)
+		assert.ErrorContains(t, err, `field at: unknown type "date", expected string, int`)
+		t.Logf("%s Drift and violations told apart", greenTick)
+	})
+
+	t.Run("reads JSON Schema contracts", func(t *testing.T) {
+		schema, err := contract.ParseJSONSchema([]byte(`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "id": {"type": "integer"},
+    "placed_at": {"type": "string", "format": "date-time"},
+    "coupon": {"anyOf": [{"type": "string"}, {"type": "null"}]},
+    "status": {"enum": ["new", "paid"]},
+    "customer": {"$ref": "#/$defs/customer"}
+  },
+  "required": ["id", "placed_at", "customer"],
+  "additionalProperties": false,
+  "$defs": {
+    "customer": {"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}
+  }
+}`))
+		assert.NoError(t, err)
+		customer := interfaces.RecordFromMap(map[string]interface{}{"name": "Ada", "vip": true})
+		record := func(placed interface{}) *interfaces.Record {
+			return interfaces.RecordFromMap(map[string]interface{}{"id": int64(7), "placed_at": placed, "coupon": nil, "customer": customer})
+		}
+		assert.NoError(t, schema.Check(record("2024-05-01T10:00:00Z")))
+		assert.NoError(t, schema.Check(record(time.Now())))
+		assert.EqualError(t, schema.Check(record("yesterday")), "schema drift: field placed_at retyped from timestamp to string")
+
+		for document, message := range map[string]string{
+			`{"type": "object", "properties": {"a": {"allOf": [{"type": "string"}]}}}`:                                                     "JSON Schema field a: allOf is not supported by contracts",
+			`{"type": "object", "properties": {"a": {"$ref": "#/$defs/a"}}, "$defs": {"a": {"properties": {"b": {"$ref": "#/$defs/a"}}}}}`: `JSON Schema field a.b: $ref "#/$defs/a" is recursive`,
+			`{"type": "array"}`: "a JSON Schema contract describes an object, found type array",
+		} {
+			_, err := contract.ParseJSONSchema([]byte(document))
+			assert.ErrorContains(t, err, message)
+		}
+		t.Logf("%s JSON Schema read", greenTick)
+	})
+
+	t.Run("infers a schema from sampled records", func(t *testing.T) {
+		records := recordsOf(
+			map[string]interface{}{"id": int64(1), "price": int64(5), "note": nil, "customer": customer("Paris")},
+			map[string]interface{}{"id": int64(2), "price": 4.5, "customer": customer("Lyon")},
+		)
+		schema := contract.Infer(records, true)
+		assert.Equal(t, []string{"customer", "id", "note", "price"}, names(schema.Fields))
+		customerField, note, price := schema.Fields[0], schema.Fields[2], schema.Fields[3]
+		assert.Equal(t, interfaces.TypeObject, customerField.Type)
+		assert.Equal(t, []string{"city", "name"}, names(customerField.Fields))
+		assert.Equal(t, interfaces.TypeAny, note.Type)
+		assert.True(t, note.Nullable && note.Optional)
+		assert.Equal(t, interfaces.TypeFloat, price.Type)
+
+		document, err := schema.JSONSchema()
+		assert.NoError(t, err)
+		saved, err := contract.ParseJSONSchema(document)
+		assert.NoError(t, err)
+		for _, record := range records {
+			assert.NoError(t, saved.Check(record))
+		}
+		assert.Equal(t, []contract.Change{{Kind: contract.NewField, Field: "customer.zip", Found: interfaces.TypeString}},
+			drift(saved.Check(recordsOf(map[string]interface{}{"id": int64(3), "price": 1.0, "customer": interfaces.RecordFromMap(map[string]interface{}{"name": "Bo", "city": "Nice", "zip": "06000"})})[0])))
+		t.Logf("%s Inferred schema saved and read back", greenTick)
+	})
+
+	t.Run("checks migrations against the schema earlier runs inferred", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "contract.json")
+		req := interfaces.Request{Contract: &interfaces.Contract{Infer: 2, Path: path}}
+		first := recordsOf(
+			map[string]interface{}{"id": int64(1), "status": "new"},
+			map[string]interface{}{"id": int64(2), "status": "paid"},
+			map[string]interface{}{"id": int64(3), "status": "new"},
+		)
+		destination := &collectDestination{}
+		assert.NoError(t, pipeline.Run(context.Background(), sliceSource{records: first}, destination, req))
+		assert.Len(t, destination.received, 3)
+		assert.FileExists(t, path)
+
+		drifted := recordsOf(
+			map[string]interface{}{"id": int64(4), "status": "new"},
+			map[string]interface{}{"id": "5", "status": "new", "channel": "web"},
+		)
+		err := pipeline.Run(context.Background(), sliceSource{records: drifted}, &collectDestination{}, req)
+		assert.ErrorContains(t, err, "record 2 does not follow the contract: schema drift: field id retyped from int to string, new field channel (string)")
+		assert.Len(t, drift(err), 2)
+
+		req.Contract.OnDrift = pipeline.DriftLog
+		destination = &collectDestination{}
+		assert.NoError(t, pipeline.Run(context.Background(), sliceSource{records: drifted}, destination, req))
+		assert.Len(t, destination.received, 2)
+
+		assert.NoError(t, os.WriteFile(path, []byte("["), 0o644))
+		assert.ErrorContains(t, pipeline.Run(context.Background(), sliceSource{records: drifted}, &collectDestination{}, req), "invalid inferred schema")
+		t.Logf("%s Drift reported against the inferred schema", greenTick)
+	})
+
+	t.Run("checks records in a contract stage", func(t *testing.T) {
+		schema := `{"type": "object", "properties": {"id": {"type": "integer"}, "status": {"enum": ["new", "paid"]}}, "required": ["id"]}`
+		nodes := func(records ...map[string]interface{}) ([]pipeline.Node, *collectDestination) {
+			destination := &collectDestination{}
+			return []pipeline.Node{
+				{Stage: interfaces.Stage{Name: "orders", Type: interfaces.StageSource, Integration: "test"}, Source: sliceSource{records: recordsOf(records...)}},
+				{Stage: interfaces.Stage{Name: "checked", Type: interfaces.StageContract, Inputs: []string{"orders"}, Contract: &interfaces.Contract{JSONSchema: schema}}},
+				{Stage: interfaces.Stage{Name: "out", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"checked"}}, Destination: destination},
+			}, destination
+		}
+
+		graphNodes, destination := nodes(map[string]interface{}{"id": int64(1), "status": "new", "extra": true})
+		graph, err := pipeline.NewGraph(graphNodes)
+		assert.NoError(t, err)
+		_, err = graph.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, destination.received, 1)
+
+		graphNodes, _ = nodes(map[string]interface{}{"id": int64(1), "status": "lost"})
+		graph, err = pipeline.NewGraph(graphNodes)
+		assert.NoError(t, err)
+		_, err = graph.Run(context.Background())
+		var violation *contract.ViolationError
+		assert.True(t, errors.As(err, &violation))
+		assert.EqualError(t, err, `stage checked failed: record 1 does not follow the contract of stage checked: schema violation: field status holds "lost", which is not one of new, paid`)
+		t.Logf("%s Contract stage checked records", greenTick)
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		source := interfaces.Stage{Name: "in", Type: interfaces.StageSource, Integration: "JSON"}
+		stage := func(c *interfaces.Contract) []interfaces.Stage {
+			return []interfaces.Stage{source,
+				{Name: "c", Type: interfaces.StageContract, Inputs: []string{"in"}, Contract: c},
+				{Name: "out", Type: interfaces.StageSink, Integration: "JSON", Inputs: []string{"c"}},
+			}
+		}
+		fields := []interfaces.ContractField{{Name: "id", Type: "int"}}
+		cases := map[string]*interfaces.Contract{
+			"stage c: a contract needs fields, a json_schema or infer":               nil,
+			"stage c: a contract needs exactly one of fields, json_schema and infer": {Fields: fields, Infer: 10},
+			"stage c: unknown contract on_drift \"skip\", expected fail or log":      {Fields: fields, OnDrift: "skip"},
+			"stage c: path is where a contract saves the schema it infers":           {Fields: fields, Path: "schema.json"},
+			"stage c: allow_new_fields does not apply to a json_schema":              {JSONSchema: `{"type": "object"}`, AllowNewFields: true},
+			"stage c: field id: only an object has fields":                           {Fields: []interfaces.ContractField{{Name: "id", Type: "int", Fields: fields}}},
+			"stage c: open missing.json: no such file or directory":                  {JSONSchema: "missing.json"},
+			"stage c: duplicate field id":                                            {Fields: append(fields, fields...)},
+		}
+		for message, c := range cases {
+			assert.ErrorContains(t, pipeline.ValidateStages(stage(c)), message)
+		}
+		t.Logf("%s %d invalid contracts rejected", greenTick, len(cases))
+	})
+}
+
+// names returns the names of fields.
+func names(fields []*contract.Field) []string {
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		result[i] = field.Name
+	}
+	return result
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/SkySingh04/fractal/factory"
 	"github.com/SkySingh04/fractal/integrations"
 	"github.com/SkySingh04/fractal/interfaces"
 	"github.com/stretchr/testify/assert"
@@ -32,36 +33,41 @@ Jane,30,San Francisco`
 	defer os.Remove(inputFileName)
 	defer os.Remove(outputFileName)
 
-	req := interfaces.Request{
-		CSVSourceFileName:      inputFileName,
-		CSVDestinationFileName: outputFileName,
-	}
+	req := interfaces.Request{Input: "CSV", Output: "CSV"}
 
-	csvSource := integrations.CSVSource{}
-	data, err := csvSource.FetchData(req)
+	// The registry decodes the config keys of config.yaml into the integration
+	csvSource, err := factory.CreateSource("CSV", map[string]interface{}{"csvsourcefilename": inputFileName})
+	if !assert.NoError(t, err, "Error creating CSV source") {
+		t.Fatalf("%s CreateSource failed", redCross)
+	}
+	records, err := fetchAll(csvSource, req)
 	if assert.NoError(t, err, "Error fetching data from CSV source") {
 		t.Logf("%s FetchData passed", greenTick)
 	} else {
 		t.Fatalf("%s FetchData failed", redCross)
 	}
 
-	dataStr, ok := data.(string)
-	if !ok {
-		t.Fatalf("%s Data type mismatch: expected string", redCross)
+	// Without rules every row is streamed unchanged, with the header as field names
+	expectedRecords := []map[string]interface{}{
+		{"name": "John", "age": "25", "city": "New York"},
+		{"name": "Jane", "age": "30", "city": "San Francisco"},
 	}
+	expectedTransformedData := inputContent
 
-	expectedTransformedData := "NAME,AGE,CITY\nJOHN,25,NEW YORK\nJANE,30,SAN FRANCISCO"
-	dataStr = strings.TrimSpace(dataStr)
-	expectedTransformedData = strings.TrimSpace(expectedTransformedData)
-
-	if assert.Equal(t, expectedTransformedData, dataStr, "Transformed data mismatch") {
+	var streamed []map[string]interface{}
+	for _, record := range records {
+		assert.Equal(t, []string{"name", "age", "city"}, record.Names(), "Field order mismatch")
+		assert.Equal(t, "test_input", record.Schema.Name, "Schema name mismatch")
+		streamed = append(streamed, record.ToMap())
+	}
+	if assert.Equal(t, expectedRecords, streamed, "Streamed records mismatch") {
 		t.Logf("%s Data validation passed", greenTick)
 	} else {
 		t.Fatalf("%s Data validation failed", redCross)
 	}
 
-	csvDestination := integrations.CSVDestination{}
-	err = csvDestination.SendData(dataStr, req)
+	csvDestination := integrations.CSVDestination{FileName: outputFileName}
+	err = sendAll(csvDestination, req, records...)
 	if assert.NoError(t, err, "Error sending data to CSV destination") {
 		t.Logf("%s SendData passed", greenTick)
 	} else {
@@ -0,0 +1,316 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/factory"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamSource streams the records sent on its channel until it is closed.
+type streamSource chan *interfaces.Record
+
+func (s streamSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	for record := range s {
+		if err := interfaces.Send(ctx, out, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamDestination passes on every record it receives on its channel.
+type streamDestination chan *interfaces.Record
+
+func (s streamDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	defer close(s)
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil || record == nil {
+			return err
+		}
+		s <- record
+	}
+}
+
+func TestPipelineGraph(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	orders := func() interfaces.DataSource {
+		return sliceSource{records: recordsOf(
+			map[string]interface{}{"id": int64(1), "region": "EU", "total": int64(50)},
+			map[string]interface{}{"id": int64(2), "region": "US", "total": int64(1500)},
+			map[string]interface{}{"id": int64(3), "region": "EU", "total": int64(2500)},
+			map[string]interface{}{"id": int64(4), "region": "US", "total": int64(-10)},
+		)}
+	}
+
+	t.Run("runs filters, routers and aggregates", func(t *testing.T) {
+		large, small, report := &collectDestination{}, &collectDestination{}, &collectDestination{}
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "report", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"revenue"}}, Destination: report},
+			{Stage: interfaces.Stage{Name: "orders", Type: interfaces.StageSource, Integration: "test"}, Source: orders()},
+			{Stage: interfaces.Stage{Name: "valid", Type: interfaces.StageFilter, Inputs: []string{"orders"}, Rules: `FIELD("total") RANGE(0,10000)`}},
+			{Stage: interfaces.Stage{Name: "by_size", Type: interfaces.StageRouter, Inputs: []string{"valid"}, Routes: []interfaces.Route{{Name: "large", When: `FIELD("total") RANGE(1000,10000)`}}}},
+			{Stage: interfaces.Stage{Name: "large", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"by_size.large"}}, Destination: large},
+			{Stage: interfaces.Stage{Name: "small", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"by_size.default"}}, Destination: small},
+			{Stage: interfaces.Stage{Name: "revenue", Type: interfaces.StageAggregate, Inputs: []string{"valid"}, GroupBy: []string{"region"}, Aggregates: []interfaces.Aggregate{
+				{Name: "revenue", Function: pipeline.Sum, Field: "total"},
+				{Name: "orders", Function: pipeline.Count},
+				{Name: "average", Function: pipeline.Avg, Field: "total"},
+				{Name: "largest", Function: pipeline.Max, Field: "total"},
+			}}},
+		})
+		assert.NoError(t, err)
+
+		result, err := graph.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, large.received, 2)
+		assert.Len(t, small.received, 1)
+		assert.Len(t, report.received, 2)
+		assert.Equal(t, []interface{}{"EU", int64(2550), int64(2), float64(1275), int64(2500)}, values(report.received[0]))
+		assert.Equal(t, []interface{}{"US", int64(1500), int64(1), float64(1500), int64(1500)}, values(report.received[1]))
+		assert.Len(t, result.Outputs, 3)
+		t.Logf("%s Pipeline wrote to %d sinks", greenTick, len(result.Outputs))
+	})
+
+	t.Run("aggregates with rules", func(t *testing.T) {
+		report := &collectDestination{}
+		source := sliceSource{records: recordsOf(
+			map[string]interface{}{"region": "EU", "customer": "ann", "total": int64(10), "created_at": "2024-05-01T09:00:00Z"},
+			map[string]interface{}{"region": "EU", "customer": "ann", "total": int64(20), "created_at": "2024-05-01T17:00:00Z"},
+			map[string]interface{}{"region": "EU", "customer": "bob", "total": int64(30), "created_at": "2024-05-02T08:00:00Z", "status": "cancelled"},
+			map[string]interface{}{"region": "US", "customer": "cat", "total": int64(40), "created_at": "2024-05-01T12:00:00Z"},
+		)}
+		rules := `DROP_IF FIELD("status") == "cancelled"
+GROUP BY FIELD("region"), SUBSTR(FIELD("created_at"), 1, 10) AS "day"
+SUM(FIELD("total")) AS "revenue"
+DISTINCT_COUNT(FIELD("customer")) AS "customers"
+COUNT() AS "orders"`
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "orders", Type: interfaces.StageSource, Integration: "test"}, Source: source},
+			{Stage: interfaces.Stage{Name: "daily", Type: interfaces.StageAggregate, Inputs: []string{"orders"}, Rules: rules}},
+			{Stage: interfaces.Stage{Name: "report", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"daily"}}, Destination: report},
+		})
+		assert.NoError(t, err)
+		result, err := graph.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, report.received, 2)
+		assert.Equal(t, []string{"region", "day", "revenue", "customers", "orders"}, report.received[0].Names())
+		assert.Equal(t, []interface{}{"EU", "2024-05-01", int64(30), int64(1), int64(2)}, values(report.received[0]))
+		assert.Equal(t, []interface{}{"US", "2024-05-01", int64(40), int64(1), int64(1)}, values(report.received[1]))
+		assert.Equal(t, []pipeline.Drop{{Rule: "DROP_IF on line 1 of the aggregation rules of stage daily", Records: 1}}, result.Dropped)
+		t.Logf("%s Aggregated %d groups", greenTick, len(report.received))
+	})
+
+	t.Run("sums decimals exactly and orders dates in time", func(t *testing.T) {
+		decimal := func(s string) interfaces.Decimal {
+			d, err := interfaces.ParseDecimal(s)
+			assert.NoError(t, err)
+			return d
+		}
+		date := func(s string) time.Time {
+			d, err := time.Parse(time.RFC3339, s)
+			assert.NoError(t, err)
+			return d
+		}
+		report := &collectDestination{}
+		source := sliceSource{records: recordsOf(
+			map[string]interface{}{"price": decimal("0.10"), "created_at": date("2024-05-01T22:00:00Z")},
+			map[string]interface{}{"price": decimal("0.2"), "created_at": date("2024-05-02T01:00:00+05:00")},
+			map[string]interface{}{"price": int64(10), "created_at": date("2024-05-03T00:00:00Z")},
+		)}
+		rules := `SUM(FIELD("price")) AS "revenue"
+AVG(FIELD("price")) AS "average"
+MIN(FIELD("created_at")) AS "first"
+MAX(FIELD("created_at")) AS "last"`
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "orders", Type: interfaces.StageSource, Integration: "test"}, Source: source},
+			{Stage: interfaces.Stage{Name: "totals", Type: interfaces.StageAggregate, Inputs: []string{"orders"}, Rules: rules}},
+			{Stage: interfaces.Stage{Name: "report", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"totals"}}, Destination: report},
+		})
+		assert.NoError(t, err)
+		_, err = graph.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{decimal("10.30"), decimal("3.433333"), date("2024-05-02T01:00:00+05:00"), date("2024-05-03T00:00:00Z")}, values(report.received[0]))
+		t.Logf("%s Decimals summed exactly", greenTick)
+	})
+
+	t.Run("aggregates streams in windows", func(t *testing.T) {
+		event := func(at string, total int64) *interfaces.Record {
+			return recordsOf(map[string]interface{}{"at": at, "total": total})[0]
+		}
+		run := func(window *interfaces.Window, events ...*interfaces.Record) []*interfaces.Record {
+			report := &collectDestination{}
+			graph, err := pipeline.NewGraph([]pipeline.Node{
+				{Stage: interfaces.Stage{Name: "events", Type: interfaces.StageSource, Integration: "test"}, Source: sliceSource{records: events}},
+				{Stage: interfaces.Stage{Name: "sums", Type: interfaces.StageAggregate, Inputs: []string{"events"}, Rules: `SUM(FIELD("total")) AS "total"`, Window: window}},
+				{Stage: interfaces.Stage{Name: "report", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"sums"}}, Destination: report},
+			})
+			assert.NoError(t, err)
+			_, err = graph.Run(context.Background())
+			assert.NoError(t, err)
+			return report.received
+		}
+		hour := func(h int) time.Time { return time.Date(2024, 5, 1, h, 0, 0, 0, time.UTC) }
+
+		// The 10:50 event arrives after the 10:00 window closed, so it is late
+		tumbling := run(&interfaces.Window{Type: pipeline.Tumbling, Size: "1h", TimeField: "at"},
+			event("2024-05-01T10:05:00Z", 1), event("2024-05-01T10:20:00Z", 2), event("2024-05-01T11:10:00Z", 4),
+			event("2024-05-01T10:50:00Z", 8), event("2024-05-01T12:30:00+01:00", 16))
+		assert.Equal(t, [][]interface{}{
+			{hour(10), hour(11), int64(3)},
+			{hour(11), hour(12), int64(20)},
+		}, [][]interface{}{values(tumbling[0]), values(tumbling[1])})
+		assert.Len(t, tumbling, 2)
+		assert.Equal(t, []string{pipeline.WindowStart, pipeline.WindowEnd, "total"}, tumbling[0].Names())
+
+		sliding := run(&interfaces.Window{Type: pipeline.Sliding, Size: "1h", Slide: "30m", TimeField: "at"},
+			event("2024-05-01T10:05:00Z", 1), event("2024-05-01T10:40:00Z", 2))
+		var totals []interface{}
+		for _, record := range sliding {
+			totals = append(totals, values(record)[2])
+		}
+		assert.Equal(t, []interface{}{int64(1), int64(3), int64(2)}, totals)
+		t.Logf("%s Emitted %d tumbling and %d sliding windows", greenTick, len(tumbling), len(sliding))
+	})
+
+	t.Run("emits windows while the stream runs", func(t *testing.T) {
+		source, report := make(streamSource), make(streamDestination)
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "events", Type: interfaces.StageSource, Integration: "test"}, Source: source},
+			{Stage: interfaces.Stage{Name: "counts", Type: interfaces.StageAggregate, Inputs: []string{"events"}, Rules: `COUNT() AS "events"`, Window: &interfaces.Window{Type: pipeline.Tumbling, Size: "20ms"}}},
+			{Stage: interfaces.Stage{Name: "report", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"counts"}}, Destination: report},
+		})
+		assert.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := graph.Run(context.Background())
+			done <- err
+		}()
+
+		source <- interfaces.NewRecord()
+		select {
+		case record := <-report:
+			count, _ := record.Get("events")
+			assert.Equal(t, int64(1), count)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no window was emitted")
+		}
+		close(source)
+		for range report {
+		}
+		assert.NoError(t, <-done)
+		t.Logf("%s Window emitted before the stream ended", greenTick)
+	})
+
+	t.Run("copies records read by several stages and merges several inputs", func(t *testing.T) {
+		first, second := &renamingDestination{}, &collectDestination{}
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "a", Type: interfaces.StageSource, Integration: "test"}, Source: orders()},
+			{Stage: interfaces.Stage{Name: "b", Type: interfaces.StageSource, Integration: "test"}, Source: orders()},
+			{Stage: interfaces.Stage{Name: "first", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"a", "b"}}, Destination: first},
+			{Stage: interfaces.Stage{Name: "second", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"a"}}, Destination: second},
+		})
+		assert.NoError(t, err)
+		result, err := graph.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, first.received, 8)
+		assert.Len(t, second.received, 4)
+		assert.Equal(t, "id", second.received[0].Names()[0])
+		assert.Equal(t, pipeline.OutputResult{Name: "first", Records: 8}, result.Outputs[0])
+		t.Logf("%s Records copied and merged", greenTick)
+	})
+
+	t.Run("a failing stage stops the pipeline", func(t *testing.T) {
+		source := endlessSource{stopped: make(chan error, 1)}
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "events", Type: interfaces.StageSource, Integration: "test"}, Source: source},
+			{Stage: interfaces.Stage{Name: "healthy", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"events"}}, Destination: &collectDestination{}},
+			{Stage: interfaces.Stage{Name: "broken", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"events"}}, Destination: &collectDestination{failAfter: 3}},
+		})
+		assert.NoError(t, err)
+		_, err = graph.Run(context.Background())
+		assert.EqualError(t, err, "stage broken failed: destination unavailable")
+		assert.ErrorIs(t, <-source.stopped, context.Canceled)
+		t.Logf("%s Pipeline stopped", greenTick)
+	})
+
+	t.Run("rejects invalid pipelines at load time", func(t *testing.T) {
+		source := interfaces.Stage{Name: "in", Type: interfaces.StageSource, Integration: "JSON"}
+		sink := func(inputs ...string) interfaces.Stage {
+			return interfaces.Stage{Name: "out", Type: interfaces.StageSink, Integration: "JSON", Inputs: inputs}
+		}
+		cases := map[string][]interfaces.Stage{
+			"pipeline has no stages":                     nil,
+			"duplicate stage name in":                    {source, source},
+			"stage out reads from unknown stage nowhere": {source, sink("nowhere")},
+			"stage x: unknown type \"join\"":             {source, {Name: "x", Type: "join", Inputs: []string{"in"}}, sink("x")},
+			"stage f is not read by any stage":           {source, {Name: "f", Type: interfaces.StageFilter, Rules: `FIELD("x") RANGE(1,2)`, Inputs: []string{"in"}}, sink("in")},
+			"pipeline has a cycle: a -> b -> a": {source,
+				{Name: "a", Type: interfaces.StageTransform, Rules: `RENAME("x", "y")`, Inputs: []string{"in", "b"}},
+				{Name: "b", Type: interfaces.StageTransform, Rules: `RENAME("x", "y")`, Inputs: []string{"a"}},
+				sink("b"),
+			},
+			"stage out reads from unknown route \"eu\" of router r": {source,
+				{Name: "r", Type: interfaces.StageRouter, Inputs: []string{"in"}, Routes: []interfaces.Route{{Name: "us", When: `FIELD("x") RANGE(1,2)`}}},
+				sink("r.eu"),
+			},
+			"route default of router r is not read by any stage": {source,
+				{Name: "r", Type: interfaces.StageRouter, Inputs: []string{"in"}, Routes: []interfaces.Route{{Name: "us", When: `FIELD("x") RANGE(1,2)`}}},
+				sink("r.us"),
+			},
+			"route eu of router r is not read by any stage": {source,
+				{Name: "r", Type: interfaces.StageRouter, Inputs: []string{"in"}, Routes: []interfaces.Route{{Name: "us", When: `FIELD("x") RANGE(1,2)`}, {Name: "eu", When: `FIELD("x") RANGE(3,4)`}}},
+				sink("r.us", "r.default"),
+			},
+			"stage sum: aggregate total: unknown function \"median\"": {source,
+				{Name: "sum", Type: interfaces.StageAggregate, Inputs: []string{"in"}, Aggregates: []interfaces.Aggregate{{Name: "total", Function: "median", Field: "x"}}},
+				sink("sum"),
+			},
+			"stage sum: an aggregate takes either rules or group_by and aggregates, not both": {source,
+				{Name: "sum", Type: interfaces.StageAggregate, Inputs: []string{"in"}, Rules: `COUNT() AS "n"`, GroupBy: []string{"x"}},
+				sink("sum"),
+			},
+			"stage sum: line 1, column 1: expected GROUP BY or an aggregate, found a condition": {source,
+				{Name: "sum", Type: interfaces.StageAggregate, Inputs: []string{"in"}, Rules: `FIELD("x") REQUIRED`},
+				sink("sum"),
+			},
+			"stage sum: window slide 2h0m0s is longer than its size 1h0m0s": {source,
+				{Name: "sum", Type: interfaces.StageAggregate, Inputs: []string{"in"}, Rules: `COUNT() AS "n"`, Window: &interfaces.Window{Type: pipeline.Sliding, Size: "1h", Slide: "2h"}},
+				sink("sum"),
+			},
+			"stage sum: unknown window type \"session\"": {source,
+				{Name: "sum", Type: interfaces.StageAggregate, Inputs: []string{"in"}, Rules: `COUNT() AS "n"`, Window: &interfaces.Window{Type: "session", Size: "1h"}},
+				sink("sum"),
+			},
+		}
+		for message, stages := range cases {
+			err := pipeline.ValidateStages(stages)
+			assert.ErrorContains(t, err, message)
+		}
+
+		_, err := factory.CreateGraph(interfaces.Request{Pipeline: interfaces.Pipeline{Stages: []interfaces.Stage{source, sink("in")}}})
+		assert.EqualError(t, err, "stage in: invalid JSON source config: missing required config: data")
+
+		_, err = factory.CreateGraph(interfaces.Request{
+			Pipeline:   interfaces.Pipeline{Stages: []interfaces.Stage{source, sink("in")}},
+			Checkpoint: interfaces.Checkpoint{Store: "file"},
+		})
+		assert.EqualError(t, err, "checkpoints are not supported for pipelines of stages, remove the checkpoint setting")
+		t.Logf("%s %d invalid pipelines rejected", greenTick, len(cases)+2)
+	})
+}
+
+// values returns the values of the fields of record in order.
+func values(record *interfaces.Record) []interface{} {
+	result := make([]interface{}, record.Len())
+	for i, field := range record.Fields {
+		result[i] = field.Value
+	}
+	return result
+}
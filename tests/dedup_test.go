@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	events := func() []*interfaces.Record {
+		return recordsOf(
+			map[string]interface{}{"id": int64(1), "status": "new", "note": "first"},
+			map[string]interface{}{"id": int64(2), "status": "new"},
+			map[string]interface{}{"id": "1", "status": "paid", "note": nil},
+			map[string]interface{}{"status": "orphan"},
+			map[string]interface{}{"id": int64(1), "status": "shipped"},
+		)
+	}
+	fieldsOf := func(records []*interfaces.Record, name string) []interface{} {
+		values := make([]interface{}, len(records))
+		for i, record := range records {
+			values[i], _ = record.Get(name)
+		}
+		return values
+	}
+
+	t.Run("keeps the first, last or merged record of each key", func(t *testing.T) {
+		expected := map[string][]interface{}{
+			pipeline.KeepFirst: {"new", "new", "orphan"},
+			pipeline.KeepLast:  {"orphan", "shipped", "new"},
+			pipeline.KeepMerge: {"orphan", "shipped", "new"},
+		}
+		for keep, statuses := range expected {
+			destination := &collectDestination{}
+			req := interfaces.Request{Dedup: &interfaces.Dedup{Keys: []string{"id"}, Keep: keep}}
+			assert.NoError(t, pipeline.Run(context.Background(), sliceSource{records: events()}, destination, req))
+			assert.Equal(t, statuses, fieldsOf(destination.received, "status"), keep)
+			if keep == pipeline.KeepMerge {
+				assert.Equal(t, []interface{}{nil, "first", nil}, fieldsOf(destination.received, "note"))
+			}
+		}
+		t.Logf("%s Kept the records of every policy", greenTick)
+	})
+
+	t.Run("counts the duplicates of each run", func(t *testing.T) {
+		req := interfaces.Request{Dedup: &interfaces.Dedup{Keys: []string{"id"}}}
+		targets := []pipeline.Target{{Name: "A", Destination: &collectDestination{}}}
+		result, err := pipeline.FanOut(context.Background(), sliceSource{records: events()}, targets, req, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, result.Outputs[0].Records)
+		assert.Equal(t, []pipeline.Drop{{Rule: "dedup on id", Records: 2}}, result.Dropped)
+		t.Logf("%s Counted %d duplicates", greenTick, result.Dropped[0].Records)
+	})
+
+	t.Run("forgets the least recently seen keys beyond max_keys", func(t *testing.T) {
+		records := recordsOf(
+			map[string]interface{}{"id": "a"},
+			map[string]interface{}{"id": "b"},
+			map[string]interface{}{"id": "a"},
+			map[string]interface{}{"id": "c"},
+			map[string]interface{}{"id": "a"},
+			map[string]interface{}{"id": "b"},
+		)
+		destination := &collectDestination{}
+		req := interfaces.Request{Dedup: &interfaces.Dedup{Keys: []string{"id"}, MaxKeys: 2}}
+		assert.NoError(t, pipeline.Run(context.Background(), sliceSource{records: records}, destination, req))
+		assert.Equal(t, []interface{}{"a", "b", "c", "b"}, fieldsOf(destination.received, "id"))
+		t.Logf("%s Forgot the least recently seen key", greenTick)
+	})
+
+	t.Run("remembers keys across runs in a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dedup.json")
+		req := interfaces.Request{Dedup: &interfaces.Dedup{Keys: []string{"id"}, Store: pipeline.DedupFile, Path: path}}
+		run := func(destination *collectDestination, ids ...string) error {
+			records := make([]*interfaces.Record, len(ids))
+			for i, id := range ids {
+				records[i] = recordsOf(map[string]interface{}{"id": id})[0]
+			}
+			return pipeline.Run(context.Background(), sliceSource{records: records}, destination, req)
+		}
+
+		assert.Error(t, run(&collectDestination{failAfter: 1}, "a", "b"))
+		assert.NoFileExists(t, path)
+
+		assert.NoError(t, run(&collectDestination{}, "a", "b", "a"))
+		destination := &collectDestination{}
+		assert.NoError(t, run(destination, "b", "c"))
+		assert.Equal(t, []interface{}{"c"}, fieldsOf(destination.received, "id"))
+
+		assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+		assert.ErrorContains(t, run(&collectDestination{}, "a"), "failed to open dedup: invalid dedup file")
+		t.Logf("%s Keys remembered across runs", greenTick)
+	})
+
+	t.Run("emits held records as their window ends", func(t *testing.T) {
+		in, out := make(streamSource), make(streamDestination)
+		graph, err := pipeline.NewGraph([]pipeline.Node{
+			{Stage: interfaces.Stage{Name: "events", Type: interfaces.StageSource, Integration: "test"}, Source: in},
+			{Stage: interfaces.Stage{Name: "latest", Type: interfaces.StageDedup, Inputs: []string{"events"}, Dedup: &interfaces.Dedup{Keys: []string{"id"}, Keep: pipeline.KeepLast, Window: "20ms"}}},
+			{Stage: interfaces.Stage{Name: "out", Type: interfaces.StageSink, Integration: "test", Inputs: []string{"latest"}}, Destination: out},
+		})
+		assert.NoError(t, err)
+		done := make(chan pipeline.Result, 1)
+		go func() {
+			result, err := graph.Run(context.Background())
+			assert.NoError(t, err)
+			done <- result
+		}()
+
+		for _, record := range events() {
+			in <- record
+		}
+		// The source is still open, so only the end of the windows emits records
+		var statuses []interface{}
+		for len(statuses) < 3 {
+			select {
+			case record := <-out:
+				status, _ := record.Get("status")
+				statuses = append(statuses, status)
+			case <-time.After(5 * time.Second):
+				t.Fatal("held records were not emitted when their window ended")
+			}
+		}
+		assert.ElementsMatch(t, []interface{}{"orphan", "shipped", "new"}, statuses)
+		in <- recordsOf(map[string]interface{}{"id": int64(1), "status": "refunded"})[0]
+		close(in)
+		record := <-out
+		status, _ := record.Get("status")
+		assert.Equal(t, "refunded", status)
+		for range out {
+		}
+		assert.Equal(t, []pipeline.Drop{{Rule: "dedup on id of stage latest", Records: 2}}, (<-done).Dropped)
+		t.Logf("%s Held records emitted after their window", greenTick)
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		source := interfaces.Stage{Name: "in", Type: interfaces.StageSource, Integration: "JSON"}
+		stage := func(dedup *interfaces.Dedup) []interfaces.Stage {
+			return []interfaces.Stage{source,
+				{Name: "d", Type: interfaces.StageDedup, Inputs: []string{"in"}, Dedup: dedup},
+				{Name: "out", Type: interfaces.StageSink, Integration: "JSON", Inputs: []string{"d"}},
+			}
+		}
+		cases := map[string]*interfaces.Dedup{
+			"stage d: a dedup needs keys":                                           nil,
+			"stage d: unknown dedup keep \"newest\", expected first, last or merge": {Keys: []string{"id"}, Keep: "newest"},
+			"stage d: invalid dedup window \"1 day\"":                               {Keys: []string{"id"}, Window: "1 day"},
+			"stage d: unknown dedup store \"redis\"":                                {Keys: []string{"id"}, Store: "redis"},
+			"stage d: max_keys bounds the memory store of a dedup only":             {Keys: []string{"id"}, Store: pipeline.DedupFile, MaxKeys: 10},
+		}
+		for message, dedup := range cases {
+			assert.ErrorContains(t, pipeline.ValidateStages(stage(dedup)), message)
+		}
+		t.Logf("%s %d invalid dedups rejected", greenTick, len(cases))
+	})
+}
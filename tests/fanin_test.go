@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/SkySingh04/fractal/factory"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordsOf builds records from field/value pairs, one map per record.
+func recordsOf(rows ...map[string]interface{}) []*interfaces.Record {
+	records := make([]*interfaces.Record, len(rows))
+	for i, row := range rows {
+		records[i] = interfaces.NewRecord()
+		names := make([]string, 0, len(row))
+		for name := range row {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			records[i].Set(name, row[name])
+		}
+	}
+	return records
+}
+
+// combine merges inputs and collects every record of the combined source.
+func combine(inputs []pipeline.Input, merge interfaces.Merge) ([]*interfaces.Record, error) {
+	source, err := pipeline.Combine(inputs, merge)
+	if err != nil {
+		return nil, err
+	}
+	destination := &collectDestination{}
+	err = pipeline.Run(context.Background(), source, destination, interfaces.Request{})
+	return destination.received, err
+}
+
+func TestFanIn(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	users := func() pipeline.Input {
+		return pipeline.Input{Name: "users", Key: "_id", Source: sliceSource{records: recordsOf(
+			map[string]interface{}{"_id": "1", "name": "Ada"},
+			map[string]interface{}{"_id": "2", "name": "Grace"},
+			map[string]interface{}{"_id": nil, "name": "Nobody"},
+		)}}
+	}
+	orders := func() pipeline.Input {
+		return pipeline.Input{Name: "orders", Key: "user_id", Source: sliceSource{records: recordsOf(
+			map[string]interface{}{"user_id": int64(1), "name": "book", "total": 12.5},
+			map[string]interface{}{"user_id": int64(1), "name": "pen", "total": 2.0},
+			map[string]interface{}{"user_id": int64(3), "name": "lamp", "total": 30.0},
+		)}}
+	}
+
+	t.Run("union streams every input", func(t *testing.T) {
+		records, err := combine([]pipeline.Input{users(), orders()}, interfaces.Merge{})
+		assert.NoError(t, err)
+		assert.Len(t, records, 6)
+		t.Logf("%s Union of %d records", greenTick, len(records))
+	})
+
+	t.Run("inner join emits a record per match", func(t *testing.T) {
+		records, err := combine([]pipeline.Input{users(), orders()}, interfaces.Merge{Mode: pipeline.InnerJoin})
+		assert.NoError(t, err)
+		assert.Len(t, records, 2)
+		for _, record := range records {
+			// The key of the match is dropped and its colliding field prefixed
+			assert.Equal(t, []string{"_id", "name", "orders_name", "total"}, record.Names())
+			name, _ := record.Get("name")
+			assert.Equal(t, "Ada", name)
+		}
+		product, _ := records[1].Get("orders_name")
+		assert.Equal(t, "pen", product)
+		t.Logf("%s Inner join produced %d records", greenTick, len(records))
+	})
+
+	t.Run("left join keeps records without a match", func(t *testing.T) {
+		records, err := combine([]pipeline.Input{users(), orders()}, interfaces.Merge{Mode: pipeline.LeftJoin})
+		assert.NoError(t, err)
+		assert.Len(t, records, 4)
+		assert.Equal(t, []string{"_id", "name"}, records[2].Names())
+		name, _ := records[3].Get("name")
+		assert.Equal(t, "Nobody", name)
+		t.Logf("%s Left join produced %d records", greenTick, len(records))
+	})
+
+	t.Run("spills large lookup inputs to disk", func(t *testing.T) {
+		price, _ := interfaces.ParseDecimal("1.50")
+		var people, payments []map[string]interface{}
+		for i := 0; i < 500; i++ {
+			people = append(people, map[string]interface{}{"id": int64(i)})
+			payments = append(payments, map[string]interface{}{"person": fmt.Sprint(i), "amount": price})
+		}
+		inputs := []pipeline.Input{
+			{Name: "people", Key: "id", Source: sliceSource{records: recordsOf(people...)}},
+			{Name: "payments", Key: "person", Source: sliceSource{records: recordsOf(payments...)}},
+		}
+		records, err := combine(inputs, interfaces.Merge{Mode: pipeline.InnerJoin, MaxInMemory: 100, Spill: true})
+		assert.NoError(t, err)
+		assert.Len(t, records, 500)
+		amount, _ := records[0].Get("amount")
+		assert.Equal(t, "1.50", amount.(interfaces.Decimal).String())
+
+		inputs[1].Source = sliceSource{records: recordsOf(payments...)}
+		_, err = combine(inputs, interfaces.Merge{Mode: pipeline.InnerJoin, MaxInMemory: 100})
+		assert.ErrorContains(t, err, "lookup input payments has more than 100 records, raise max_in_memory or enable spill")
+		t.Logf("%s Spilled join produced %d records", greenTick, len(records))
+	})
+
+	t.Run("input failure fails the merge", func(t *testing.T) {
+		failing := pipeline.Input{Name: "broken", Key: "user_id", Source: failingSource{}}
+		_, err := combine([]pipeline.Input{users(), failing}, interfaces.Merge{Mode: pipeline.InnerJoin})
+		assert.ErrorContains(t, err, "input broken: source unavailable")
+
+		_, err = combine([]pipeline.Input{users(), failing}, interfaces.Merge{})
+		assert.ErrorContains(t, err, "input broken: source unavailable")
+		t.Logf("%s Input failure reported", greenTick)
+	})
+
+	t.Run("rejects invalid merges", func(t *testing.T) {
+		_, err := pipeline.Combine([]pipeline.Input{users(), orders()}, interfaces.Merge{Mode: "cross"})
+		assert.ErrorContains(t, err, `unknown merge mode "cross"`)
+
+		_, err = pipeline.Combine([]pipeline.Input{users(), {Name: "orders"}}, interfaces.Merge{Mode: pipeline.LeftJoin})
+		assert.EqualError(t, err, "left_join: input orders has no join key")
+		t.Logf("%s Invalid merges rejected", greenTick)
+	})
+
+	t.Run("creates the inputs of a request", func(t *testing.T) {
+		req := interfaces.Request{
+			Inputs: []interfaces.Input{
+				{Name: "east", Input: "JSON", InputConfig: map[string]interface{}{"data": `[{"id": 1}]`}},
+				{Name: "west", Input: "JSON", InputConfig: map[string]interface{}{"data": `[{"id": 2}, {"id": 3}]`}},
+			},
+		}
+		source, err := factory.CreateInputs(req)
+		assert.NoError(t, err)
+		destination := &collectDestination{}
+		assert.NoError(t, pipeline.Run(context.Background(), source, destination, req))
+		assert.Len(t, destination.received, 3)
+
+		req.Inputs[1].Name = "east"
+		_, err = factory.CreateInputs(req)
+		assert.ErrorContains(t, err, "duplicate input name east")
+		t.Logf("%s Request inputs created", greenTick)
+	})
+}
+
+// failingSource fails without sending a record.
+type failingSource struct{}
+
+func (failingSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	return fmt.Errorf("source unavailable")
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SkySingh04/fractal/factory"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSource streams records and counts how many times it was read.
+type countingSource struct {
+	sliceSource
+	reads *int32
+}
+
+func (c countingSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	atomic.AddInt32(c.reads, 1)
+	return c.sliceSource.FetchData(ctx, req, out)
+}
+
+// renamingDestination changes every record it receives before storing it.
+type renamingDestination struct {
+	collectDestination
+}
+
+func (r *renamingDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil || record == nil {
+			return err
+		}
+		record.Rename("id", "key")
+		r.received = append(r.received, record)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	records := make([]*interfaces.Record, pipeline.BufferSize*3)
+	for i := range records {
+		records[i] = interfaces.NewRecord()
+		records[i].Set("id", i)
+	}
+	targetsFor := func(destinations ...interfaces.DataDestination) []pipeline.Target {
+		targets := make([]pipeline.Target, len(destinations))
+		for i, destination := range destinations {
+			targets[i] = pipeline.Target{Name: string(rune('A' + i)), Destination: destination}
+		}
+		return targets
+	}
+
+	t.Run("reads the source once and writes every output", func(t *testing.T) {
+		var reads int32
+		source := countingSource{sliceSource{records: records}, &reads}
+		first, second := &renamingDestination{}, &collectDestination{}
+
+		result, err := pipeline.FanOut(context.Background(), source, targetsFor(first, second), interfaces.Request{}, "")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), reads)
+		assert.Len(t, first.received, len(records))
+		assert.Len(t, second.received, len(records))
+		// Each output gets its own copy, so renaming in one does not affect the other
+		assert.Equal(t, []string{"key"}, first.received[0].Names())
+		assert.Equal(t, []string{"id"}, second.received[0].Names())
+		assert.Equal(t, len(records), result.Outputs[1].Records)
+		t.Logf("%s Source read once for %d outputs", greenTick, len(result.Outputs))
+	})
+
+	t.Run("all_or_nothing stops every output on the first failure", func(t *testing.T) {
+		failing := &collectDestination{failAfter: 5}
+		healthy := &collectDestination{}
+		source := endlessSource{stopped: make(chan error, 1)}
+
+		result, err := pipeline.FanOut(context.Background(), source, targetsFor(failing, healthy), interfaces.Request{}, pipeline.AllOrNothing)
+		var outputErr *pipeline.OutputError
+		assert.ErrorAs(t, err, &outputErr)
+		assert.EqualError(t, err, "failed to send data to A: destination unavailable")
+		assert.ErrorIs(t, <-source.stopped, context.Canceled)
+		assert.ErrorIs(t, result.Outputs[1].Err, context.Canceled)
+		t.Logf("%s Migration stopped on output failure", greenTick)
+	})
+
+	t.Run("best_effort keeps writing to the healthy outputs", func(t *testing.T) {
+		failing := &collectDestination{failAfter: 5}
+		healthy := &collectDestination{}
+
+		result, err := pipeline.FanOut(context.Background(), sliceSource{records: records}, targetsFor(failing, healthy), interfaces.Request{}, pipeline.BestEffort)
+		assert.NoError(t, err)
+		assert.Len(t, healthy.received, len(records))
+		assert.Len(t, result.Failed(), 1)
+		assert.Equal(t, "A", result.Failed()[0].Name)
+		t.Logf("%s Failed output detached", greenTick)
+	})
+
+	t.Run("best_effort fails when every output fails", func(t *testing.T) {
+		source := endlessSource{stopped: make(chan error, 1)}
+		_, err := pipeline.FanOut(context.Background(), source, targetsFor(&collectDestination{failAfter: 1}, &collectDestination{failAfter: 2}), interfaces.Request{}, pipeline.BestEffort)
+		assert.EqualError(t, err, "failed to send data to A: destination unavailable; B: destination unavailable")
+		assert.ErrorIs(t, <-source.stopped, context.Canceled)
+		t.Logf("%s All outputs failed", greenTick)
+	})
+
+	t.Run("each output can override the policy", func(t *testing.T) {
+		failing := &collectDestination{failAfter: 5}
+		healthy := &collectDestination{}
+		targets := targetsFor(failing, healthy)
+		targets[0].ErrorHandling = "LOG_AND_CONTINUE"
+		result, err := pipeline.FanOut(context.Background(), sliceSource{records: records}, targets, interfaces.Request{}, pipeline.AllOrNothing)
+		assert.NoError(t, err)
+		assert.Len(t, healthy.received, len(records))
+		assert.Equal(t, "A", result.Failed()[0].Name)
+
+		source := endlessSource{stopped: make(chan error, 1)}
+		targets = targetsFor(&collectDestination{}, &collectDestination{failAfter: 5})
+		targets[1].ErrorHandling = pipeline.AllOrNothing
+		result, err = pipeline.FanOut(context.Background(), source, targets, interfaces.Request{}, pipeline.BestEffort)
+		assert.EqualError(t, err, "failed to send data to B: destination unavailable")
+		assert.ErrorIs(t, <-source.stopped, context.Canceled)
+		assert.ErrorIs(t, result.Outputs[0].Err, context.Canceled)
+
+		targets[1].ErrorHandling = "RETRY"
+		_, err = pipeline.FanOut(context.Background(), sliceSource{}, targets, interfaces.Request{}, "")
+		assert.ErrorContains(t, err, `output B: unknown error handling "RETRY"`)
+		t.Logf("%s Output policies applied", greenTick)
+	})
+
+	t.Run("applies the transformation rules of each output", func(t *testing.T) {
+		rows := recordsOf(map[string]interface{}{"id": 1, "name": "a"})
+		first, second := &collectDestination{}, &collectDestination{}
+		targets := targetsFor(first, second)
+		targets[0].Request.TransformationRules = `RENAME("name", "title")`
+		targets[1].Request.TransformationRules = `ADD_FIELD("copy", TRUE)`
+		req := interfaces.Request{TransformationRules: `ADD_FIELD("shared", 1)`, Outputs: make([]interfaces.Output, 2)}
+		_, err := pipeline.FanOut(context.Background(), sliceSource{records: rows}, targets, req, "")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"id", "title", "shared"}, first.received[0].Names())
+		assert.Equal(t, []string{"id", "name", "shared", "copy"}, second.received[0].Names())
+		t.Logf("%s Outputs transformed independently", greenTick)
+	})
+
+	t.Run("counts the records filter rules drop", func(t *testing.T) {
+		rows := recordsOf(
+			map[string]interface{}{"id": 1, "status": "active"},
+			map[string]interface{}{"id": 2, "status": "deleted"},
+			map[string]interface{}{"id": 3, "status": "active"},
+			map[string]interface{}{"id": 4, "status": "deleted"},
+		)
+		first, second := &collectDestination{}, &collectDestination{}
+		targets := targetsFor(first, second)
+		targets[1].Request.TransformationRules = `KEEP_IF FIELD("id") > 1`
+		req := interfaces.Request{ValidationRules: `DROP_IF FIELD("status") == "deleted"`, Outputs: make([]interfaces.Output, 2)}
+		result, err := pipeline.FanOut(context.Background(), sliceSource{records: rows}, targets, req, "")
+		assert.NoError(t, err)
+		assert.Len(t, first.received, 2)
+		assert.Len(t, second.received, 1)
+		assert.Equal(t, []pipeline.Drop{
+			{Rule: "DROP_IF on line 1 of the validation rules", Records: 2},
+			{Rule: "KEEP_IF on line 1 of the transformation rules of output B", Records: 1},
+		}, result.Dropped)
+		t.Logf("%s Dropped %d records", greenTick, len(rows)-len(first.received))
+	})
+
+	t.Run("rejects unknown policies", func(t *testing.T) {
+		_, err := pipeline.FanOut(context.Background(), sliceSource{}, targetsFor(&collectDestination{}), interfaces.Request{}, "sometimes")
+		assert.ErrorContains(t, err, `unknown fan-out policy "sometimes"`)
+		t.Logf("%s Unknown policy rejected", greenTick)
+	})
+}
+
+func TestFanOutRequest(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("single output requests keep working", func(t *testing.T) {
+		req := interfaces.Request{Output: "CSV", OutputConfig: map[string]interface{}{"csv_destination_file_name": "out.csv"}, ErrorHandling: "LOG_AND_CONTINUE"}
+		targets, err := factory.CreateTargets(req)
+		assert.NoError(t, err)
+		assert.Len(t, targets, 1)
+		assert.Equal(t, "CSV", targets[0].Name)
+		assert.Equal(t, "LOG_AND_CONTINUE", targets[0].Request.ErrorHandling)
+		assert.Empty(t, targets[0].ErrorHandling)
+		t.Logf("%s Single output created", greenTick)
+	})
+
+	t.Run("each output gets its own settings", func(t *testing.T) {
+		req := interfaces.Request{
+			Input:               "CSV",
+			TransformationRules: "shared",
+			Outputs: []interfaces.Output{
+				{Name: "archive", Output: "CSV", OutputConfig: map[string]interface{}{"csv_destination_file_name": "a.csv"}, TransformationRules: "archive rules"},
+				{Output: "YAML", OutputConfig: map[string]interface{}{"file_path": "b.yaml"}, ErrorHandling: "STOP_ON_ERROR"},
+			},
+		}
+		targets, err := factory.CreateTargets(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "archive", targets[0].Name)
+		assert.Equal(t, "archive rules", targets[0].Request.TransformationRules)
+		assert.Equal(t, "YAML", targets[1].Name)
+		assert.Equal(t, "YAML", targets[1].Request.Output)
+		assert.Equal(t, "STOP_ON_ERROR", targets[1].Request.ErrorHandling)
+		assert.Equal(t, "STOP_ON_ERROR", targets[1].ErrorHandling)
+		assert.Empty(t, targets[1].Request.Outputs)
+		t.Logf("%s Outputs configured", greenTick)
+	})
+
+	t.Run("rejects invalid outputs before reading the source", func(t *testing.T) {
+		_, err := factory.CreateTargets(interfaces.Request{Outputs: []interfaces.Output{{Output: "YAML"}}})
+		assert.EqualError(t, err, "output YAML: invalid YAML destination config: missing required config: file_path")
+
+		config := map[string]interface{}{"file_path": "b.yaml"}
+		_, err = factory.CreateTargets(interfaces.Request{Outputs: []interfaces.Output{{Output: "YAML", OutputConfig: config}, {Output: "yaml", Name: "YAML", OutputConfig: config}}})
+		assert.ErrorContains(t, err, "duplicate output name YAML")
+		t.Logf("%s Invalid outputs rejected", greenTick)
+	})
+}
@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/SkySingh04/fractal/config"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("prints rules in canonical form", func(t *testing.T) {
+		cases := map[string]string{
+			`field('age')   type(int) range(18,*)`:                                   `FIELD("age") TYPE(INT) RANGE(18, *)`,
+			`FIELD("a")==1 or (FIELD("b")>2 and not FIELD("c") REQUIRED)`:            `FIELD("a") == 1 OR FIELD("b") > 2 AND NOT FIELD("c") REQUIRED`,
+			`(FIELD("a")==1 or FIELD("b")>2) and FIELD("c") in('x',-1)`:              `(FIELD("a") == 1 OR FIELD("b") > 2) AND FIELD("c") IN("x", -1)`,
+			`not (FIELD("a") == 1 and FIELD("b") == 2)`:                              `NOT (FIELD("a") == 1 AND FIELD("b") == 2)`,
+			`FIELD("a") - (1 - 2) * -FIELD("b") > -(3+4) % 2`:                        `FIELD("a") - (1 - 2) * -FIELD("b") > -(3 + 4) % 2`,
+			`FIELD("email") matches(EMAIL_REGEX) matches('^\d+\.\'$')`:               `FIELD("email") MATCHES(EMAIL_REGEX) MATCHES("^\d+\.'$")`,
+			`drop_if lower(FIELD("s")) == 'x'`:                                       `DROP_IF LOWER(FIELD("s")) == "x"`,
+			`rename('a' , 'b')`:                                                      `RENAME("a", "b")`,
+			`map('s', {'A': 'active', 1 : TRUE})`:                                    `MAP("s", {"A": "active", 1: TRUE})`,
+			`if FIELD("a") > 1 then add_field('b', cast(FIELD("c"), decimal(10,2)))`: `IF FIELD("a") > 1 THEN ADD_FIELD("b", CAST(FIELD("c"), DECIMAL(10, 2)))`,
+			`add_field('d', cast(FIELD("d"), date('02/01/2006','UTC')))`:             `ADD_FIELD("d", CAST(FIELD("d"), DATE("02/01/2006", "UTC")))`,
+			`ADD_FIELD("q", 'say "hi" \\')`:                                          `ADD_FIELD("q", "say \"hi\" \\")`,
+			`group by FIELD("a"), lower(FIELD("b")) as 'b', FIELD("c") AS "d"`:       `GROUP BY FIELD("a"), LOWER(FIELD("b")) AS "b", FIELD("c") AS "d"`,
+			`count() as 'n'`: `COUNT() AS "n"`,
+		}
+		for rules, expected := range cases {
+			set, err := language.Parse(rules)
+			assert.NoError(t, err, rules)
+			assert.Equal(t, expected, set.String(), rules)
+
+			// The canonical text parses to the same rules
+			again, err := language.Parse(expected)
+			assert.NoError(t, err, expected)
+			assert.Equal(t, expected, again.String())
+		}
+		t.Logf("%s %d rules printed", greenTick, len(cases))
+	})
+
+	t.Run("prints rules built in code", func(t *testing.T) {
+		rule := &language.If{
+			Condition: &language.Comparison{Op: ">=", Left: &language.FieldRef{Name: "age"}, Right: &language.Literal{Kind: language.LiteralNumber, Value: "18"}},
+			Then:      &language.AddField{Field: "adult", Value: &language.Literal{Kind: language.LiteralBool, Value: "TRUE"}},
+		}
+		text := language.Print(rule)
+		assert.Equal(t, `IF FIELD("age") >= 18 THEN ADD_FIELD("adult", TRUE)`, text)
+		_, err := language.ParseTransformation(text)
+		assert.NoError(t, err)
+		t.Logf("%s Generated rule printed", greenTick)
+	})
+
+	t.Run("formats rule texts keeping comments", func(t *testing.T) {
+		formatted, err := language.Format(`# checks
+field('age')   type(int)   # adults only
+
+
+  FIELD("email") REQUIRED
+FIELD("status") IN(
+  # legacy values
+  'A', 'B'
+)
+# end
+`, language.Validation)
+		assert.NoError(t, err)
+		expected := `# checks
+FIELD("age") TYPE(INT)  # adults only
+
+FIELD("email") REQUIRED
+# legacy values
+FIELD("status") IN("A", "B")
+# end
+`
+		assert.Equal(t, expected, formatted)
+
+		again, err := language.Format(formatted, language.Validation)
+		assert.NoError(t, err)
+		assert.Equal(t, formatted, again)
+
+		_, err = language.Format(`RENAME("a", "b")`, language.Validation)
+		assert.ErrorContains(t, err, "expected a condition, found an operation")
+		t.Logf("%s Rule text formatted", greenTick)
+	})
+
+	t.Run("rewrites the rules of a configuration file", func(t *testing.T) {
+		formatted, err := config.FormatRules([]byte(`# Fractal
+inputMethod: CSV
+validations:
+    - field('age')   type(int)
+    - FIELD("email") REQUIRED
+# rules
+transformations: |
+  add_field('total', FIELD("a")*FIELD("b"))
+
+  rename('a','b')   # legacy name
+
+outputMethod: JSON
+`))
+		assert.NoError(t, err)
+		assert.Equal(t, `# Fractal
+inputMethod: CSV
+validations:
+    - FIELD("age") TYPE(INT)
+    - FIELD("email") REQUIRED
+# rules
+transformations: |
+  ADD_FIELD("total", FIELD("a") * FIELD("b"))
+
+  RENAME("a", "b")  # legacy name
+
+outputMethod: JSON
+`, string(formatted))
+
+		data := []byte("validations: field('a') required\ninputMethod: CSV\n")
+		formatted, err = config.FormatRules(data)
+		assert.NoError(t, err)
+		assert.Equal(t, "validations: FIELD(\"a\") REQUIRED\ninputMethod: CSV\n", string(formatted))
+
+		again, err := config.FormatRules(formatted)
+		assert.NoError(t, err)
+		assert.Equal(t, formatted, again)
+
+		_, err = config.FormatRules([]byte("transformations: FIELD(\"a\") REQURED\n"))
+		assert.EqualError(t, err, "transformations: line 1, column 12: expected a condition or a comparison after FIELD(\"a\"), found REQURED; did you mean REQUIRED?")
+		t.Logf("%s Configuration rules rewritten", greenTick)
+	})
+
+	t.Run("rewrites the rules of outputs and stages", func(t *testing.T) {
+		formatted, err := config.FormatRules([]byte(`outputs:
+  - name: archive
+    outputMethod: CSV
+    transformations: rename('a','b')
+  - outputMethod: JSON
+    transformations:
+      - add_field('x',1)
+pipeline:
+  stages:
+    - name: valid
+      type: filter
+      inputs: [orders]
+      rules: |
+        field('total')   range(0,10000)
+        # large orders
+        field('total') > 0
+    - {name: clean, type: transform, rules: "rename('c','d')"}
+    - name: by_size
+      type: router
+      routes:
+        - when: field('total') > 1000
+          name: large
+    - rules: count() as 'n'
+      type: aggregate
+      name: sum
+`))
+		assert.NoError(t, err)
+		assert.Equal(t, `outputs:
+  - name: archive
+    outputMethod: CSV
+    transformations: RENAME("a", "b")
+  - outputMethod: JSON
+    transformations:
+      - ADD_FIELD("x", 1)
+pipeline:
+  stages:
+    - name: valid
+      type: filter
+      inputs: [orders]
+      rules: |
+        FIELD("total") RANGE(0, 10000)
+        # large orders
+        FIELD("total") > 0
+    - {name: clean, type: transform, rules: "rename('c','d')"}
+    - name: by_size
+      type: router
+      routes:
+        - when: FIELD("total") > 1000
+          name: large
+    - rules: COUNT() AS "n"
+      type: aggregate
+      name: sum
+`, string(formatted))
+
+		again, err := config.FormatRules(formatted)
+		assert.NoError(t, err)
+		assert.Equal(t, formatted, again)
+
+		_, err = config.FormatRules([]byte("pipeline:\n  stages:\n    - name: valid\n      type: filter\n      rules: RENAME(\"a\", \"b\")\n"))
+		assert.ErrorContains(t, err, "pipeline.stages[valid].rules: ")
+		t.Logf("%s Output and stage rules rewritten", greenTick)
+	})
+}
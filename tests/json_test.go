@@ -37,8 +37,10 @@ func TestJSONIntegration(t *testing.T) {
 
 	// Prepare the request object
 	req := interfaces.Request{
-		JSONSourceData:     inputJSON,
-		JSONOutputFilename: outputFileName,
+		Input:        "JSON",
+		Output:       "JSON",
+		InputConfig:  map[string]interface{}{"data": inputJSON},
+		OutputConfig: map[string]interface{}{"filename": outputFileName},
 	}
 
 	// Mock FetchData to ensure it returns valid data
@@ -55,9 +57,9 @@ func TestJSONIntegration(t *testing.T) {
 
 	// Mock SendData to ensure it simulates sending without errors
 	t.Run("Test SendData", func(t *testing.T) {
-		jsonDestination := integrations.JSONDestination{}
+		jsonDestination := integrations.JSONDestination{Filename: outputFileName}
 		// Mocking SendData to simulate sending without errors
-		err := jsonDestination.SendData(expectedOutputJSON, req)
+		err := sendAll(jsonDestination, req, interfaces.RecordFromMap(expectedOutputJSON))
 		if assert.NoError(t, err, "Error sending data to JSON destination") {
 			fmt.Printf("%s SendData passed\n", GreenTick)
 		} else {
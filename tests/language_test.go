@@ -0,0 +1,827 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/integrations"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/SkySingh04/fractal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleParser(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	parse := func(t *testing.T, rules string) *language.RuleSet {
+		set, err := language.Parse(rules)
+		assert.NoError(t, err)
+		return set
+	}
+	condition := func(set *language.RuleSet, i int) language.Expr {
+		return set.Rules[i].(*language.Check).Condition
+	}
+
+	t.Run("AND binds tighter than OR and NOT tighter than AND", func(t *testing.T) {
+		set := parse(t, `FIELD("a") REQUIRED OR NOT FIELD("b") REQUIRED AND FIELD("c") REQUIRED`)
+		or, ok := condition(set, 0).(*language.Logical)
+		assert.True(t, ok)
+		assert.Equal(t, "OR", or.Op)
+		and, ok := or.Right.(*language.Logical)
+		assert.True(t, ok)
+		assert.Equal(t, "AND", and.Op)
+		assert.IsType(t, &language.Not{}, and.Left)
+
+		grouped := parse(t, `(FIELD("a") REQUIRED OR FIELD("b") REQUIRED) and FIELD("c") REQUIRED`)
+		assert.Equal(t, "AND", condition(grouped, 0).(*language.Logical).Op)
+		t.Logf("%s Precedence respected", greenTick)
+	})
+
+	t.Run("chains conditions on a field", func(t *testing.T) {
+		set := parse(t, `FIELD("age") TYPE(INT) RANGE(18, 65)`)
+		test := condition(set, 0).(*language.FieldTest)
+		assert.Equal(t, "age", test.Field.Name)
+		assert.Len(t, test.Conditions, 2)
+		assert.Equal(t, "INT", test.Conditions[0].(*language.TypeCondition).Type)
+		assert.Equal(t, "65", test.Conditions[1].(*language.RangeCondition).Max.Value)
+		t.Logf("%s Conditions chained", greenTick)
+	})
+
+	t.Run("reads one rule per line", func(t *testing.T) {
+		set := parse(t, `
+# Customers
+FIELD("id") REQUIRED
+FIELD("status") IN ("active", 'inactive')
+
+FIELD("email") MATCHES(EMAIL_REGEX) OR (
+    FIELD("phone") MATCHES("^\+\d+$")
+)
+`)
+		assert.Len(t, set.Rules, 3)
+		assert.Equal(t, language.Pos{Line: 3, Column: 1}, set.Rules[0].Pos())
+		assert.Len(t, condition(set, 1).(*language.FieldTest).Conditions[0].(*language.InCondition).Values, 2)
+		assert.Equal(t, `^\+\d+$`, condition(set, 2).(*language.Logical).Right.(*language.FieldTest).Conditions[0].(*language.MatchesCondition).Pattern)
+
+		empty, err := language.Parse("  \n# nothing yet\n")
+		assert.NoError(t, err)
+		assert.Nil(t, empty)
+		t.Logf("%s Read %d rules", greenTick, len(set.Rules))
+	})
+
+	t.Run("reports where rules are invalid", func(t *testing.T) {
+		cases := map[string]string{
+			`FIELD("a") RANGE(1, 2) FIELD("b") REQUIRED`:     `line 1, column 24: expected the end of the rule, found FIELD; write one rule per line`,
+			"FIELD(\"a\") REQUIRED\nFIELD(\"b\") TYPE(TEXT)": `line 2, column 17: unknown type TEXT, expected STRING, INT, FLOAT, BOOL, DATE or DECIMAL`,
+			`FIELD("a") REQUIRED AND`:                        `line 1, column 24: expected FIELD, a value or a parenthesis, found the end of the rules`,
+			`(FIELD("a") REQUIRED`:                           `line 1, column 21: expected a closing parenthesis, found the end of the rules; close the parenthesis opened at line 1, column 1`,
+			`FIELD("a")`:                                     `line 1, column 11: expected a condition or a comparison after FIELD("a"), found the end of the rules`,
+			`FIELD("a") MATCHES("(")`:                        "line 1, column 20: invalid regular expression: error parsing regexp: missing closing ): `(`",
+			`FIELD("a") RANGE(2, 1.5)`:                       `line 1, column 18: the lower bound 2 of RANGE is greater than the upper bound 1.5`,
+			`FIELD("a) REQUIRED`:                             `line 1, column 7: unterminated string; close the string with " before the end of the line`,
+			`FIELD("a") = 1`:                                 `line 1, column 12: unexpected character '='; use == to compare values`,
+			`FIELD("a") + 1`:                                 `line 1, column 15: expected a comparison after FIELD("a") + 1, found the end of the rules`,
+			`FIELD("a") > 1 > 2`:                             `line 1, column 16: comparisons cannot be chained; combine them with AND`,
+			`FIELD("a") REQUIRED + 1 > 2`:                    `line 1, column 1: expected a value, found a condition`,
+		}
+		for rules, message := range cases {
+			_, err := language.Parse(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		t.Logf("%s %d invalid rules rejected", greenTick, len(cases))
+	})
+
+	t.Run("parses comparisons and arithmetic", func(t *testing.T) {
+		set := parse(t, `NOT FIELD("price") * (FIELD("qty") - 1) <= -FIELD("limit") % 7 AND FIELD("a") > -1`)
+		and := condition(set, 0).(*language.Logical)
+		comparison := and.Left.(*language.Not).X.(*language.Comparison)
+		assert.Equal(t, "<=", comparison.Op)
+		product := comparison.Left.(*language.Arithmetic)
+		assert.Equal(t, "*", product.Op)
+		assert.Equal(t, "-", product.Right.(*language.Arithmetic).Op)
+		remainder := comparison.Right.(*language.Arithmetic)
+		assert.Equal(t, "%", remainder.Op)
+		assert.IsType(t, &language.Negate{}, remainder.Left)
+		assert.Equal(t, "-1", and.Right.(*language.Comparison).Right.(*language.Literal).Value)
+
+		bounds := parse(t, `FIELD("t") RANGE(-10, -1) IN (-5, "x")`)
+		assert.Equal(t, "-10", condition(bounds, 0).(*language.FieldTest).Conditions[0].(*language.RangeCondition).Min.Value)
+		t.Logf("%s Operators parsed with their precedence", greenTick)
+	})
+
+	t.Run("compares values and fields", func(t *testing.T) {
+		set := parse(t, "FIELD(\"price\") * FIELD(\"qty\") <= 10000\nFIELD(\"end\") > FIELD(\"start\")\nFIELD(\"status\") != \"deleted\"")
+		record := func(price, qty interface{}, start, end string) *interfaces.Record {
+			return interfaces.RecordFromMap(map[string]interface{}{"price": price, "qty": qty, "start": start, "end": end, "status": "active"})
+		}
+		assert.NoError(t, language.Validate(record("2500", int64(4), "2024-01-01", "2024-02-01"), set))
+		assert.NoError(t, language.Validate(record(99.5, "3", "9", "10"), set))
+		assert.EqualError(t, language.Validate(record("2500.5", int64(4), "1", "2"), set), `rule on line 1: FIELD("price") * FIELD("qty") <= 10000 is false for 10002 <= 10000`)
+		assert.EqualError(t, language.Validate(record(1, 1, "2024-03-01", "2024-02-01"), set), `rule on line 2: FIELD("end") > FIELD("start") is false for "2024-02-01" > "2024-03-01"`)
+		assert.EqualError(t, language.Validate(record("a lot", 1, "1", "2"), set), `rule on line 1: FIELD("price") is 'a lot', not a number`)
+		assert.EqualError(t, language.Validate(interfaces.RecordFromMap(map[string]interface{}{"qty": 1}), set), `rule on line 1: FIELD("price") * FIELD("qty") <= 10000 is false for NULL <= 10000`)
+
+		division := parse(t, `FIELD("a") / FIELD("b") == 2.5 AND FIELD("a") % FIELD("b") == 1`)
+		assert.NoError(t, language.Validate(interfaces.RecordFromMap(map[string]interface{}{"a": 5, "b": 2}), division))
+		assert.EqualError(t, language.Validate(interfaces.RecordFromMap(map[string]interface{}{"a": 5, "b": 0}), division), `rule on line 1: FIELD("a") / FIELD("b"): division by zero`)
+		t.Logf("%s Comparisons evaluated", greenTick)
+	})
+
+	t.Run("validates records", func(t *testing.T) {
+		set := parse(t, "FIELD(\"age\") TYPE(INT) RANGE(18, 65)\nNOT FIELD(\"status\") IN (\"deleted\") OR FIELD(\"admin\") IN (\"true\")")
+		record := func(age, status, admin string) *interfaces.Record {
+			return interfaces.RecordFromMap(map[string]interface{}{"age": age, "status": status, "admin": admin})
+		}
+		assert.NoError(t, language.Validate(record("30", "active", "false"), set))
+		assert.NoError(t, language.Validate(record("30", "deleted", "true"), set))
+		assert.EqualError(t, language.Validate(record("70", "active", "false"), set), "rule on line 1: field age: value '70' not in range")
+		assert.EqualError(t, language.Validate(record("thirty", "active", "false"), set), "rule on line 1: field age: value 'thirty' is not an integer")
+		assert.EqualError(t, language.Validate(record("30", "deleted", "false"), set), "rule on line 2: NOT condition failed, and field admin: value 'false' not in allowed list")
+		t.Logf("%s Records validated", greenTick)
+	})
+}
+
+func TestTransformations(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	transform := func(t *testing.T, rules string, fields map[string]interface{}) *interfaces.Record {
+		set, err := language.ParseTransformation(rules)
+		assert.NoError(t, err)
+		record, err := language.Transform(interfaces.RecordFromMap(fields), set)
+		assert.NoError(t, err)
+		return record
+	}
+
+	t.Run("parses operations", func(t *testing.T) {
+		set, err := language.ParseTransformation(`
+RENAME("old_field", "new_field")
+MAP("status", {"active": 1, 'inactive': 0})
+IF FIELD("age") RANGE(51, 200) THEN ADD_FIELD("senior", TRUE)
+`)
+		assert.NoError(t, err)
+		assert.Len(t, set.Rules, 3)
+		assert.Equal(t, "new_field", set.Rules[0].(*language.Rename).To)
+		assert.Len(t, set.Rules[1].(*language.Map).Entries, 2)
+		assert.Equal(t, "senior", set.Rules[2].(*language.If).Then.(*language.AddField).Field)
+		t.Logf("%s Operations parsed", greenTick)
+	})
+
+	t.Run("applies operations in order", func(t *testing.T) {
+		record := transform(t, `
+RENAME("state", "status")
+MAP("status", {"active": 1, "inactive": 0})
+ADD_FIELD("copy", FIELD("status"))
+ADD_FIELD("source", "csv")
+IF FIELD("age") > 50 THEN ADD_FIELD("senior", TRUE)
+IF FIELD("age") <= 50 THEN RENAME("age", "young")
+ADD_FIELD("next", FIELD("age") + 1)
+`, map[string]interface{}{"age": "60", "state": "inactive"})
+		assert.Equal(t, []string{"age", "status", "copy", "source", "senior", "next"}, record.Names())
+		status, _ := record.Get("status")
+		copied, _ := record.Get("copy")
+		senior, _ := record.Get("senior")
+		assert.Equal(t, int64(0), status)
+		assert.Equal(t, int64(0), copied)
+		next, _ := record.Get("next")
+		assert.Equal(t, true, senior)
+		assert.Equal(t, int64(61), next)
+		t.Logf("%s Operations applied in order", greenTick)
+	})
+
+	t.Run("keeps integer results past int64 as decimals", func(t *testing.T) {
+		record := transform(t, `
+ADD_FIELD("sum", FIELD("max") + 1)
+ADD_FIELD("difference", 0 - FIELD("max") - 2)
+ADD_FIELD("product", FIELD("max") * 2)
+ADD_FIELD("small", FIELD("max") - 1)
+`, map[string]interface{}{"max": int64(math.MaxInt64)})
+		for name, want := range map[string]string{"sum": "9223372036854775808", "difference": "-9223372036854775809", "product": "18446744073709551614"} {
+			value, _ := record.Get(name)
+			assert.IsType(t, interfaces.Decimal{}, value, name)
+			assert.Equal(t, want, interfaces.ValueString(value), name)
+		}
+		small, _ := record.Get("small")
+		assert.Equal(t, int64(math.MaxInt64-1), small)
+		t.Logf("%s Overflowing integer arithmetic kept exact", greenTick)
+	})
+
+	t.Run("keeps values MAP does not know", func(t *testing.T) {
+		record := transform(t, `MAP("code", {1: "one", "x": NULL})`, map[string]interface{}{"code": "1.0"})
+		code, _ := record.Get("code")
+		assert.Equal(t, "one", code)
+
+		record = transform(t, `MAP("code", {1: "one"})`, map[string]interface{}{"code": "2"})
+		code, _ = record.Get("code")
+		assert.Equal(t, "2", code)
+
+		record = transform(t, `MAP("missing", {1: "one"})`, map[string]interface{}{"code": "1"})
+		assert.Equal(t, []string{"code"}, record.Names())
+		t.Logf("%s Unmapped values kept", greenTick)
+	})
+
+	t.Run("uses one time for a record", func(t *testing.T) {
+		record := transform(t, "ADD_FIELD(\"created\", CURRENT_TIME())\nADD_FIELD(\"updated\", CURRENT_TIME())", map[string]interface{}{})
+		created, _ := record.Get("created")
+		updated, _ := record.Get("updated")
+		assert.IsType(t, time.Time{}, created)
+		assert.Equal(t, created, updated)
+		t.Logf("%s CURRENT_TIME is stable", greenTick)
+	})
+
+	t.Run("rejects invalid operations", func(t *testing.T) {
+		cases := map[string]string{
+			`RENAME("a")`:                              `line 1, column 11: expected "," after the field name of RENAME, found )`,
+			`MAP("a", {"x": 1, "x": 2})`:               `line 1, column 19: duplicate key "x" in mapping`,
+			`ADD_FIELD("a", TODAY())`:                  `line 1, column 16: unknown function TODAY`,
+			`ADD_FIELD("a", CURRENT_TIME(1))`:          `line 1, column 16: CURRENT_TIME takes no arguments, found 1`,
+			`IF FIELD("a") REQUIRED ADD_FIELD("b", 1)`: `line 1, column 24: expected THEN after the condition of IF, found ADD_FIELD`,
+			`FIELD("a") REQUIRED`:                      `line 1, column 1: expected RENAME, MAP, ADD_FIELD or IF, found a condition; conditions belong in validation rules`,
+		}
+		for rules, message := range cases {
+			_, err := language.ParseTransformation(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		_, err := language.ParseValidation(`RENAME("a", "b")`)
+		assert.EqualError(t, err, "line 1, column 1: expected a condition, found an operation; operations belong in transformation rules")
+		t.Logf("%s %d invalid operations rejected", greenTick, len(cases)+1)
+	})
+}
+
+func TestFunctions(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	transform := func(t *testing.T, rules string, fields map[string]interface{}) *interfaces.Record {
+		set, err := language.ParseTransformation(rules)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		record, err := language.Transform(interfaces.RecordFromMap(fields), set)
+		assert.NoError(t, err)
+		return record
+	}
+	get := func(record *interfaces.Record, name string) interface{} {
+		value, _ := record.Get(name)
+		return value
+	}
+
+	t.Run("calls the built-in functions", func(t *testing.T) {
+		record := transform(t, `
+ADD_FIELD("email", LOWER(TRIM(FIELD("email"))))
+ADD_FIELD("label", CONCAT(FIELD("name"), " #", FIELD("id"), FIELD("missing")))
+ADD_FIELD("initials", SUBSTR(FIELD("name"), 1, 2))
+ADD_FIELD("last", SUBSTR(FIELD("name"), -3))
+ADD_FIELD("nickname", COALESCE(FIELD("nickname"), FIELD("missing"), FIELD("name")))
+ADD_FIELD("hash", HASH_SHA256("abc"))
+ADD_FIELD("born", PARSE_DATE(FIELD("born"), "02/01/2006"))
+ADD_FIELD("joined", PARSE_DATE("2024-03-01"))
+ADD_FIELD("id", TO_INT(FIELD("id")) + 1)
+ADD_FIELD("price", ROUND(FIELD("price"), 1))
+ADD_FIELD("whole", ROUND(-2.5))
+ADD_FIELD("key", UUID())
+ADD_FIELD("at", NOW())
+`, map[string]interface{}{"email": "  Ada@Example.COM ", "name": "Ada Lovelace", "nickname": "", "id": "41.9", "price": "19.96", "born": "10/12/1815"})
+
+		assert.Equal(t, "ada@example.com", get(record, "email"))
+		assert.Equal(t, "Ada Lovelace #41.9", get(record, "label"))
+		assert.Equal(t, "Ad", get(record, "initials"))
+		assert.Equal(t, "ace", get(record, "last"))
+		assert.Equal(t, "Ada Lovelace", get(record, "nickname"))
+		assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", get(record, "hash"))
+		assert.Equal(t, time.Date(1815, 12, 10, 0, 0, 0, 0, time.UTC), get(record, "born"))
+		assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), get(record, "joined"))
+		assert.Equal(t, int64(42), get(record, "id"))
+		assert.Equal(t, 20.0, get(record, "price"))
+		assert.Equal(t, -3.0, get(record, "whole"))
+		assert.Len(t, get(record, "key"), 36)
+		assert.IsType(t, time.Time{}, get(record, "at"))
+
+		nulls := transform(t, `ADD_FIELD("lower", LOWER(FIELD("missing")))`, map[string]interface{}{})
+		lower, exists := nulls.Get("lower")
+		assert.True(t, exists)
+		assert.Nil(t, lower)
+		t.Logf("%s Built-in functions called", greenTick)
+	})
+
+	t.Run("checks calls when parsing", func(t *testing.T) {
+		cases := map[string]string{
+			`ADD_FIELD("a", LOWER())`:                  `line 1, column 16: LOWER takes 1 argument, found 0`,
+			`ADD_FIELD("a", SUBSTR("abc"))`:            `line 1, column 16: SUBSTR takes 2 or 3 arguments, found 1`,
+			`ADD_FIELD("a", CONCAT())`:                 `line 1, column 16: CONCAT takes at least 1 argument, found 0`,
+			`ADD_FIELD("a", LOWER(5))`:                 `line 1, column 22: argument 1 of LOWER must be a STRING, found 5, an INT`,
+			`ADD_FIELD("a", SUBSTR("abc", 1.5))`:       `line 1, column 30: argument 2 of SUBSTR must be an INT, found 1.5, a FLOAT`,
+			`ADD_FIELD("a", ROUND(LOWER(FIELD("x"))))`: `line 1, column 22: argument 1 of ROUND must be a FLOAT, found LOWER(FIELD("x")), a STRING`,
+			`ADD_FIELD("a", HASH_SHA256(TRUE))`:        `line 1, column 28: argument 1 of HASH_SHA256 must be a STRING, found TRUE, a BOOL`,
+		}
+		for rules, message := range cases {
+			_, err := language.ParseTransformation(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		t.Logf("%s %d invalid calls rejected", greenTick, len(cases))
+	})
+
+	t.Run("reports values of the wrong type", func(t *testing.T) {
+		set, err := language.ParseTransformation(`ADD_FIELD("a", ROUND(FIELD("price")))`)
+		assert.NoError(t, err)
+		_, err = language.Transform(interfaces.RecordFromMap(map[string]interface{}{"price": "free"}), set)
+		assert.EqualError(t, err, "rule on line 1: argument 1 of ROUND is 'free', not a FLOAT")
+
+		set, err = language.ParseTransformation(`ADD_FIELD("a", PARSE_DATE(FIELD("day")))`)
+		assert.NoError(t, err)
+		_, err = language.Transform(interfaces.RecordFromMap(map[string]interface{}{"day": "someday"}), set)
+		assert.EqualError(t, err, `rule on line 1: PARSE_DATE(FIELD("day")): 'someday' is not a date`)
+		t.Logf("%s Wrong values reported", greenTick)
+	})
+
+	t.Run("calls registered Go functions", func(t *testing.T) {
+		language.RegisterFunction(language.Function{
+			Name:   "mask",
+			Args:   []language.Type{language.TypeString, language.TypeInt},
+			Result: language.TypeString,
+			Call: func(args []interface{}) (interface{}, error) {
+				s, keep := args[0].(string), int(args[1].(int64))
+				if keep > len(s) {
+					keep = len(s)
+				}
+				return strings.Repeat("*", len(s)-keep) + s[len(s)-keep:], nil
+			},
+		})
+		t.Cleanup(func() { language.UnregisterFunction("mask") })
+		record := transform(t, `ADD_FIELD("card", MASK(FIELD("card"), 4))`, map[string]interface{}{"card": "4111111111111111"})
+		assert.Equal(t, "************1111", get(record, "card"))
+
+		set, err := language.ParseValidation(`Mask(FIELD("card"), 2) == "**11"`)
+		assert.NoError(t, err)
+		assert.NoError(t, language.Validate(interfaces.RecordFromMap(map[string]interface{}{"card": 1211}), set))
+
+		err = language.AddFunction(language.Function{Name: "MASK", Call: func([]interface{}) (interface{}, error) { return nil, nil }})
+		assert.ErrorIs(t, err, registry.ErrDuplicate)
+		assert.EqualError(t, language.AddFunction(language.Function{Name: "if", Call: func([]interface{}) (interface{}, error) { return nil, nil }}), "function if: IF is a reserved word")
+		assert.EqualError(t, language.AddFunction(language.Function{Name: "NOOP"}), "function NOOP has no Call")
+
+		var names []string
+		for _, f := range language.Functions() {
+			names = append(names, f.Name)
+		}
+		assert.Contains(t, names, "MASK")
+		assert.Contains(t, names, "ROUND")
+
+		assert.True(t, language.UnregisterFunction("Mask"))
+		assert.False(t, language.UnregisterFunction("MASK"))
+		_, err = language.ParseTransformation(`ADD_FIELD("card", MASK(FIELD("card"), 3))`)
+		assert.EqualError(t, err, "line 1, column 19: unknown function MASK")
+		t.Logf("%s Registered function called", greenTick)
+	})
+}
+
+func TestFieldPaths(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	document := func(t *testing.T) *interfaces.Record {
+		value, err := interfaces.ParseJSON([]byte(`{
+			"id": 7,
+			"user": {"name": "Ada", "age": 36, "address": {"city": "London", "id": 70}},
+			"items": [{"sku": "a-1", "price": 20, "id": 71}, {"sku": "b-2", "price": 45, "id": 72}],
+			"user.age": "flat"
+		}`))
+		assert.NoError(t, err)
+		return value.(*interfaces.Record)
+	}
+	get := func(t *testing.T, path string, record *interfaces.Record) []interface{} {
+		p, err := language.ParsePath(path)
+		assert.NoError(t, err)
+		return p.Get(record)
+	}
+
+	t.Run("selects nested values", func(t *testing.T) {
+		record := document(t)
+		assert.Equal(t, []interface{}{int64(36)}, get(t, "$.user.age", record))
+		assert.Equal(t, []interface{}{"flat"}, get(t, "user.age", record))
+		assert.Equal(t, []interface{}{"London"}, get(t, "$['user'].address.city", record))
+		assert.Equal(t, []interface{}{"b-2"}, get(t, "$.items[1].sku", record))
+		assert.Equal(t, []interface{}{"b-2"}, get(t, "items[-1].sku", record))
+		assert.Equal(t, []interface{}{int64(20), int64(45)}, get(t, "$.items[*].price", record))
+		assert.Equal(t, []interface{}{int64(7), int64(70), int64(71), int64(72)}, get(t, "$..id", record))
+		assert.Empty(t, get(t, "$.items[5].sku", record))
+		assert.Empty(t, get(t, "$.user.name.first", record))
+		t.Logf("%s Paths resolved", greenTick)
+	})
+
+	t.Run("rejects invalid paths", func(t *testing.T) {
+		cases := map[string]string{
+			`FIELD("$") REQUIRED`:               `line 1, column 7: path "$" selects the whole record, name a field after $`,
+			`FIELD("$.items[x]") REQUIRED`:      `line 1, column 7: path "$.items[x]": expected an index, * or a quoted name in brackets, found "x"`,
+			`FIELD("$.items[0") REQUIRED`:       `line 1, column 7: path "$.items[0": missing ]`,
+			`RENAME("$.items[0]", "first")`:     `line 1, column 8: RENAME needs a path ending with a field name, found "$.items[0]"`,
+			`RENAME("$.items[*].sku", "$.sku")`: `line 1, column 26: RENAME from a path with wildcards can only give the fields a new name, not move them to "$.sku"`,
+			`FIELD("a..") REQUIRED`:             `line 1, column 7: path "a..": expected a field name after "a.."`,
+		}
+		for rules, message := range cases {
+			_, err := language.Parse(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		t.Logf("%s %d invalid paths rejected", greenTick, len(cases))
+	})
+
+	t.Run("validates nested values", func(t *testing.T) {
+		set, err := language.ParseValidation(`
+FIELD("$.user.age") TYPE(INT) RANGE(18, 65)
+FIELD("$.items[*].price") RANGE(1, 100)
+FIELD("$.items[0].price") < FIELD("$.items[1].price")
+`)
+		assert.NoError(t, err)
+		record := document(t)
+		assert.NoError(t, language.Validate(record, set))
+
+		items, _ := record.Get("items")
+		items.([]interface{})[1].(*interfaces.Record).Set("price", 450)
+		assert.EqualError(t, language.Validate(record, set), "rule on line 3: field $.items[*].price: value '450' not in range")
+		t.Logf("%s Nested values validated", greenTick)
+	})
+
+	t.Run("transforms nested values", func(t *testing.T) {
+		set, err := language.ParseTransformation(`
+RENAME("$.user.name", "full_name")
+RENAME("$.user.address.city", "$.city")
+RENAME("$.items[*].sku", "code")
+MAP("$.items[*].code", {"a-1": "A1"})
+ADD_FIELD("$.user.address.country", "UK")
+ADD_FIELD("$.meta.source", "json")
+ADD_FIELD("prices", FIELD("$.items[*].price"))
+`)
+		assert.NoError(t, err)
+		record, err := language.Transform(document(t), set)
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{"Ada"}, get(t, "$.user.full_name", record))
+		assert.Equal(t, []interface{}{"London"}, get(t, "$.city", record))
+		assert.Equal(t, []interface{}{"UK"}, get(t, "$.user.address.country", record))
+		assert.Equal(t, []interface{}{"A1", "b-2"}, get(t, "$.items[*].code", record))
+		assert.Equal(t, []interface{}{"json"}, get(t, "$.meta.source", record))
+		assert.Equal(t, []interface{}{[]interface{}{int64(20), int64(45)}}, get(t, "prices", record))
+
+		set, err = language.ParseTransformation(`ADD_FIELD("$.items[3].sku", "x")`)
+		assert.NoError(t, err)
+		_, err = language.Transform(document(t), set)
+		assert.EqualError(t, err, "rule on line 1: cannot set $.items[3].sku: $.items[3] does not exist")
+		t.Logf("%s Nested values transformed", greenTick)
+	})
+
+	t.Run("applies to document sources", func(t *testing.T) {
+		source := integrations.JSONSource{Data: `[{"user": {"age": 30}}, {"user": {"age": 16}}]`}
+		req := interfaces.Request{ValidationRules: `FIELD("$.user.age") >= 18`}
+		err := pipeline.Run(context.Background(), source, &collectDestination{}, req)
+		assert.EqualError(t, err, `failed to fetch data from source: record failed validation: rule on line 1: FIELD("$.user.age") >= 18 is false for 16 >= 18`)
+		t.Logf("%s JSON documents validated", greenTick)
+	})
+}
+
+func TestCompiledRules(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+	rules := "FIELD(\"id\") TYPE(INT) RANGE(0, 1000000)\nFIELD(\"email\") MATCHES(EMAIL_REGEX)\nFIELD(\"price\") * FIELD(\"qty\") <= 10000"
+
+	t.Run("compiles each text once", func(t *testing.T) {
+		first, err := language.CompileValidation(rules)
+		assert.NoError(t, err)
+		second, err := language.CompileValidation(rules)
+		assert.NoError(t, err)
+		assert.Same(t, first, second)
+
+		_, err = language.CompileTransformation(rules)
+		assert.ErrorContains(t, err, "conditions belong in validation rules")
+		t.Logf("%s Compiled rules reused", greenTick)
+	})
+
+	t.Run("is shared by concurrent workers", func(t *testing.T) {
+		set, err := language.CompileValidation(rules)
+		assert.NoError(t, err)
+		errs := make(chan error, 8)
+		for worker := 0; worker < 8; worker++ {
+			go func() {
+				for i := 0; i < 1000; i++ {
+					// Alternate layouts so the field positions remembered by the rules change
+					fields := []interfaces.Field{{Name: "id", Value: int64(i)}, {Name: "email", Value: "a@b.co"}, {Name: "price", Value: "2"}, {Name: "qty", Value: int64(worker)}}
+					if i%2 == 1 {
+						fields[0], fields[3] = fields[3], fields[0]
+					}
+					if err := language.Validate(&interfaces.Record{Fields: fields}, set); err != nil {
+						errs <- err
+						return
+					}
+				}
+				errs <- nil
+			}()
+		}
+		for worker := 0; worker < 8; worker++ {
+			assert.NoError(t, <-errs)
+		}
+		t.Logf("%s Rules evaluated concurrently", greenTick)
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("reports every error of a rule text", func(t *testing.T) {
+		rules := `FIELD("age") REQURED
+FIELD("name") = "x"
+FIELD("ok") REQUIRED
+RENAME("a", "b")
+(FIELD("a") REQUIRED
+  AND FIELD("b) REQUIRED
+FIELD("email") MATCHES(EMAIL_REGX)`
+		diagnostics := language.Diagnose(rules, language.Validation)
+		var lines []int
+		for _, d := range diagnostics {
+			lines = append(lines, d.Pos.Line)
+		}
+		assert.Equal(t, []int{1, 2, 4, 6, 7}, lines)
+
+		misspelt := diagnostics[0]
+		assert.Equal(t, language.Pos{Line: 1, Column: 14}, misspelt.Pos)
+		assert.Equal(t, "REQURED", misspelt.Token)
+		assert.Contains(t, misspelt.Expected, "REQUIRED")
+		assert.Equal(t, "did you mean REQUIRED?", misspelt.Suggestion)
+		assert.Equal(t, "use == to compare values", diagnostics[1].Suggestion)
+		assert.Equal(t, "operations belong in transformation rules", diagnostics[2].Suggestion)
+		assert.Equal(t, "unterminated string", diagnostics[3].Message)
+		assert.Equal(t, "did you mean EMAIL_REGEX?", diagnostics[4].Suggestion)
+
+		assert.Empty(t, language.Diagnose(`FIELD("age") REQUIRED`, language.Validation))
+		assert.Len(t, language.Diagnose(`FIELD("age") REQUIRED`, language.Transformation), 1)
+		t.Logf("%s Reported %d errors", greenTick, len(diagnostics))
+	})
+
+	t.Run("suggests fixes", func(t *testing.T) {
+		cases := map[string]string{
+			`FIELD("a") TYPE(STRNG)`:                     "did you mean STRING?",
+			`ADD_FIELD("a", CURENT_TIME())`:              "did you mean CURRENT_TIME?",
+			"FIELD(\"a\"\n  REQUIRED":                    "close the parenthesis opened at line 1, column 6",
+			`MAP("a", {"x": 1)`:                          "close the mapping opened at line 1, column 10",
+			`FIELD("a") REQUIRED FIELD("b")`:             "write one rule per line",
+			`FIELD("a") REQUIRED && FIELD("b") REQUIRED`: "use AND to combine conditions",
+		}
+		for rules, suggestion := range cases {
+			diagnostics := language.Diagnose(rules, "")
+			if assert.Len(t, diagnostics, 1, rules) {
+				assert.Equal(t, suggestion, diagnostics[0].Suggestion, rules)
+			}
+		}
+		t.Logf("%s %d fixes suggested", greenTick, len(cases))
+	})
+
+	t.Run("encodes diagnostics as JSON", func(t *testing.T) {
+		data, err := json.Marshal(language.Diagnose(`FIELD("a") > `, language.Validation))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[{"position": {"line": 1, "column": 14}, "message": "expected FIELD, a value or a parenthesis, found the end of the rules", "token": "the end of the rules", "expected": ["FIELD", "a value", "("]}]`, string(data))
+		t.Logf("%s Diagnostics encoded", greenTick)
+	})
+}
+
+func TestFilters(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	record := func(fields map[string]interface{}) *interfaces.Record {
+		return recordsOf(fields)[0]
+	}
+
+	t.Run("drops records instead of failing them", func(t *testing.T) {
+		set, err := language.ParseValidation("FIELD(\"id\") REQUIRED\nDROP_IF FIELD(\"status\") == \"deleted\"\nFIELD(\"id\") TYPE(INT)")
+		assert.NoError(t, err)
+		assert.NoError(t, language.Validate(record(map[string]interface{}{"id": 1, "status": "active"}), set))
+
+		err = language.Validate(record(map[string]interface{}{"id": "x", "status": "deleted"}), set)
+		var drop *language.DropError
+		assert.ErrorAs(t, err, &drop)
+		assert.EqualError(t, err, "record dropped by DROP_IF on line 2")
+
+		// Rules above the filter still fail the records they reject
+		err = language.Validate(record(map[string]interface{}{"status": "deleted"}), set)
+		assert.NotErrorAs(t, err, &drop)
+		t.Logf("%s Deleted records dropped", greenTick)
+	})
+
+	t.Run("keeps the records a KEEP_IF condition holds for", func(t *testing.T) {
+		set, err := language.ParseTransformation("RENAME(\"state\", \"status\")\nKEEP_IF FIELD(\"status\") IN(\"active\", \"pending\")\nADD_FIELD(\"kept\", TRUE)")
+		assert.NoError(t, err)
+		kept, err := language.Transform(record(map[string]interface{}{"state": "pending"}), set)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"status", "kept"}, kept.Names())
+
+		var drop *language.DropError
+		_, err = language.Transform(record(map[string]interface{}{"state": "closed"}), set)
+		assert.ErrorAs(t, err, &drop)
+		_, err = language.Transform(record(map[string]interface{}{"other": 1}), set)
+		assert.ErrorAs(t, err, &drop, "a missing field does not satisfy KEEP_IF")
+		t.Logf("%s Other records dropped", greenTick)
+	})
+
+	t.Run("are rules of their own", func(t *testing.T) {
+		assert.Empty(t, language.Diagnose(`KEEP_IF FIELD("a") REQUIRED`, language.Validation))
+		assert.Empty(t, language.Diagnose(`KEEP_IF FIELD("a") REQUIRED`, language.Transformation))
+
+		cases := map[string]string{
+			`DROP_IF`: "line 1, column 8: expected FIELD, a value or a parenthesis, found the end of the rules",
+			`IF FIELD("a") REQUIRED THEN DROP_IF TRUE`: "line 1, column 29: expected RENAME, MAP, ADD_FIELD or IF, found DROP_IF",
+			`FIELD("a") REQUIRED DROP_IF TRUE`:         "line 1, column 21: expected the end of the rule, found DROP_IF; write one rule per line",
+		}
+		for rules, message := range cases {
+			_, err := language.Parse(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		t.Logf("%s %d misplaced filters rejected", greenTick, len(cases))
+	})
+}
+
+func TestAggregationRules(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("parses GROUP BY and aggregates", func(t *testing.T) {
+		set, err := language.ParseAggregation(`DROP_IF FIELD("status") == "cancelled"
+GROUP BY FIELD("region"), SUBSTR(FIELD("created_at"), 1, 10) AS "day"
+SUM(FIELD("total")) AS "revenue"
+COUNT() AS "orders"
+DISTINCT_COUNT(FIELD("customer")) AS "customers"`)
+		assert.NoError(t, err)
+		assert.Len(t, set.Rules, 5)
+		group := set.Rules[1].(*language.GroupBy)
+		assert.Equal(t, "region", group.Keys[0].Name)
+		assert.Equal(t, "day", group.Keys[1].Name)
+		count := set.Rules[3].(*language.Aggregate)
+		assert.Equal(t, "COUNT", count.Function)
+		assert.Nil(t, count.Value)
+
+		day, err := language.Evaluate(recordsOf(map[string]interface{}{"created_at": "2024-05-01T10:00:00Z"})[0], group.Keys[1].Value)
+		assert.NoError(t, err)
+		assert.Equal(t, "2024-05-01", day)
+		t.Logf("%s Parsed %d aggregation rules", greenTick, len(set.Rules))
+	})
+
+	t.Run("rejects invalid aggregation rules", func(t *testing.T) {
+		cases := map[string]string{
+			`GROUP BY LOWER(FIELD("a"))`:                          `line 1, column 27: expected AS and a name for LOWER(FIELD("a")), found the end of the rules`,
+			`SUM(FIELD("a"))`:                                     `line 1, column 16: expected AS and a name for SUM, found the end of the rules`,
+			`SUM() AS "a"`:                                        `line 1, column 5: expected FIELD, a value or a parenthesis, found )`,
+			"GROUP BY FIELD(\"a\")\nGROUP BY FIELD(\"b\")":        `line 2, column 1: GROUP BY is already on line 1; list every key in one GROUP BY`,
+			"GROUP BY FIELD(\"a\")\nCOUNT() AS \"a\"":             `line 2, column 1: duplicate name "a" in aggregation rules`,
+			`FIELD("a") REQUIRED`:                                 `line 1, column 1: expected GROUP BY or an aggregate, found a condition; conditions belong in validation rules`,
+			`RENAME("a", "b")`:                                    `line 1, column 1: expected GROUP BY or an aggregate, found an operation; operations belong in transformation rules`,
+			`IF FIELD("a") REQUIRED THEN ADD_FIELD("n", COUNT())`: `line 1, column 44: expected FIELD, a value or a parenthesis, found COUNT; COUNT is an aggregate, which belongs in aggregation rules`,
+		}
+		for rules, message := range cases {
+			_, err := language.ParseAggregation(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		_, err := language.ParseValidation(`COUNT() AS "n"`)
+		assert.EqualError(t, err, "line 1, column 1: expected a condition, found an aggregate; GROUP BY and aggregates belong in aggregation rules")
+		t.Logf("%s %d invalid rules rejected", greenTick, len(cases)+1)
+	})
+}
+
+func TestTypes(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	transform := func(t *testing.T, rules string, fields map[string]interface{}) (*interfaces.Record, error) {
+		set, err := language.ParseTransformation(rules)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return language.Transform(interfaces.RecordFromMap(fields), set)
+	}
+	get := func(record *interfaces.Record, name string) interface{} {
+		value, _ := record.Get(name)
+		return value
+	}
+	validate := func(t *testing.T, rules string, value interface{}) error {
+		set, err := language.ParseValidation(rules)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return language.Validate(interfaces.RecordFromMap(map[string]interface{}{"x": value}), set)
+	}
+
+	t.Run("casts values", func(t *testing.T) {
+		record, err := transform(t, `
+ADD_FIELD("price", CAST(FIELD("price"), DECIMAL(10, 2)))
+ADD_FIELD("total", CAST("0.1", DECIMAL) + CAST("0.2", DECIMAL) * 2)
+ADD_FIELD("qty", CAST(FIELD("qty"), INT))
+ADD_FIELD("id", CAST(FIELD("id"), STRING))
+ADD_FIELD("active", CAST(FIELD("active"), BOOL))
+ADD_FIELD("at", CAST(FIELD("at"), DATE("02/01/2006 15:04", "Europe/Paris")))
+ADD_FIELD("seen", PARSE_DATE(FIELD("seen"), "2006-01-02 15:04", "+05:30"))
+ADD_FIELD("none", CAST(FIELD("missing"), INT))
+`, map[string]interface{}{"price": "19.995", "qty": "3", "id": int64(7), "active": "true", "at": "10/01/2024 10:30", "seen": "2024-01-10 15:00"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "20.00", interfaces.ValueString(get(record, "price")))
+		assert.Equal(t, "0.5", interfaces.ValueString(get(record, "total")))
+		assert.Equal(t, int64(3), get(record, "qty"))
+		assert.Equal(t, "7", get(record, "id"))
+		assert.Equal(t, true, get(record, "active"))
+		at := get(record, "at").(time.Time)
+		assert.Equal(t, time.Date(2024, 1, 10, 9, 30, 0, 0, time.UTC), at.UTC())
+		assert.Equal(t, "Europe/Paris", at.Location().String())
+		assert.Equal(t, time.Date(2024, 1, 10, 9, 30, 0, 0, time.UTC), get(record, "seen").(time.Time).UTC())
+		assert.Nil(t, get(record, "none"))
+
+		_, err = transform(t, `ADD_FIELD("price", CAST(FIELD("price"), DECIMAL(4, 2)))`, map[string]interface{}{"price": "123.456"})
+		assert.EqualError(t, err, `rule on line 1: CAST(FIELD("price"), DECIMAL(4, 2)): '123.456' does not fit in DECIMAL(4, 2)`)
+		_, err = transform(t, `ADD_FIELD("qty", CAST(FIELD("qty"), INT))`, map[string]interface{}{"qty": "1.5"})
+		assert.EqualError(t, err, `rule on line 1: CAST(FIELD("qty"), INT): '1.5' is not an INT`)
+		t.Logf("%s Values cast", greenTick)
+	})
+
+	t.Run("rounds decimals exactly", func(t *testing.T) {
+		record, err := transform(t, `
+ADD_FIELD("cents", ROUND(FIELD("price"), 2))
+ADD_FIELD("whole", ROUND(FIELD("debt")))
+ADD_FIELD("tens", ROUND(FIELD("price"), -1))
+ADD_FIELD("padded", ROUND(CAST("1.5", DECIMAL), 3))
+ADD_FIELD("float", ROUND(FIELD("float"), 2))
+`, map[string]interface{}{
+			"price": interfaces.Decimal{Unscaled: big.NewInt(1000000000000000125), Scale: 3},
+			"debt":  interfaces.Decimal{Unscaled: big.NewInt(-25), Scale: 1},
+			"float": 2.675,
+		})
+		assert.NoError(t, err)
+		for name, want := range map[string]string{"cents": "1000000000000000.13", "whole": "-3", "tens": "1000000000000000", "padded": "1.500"} {
+			assert.IsType(t, interfaces.Decimal{}, get(record, name), name)
+			assert.Equal(t, want, interfaces.ValueString(get(record, name)), name)
+		}
+		assert.IsType(t, float64(0), get(record, "float"))
+
+		_, err = transform(t, `ADD_FIELD("x", ROUND(FIELD("x"), 5000))`, map[string]interface{}{"x": interfaces.Decimal{Unscaled: big.NewInt(1)}})
+		assert.EqualError(t, err, `rule on line 1: ROUND(FIELD("x"), 5000): 5000 places is out of range`)
+		_, err = language.ParseTransformation(`ADD_FIELD("x", LOWER(ROUND(CAST("1", DECIMAL))))`)
+		assert.EqualError(t, err, `line 1, column 22: argument 1 of LOWER must be a STRING, found ROUND(CAST("1", DECIMAL)), a DECIMAL`)
+		t.Logf("%s Decimals rounded exactly", greenTick)
+	})
+
+	t.Run("checks types with parameters", func(t *testing.T) {
+		assert.NoError(t, validate(t, `FIELD("x") TYPE(DECIMAL(5, 2))`, "123.45"))
+		assert.NoError(t, validate(t, `FIELD("x") TYPE(DECIMAL(5, 2))`, 12.5))
+		assert.EqualError(t, validate(t, `FIELD("x") TYPE(DECIMAL(5, 2))`, "123.456"), "rule on line 1: field x: value '123.456' does not fit in DECIMAL(5, 2)")
+		assert.EqualError(t, validate(t, `FIELD("x") TYPE(DECIMAL(5, 2))`, "1234.5"), "rule on line 1: field x: value '1234.5' does not fit in DECIMAL(5, 2)")
+		assert.EqualError(t, validate(t, `FIELD("x") TYPE(DECIMAL)`, "abc"), "rule on line 1: field x: value 'abc' is not a decimal")
+		assert.NoError(t, validate(t, `FIELD("x") TYPE(DATE("02/01/2006"))`, "31/12/2024"))
+		assert.EqualError(t, validate(t, `FIELD("x") TYPE(DATE("02/01/2006"))`, "2024-12-31"), "rule on line 1: field x: value '2024-12-31' is not a valid date")
+		assert.NoError(t, validate(t, `FIELD("x") TYPE(DATE)`, time.Now()))
+		assert.NoError(t, validate(t, `FIELD("x") TYPE(DATE)`, "2024-12-31T23:59:00+01:00"))
+		assert.NoError(t, validate(t, `FIELD("x") TYPE(INT)`, 42.0))
+		assert.EqualError(t, validate(t, `FIELD("x") TYPE(INT)`, "4.2"), "rule on line 1: field x: value '4.2' is not an integer")
+		t.Logf("%s Typed values checked", greenTick)
+	})
+
+	t.Run("ranges over numbers, dates and open bounds", func(t *testing.T) {
+		assert.NoError(t, validate(t, `FIELD("x") RANGE(0.5, 99.99)`, "99.99"))
+		assert.EqualError(t, validate(t, `FIELD("x") RANGE(0.5, 99.99)`, "99.991"), "rule on line 1: field x: value '99.991' not in range")
+		assert.NoError(t, validate(t, `FIELD("x") RANGE(0.1, 0.3)`, 0.1))
+		assert.NoError(t, validate(t, `FIELD("x") RANGE(0, 1)`, interfaces.Decimal{Unscaled: big.NewInt(5), Scale: 1}))
+		assert.NoError(t, validate(t, `FIELD("x") RANGE(0, *)`, int64(1)<<62))
+		assert.EqualError(t, validate(t, `FIELD("x") RANGE(0, *)`, -1), "rule on line 1: field x: value '-1' not in range")
+		assert.NoError(t, validate(t, `FIELD("x") RANGE("2024-01-01", "2024-12-31")`, "2024-06-30 12:00:00"))
+		assert.NoError(t, validate(t, `FIELD("x") RANGE(*, "2024-12-31")`, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.EqualError(t, validate(t, `FIELD("x") RANGE("2024-01-01", *)`, "2023-12-31"), "rule on line 1: field x: value '2023-12-31' not in range")
+		assert.EqualError(t, validate(t, `FIELD("x") RANGE("2024-01-01", *)`, "soon"), "rule on line 1: field x: field value should be a date")
+		assert.EqualError(t, validate(t, `FIELD("x") RANGE(1, 2)`, "many"), "rule on line 1: field x: field value should be a number")
+		t.Logf("%s Ranges checked", greenTick)
+	})
+
+	t.Run("rejects invalid types and bounds", func(t *testing.T) {
+		cases := map[string]string{
+			`FIELD("a") RANGE(*, *)`:                        `line 1, column 12: RANGE needs at least one bound; use * for one bound only`,
+			`FIELD("a") RANGE(1, "2024-01-01")`:             `line 1, column 21: range bounds must both be numbers or both be dates, found 1 and "2024-01-01"`,
+			`FIELD("a") RANGE("soon", *)`:                   `line 1, column 18: range bounds must be numbers or dates, found "soon"; write dates as 2006-01-02 or 2006-01-02T15:04:05Z`,
+			`FIELD("a") RANGE("2024-02-01", "2024-01-01")`:  `line 1, column 18: the lower bound "2024-02-01" of RANGE is greater than the upper bound "2024-01-01"`,
+			`FIELD("a") TYPE(DECIMAL(2, 3))`:                `line 1, column 28: the scale of DECIMAL must be an integer from 0 to the precision 2, found 3`,
+			`FIELD("a") TYPE(INT(4))`:                       `line 1, column 20: INT takes no parameters`,
+			`FIELD("a") TYPE(DATE("2006", "Mars/Olympus"))`: `line 1, column 30: unknown time zone "Mars/Olympus"; use an IANA name such as Europe/Paris, or an offset such as +05:30`,
+			`FIELD("a") == CAST(FIELD("b"))`:                `line 1, column 30: expected "," between the value and the type of CAST, found )`,
+			`FIELD("a") == CAST(FIELD("b"), MONEY)`:         `line 1, column 32: unknown type MONEY, expected STRING, INT, FLOAT, BOOL, DATE or DECIMAL`,
+			`LOWER(CAST(FIELD("b"), DECIMAL)) == "1"`:       `line 1, column 7: argument 1 of LOWER must be a STRING, found CAST(FIELD("b"), DECIMAL), a DECIMAL`,
+		}
+		for rules, message := range cases {
+			_, err := language.Parse(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		t.Logf("%s %d invalid rules rejected", greenTick, len(cases))
+	})
+}
+
+func BenchmarkValidate(b *testing.B) {
+	set, err := language.CompileValidation("FIELD(\"id\") TYPE(INT) RANGE(0, 1000000)\nFIELD(\"email\") MATCHES(EMAIL_REGEX)\nFIELD(\"price\") * FIELD(\"qty\") <= 10000")
+	if err != nil {
+		b.Fatal(err)
+	}
+	record := interfaces.RecordFromMap(map[string]interface{}{"id": "42", "email": "ada@example.com", "price": "19.99", "qty": "3", "name": "Ada"})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := language.Validate(record, set); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
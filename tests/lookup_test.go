@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapDatasets holds reference datasets as maps from key to field values.
+type mapDatasets map[string]map[string]map[string]interface{}
+
+func (m mapDatasets) Lookup(dataset, key string, value interface{}, field string) (interface{}, bool, error) {
+	records, ok := m[dataset]
+	if !ok {
+		return nil, false, assert.AnError
+	}
+	record, ok := records[interfaces.ValueString(value)]
+	if !ok {
+		return nil, false, nil
+	}
+	return record[field], true, nil
+}
+
+func TestLookup(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	products := func(names ...string) []*interfaces.Record {
+		records := make([]*interfaces.Record, len(names))
+		for i, name := range names {
+			records[i] = interfaces.RecordFromMap(map[string]interface{}{"sku": int64(i + 1), "category": name})
+		}
+		return records
+	}
+	orders := func() []*interfaces.Record {
+		return []*interfaces.Record{
+			interfaces.RecordFromMap(map[string]interface{}{"id": 1, "sku": "1"}),
+			interfaces.RecordFromMap(map[string]interface{}{"id": 2, "sku": "3"}),
+		}
+	}
+
+	t.Run("looks up fields with a default for misses", func(t *testing.T) {
+		set, err := language.ParseTransformation(`
+ADD_FIELD("category", LOOKUP(FIELD("sku"), "products", "sku", "category", "unknown"))
+ADD_FIELD("name", LOOKUP(FIELD("sku"), "products", "sku", "name"))
+`)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"products"}, set.Datasets())
+
+		datasets := mapDatasets{"products": {"A1": {"category": "books", "name": "Dune"}}}
+		record, err := language.TransformWith(interfaces.RecordFromMap(map[string]interface{}{"sku": "A1"}), set, datasets)
+		assert.NoError(t, err)
+		category, _ := record.Get("category")
+		name, _ := record.Get("name")
+		assert.Equal(t, "books", category)
+		assert.Equal(t, "Dune", name)
+
+		record, err = language.TransformWith(interfaces.RecordFromMap(map[string]interface{}{"sku": "Z9"}), set, datasets)
+		assert.NoError(t, err)
+		category, _ = record.Get("category")
+		name, _ = record.Get("name")
+		assert.Equal(t, "unknown", category)
+		assert.Nil(t, name)
+
+		_, err = language.Transform(interfaces.RecordFromMap(map[string]interface{}{"sku": "A1"}), set)
+		assert.ErrorContains(t, err, `unknown dataset "products", no reference datasets are loaded`)
+		t.Logf("%s Looked up values and defaults", greenTick)
+	})
+
+	t.Run("rejects computed dataset and field names", func(t *testing.T) {
+		cases := map[string]string{
+			`ADD_FIELD("c", LOOKUP(FIELD("sku"), FIELD("set"), "sku", "category"))`: `line 1, column 37: argument 2 of LOOKUP must be a quoted string, found FIELD("set")`,
+			`ADD_FIELD("c", LOOKUP(FIELD("sku"), "products", LOWER("SKU"), "c"))`:   `line 1, column 49: argument 3 of LOOKUP must be a quoted string, found LOWER("SKU")`,
+		}
+		for rules, message := range cases {
+			_, err := language.ParseTransformation(rules)
+			assert.EqualError(t, err, message, rules)
+		}
+		t.Logf("%s Computed names rejected", greenTick)
+	})
+
+	t.Run("enriches records from a cached reference", func(t *testing.T) {
+		var reads int32
+		references, err := pipeline.NewReferences([]pipeline.Reference{
+			{Name: "products", Source: countingSource{sliceSource{records: products("books", "games")}, &reads}},
+		})
+		assert.NoError(t, err)
+		ctx := pipeline.WithReferences(context.Background(), references)
+		req := interfaces.Request{TransformationRules: `ADD_FIELD("category", LOOKUP(FIELD("sku"), "products", "sku", "category", "other"))`}
+
+		for run := 0; run < 2; run++ {
+			destination := &collectDestination{}
+			assert.NoError(t, pipeline.Run(ctx, sliceSource{records: orders()}, destination, req))
+			if assert.Len(t, destination.received, 2) {
+				first, _ := destination.received[0].Get("category")
+				second, _ := destination.received[1].Get("category")
+				assert.Equal(t, "books", first)
+				assert.Equal(t, "other", second)
+			}
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&reads))
+		t.Logf("%s Reference read once for %d runs", greenTick, 2)
+	})
+
+	t.Run("reloads a reference once it is due", func(t *testing.T) {
+		var reads int32
+		references, err := pipeline.NewReferences([]pipeline.Reference{
+			{Name: "products", Source: countingSource{sliceSource{records: products("books")}, &reads}, Refresh: 20 * time.Millisecond},
+		})
+		assert.NoError(t, err)
+		ctx := pipeline.WithReferences(context.Background(), references)
+		req := interfaces.Request{TransformationRules: `ADD_FIELD("category", LOOKUP(FIELD("sku"), "products", "sku", "category"))`}
+
+		assert.NoError(t, pipeline.Run(ctx, sliceSource{records: orders()}, &collectDestination{}, req))
+		time.Sleep(30 * time.Millisecond)
+		assert.NoError(t, pipeline.Run(ctx, sliceSource{records: orders()}, &collectDestination{}, req))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&reads))
+		t.Logf("%s Reference reloaded", greenTick)
+	})
+
+	t.Run("fails on undefined and duplicate references", func(t *testing.T) {
+		output := interfaces.Request{TransformationRules: `ADD_FIELD("c", LOOKUP(FIELD("sku"), "prices", "sku", "price"))`}
+		targets := []pipeline.Target{{Name: "A", Destination: &collectDestination{}, Request: output}}
+		_, err := pipeline.FanOut(context.Background(), sliceSource{records: orders()}, targets, interfaces.Request{}, "")
+		assert.EqualError(t, err, `rules look up dataset "prices", which is not in the references of the migration`)
+
+		_, err = pipeline.NewReferences([]pipeline.Reference{{Name: "products"}, {Name: "products"}})
+		assert.EqualError(t, err, "duplicate reference name products")
+		t.Logf("%s Invalid references reported", greenTick)
+	})
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	_ "github.com/SkySingh04/fractal/integrations"
+	"github.com/SkySingh04/fractal/openapi"
+	"github.com/SkySingh04/fractal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntegrationMetadata(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("describes every registered integration", func(t *testing.T) {
+		sources := registry.Sources()
+		assert.Len(t, sources, len(registry.GetSources()))
+		for i, metadata := range sources {
+			if i > 0 {
+				assert.Less(t, strings.ToLower(sources[i-1].Name), strings.ToLower(metadata.Name), "sources are sorted by name")
+			}
+			assert.Equal(t, "source", metadata.Kind)
+			assert.NotEmpty(t, metadata.Description, metadata.Name)
+			assert.NotEmpty(t, metadata.Version, metadata.Name)
+			assert.NotEmpty(t, metadata.Capabilities, metadata.Name)
+			for _, field := range metadata.Config {
+				assert.NotEmpty(t, field.Description, "%s.%s", metadata.Name, field.Name)
+			}
+		}
+		assert.Len(t, registry.Destinations(), len(registry.GetDestinations()))
+		t.Logf("%s %d sources described", greenTick, len(sources))
+	})
+
+	t.Run("combines the registered info with the config schema", func(t *testing.T) {
+		metadata, ok := registry.SourceMetadata("Kafka")
+		assert.True(t, ok)
+		assert.Equal(t, "Apache Kafka", metadata.DisplayName)
+		assert.True(t, metadata.Has(registry.CapabilityStreaming))
+		assert.False(t, metadata.Has(registry.CapabilityBatch))
+		assert.Equal(t, registry.ConfigField{
+			Name:        "group_id",
+			Type:        "string",
+			Default:     "fractal-group",
+			Description: "Consumer group that tracks the read offsets",
+		}, withoutIndex(metadata.Config[2]))
+
+		metadata, ok = registry.DestinationMetadata("PostgreSQL")
+		assert.True(t, ok)
+		assert.True(t, metadata.Config[0].Secret)
+		assert.NotEmpty(t, metadata.Config[0].Example)
+
+		_, ok = registry.DestinationMetadata("Unknown")
+		assert.False(t, ok)
+		t.Logf("%s Metadata combined", greenTick)
+	})
+
+	t.Run("generates the OpenAPI document from the metadata", func(t *testing.T) {
+		document := openapi.Document()
+		schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		for _, metadata := range registry.Sources() {
+			assert.Contains(t, schemas, metadata.Name+"SourceConfig")
+		}
+		for _, metadata := range registry.Destinations() {
+			assert.Contains(t, schemas, metadata.Name+"DestinationConfig")
+		}
+
+		rabbit := schemas["RabbitMQSourceConfig"].(map[string]interface{})
+		assert.Equal(t, []string{"url", "queue_name"}, rabbit["required"])
+		url := rabbit["properties"].(map[string]interface{})["url"].(map[string]interface{})
+		assert.Equal(t, "password", url["format"])
+
+		paths := document["paths"].(map[string]interface{})
+		assert.Contains(t, paths, "/migrate")
+		assert.Contains(t, paths, "/integrations")
+		t.Logf("%s OpenAPI document generated", greenTick)
+	})
+}
+
+// withoutIndex drops the unexported field index so config fields can be compared.
+func withoutIndex(field registry.ConfigField) registry.ConfigField {
+	return registry.ConfigField{
+		Name:        field.Name,
+		Type:        field.Type,
+		Required:    field.Required,
+		Default:     field.Default,
+		Secret:      field.Secret,
+		Description: field.Description,
+		Example:     field.Example,
+	}
+}
@@ -41,12 +41,18 @@ func TestMongoDBIntegration(t *testing.T) {
 
 	// Define the request for MongoDB source
 	req := interfaces.Request{
-		SourceMongoDBConnString: "mongodb://localhost:27017",
-		SourceMongoDBDatabase:   "test_db",
-		SourceMongoDBCollection: "test_collection",
-		TargetMongoDBConnString: "mongodb://localhost:27017",
-		TargetMongoDBDatabase:   "test_db",
-		TargetMongoDBCollection: "test_collection_out",
+		Input:  "MongoDB",
+		Output: "MongoDB",
+		InputConfig: map[string]interface{}{
+			"conn_string": "mongodb://localhost:27017",
+			"database":    "test_db",
+			"collection":  "test_collection",
+		},
+		OutputConfig: map[string]interface{}{
+			"conn_string": "mongodb://localhost:27017",
+			"database":    "test_db",
+			"collection":  "test_collection_out",
+		},
 	}
 
 	// Mock the FetchData method for successful data fetch
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// fetchAll runs source.FetchData and collects every streamed record.
+func fetchAll(source interfaces.DataSource, req interfaces.Request) ([]*interfaces.Record, error) {
+	out := make(chan *interfaces.Record)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errCh <- source.FetchData(context.Background(), req, out)
+	}()
+
+	var records []*interfaces.Record
+	for record := range out {
+		records = append(records, record)
+	}
+	return records, <-errCh
+}
+
+// sendAll streams records into destination.SendData.
+func sendAll(destination interfaces.DataDestination, req interfaces.Request, records ...*interfaces.Record) error {
+	in := make(chan *interfaces.Record, len(records))
+	for _, record := range records {
+		in <- record
+	}
+	close(in)
+	return destination.SendData(context.Background(), in, req)
+}
+
+// sliceSource streams a fixed list of records.
+type sliceSource struct {
+	records []*interfaces.Record
+}
+
+func (s sliceSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	for _, record := range s.records {
+		if err := interfaces.Send(ctx, out, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endlessSource streams records until it is cancelled.
+type endlessSource struct {
+	stopped chan error
+}
+
+func (e endlessSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	for {
+		if err := interfaces.Send(ctx, out, interfaces.NewRecord()); err != nil {
+			e.stopped <- err
+			return err
+		}
+	}
+}
+
+// collectDestination stores every record it receives, failing after failAfter records when set.
+type collectDestination struct {
+	received  []*interfaces.Record
+	failAfter int
+}
+
+func (c *collectDestination) SendData(ctx context.Context, in <-chan *interfaces.Record, req interfaces.Request) error {
+	for {
+		record, err := interfaces.Receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return nil
+		}
+		if c.failAfter > 0 && len(c.received) == c.failAfter {
+			return errors.New("destination unavailable")
+		}
+		c.received = append(c.received, record)
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	records := make([]*interfaces.Record, pipeline.BufferSize*3)
+	for i := range records {
+		records[i] = interfaces.NewRecord()
+		records[i].Set("id", i)
+	}
+
+	t.Run("streams every record in order", func(t *testing.T) {
+		destination := &collectDestination{}
+		err := pipeline.Run(context.Background(), sliceSource{records: records}, destination, interfaces.Request{})
+		assert.NoError(t, err)
+		assert.Equal(t, records, destination.received)
+		t.Logf("%s Streamed %d records", greenTick, len(destination.received))
+	})
+
+	t.Run("destination failure does not block the source", func(t *testing.T) {
+		destination := &collectDestination{failAfter: 5}
+		err := pipeline.Run(context.Background(), sliceSource{records: records}, destination, interfaces.Request{})
+		assert.ErrorContains(t, err, "destination unavailable")
+		assert.Len(t, destination.received, 5)
+		t.Logf("%s Destination failure reported", greenTick)
+	})
+
+	t.Run("destination failure cancels the source", func(t *testing.T) {
+		source := endlessSource{stopped: make(chan error, 1)}
+		err := pipeline.Run(context.Background(), source, &collectDestination{failAfter: 1}, interfaces.Request{})
+		assert.ErrorContains(t, err, "destination unavailable")
+		assert.ErrorIs(t, <-source.stopped, context.Canceled)
+		t.Logf("%s Source cancelled after destination failure", greenTick)
+	})
+
+	t.Run("cancelling the context stops the migration", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		source := endlessSource{stopped: make(chan error, 1)}
+		err := pipeline.Run(ctx, source, &collectDestination{}, interfaces.Request{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, <-source.stopped, context.DeadlineExceeded)
+		t.Logf("%s Migration stopped on cancellation", greenTick)
+	})
+	t.Run("applies rules to any source", func(t *testing.T) {
+		rows := recordsOf(
+			map[string]interface{}{"id": 1, "state": "active"},
+			map[string]interface{}{"id": 2, "state": "inactive"},
+		)
+		req := interfaces.Request{
+			ValidationRules:     `FIELD("id") TYPE(INT)`,
+			TransformationRules: "RENAME(\"state\", \"status\")\nMAP(\"status\", {\"active\": 1, \"inactive\": 0})",
+		}
+		destination := &collectDestination{}
+		assert.NoError(t, pipeline.Run(context.Background(), sliceSource{records: rows}, destination, req))
+		assert.Len(t, destination.received, 2)
+		status, _ := destination.received[1].Get("status")
+		assert.Equal(t, int64(0), status)
+
+		req.ValidationRules = `FIELD("id") RANGE(1, 1)`
+		err := pipeline.Run(context.Background(), sliceSource{records: recordsOf(map[string]interface{}{"id": 2})}, &collectDestination{}, req)
+		assert.EqualError(t, err, "failed to fetch data from source: record failed validation: rule on line 1: field id: value '2' not in range")
+
+		req.TransformationRules = `RENAME("state")`
+		err = pipeline.Run(context.Background(), sliceSource{}, &collectDestination{}, req)
+		assert.ErrorContains(t, err, "failed to parse transformation rules: line 1, column 15")
+		t.Logf("%s Rules applied between source and destination", greenTick)
+	})
+}
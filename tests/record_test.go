@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SkySingh04/fractal/integrations"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("JSON round trip keeps field order and types", func(t *testing.T) {
+		input := `{"zeta":1,"alpha":"a","price":12.345678901234567890123,"tags":["x",null],"nested":{"b":true,"a":2.5}}`
+		var record interfaces.Record
+		assert.NoError(t, record.UnmarshalJSON([]byte(input)))
+		assert.Equal(t, []string{"zeta", "alpha", "price", "tags", "nested"}, record.Names())
+
+		zeta, _ := record.Get("zeta")
+		assert.Equal(t, int64(1), zeta)
+		price, _ := record.Get("price")
+		assert.IsType(t, interfaces.Decimal{}, price)
+		assert.Equal(t, "12.345678901234567890123", price.(interfaces.Decimal).String())
+
+		output, err := record.MarshalJSON()
+		assert.NoError(t, err)
+		assert.Equal(t, input, string(output))
+		t.Logf("%s JSON round trip passed", greenTick)
+	})
+
+	t.Run("Set normalizes native values", func(t *testing.T) {
+		now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		record := interfaces.NewRecord()
+		record.Set("count", 3)
+		record.Set("ratio", float32(0.5))
+		record.Set("when", now)
+		record.Set("meta", map[string]interface{}{"b": 1, "a": "x"})
+		record.Set("list", []string{"a", "b"})
+
+		assert.Equal(t, interfaces.TypeInt, interfaces.TypeOf(record.Fields[0].Value))
+		assert.Equal(t, interfaces.TypeFloat, interfaces.TypeOf(record.Fields[1].Value))
+		assert.Equal(t, interfaces.TypeTimestamp, interfaces.TypeOf(record.Fields[2].Value))
+		assert.Equal(t, []string{"a", "b"}, record.Fields[3].Value.(*interfaces.Record).Names())
+		assert.Equal(t, []interface{}{"a", "b"}, record.Fields[4].Value)
+
+		clone := record.Clone()
+		clone.Rename("count", "total")
+		clone.Delete("ratio")
+		assert.Equal(t, []string{"count", "ratio", "when", "meta", "list"}, record.Names())
+		assert.Equal(t, []string{"total", "when", "meta", "list"}, clone.Names())
+		t.Logf("%s Normalization passed", greenTick)
+	})
+
+	t.Run("Decimal parsing", func(t *testing.T) {
+		for _, text := range []string{"0", "-0.5", "100", "0.001", "-12.340"} {
+			d, err := interfaces.ParseDecimal(text)
+			assert.NoError(t, err)
+			assert.Equal(t, text, d.String())
+		}
+		_, err := interfaces.ParseDecimal("1.2.3")
+		assert.Error(t, err)
+		t.Logf("%s Decimal parsing passed", greenTick)
+	})
+}
+
+func TestRecordsCrossIntegrations(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "people.csv")
+	yamlFile := filepath.Join(dir, "people.yaml")
+	assert.NoError(t, os.WriteFile(csvFile, []byte("name,age\nJohn,25\nJane,30\n"), 0644))
+
+	// CSV rows become YAML documents
+	req := interfaces.Request{Input: "CSV", Output: "YAML"}
+	assert.NoError(t, pipeline.Run(context.Background(), integrations.CSVSource{FileName: csvFile}, integrations.YAMLDestination{FilePath: yamlFile}, req))
+
+	// and YAML documents become JSON objects
+	jsonFile := filepath.Join(dir, "people.json")
+	req = interfaces.Request{Input: "YAML", Output: "JSON"}
+	assert.NoError(t, pipeline.Run(context.Background(), integrations.YAMLSource{FilePath: yamlFile}, integrations.JSONDestination{Filename: jsonFile}, req))
+
+	output, err := os.ReadFile(jsonFile)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"name":"John","age":"25","transformed":true},{"name":"Jane","age":"30","transformed":true}]`, string(output))
+	t.Logf("%s CSV to YAML to JSON passed", greenTick)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SkySingh04/fractal/integrations"
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// nopSource is a source with no configuration, used to exercise the registry.
+type nopSource struct{}
+
+func (nopSource) FetchData(ctx context.Context, req interfaces.Request, out chan<- *interfaces.Record) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	t.Run("names are case-insensitive and aliases resolve", func(t *testing.T) {
+		for _, name := range []string{"PostgreSQL", "postgresql", "POSTGRES"} {
+			_, ok := registry.GetSource(name)
+			assert.True(t, ok, name)
+		}
+		source, err := registry.NewSource("mongo", map[string]interface{}{"conn_string": "mongodb://localhost", "database": "db", "collection": "c"})
+		assert.NoError(t, err)
+		assert.IsType(t, integrations.MongoDBSource{}, source)
+
+		metadata, ok := registry.DestinationMetadata("postgres")
+		assert.True(t, ok)
+		assert.Equal(t, "PostgreSQL", metadata.Name)
+		assert.Equal(t, []string{"postgres"}, metadata.Aliases)
+		t.Logf("%s Names resolved", greenTick)
+	})
+
+	t.Run("rejects duplicates", func(t *testing.T) {
+		assert.Panics(t, func() { registry.RegisterSource("csv", nopSource{}) })
+		assert.ErrorIs(t, registry.AddSource("Postgres", nopSource{}), registry.ErrDuplicate)
+		assert.ErrorIs(t, registry.AddAlias("kafka", "RabbitMQ"), registry.ErrDuplicate)
+		assert.ErrorIs(t, registry.AddAlias("POSTGRES", "MongoDB"), registry.ErrDuplicate)
+		t.Logf("%s Duplicates rejected", greenTick)
+	})
+
+	t.Run("registers and unregisters concurrently", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name := fmt.Sprintf("Runtime%02d", i)
+				assert.NoError(t, registry.AddSource(name, nopSource{}))
+				_ = registry.SourceNames()
+				_ = registry.Sources()
+			}()
+		}
+		wg.Wait()
+
+		names := registry.SourceNames()
+		assert.Contains(t, names, "Runtime07")
+		for i := 0; i < 20; i++ {
+			assert.True(t, registry.UnregisterSource(fmt.Sprintf("runtime%02d", i)))
+		}
+		assert.False(t, registry.UnregisterSource("Runtime07"))
+		assert.NotContains(t, registry.SourceNames(), "Runtime07")
+		t.Logf("%s Concurrent registration passed", greenTick)
+	})
+
+	t.Run("returns sorted snapshots", func(t *testing.T) {
+		names := registry.DestinationNames()
+		assert.IsNonDecreasing(t, lower(names))
+
+		snapshot := registry.GetSources()
+		delete(snapshot, "CSV")
+		_, ok := registry.GetSource("CSV")
+		assert.True(t, ok, "changing a snapshot does not change the registry")
+		t.Logf("%s Snapshots sorted", greenTick)
+	})
+
+	t.Run("forgets the description of unregistered integrations", func(t *testing.T) {
+		registry.Describe("Ephemeral", registry.Info{Description: "Gone soon"})
+		assert.NoError(t, registry.AddSource("Ephemeral", nopSource{}))
+		metadata, ok := registry.SourceMetadata("ephemeral")
+		assert.True(t, ok)
+		assert.Equal(t, "Gone soon", metadata.Description)
+
+		assert.True(t, registry.UnregisterSource("Ephemeral"))
+		assert.NoError(t, registry.AddSource("Ephemeral", nopSource{}))
+		metadata, _ = registry.SourceMetadata("ephemeral")
+		assert.Empty(t, metadata.Description)
+		assert.True(t, registry.UnregisterSource("Ephemeral"))
+		t.Logf("%s Description removed", greenTick)
+	})
+}
+
+func lower(names []string) []string {
+	lowered := make([]string, len(names))
+	for i, name := range names {
+		lowered[i] = strings.ToLower(name)
+	}
+	return lowered
+}
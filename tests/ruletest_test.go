@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SkySingh04/fractal/interfaces"
+	"github.com/SkySingh04/fractal/language"
+	"github.com/SkySingh04/fractal/ruletest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleTest(t *testing.T) {
+	greenTick := "\033[32m✔\033[0m"
+
+	// load writes files to a temporary directory and loads the suite in the
+	// first of them
+	load := func(t *testing.T, files ...string) (*ruletest.Suite, error) {
+		dir := t.TempDir()
+		names := []string{"rules_test.yaml", "config.yaml"}
+		for i, content := range files {
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, names[i]), []byte(content), 0o644))
+		}
+		return ruletest.Load(filepath.Join(dir, names[0]))
+	}
+	run := func(t *testing.T, files ...string) []ruletest.Result {
+		suite, err := load(t, files...)
+		assert.NoError(t, err)
+		return suite.Run()
+	}
+
+	t.Run("validates every rule of a record", func(t *testing.T) {
+		set, err := language.ParseValidation("FIELD(\"id\") REQUIRED\nFIELD(\"age\") TYPE(INT)\nDROP_IF FIELD(\"status\") == \"deleted\"")
+		assert.NoError(t, err)
+		errs := language.ValidateAll(recordsOf(map[string]interface{}{"age": "x", "status": "deleted"})[0], set, nil)
+		assert.Len(t, errs, 3)
+		assert.ErrorContains(t, errs[0], "rule on line 1")
+		assert.ErrorContains(t, errs[1], "rule on line 2")
+		var drop *language.DropError
+		assert.ErrorAs(t, errs[2], &drop)
+
+		assert.Empty(t, language.ValidateAll(recordsOf(map[string]interface{}{"id": 1, "age": 3})[0], set, nil))
+		t.Logf("%s Every failed rule reported", greenTick)
+	})
+
+	t.Run("passes cases whose outcome matches", func(t *testing.T) {
+		results := run(t, `
+validations: |
+  FIELD("age") TYPE(INT)
+  DROP_IF FIELD("status") == "deleted"
+transformations: |
+  IF FIELD("age") > 50 THEN ADD_FIELD("senior", TRUE)
+  RENAME("name", "full_name")
+cases:
+  - name: adult
+    input: {name: Ada, age: 60, tags: [a, b]}
+    output: {full_name: Ada, senior: true, age: 60.0, tags: [a, b]}
+  - name: bad age
+    input: {age: old}
+    errors: [rule on line 1]
+  - input: {age: 3, status: deleted}
+    dropped: true
+  - input: {age: 3}
+`)
+		assert.Len(t, results, 4)
+		for _, result := range results {
+			assert.True(t, result.Passed(), "%s: %v %v", result.Case.Name, result.Diff, result.Err)
+		}
+		assert.Equal(t, "case 3", results[2].Case.Name)
+		assert.Equal(t, 15, results[2].Case.Line)
+		t.Logf("%s %d cases passed", greenTick, len(results))
+	})
+
+	t.Run("lists the differences of failed cases", func(t *testing.T) {
+		results := run(t, `
+transformations: |
+  ADD_FIELD("total", FIELD("price") * FIELD("quantity"))
+  ADD_FIELD("customer", FIELD("$.order.customer"))
+cases:
+  - input: {price: 2, quantity: 3, order: {customer: ann, lines: [1, 2]}}
+    output: {price: 2, quantity: 3, total: 7, customer: "ann", order: {customer: bob, lines: [1]}}
+  - input: {price: 2}
+    errors: [must be set]
+  - validations: FIELD("price") > 5
+    input: {price: 2}
+    output: {price: 2}
+  - validations: FIELD("price") > 1
+    input: {price: 2}
+    dropped: true
+`)
+		assert.Equal(t, []string{
+			"- total: 7",
+			"+ total: 6",
+			"- order.customer: \"bob\"",
+			"+ order.customer: \"ann\"",
+			"+ order.lines[1]: 2",
+		}, results[0].Diff)
+		assert.Equal(t, "- error: must be set", results[1].Diff[0])
+		assert.Equal(t, []string{"- price: 2", "+ error: rule on line 1: FIELD(\"price\") > 5 is false for 2 > 5"}, results[2].Diff)
+		assert.Equal(t, "- dropped by a DROP_IF or KEEP_IF rule", results[3].Diff[0])
+		for _, result := range results {
+			assert.False(t, result.Passed())
+		}
+		t.Logf("%s %d failed cases explained", greenTick, len(results))
+	})
+
+	t.Run("looks up the datasets of the suite", func(t *testing.T) {
+		results := run(t, `
+transformations: ADD_FIELD("category", LOOKUP(FIELD("sku"), "products", "sku", "category", "none"))
+datasets:
+  products:
+    - {sku: 1, category: books}
+cases:
+  - input: {sku: "1"}
+    output: {sku: "1", category: books}
+  - input: {sku: 2}
+    output: {sku: 2, category: none}
+  - transformations: ADD_FIELD("x", LOOKUP(FIELD("sku"), "stores", "id", "x"))
+    input: {sku: 1}
+    errors: [unknown dataset "stores"]
+`)
+		for _, result := range results {
+			assert.True(t, result.Passed(), "%s: %v %v", result.Case.Name, result.Diff, result.Err)
+		}
+		t.Logf("%s Datasets looked up", greenTick)
+	})
+
+	t.Run("tests the rules of a configuration file", func(t *testing.T) {
+		results := run(t, `
+config: config.yaml
+cases:
+  - input: {id: 1}
+    output: {id: 1, source: csv}
+  - input: {}
+    errors: [rule on line 1]
+`, `
+inputMethod: CSV
+validations: FIELD("id") REQUIRED
+transformations: ADD_FIELD("source", "csv")
+`)
+		for _, result := range results {
+			assert.True(t, result.Passed(), "%s: %v %v", result.Case.Name, result.Diff, result.Err)
+		}
+
+		results = run(t, `
+transformations: ADD_FIELD(
+cases:
+  - input: {id: 1}
+`)
+		assert.ErrorContains(t, results[0].Err, "invalid transformations: line 1")
+		t.Logf("%s Configuration rules tested", greenTick)
+	})
+
+	t.Run("rejects invalid suites", func(t *testing.T) {
+		cases := map[string]string{
+			"cases: []":                             "the suite has no cases",
+			"cases:\n  - input: {}\n    outptu: {}": "line 3: unknown case setting outptu",
+			"cases:\n  - input: {}\n    output: {}\n    dropped: true": "line 2: case 1 expects several outcomes, give one of output, errors and dropped",
+			"cases:\n  - input: [1]":                                   "line 2: a record is a mapping of fields to values",
+		}
+		for content, expected := range cases {
+			_, err := load(t, content)
+			assert.ErrorContains(t, err, expected, content)
+		}
+		t.Logf("%s %d invalid suites rejected", greenTick, len(cases))
+	})
+
+	t.Run("keeps the input of a case", func(t *testing.T) {
+		suite, err := load(t, "transformations: RENAME(\"a\", \"b\")\ncases:\n  - input: {a: 1}\n    output: {b: 1}\n")
+		assert.NoError(t, err)
+		assert.True(t, suite.Run()[0].Passed())
+		assert.Equal(t, []string{"a"}, suite.Cases[0].Input.Names())
+		assert.IsType(t, &interfaces.Record{}, suite.Cases[0].Input.Record)
+		t.Logf("%s Input left as written", greenTick)
+	})
+}
@@ -54,23 +54,20 @@ skills:
 	destinationFilePath := sourceFilePath + "_out.yaml"
 
 	// Initialize YAMLSource and YAMLDestination
-	yamlSource := integrations.YAMLSource{}
-	yamlDestination := integrations.YAMLDestination{}
+	yamlSource := integrations.YAMLSource{FilePath: sourceFilePath}
+	yamlDestination := integrations.YAMLDestination{FilePath: destinationFilePath}
 
 	// Define the request
-	req := interfaces.Request{
-		YAMLSourceFilePath:      sourceFilePath,
-		YAMLDestinationFilePath: destinationFilePath,
-	}
+	req := interfaces.Request{Input: "YAML", Output: "YAML"}
 
 	// Fetch data from source
-	fetchedData, err := yamlSource.FetchData(req)
+	fetchedData, err := fetchAll(yamlSource, req)
 	logTestStatus("Fetch data from YAML source", err)
 	assert.NoError(t, err, "FetchData failed")
-	assert.NotNil(t, fetchedData, "Fetched data should not be nil")
+	assert.Len(t, fetchedData, 1, "A single YAML document should stream a single record")
 
 	// Write data to destination
-	err = yamlDestination.SendData(fetchedData, req)
+	err = sendAll(yamlDestination, req, fetchedData...)
 	logTestStatus("Write data to YAML destination", err)
 	assert.NoError(t, err, "SendData failed")
 